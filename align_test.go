@@ -0,0 +1,75 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+// TestAlignment checks that the fields accessed by 64-bit atomic
+// operations are 64-bit aligned.  This only finds problems on 32-bit
+// platforms, see TestAlignment386.
+func TestAlignment(t *testing.T) {
+	var conn Conn
+	var it idleTimer
+	var mt messageTrace
+	var a activity
+	var s Stats
+	var cc controlCounters
+	offsets := map[string]uintptr{
+		"Conn.readLimit":     unsafe.Offsetof(conn.readLimit),
+		"idleTimer.last":     unsafe.Offsetof(it.last),
+		"idleTimer.pingSent": unsafe.Offsetof(it.pingSent),
+		"messageTrace.bytes": unsafe.Offsetof(mt.bytes),
+		"activity.last":      unsafe.Offsetof(a.last),
+		"activity.ping":      unsafe.Offsetof(a.ping),
+		"Stats.open":         unsafe.Offsetof(s.open),
+		"Stats.unsolicited":  unsafe.Offsetof(s.unsolicited),
+		"controlCounters":    unsafe.Offsetof(cc.unsolicited),
+	}
+	for name, offs := range offsets {
+		if offs%8 != 0 {
+			t.Errorf("%s: offset %d is not 64-bit aligned", name, offs)
+		}
+	}
+}
+
+// TestAlignment386 runs TestAlignment and the tests which exercise the
+// atomic fields on
+// GOARCH=386.
+func TestAlignment386(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("only run on linux/amd64")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	cmd := exec.Command(goTool, "test", "-count=1", "-run", "^(TestAlignment|TestIdleTimeout|TestDialerLimits|TestMessageHooks)$", ".")
+	cmd.Env = append(os.Environ(), "GOARCH=386", "CGO_ENABLED=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("GOARCH=386: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,218 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// broadcastBatchSize is the number of clients handled by a worker
+// in one go.
+const broadcastBatchSize = 64
+
+// Broadcaster sends messages to large numbers of clients, using a fixed pool
+// of worker goroutines.  In contrast to BroadcastBinary and BroadcastText,
+// which serve one client at a time, the clients are split into batches which
+// are served concurrently.  The frame header for each message is only
+// encoded once, and all clients share the message body: large messages are
+// passed to the operating system using vectored writes, without copying
+// them for each client.  Thus, memory use does not grow with the number of
+// clients.
+//
+// A Broadcaster can be used concurrently from different goroutines.  Use
+// NewBroadcaster to create a Broadcaster, and call Close to stop the worker
+// goroutines after use.
+type Broadcaster struct {
+	jobs chan<- *broadcastBatch
+}
+
+type broadcastBatch struct {
+	ctx     context.Context
+	clients []*Conn
+	offset  int // index of clients[0] in the slice passed to broadcast
+	tp      MessageType
+	msg     []byte
+	header  []byte // the frame header for msg, for server connections
+	res     *broadcastResult
+}
+
+type broadcastResult struct {
+	sync.Mutex
+	wg     sync.WaitGroup
+	errors map[int]error
+}
+
+// NewBroadcaster creates a new Broadcaster which uses the given number of
+// worker goroutines.  If workers is zero or negative, runtime.GOMAXPROCS(0)
+// workers are used.
+func NewBroadcaster(workers int) *Broadcaster {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan *broadcastBatch)
+	for i := 0; i < workers; i++ {
+		go broadcastWorker(jobs)
+	}
+	return &Broadcaster{jobs: jobs}
+}
+
+// Close stops the worker goroutines.  The Broadcaster cannot be used any
+// more after Close has been called.
+func (b *Broadcaster) Close() {
+	close(b.jobs)
+}
+
+// BroadcastBinary sends a binary message to all clients in the given slice.
+// The return value contains all errors that occurred during sending.  The
+// keys of the map are the indices of the clients in the slice.
+func (b *Broadcaster) BroadcastBinary(ctx context.Context, clients []*Conn, msg []byte) map[int]error {
+	return b.broadcast(ctx, clients, Binary, msg)
+}
+
+// BroadcastText sends a text message to all clients in the given slice.
+// The return value contains all errors that occurred during sending.  The
+// keys of the map are the indices of the clients in the slice.
+func (b *Broadcaster) BroadcastText(ctx context.Context, clients []*Conn, msg string) map[int]error {
+	return b.broadcast(ctx, clients, Text, []byte(msg))
+}
+
+func (b *Broadcaster) broadcast(ctx context.Context, clients []*Conn, tp MessageType, msg []byte) map[int]error {
+	header := make([]byte, maxHeaderSize)
+	n := encodeHeader(header, tp, uint64(len(msg)), true)
+	header = header[:n]
+
+	res := &broadcastResult{
+		errors: make(map[int]error),
+	}
+	for start := 0; start < len(clients); start += broadcastBatchSize {
+		end := start + broadcastBatchSize
+		if end > len(clients) {
+			end = len(clients)
+		}
+		batch := &broadcastBatch{
+			ctx:     ctx,
+			clients: clients[start:end],
+			offset:  start,
+			tp:      tp,
+			msg:     msg,
+			header:  header,
+			res:     res,
+		}
+		res.wg.Add(1)
+		select {
+		case b.jobs <- batch:
+		case <-ctx.Done():
+			res.wg.Done()
+			res.Lock()
+			for i := start; i < len(clients); i++ {
+				res.errors[i] = ctx.Err()
+			}
+			res.Unlock()
+			start = len(clients)
+		}
+	}
+	res.wg.Wait()
+
+	return res.errors
+}
+
+func broadcastWorker(jobs <-chan *broadcastBatch) {
+	for batch := range jobs {
+		batch.run()
+		batch.res.wg.Done()
+	}
+}
+
+// run sends the message to all clients in the batch.  Clients whose sender
+// is available are served first, so that a slow client does not hold up
+// the others.
+func (batch *broadcastBatch) run() {
+	var waiting []int
+	for i, conn := range batch.clients {
+		if conn.Draining() {
+			batch.setError(i, ErrDraining)
+			continue
+		}
+		select {
+		case wb := <-conn.senderStore:
+			batch.setError(i, batch.send(wb))
+		default:
+			waiting = append(waiting, i)
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+
+	cases := make([]reflect.SelectCase, len(waiting)+1)
+	for j, i := range waiting {
+		cases[j] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(batch.clients[i].senderStore),
+		}
+	}
+	cases[len(waiting)] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(batch.ctx.Done()),
+	}
+	disabled := reflect.Zero(reflect.ChanOf(reflect.BothDir,
+		reflect.TypeOf(&sender{})))
+	for todo := len(waiting); todo > 0; todo-- {
+		j, recv, _ := reflect.Select(cases)
+		if j == len(waiting) { // the context was cancelled
+			for j, i := range waiting {
+				if cases[j].Chan != disabled {
+					batch.setError(i, batch.ctx.Err())
+				}
+			}
+			return
+		}
+		cases[j].Chan = disabled
+		wb, _ := recv.Interface().(*sender)
+		batch.setError(waiting[j], batch.send(wb))
+	}
+}
+
+func (batch *broadcastBatch) setError(i int, err error) {
+	if err == nil {
+		return
+	}
+	batch.res.Lock()
+	batch.res.errors[batch.offset+i] = err
+	batch.res.Unlock()
+}
+
+// send transmits the message using wb, which has been taken from the
+// senderStore of a client.  A nil value indicates a closed connection.
+func (batch *broadcastBatch) send(wb *sender) error {
+	if wb == nil {
+		return ErrConnClosed
+	}
+	defer wb.release()
+
+	if wb.isShuttingDown() {
+		return ErrConnClosed
+	}
+	if wb.mask {
+		// client connections need a new masking key for every frame
+		return wb.sendFrame(batch.tp, batch.msg, true)
+	}
+	return wb.sendPrepared(batch.tp, batch.header, batch.msg)
+}
@@ -0,0 +1,189 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	const numClients = 100
+
+	conns := make(chan *Conn, numClients)
+	server, err := StartTestServer(func(c *Conn) {
+		conns <- c
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var clients []*TestClient
+	var serverConns []*Conn
+	for i := 0; i < numClients; i++ {
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		serverConns = append(serverConns, <-conns)
+	}
+
+	// The last connection is closed, sending to it must fail.
+	serverConns[numClients-1].Close(StatusOK, "")
+	_, _, err = clients[numClients-1].ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBroadcaster(4)
+	defer b.Close()
+	errors := b.BroadcastText(context.Background(), serverConns, "hello")
+	if len(errors) != 1 || errors[numClients-1] != ErrConnClosed {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	for i := 0; i < numClients-1; i++ {
+		tp, body, err := clients[i].ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != Text || string(body) != "hello" {
+			t.Errorf("client %d: wrong message %s %q", i, tp, body)
+		}
+	}
+
+	for _, conn := range serverConns[:numClients-1] {
+		conn.Close(StatusOK, "")
+	}
+}
+
+func TestBroadcasterBlocked(t *testing.T) {
+	const numClients = 10
+
+	conns := make(chan *Conn, numClients)
+	server, err := StartTestServer(func(c *Conn) {
+		conns <- c
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var clients []*TestClient
+	var serverConns []*Conn
+	for i := 0; i < numClients; i++ {
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		serverConns = append(serverConns, <-conns)
+	}
+
+	// The sender of the first connection is held, so that the
+	// broadcast to this client cannot proceed.
+	wb := <-serverConns[0].senderStore
+
+	b := NewBroadcaster(1)
+	defer b.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan map[int]error)
+	go func() {
+		done <- b.BroadcastText(ctx, serverConns, "hello")
+	}()
+
+	// all other clients must be served while the first one is blocked
+	for i := 1; i < numClients; i++ {
+		tp, body, err := clients[i].ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != Text || string(body) != "hello" {
+			t.Errorf("client %d: wrong message %s %q", i, tp, body)
+		}
+	}
+
+	cancel()
+	errors := <-done
+	if len(errors) != 1 || errors[0] != context.Canceled {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	wb.release()
+	for _, conn := range serverConns {
+		conn.Close(StatusOK, "")
+	}
+}
+
+// TestBroadcasterLarge checks that large messages, which are sent without
+// copying, arrive intact.
+func TestBroadcasterLarge(t *testing.T) {
+	const numClients = 5
+
+	conns := make(chan *Conn, numClients)
+	server, err := StartTestServer(func(c *Conn) {
+		conns <- c
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	msg := make([]byte, 100000)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+
+	var serverConns []*Conn
+	results := make(chan error, numClients)
+	for i := 0; i < numClients; i++ {
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		serverConns = append(serverConns, <-conns)
+		go func() {
+			tp, body, err := client.ReadFrame()
+			if err == nil && (tp != Binary || !bytes.Equal(body, msg)) {
+				err = fmt.Errorf("wrong message %s, %d bytes", tp, len(body))
+			}
+			results <- err
+		}()
+	}
+
+	b := NewBroadcaster(2)
+	defer b.Close()
+	errors := b.BroadcastBinary(context.Background(), serverConns, msg)
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	for i := 0; i < numClients; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+	for _, conn := range serverConns {
+		conn.Close(StatusOK, "")
+	}
+}
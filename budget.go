@@ -0,0 +1,107 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "sync"
+
+// SlowConsumerPolicy determines what happens to the connection with the
+// most queued data, when a SendBudget is exhausted.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerClose discards the queued messages of the connection
+	// and closes the connection with status StatusPolicyViolation.
+	SlowConsumerClose SlowConsumerPolicy = iota
+
+	// SlowConsumerDrop discards the queued messages of the connection,
+	// but keeps the connection open.
+	SlowConsumerDrop
+)
+
+// SendBudget limits the total number of bytes held in the SendQueues of
+// many connections.  When adding a message would exceed the limit, Policy
+// is applied to the queue holding the most data, until the message fits.
+// This prevents a few slow clients from using up all memory, for example
+// during a broadcast storm.
+//
+// To use a SendBudget, set the SendBudget field of the Handler.  The
+// fields must not be changed once the budget is in use.
+type SendBudget struct {
+	Limit  int64 // maximum number of queued bytes
+	Policy SlowConsumerPolicy
+
+	mu     sync.Mutex
+	used   int64
+	queues map[*SendQueue]struct{}
+}
+
+// Used returns the number of bytes currently held in all queues.
+func (b *SendBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+func (b *SendBudget) add(q *SendQueue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queues == nil {
+		b.queues = make(map[*SendQueue]struct{})
+	}
+	b.queues[q] = struct{}{}
+}
+
+func (b *SendBudget) remove(q *SendQueue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.queues, q)
+}
+
+// reserve makes room for n more bytes.  If this is not possible, even
+// after applying the policy to all queues holding data, ErrQueueFull is
+// returned.
+func (b *SendBudget) reserve(n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used+n > b.Limit {
+		var worst *SendQueue
+		var worstSize int64
+		for q := range b.queues {
+			if size := q.queuedBytes(); size > worstSize {
+				worst = q
+				worstSize = size
+			}
+		}
+		if worst == nil {
+			return ErrQueueFull
+		}
+		b.used -= worst.drop(b.Policy == SlowConsumerClose)
+	}
+	b.used += n
+	return nil
+}
+
+// release returns n bytes to the budget.
+func (b *SendBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
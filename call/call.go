@@ -0,0 +1,217 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package call matches responses to requests sent over a websocket
+// connection.
+//
+// Many protocols on top of websockets allow both sides to send requests at
+// any time, and responses can arrive in any order.  To match responses to
+// requests, every request is tagged with a correlation ID, and the
+// response carries the same ID.  How the ID is stored in the message is
+// determined by a [Protocol].  A [Caller] allocates the IDs, keeps track
+// of outstanding requests and delivers each response to the waiting
+// [Caller.Call].
+package call
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"seehuhn.de/go/websocket"
+)
+
+// DefaultMaxMessageSize is the default limit for the size of received
+// messages, see [Caller.SetMaxMessageSize].
+const DefaultMaxMessageSize = 1 << 20
+
+// Protocol describes how correlation IDs are stored in messages.
+type Protocol interface {
+	// Tag returns the message which sends the given request with the
+	// correlation ID id.
+	Tag(id uint64, request []byte) ([]byte, error)
+
+	// Match checks whether msg is a response.  If so, the correlation ID
+	// of the corresponding request is returned, and ok is true.
+	Match(tp websocket.MessageType, msg []byte) (id uint64, ok bool)
+}
+
+// Caller sends requests over a websocket connection and matches the
+// responses.  While a Caller is in use, it reads all messages from the
+// connection; the application must not call the Receive* methods of the
+// connection itself.
+type Caller struct {
+	conn      *websocket.Conn
+	proto     Protocol
+	tp        websocket.MessageType
+	unmatched func(tp websocket.MessageType, msg []byte)
+
+	mu             sync.Mutex
+	nextID         uint64
+	pending        map[uint64]chan []byte
+	err            error // set once the connection has been closed
+	maxMessageSize int
+
+	done chan struct{}
+}
+
+// NewCaller starts a new Caller for conn.  Requests are sent as messages of
+// type tp.  Received messages which are not responses to a pending call
+// (for example requests from the peer, or responses which arrive after
+// the call has been cancelled) are passed to unmatched, if unmatched is
+// non-nil.  The function unmatched is called from the goroutine which reads
+// from the connection; no further messages are read until unmatched
+// returns.
+func NewCaller(conn *websocket.Conn, proto Protocol, tp websocket.MessageType,
+	unmatched func(tp websocket.MessageType, msg []byte)) *Caller {
+	c := &Caller{
+		conn:      conn,
+		proto:     proto,
+		tp:        tp,
+		unmatched: unmatched,
+		pending:   make(map[uint64]chan []byte),
+		done:      make(chan struct{}),
+
+		maxMessageSize: DefaultMaxMessageSize,
+	}
+	go c.readLoop()
+	return c
+}
+
+// SetMaxMessageSize sets the maximal size of received messages.  If a
+// larger message is received, the message is discarded, the connection is
+// closed with status websocket.StatusTooLarge, and all pending calls fail
+// with websocket.ErrTooLarge.
+func (c *Caller) SetMaxMessageSize(n int) {
+	c.mu.Lock()
+	c.maxMessageSize = n
+	c.mu.Unlock()
+}
+
+// Call sends a request and waits for the response.  The returned message
+// is the complete response, as received from the peer.
+//
+// If ctx is cancelled before the response arrives, Call returns ctx.Err().
+// Use context.WithTimeout to limit the time spent waiting.  If the
+// connection is closed before the response arrives,
+// websocket.ErrConnClosed is returned.
+func (c *Caller) Call(ctx context.Context, request []byte) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	msg, err := c.proto.Tag(id, request)
+	if err == nil {
+		err = c.send(msg)
+	}
+	if err != nil {
+		c.cancel(id)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-c.done:
+		// A response may have arrived just before the connection was
+		// closed.
+		select {
+		case resp := <-ch:
+			return resp, nil
+		default:
+		}
+		return nil, c.err
+	case <-ctx.Done():
+		c.cancel(id)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Caller) send(msg []byte) error {
+	if c.tp == websocket.Text {
+		return c.conn.SendText(string(msg))
+	}
+	return c.conn.SendBinary(msg)
+}
+
+func (c *Caller) cancel(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Done returns a channel which is closed once the connection has been
+// closed and all pending calls have failed.
+func (c *Caller) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Caller) readLoop() {
+	var err error
+	for {
+		var tp websocket.MessageType
+		var r io.Reader
+		tp, r, err = c.conn.ReceiveMessage()
+		if err != nil {
+			break
+		}
+		c.mu.Lock()
+		limit := c.maxMessageSize
+		c.mu.Unlock()
+		var msg []byte
+		msg, err = io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			break
+		}
+		if len(msg) > limit {
+			io.Copy(io.Discard, r)
+			c.conn.Close(websocket.StatusTooLarge, "")
+			err = websocket.ErrTooLarge
+			break
+		}
+
+		if id, ok := c.proto.Match(tp, msg); ok {
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+				continue
+			}
+		}
+		if c.unmatched != nil {
+			c.unmatched(tp, msg)
+		}
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = websocket.ErrConnClosed
+	}
+	c.mu.Lock()
+	c.err = err
+	c.pending = nil
+	c.mu.Unlock()
+	close(c.done)
+}
@@ -0,0 +1,153 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+// textProtocol sends requests as "q<id> <body>" and expects responses of
+// the form "r<id> <body>".
+type textProtocol struct{}
+
+func (textProtocol) Tag(id uint64, request []byte) ([]byte, error) {
+	return []byte(fmt.Sprintf("q%d %s", id, request)), nil
+}
+
+func (textProtocol) Match(tp websocket.MessageType, msg []byte) (uint64, bool) {
+	s := string(msg)
+	if !strings.HasPrefix(s, "r") {
+		return 0, false
+	}
+	idx := strings.IndexByte(s, ' ')
+	if idx < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s[1:idx], 10, 64)
+	return id, err == nil
+}
+
+// server answers requests in a separate goroutine each, converting the
+// body to upper case.  Requests with body "ignore" are not answered, and
+// the request "close" closes the connection.
+func server(conn *websocket.Conn) {
+	conn.SendText("hello")
+	for {
+		msg, err := conn.ReceiveText(1024)
+		if err != nil {
+			break
+		}
+		idx := strings.IndexByte(msg, ' ')
+		id, body := msg[1:idx], msg[idx+1:]
+		switch body {
+		case "ignore":
+			continue
+		case "close":
+			conn.Close(websocket.StatusOK, "")
+			return
+		}
+		go conn.SendText("r" + id + " " + strings.ToUpper(body))
+	}
+	conn.Close(websocket.StatusOK, "")
+}
+
+func TestCall(t *testing.T) {
+	s := httptest.NewServer(&websocket.Handler{Handle: server})
+	defer s.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(s.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unmatched := make(chan string, 1)
+	c := NewCaller(conn, textProtocol{}, websocket.Text,
+		func(tp websocket.MessageType, msg []byte) {
+			unmatched <- string(msg)
+		})
+
+	if msg := <-unmatched; msg != "hello" {
+		t.Errorf("unexpected unmatched message %q", msg)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf("call %d", i)
+			resp, err := c.Call(context.Background(), []byte(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !strings.HasSuffix(string(resp), " "+strings.ToUpper(body)) {
+				t.Errorf("wrong response %q for %q", resp, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = c.Call(ctx, []byte("ignore"))
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	_, err = c.Call(context.Background(), []byte("close"))
+	if !errors.Is(err, websocket.ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	<-c.Done()
+	_, err = c.Call(context.Background(), []byte("late"))
+	if !errors.Is(err, websocket.ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	conn.Close(websocket.StatusOK, "")
+}
+
+func TestTooLarge(t *testing.T) {
+	s := httptest.NewServer(&websocket.Handler{Handle: server})
+	defer s.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(s.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCaller(conn, textProtocol{}, websocket.Text, nil)
+	c.SetMaxMessageSize(10)
+
+	_, err = c.Call(context.Background(), []byte("a long request"))
+	if err != websocket.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	<-c.Done()
+	conn.Wait()
+}
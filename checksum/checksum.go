@@ -0,0 +1,141 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package checksum adds a CRC-32 checksum to every message sent over a
+// websocket connection, and verifies the checksum on receive.
+//
+// Websocket frames are protected by the TCP checksum only, which is too
+// weak to reliably detect corruption by faulty network equipment on long
+// links.  The checksum detects accidental corruption, but offers no
+// protection against deliberate modification; use the envelope package
+// for this.
+//
+// The checksum is computed over the payload using the Castagnoli
+// polynomial (CRC-32C).  Binary messages consist of the checksum as a 4
+// byte big-endian integer, followed by the payload.  Text messages consist
+// of the checksum as 8 lower-case hexadecimal digits, a '.' character, and
+// the payload.
+package checksum
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"seehuhn.de/go/websocket"
+)
+
+// DefaultMaxMessageSize is the default value for Conn.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 20
+
+const (
+	sumSize        = 4
+	encodedSumSize = 2 * sumSize
+)
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Conn adds checksums to outgoing messages, and verifies the checksums of
+// incoming messages.
+type Conn struct {
+	// MaxMessageSize is the maximal size of received messages, including
+	// the checksum.  This must be set before the first call to Receive.
+	MaxMessageSize int
+
+	ws *websocket.Conn
+}
+
+// NewConn returns a new Conn which sends and receives messages on ws.
+// Both sides of the connection must use the checksum package.
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		ws:             ws,
+	}
+}
+
+// Send adds a checksum to the payload and sends the message.
+func (c *Conn) Send(tp websocket.MessageType, payload []byte) error {
+	var sum [sumSize]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(payload, table))
+
+	switch tp {
+	case websocket.Text:
+		msg := make([]byte, encodedSumSize+1+len(payload))
+		hex.Encode(msg, sum[:])
+		msg[encodedSumSize] = '.'
+		copy(msg[encodedSumSize+1:], payload)
+		return c.ws.SendText(string(msg))
+	case websocket.Binary:
+		msg := make([]byte, sumSize+len(payload))
+		copy(msg, sum[:])
+		copy(msg[sumSize:], payload)
+		return c.ws.SendBinary(msg)
+	default:
+		return websocket.ErrMessageType
+	}
+}
+
+// Receive reads the next message and verifies its checksum.  The payload
+// is returned without the checksum.  If the checksum does not match, the
+// connection is closed with status StatusInvalidData and ErrChecksum is
+// returned.
+func (c *Conn) Receive() (websocket.MessageType, []byte, error) {
+	tp, r, err := c.ws.ReceiveMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	msg, err := io.ReadAll(io.LimitReader(r, int64(c.MaxMessageSize)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(msg) > c.MaxMessageSize {
+		io.Copy(io.Discard, r)
+		c.ws.Close(websocket.StatusTooLarge, "")
+		return 0, nil, websocket.ErrTooLarge
+	}
+
+	var sum, payload []byte
+	switch tp {
+	case websocket.Text:
+		if len(msg) > encodedSumSize && msg[encodedSumSize] == '.' {
+			sum = make([]byte, sumSize)
+			_, err = hex.Decode(sum, msg[:encodedSumSize])
+			payload = msg[encodedSumSize+1:]
+		}
+	case websocket.Binary:
+		if len(msg) >= sumSize {
+			sum, payload = msg[:sumSize], msg[sumSize:]
+		}
+	}
+	if sum == nil || err != nil ||
+		binary.BigEndian.Uint32(sum) != crc32.Checksum(payload, table) {
+		c.ws.Close(websocket.StatusInvalidData, "checksum mismatch")
+		return 0, nil, ErrChecksum
+	}
+	return tp, payload, nil
+}
+
+// Close closes the underlying websocket connection.
+func (c *Conn) Close(code websocket.Status, message string) error {
+	return c.ws.Close(code, message)
+}
+
+// ErrChecksum is returned by Receive if the checksum of a message does not
+// match the payload.
+var ErrChecksum = errors.New("checksum: message corrupted")
@@ -0,0 +1,124 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+func dial(t *testing.T, handle func(*websocket.Conn)) (*websocket.Conn, func()) {
+	t.Helper()
+	server := httptest.NewServer(&websocket.Handler{Handle: handle})
+	ws, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, func() {
+		ws.Close(websocket.StatusOK, "")
+		ws.Wait()
+		server.Close()
+	}
+}
+
+// echo returns all messages to the client, until an error occurs.
+func echo(errors chan<- error) func(*websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		c := NewConn(ws)
+		for {
+			tp, msg, err := c.Receive()
+			if err != nil {
+				errors <- err
+				break
+			}
+			err = c.Send(tp, msg)
+			if err != nil {
+				errors <- err
+				break
+			}
+		}
+		c.Close(websocket.StatusOK, "")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	serverErr := make(chan error, 1)
+	ws, cleanup := dial(t, echo(serverErr))
+	defer cleanup()
+
+	c := NewConn(ws)
+	msgs := []struct {
+		tp   websocket.MessageType
+		data string
+	}{
+		{websocket.Text, "hello"},
+		{websocket.Binary, "\x00\x01\x02"},
+		{websocket.Text, ""},
+		{websocket.Binary, ""},
+	}
+	for _, m := range msgs {
+		err := c.Send(m.tp, []byte(m.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, data, err := c.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != m.tp || string(data) != m.data {
+			t.Errorf("wrong message %s %q", tp, data)
+		}
+	}
+
+	err := c.Close(websocket.StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-serverErr; !errors.Is(err, websocket.ErrConnClosed) {
+		t.Errorf("unexpected server error %v", err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	for _, msg := range []string{
+		"9a71bb4c.hellp", // checksum of "hello"
+		"hello",
+		"",
+	} {
+		serverErr := make(chan error, 1)
+		ws, cleanup := dial(t, echo(serverErr))
+
+		err := ws.SendText(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-serverErr; err != ErrChecksum {
+			t.Errorf("%q: expected ErrChecksum, got %v", msg, err)
+		}
+		_, status, _ := ws.Wait()
+		if status != websocket.StatusInvalidData {
+			t.Errorf("%q: wrong status %d", msg, status)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,545 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Dialer contains options for connecting to a websocket server.
+// The zero value is a valid configuration.
+type Dialer struct {
+	// Header contains additional HTTP header fields to send in the
+	// handshake request, for example an Origin header or cookies.
+	Header http.Header
+
+	// TLSConfig is used for connections to "wss" URLs.  This can be used
+	// to configure custom root certificates or client certificates.  If
+	// TLSConfig.ServerName is empty, the host name from the URL is used.
+	// Since the websocket handshake requires HTTP/1.1, all ALPN protocols
+	// other than "http/1.1" are removed from TLSConfig.NextProtos.  If
+	// TLSConfig is nil, the default configuration is used.
+	TLSConfig *tls.Config
+
+	// NetDial, if set, is used by Dial to open the network connection,
+	// instead of a net.Dialer.  The network is "tcp", or "unix" for
+	// "ws+unix" URLs.  This can be used to connect through a proxy or to
+	// use custom socket options.  If NetDial is set, Resolver and
+	// FallbackDelay are ignored.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Resolver, if set, is used to look up the host names of servers.
+	Resolver *net.Resolver
+
+	// FallbackDelay controls the dual-stack fallback ("Happy Eyeballs")
+	// for host names which resolve to both IPv6 and IPv4 addresses: if
+	// no connection to the preferred address family has been established
+	// after this delay, connections using the other address family are
+	// attempted in parallel.  This avoids long hangs on networks with
+	// broken IPv6 connectivity.  If FallbackDelay is zero, a default of
+	// 300ms is used; a negative value disables the fallback.  See
+	// net.Dialer.FallbackDelay for details.
+	FallbackDelay time.Duration
+
+	// TraceHooks, if set, is used to trace the frames sent and received
+	// on connections established by the Dialer.
+	TraceHooks *TraceHooks
+
+	// ValidateOutgoingText, if set, makes SendText and SendMessage(Text)
+	// check that the message is valid utf-8, see
+	// [Handler.ValidateOutgoingText].
+	ValidateOutgoingText bool
+
+	// Subprotocols lists the websocket sub-protocols offered to the
+	// server, in decreasing order of preference.  The protocol selected
+	// by the server is available in the Protocol field of the returned
+	// Conn; this is empty if the server did not select a protocol.  If
+	// the server selects a protocol which was not offered, Dial returns
+	// [ErrBadHandshake].  If set, Subprotocols replaces any
+	// Sec-WebSocket-Protocol field in Header.
+	Subprotocols []string
+
+	// Host, if set, is sent in the Host header of the handshake request,
+	// instead of the host given in the URL.  For "ws+unix" URLs, which do
+	// not contain a host name, the default is "localhost".
+	Host string
+
+	// RSVExtensions lists experimental extensions which use the reserved
+	// bits of the frame header, see [Handler.RSVExtensions].  Extensions
+	// with a name are offered to the server in the handshake.
+	RSVExtensions []RSVExtension
+
+	// AutoDrain, if set, makes the Receive functions discard the unread
+	// part of a message returned by a previous call to ReceiveMessage, see
+	// [Handler.AutoDrain].
+	AutoDrain bool
+
+	// IdleTimeout and IdleGrace, if IdleTimeout is positive, close
+	// connections on which no data has been received for a while, see
+	// [Handler.IdleTimeout].
+	IdleTimeout time.Duration
+	IdleGrace   time.Duration
+
+	// ControlFrameLimit and UnsolicitedPongLimit, if positive, disconnect
+	// servers which send too many control frames, see
+	// [Handler.ControlFrameLimit] and [Handler.UnsolicitedPongLimit].
+	ControlFrameLimit    int
+	UnsolicitedPongLimit int
+
+	// ReadLimit is the maximal length of messages returned by
+	// Conn.Receive, see [Handler.ReadLimit].
+	ReadLimit int
+
+	// MaxRedirects is the maximal number of HTTP redirects which Dial
+	// follows during the handshake.  If MaxRedirects is zero, redirects
+	// are not followed and [ErrBadHandshake] is returned instead.
+	// Redirect targets with "http" and "https" schemes are converted to
+	// "ws" and "wss", respectively.  Redirects from "wss" to "ws" are
+	// rejected, as are redirects for "ws+unix" URLs and redirects received
+	// by DialConn.  As in net/http, the Host override is dropped when a
+	// redirect leads to a different host, and the Authorization and Cookie
+	// header fields are only sent to the original domain and its
+	// subdomains.
+	MaxRedirects int
+
+	onPing func(body []byte) // used by ProxyHandler
+}
+
+// DefaultDialer is a Dialer with all fields set to their default values.
+var DefaultDialer = &Dialer{}
+
+// Dial opens a websocket connection to the server at the given URL.  The
+// URL must use the "ws", "wss" or "ws+unix" scheme.  The context is used
+// for establishing the connection and for the websocket handshake, it has
+// no effect on the returned connection.
+//
+// URLs with the "ws+unix" scheme connect to a server listening on a Unix
+// domain socket.  The URL contains the path of the socket and the
+// resource name, separated by a colon, for example
+// "ws+unix:///run/app.sock:/api/ws".  If the resource name is omitted,
+// "/" is used.
+//
+// If the server rejects the websocket handshake, [ErrBadHandshake] is
+// returned.
+func (d *Dialer) Dial(ctx context.Context, urlStr string) (*Conn, error) {
+	u, socket, err := parseURL(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	orig := u
+	hop := d
+	for hops := 0; ; hops++ {
+		conn, err := hop.dial(ctx, u, socket)
+		redirect, ok := err.(*redirectError)
+		if !ok {
+			return conn, err
+		}
+		if socket != "" {
+			return nil, ErrBadHandshake
+		}
+		if hops >= d.MaxRedirects {
+			return nil, errRedirectLimit
+		}
+		u, err = redirectTarget(u, redirect.location)
+		if err != nil {
+			return nil, err
+		}
+		hop = d.redirectDialer(orig, u)
+	}
+}
+
+// dial opens a network connection to the server at u, or to the Unix
+// domain socket if socket is non-empty, and performs the handshake.
+func (d *Dialer) dial(ctx context.Context, u *url.URL, socket string) (*Conn, error) {
+	network := "tcp"
+	addr := u.Host
+	if socket != "" {
+		network = "unix"
+		addr = socket
+	} else if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dial := d.NetDial
+	if dial == nil {
+		netDialer := &net.Dialer{
+			Resolver:      d.Resolver,
+			FallbackDelay: d.FallbackDelay,
+		}
+		dial = netDialer.DialContext
+	}
+	raw, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.handshake(ctx, raw, u)
+}
+
+// DialConn opens a websocket connection over an existing network
+// connection, for example a connection through a tunnel or a connection
+// which was established by other means.  For "wss" URLs, the TLS handshake
+// is performed on raw before the websocket handshake.  The host in the
+// URL is only used for the handshake request and for the TLS server name;
+// no new network connection is made.
+//
+// If the handshake fails, raw is closed.
+func (d *Dialer) DialConn(ctx context.Context, raw net.Conn, urlStr string) (*Conn, error) {
+	u, _, err := parseURL(urlStr)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	conn, err := d.handshake(ctx, raw, u)
+	if _, ok := err.(*redirectError); ok {
+		err = ErrBadHandshake
+	}
+	return conn, err
+}
+
+// NewClientConn performs the client side of the websocket handshake over
+// the existing network connection raw, using the options of DefaultDialer.
+// The connection can for example be a TLS connection, a connection through
+// a proxy, or one end of a net.Pipe, see [Dialer.DialConn] for details.
+func NewClientConn(ctx context.Context, raw net.Conn, urlStr string) (*Conn, error) {
+	return DefaultDialer.DialConn(ctx, raw, urlStr)
+}
+
+// parseURL parses a websocket URL.  For "ws+unix" URLs, the path of the
+// socket is returned separately, and the URL is converted into an
+// equivalent "ws" URL with host "localhost".
+func parseURL(urlStr string) (*url.URL, string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+		return u, "", nil
+	case "ws+unix":
+		// handled below
+	default:
+		return nil, "", errURLScheme
+	}
+
+	socket := u.Path
+	resource := "/"
+	if idx := strings.IndexByte(socket, ':'); idx >= 0 {
+		socket, resource = socket[:idx], socket[idx+1:]
+	}
+	if socket == "" || u.Host != "" || !strings.HasPrefix(resource, "/") {
+		return nil, "", errUnixURL
+	}
+	wsURL := &url.URL{
+		Scheme:   "ws",
+		Host:     "localhost",
+		Path:     resource,
+		RawQuery: u.RawQuery,
+	}
+	return wsURL, socket, nil
+}
+
+// handshake performs the TLS handshake (for "wss" URLs) and the websocket
+// handshake on raw.  On failure, raw is closed.
+func (d *Dialer) handshake(ctx context.Context, raw net.Conn, u *url.URL) (*Conn, error) {
+	// Abort the handshake, if the context is cancelled.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			raw.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		raw.SetDeadline(deadline)
+	}
+
+	conn, nc, rw, err := d.doHandshake(ctx, raw, u)
+	close(stop)
+	<-stopped
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+
+	conn.initialize(nc, rw)
+	return conn, nil
+}
+
+// doHandshake performs the handshakes and returns the new connection object,
+// together with the network connection to use (a *tls.Conn for "wss" URLs)
+// and the buffers for that connection.
+func (d *Dialer) doHandshake(ctx context.Context, raw net.Conn, u *url.URL) (*Conn, net.Conn, *bufio.ReadWriter, error) {
+	nc := raw
+	if u.Scheme == "wss" {
+		var config *tls.Config
+		if d.TLSConfig != nil {
+			config = d.TLSConfig.Clone()
+		} else {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		var protos []string
+		for _, proto := range config.NextProtos {
+			if proto == "http/1.1" {
+				protos = append(protos, proto)
+			}
+		}
+		config.NextProtos = protos
+		tlsConn := tls.Client(raw, config)
+		err := tlsConn.Handshake()
+		if err != nil {
+			return nil, nil, nil, handshakeError(ctx, err)
+		}
+		proto := tlsConn.ConnectionState().NegotiatedProtocol
+		if proto != "" && proto != "http/1.1" {
+			return nil, nil, nil, ErrBadHandshake
+		}
+		nc = tlsConn
+	}
+
+	nonce := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: "GET",
+		URL: &url.URL{
+			Scheme:   "http",
+			Host:     u.Host,
+			Path:     u.Path,
+			RawPath:  u.RawPath,
+			RawQuery: u.RawQuery,
+		},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if d.Host != "" {
+		req.Host = d.Host
+	}
+	for name, values := range d.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if offer := offerRSVExtensions(d.RSVExtensions); offer != "" {
+		req.Header.Set("Sec-WebSocket-Extensions", offer)
+	}
+
+	w := bufio.NewWriter(nc)
+	err = req.Write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return nil, nil, nil, handshakeError(ctx, err)
+	}
+
+	r := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, nil, nil, handshakeError(ctx, err)
+	}
+	resp.Body.Close()
+	if isRedirect(resp.StatusCode) && d.MaxRedirects > 0 {
+		location := resp.Header.Get("Location")
+		if location != "" {
+			return nil, nil, nil, &redirectError{location: location}
+		}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!containsTokenFold(resp.Header.Values("Upgrade"), "websocket") ||
+		!containsTokenFold(resp.Header.Values("Connection"), "upgrade") ||
+		resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, nil, nil, ErrBadHandshake
+	}
+	extensions, rsvBits, ok := confirmRSVExtensions(d.RSVExtensions,
+		resp.Header.Values("Sec-Websocket-Extensions"))
+	if !ok {
+		return nil, nil, nil, ErrBadHandshake
+	}
+	protocol := resp.Header.Get("Sec-Websocket-Protocol")
+	if protocol != "" && !d.offersProtocol(protocol) {
+		return nil, nil, nil, ErrBadHandshake
+	}
+
+	conn := &Conn{
+		ResourceName: u.RequestURI(),
+		RemoteAddr:   raw.RemoteAddr().String(),
+		Protocol:     protocol,
+		HandshakeKey: key,
+
+		isClient:     true,
+		extensions:   extensions,
+		rsvBits:      rsvBits,
+		validateText: d.ValidateOutgoingText,
+		autoDrain:    d.AutoDrain,
+		idleTimeout:  d.IdleTimeout,
+		idleGrace:    d.IdleGrace,
+		controlLimit: d.ControlFrameLimit,
+		pongLimit:    d.UnsolicitedPongLimit,
+		readLimit:    int64(d.ReadLimit),
+		onPing:       d.onPing,
+		trace:        newTraceState(d.TraceHooks),
+	}
+	return conn, nc, bufio.NewReadWriter(r, w), nil
+}
+
+// offersProtocol reports whether the sub-protocol selected by the server
+// was offered in the handshake request.  If Subprotocols is empty, the
+// offer may have been given in the Header field instead.
+func (d *Dialer) offersProtocol(protocol string) bool {
+	offered := d.Subprotocols
+	if len(offered) == 0 {
+		for _, value := range d.Header.Values("Sec-Websocket-Protocol") {
+			offered = append(offered, strings.Split(value, ",")...)
+		}
+	}
+	for _, p := range offered {
+		if strings.TrimSpace(p) == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// handshakeError returns the context error, if the handshake was aborted
+// because the context was cancelled, and err otherwise.
+func handshakeError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// redirectError is returned by doHandshake, if the server redirected the
+// handshake request and the Dialer is configured to follow redirects.
+type redirectError struct {
+	location string
+}
+
+func (err *redirectError) Error() string {
+	return "websocket handshake redirected to " + err.location
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectTarget resolves the Location header of a redirect response,
+// relative to the URL u of the redirected request.
+func redirectTarget(u *url.URL, location string) (*url.URL, error) {
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	target := u.ResolveReference(ref)
+	switch target.Scheme {
+	case "http":
+		target.Scheme = "ws"
+	case "https":
+		target.Scheme = "wss"
+	case "ws", "wss":
+		// nothing to do
+	default:
+		return nil, errRedirectScheme
+	}
+	if u.Scheme == "wss" && target.Scheme != "wss" {
+		return nil, errRedirectScheme
+	}
+	if target.Host == "" {
+		return nil, errRedirectScheme
+	}
+	return target, nil
+}
+
+// sensitiveHeaders lists the header fields which are not sent to a
+// different domain when following a redirect, as in net/http.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Www-Authenticate",
+	"Cookie",
+	"Cookie2",
+}
+
+// redirectDialer returns the Dialer to use for a redirect from the URL
+// orig of the original request to target.  If target is on a different
+// host, the Host override is not used.  If target is not in the domain of
+// orig or one of its subdomains, the sensitive header fields are removed.
+func (d *Dialer) redirectDialer(orig, target *url.URL) *Dialer {
+	origHost := strings.ToLower(orig.Hostname())
+	targetHost := strings.ToLower(target.Hostname())
+	if targetHost == origHost {
+		return d
+	}
+
+	hop := *d
+	hop.Host = ""
+	if !strings.HasSuffix(targetHost, "."+origHost) {
+		hop.Header = d.Header.Clone()
+		for _, name := range sensitiveHeaders {
+			hop.Header.Del(name)
+		}
+	}
+	return &hop
+}
+
+var (
+	errURLScheme      = errors.New("unsupported URL scheme")
+	errUnixURL        = errors.New("invalid ws+unix URL")
+	errRedirectLimit  = errors.New("too many redirects in websocket handshake")
+	errRedirectScheme = errors.New("invalid redirect in websocket handshake")
+)
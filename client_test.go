@@ -0,0 +1,612 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDial(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: echo})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url+"/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.ResourceName != "/echo" {
+		t.Errorf("wrong resource name %q", conn.ResourceName)
+	}
+	if ext := conn.Extensions(); len(ext) != 0 {
+		t.Errorf("unexpected extensions %v", ext)
+	}
+
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := conn.ReceiveText(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hello" {
+		t.Errorf("wrong text message %q", text)
+	}
+
+	// a message which does not fit into the write buffer
+	msg := make([]byte, 100000)
+	for i := range msg {
+		msg[i] = byte(i % 251)
+	}
+	err = conn.SendBinary(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2*len(msg))
+	n, err := conn.ReceiveBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Error("wrong binary message")
+	}
+
+	err = conn.Close(StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, status, _ := conn.Wait()
+	if info != ServerClosed || status != StatusOK {
+		t.Errorf("unexpected close: %d %d", info, status)
+	}
+}
+
+func TestNetAddr(t *testing.T) {
+	serverAddr := make(chan string, 1)
+	server := httptest.NewServer(&Handler{
+		Handle: func(conn *Conn) {
+			serverAddr <- conn.Network() + " " + conn.LocalNetAddr().String() +
+				" " + conn.RemoteNetAddr().String()
+			conn.Close(StatusOK, "")
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Wait()
+
+	if conn.Network() != "tcp" {
+		t.Errorf("wrong network %q", conn.Network())
+	}
+	if conn.RemoteNetAddr().String() != server.Listener.Addr().String() {
+		t.Errorf("wrong remote address %s", conn.RemoteNetAddr())
+	}
+	expected := "tcp " + conn.RemoteNetAddr().String() + " " + conn.LocalNetAddr().String()
+	if got := <-serverAddr; got != expected {
+		t.Errorf("wrong server side addresses %q != %q", got, expected)
+	}
+}
+
+func TestDialRejected(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, err := DefaultDialer.Dial(context.Background(), url)
+	if err != ErrBadHandshake {
+		t.Errorf("expected ErrBadHandshake, got %v", err)
+	}
+}
+
+// TestDialALPN checks that the dialer does not offer HTTP/2 to the server,
+// since the websocket handshake requires HTTP/1.1.
+func TestDialALPN(t *testing.T) {
+	server := httptest.NewUnstartedServer(&Handler{Handle: echo})
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	dialer := &Dialer{
+		TLSConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "example.com",
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(StatusOK, "")
+	conn.Wait()
+
+	if len(dialer.TLSConfig.NextProtos) != 2 {
+		t.Error("TLSConfig was modified")
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	server := httptest.NewTLSServer(&Handler{Handle: echo})
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	dialer := &Dialer{
+		TLSConfig: &tls.Config{RootCAs: roots},
+	}
+
+	// The test certificate is valid for example.com, but not for the
+	// host name "localhost".
+	localURL := strings.Replace(url, "127.0.0.1", "localhost", 1)
+	_, err := dialer.Dial(context.Background(), localURL)
+	if err == nil {
+		t.Error("certificate for wrong host name accepted")
+	}
+
+	dialer.TLSConfig.ServerName = "example.com"
+	for _, u := range []string{url, localURL} {
+		conn, err := dialer.Dial(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conn.SendText("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := conn.ReceiveText(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != "hello" {
+			t.Errorf("wrong text message %q", text)
+		}
+		conn.Close(StatusOK, "")
+		conn.Wait()
+	}
+}
+
+func TestDialConn(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: echo})
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DefaultDialer.DialConn(context.Background(), raw,
+		"ws://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SendBinary([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, err := conn.ReceiveBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{1, 2, 3}) {
+		t.Errorf("wrong message % x", buf[:n])
+	}
+	conn.Close(StatusOK, "")
+	conn.Wait()
+}
+
+func TestDialUnix(t *testing.T) {
+	type result struct{ host, resource string }
+	results := make(chan result, 2)
+	server, err := StartTestServerWithHandler(&Handler{
+		OriginAllowed: func(*url.URL) bool { return true },
+		AccessAllowed: func(req *http.Request) (bool, interface{}) {
+			return true, req.Host
+		},
+		Handle: func(conn *Conn) {
+			results <- result{conn.RequestData.(string), conn.ResourceName}
+			conn.Close(StatusOK, "")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	testCases := []struct {
+		dialer   *Dialer
+		url      string
+		expected result
+	}{
+		{DefaultDialer, "ws+unix://" + server.addr.Name, result{"localhost", "/"}},
+		{&Dialer{Host: "example.com"}, "ws+unix://" + server.addr.Name + ":/chat",
+			result{"example.com", "/chat"}},
+	}
+	for _, tc := range testCases {
+		conn, err := tc.dialer.Dial(context.Background(), tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Wait()
+		if got := <-results; got != tc.expected {
+			t.Errorf("%s: wrong result %v", tc.url, got)
+		}
+	}
+
+	_, err = DefaultDialer.Dial(context.Background(), "ws+unix://host/sock")
+	if err != errUnixURL {
+		t.Errorf("expected errUnixURL, got %v", err)
+	}
+}
+
+func TestDialSubprotocols(t *testing.T) {
+	serverProto := make(chan string, 1)
+	server := httptest.NewServer(&Handler{
+		Subprotocols: []string{"v2", "v1"},
+		Handle: func(conn *Conn) {
+			serverProto <- conn.Protocol
+			conn.Close(StatusOK, "")
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	cases := []struct {
+		offer    []string
+		expected string
+	}{
+		{[]string{"v1", "v2"}, "v2"},
+		{[]string{"v1"}, "v1"},
+		{[]string{"v3"}, ""},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		dialer := &Dialer{Subprotocols: c.offer}
+		conn, err := dialer.Dial(context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Wait()
+		if conn.Protocol != c.expected {
+			t.Errorf("%q: wrong client protocol %q", c.offer, conn.Protocol)
+		}
+		if p := <-serverProto; p != c.expected {
+			t.Errorf("%q: wrong server protocol %q", c.offer, p)
+		}
+	}
+}
+
+func TestDialSubprotocolNotOffered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", acceptKey(req.Header.Get("Sec-WebSocket-Key")))
+		w.Header().Set("Sec-WebSocket-Protocol", "other")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialer := &Dialer{Subprotocols: []string{"v1"}}
+	_, err := dialer.Dial(context.Background(), url)
+	if err != ErrBadHandshake {
+		t.Errorf("expected ErrBadHandshake, got %v", err)
+	}
+}
+
+func TestDialRedirect(t *testing.T) {
+	target := httptest.NewServer(&Handler{
+		Handle: func(conn *Conn) {
+			conn.SendText(conn.ResourceName)
+			conn.Close(StatusOK, "")
+		},
+	})
+	defer target.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusFound))
+	mux.Handle("/b", http.RedirectHandler(target.URL+"/c", http.StatusTemporaryRedirect))
+	mux.Handle("/loop", http.RedirectHandler("/loop", http.StatusFound))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, err := DefaultDialer.Dial(context.Background(), url+"/a")
+	if err != ErrBadHandshake {
+		t.Errorf("expected ErrBadHandshake, got %v", err)
+	}
+
+	dialer := &Dialer{MaxRedirects: 1}
+	_, err = dialer.Dial(context.Background(), url+"/a")
+	if err != errRedirectLimit {
+		t.Errorf("expected errRedirectLimit, got %v", err)
+	}
+
+	dialer.MaxRedirects = 2
+	conn, err := dialer.Dial(context.Background(), url+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.ReceiveText(100)
+	if err != nil || msg != "/c" {
+		t.Errorf("wrong message %q %v", msg, err)
+	}
+	conn.Wait()
+
+	dialer.MaxRedirects = 10
+	_, err = dialer.Dial(context.Background(), url+"/loop")
+	if err != errRedirectLimit {
+		t.Errorf("expected errRedirectLimit, got %v", err)
+	}
+}
+
+func TestDialRedirectCrossHost(t *testing.T) {
+	type seen struct {
+		host, auth, cookie, accept string
+	}
+	requests := make(chan seen, 1)
+	location := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, <-location, http.StatusFound)
+	})
+	mux.Handle("/ws", &Handler{
+		AccessAllowed: func(r *http.Request) (bool, interface{}) {
+			requests <- seen{
+				host:   r.Host,
+				auth:   r.Header.Get("Authorization"),
+				cookie: r.Header.Get("Cookie"),
+				accept: r.Header.Get("Accept-Language"),
+			}
+			return true, nil
+		},
+		Handle: func(conn *Conn) {
+			conn.Close(StatusOK, "")
+		},
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	// All host names are mapped to the test server by NetDial.
+	netDialer := &net.Dialer{}
+	dialer := &Dialer{
+		Header: http.Header{
+			"Authorization":   {"Bearer secret"},
+			"Cookie":          {"session=secret"},
+			"Accept-Language": {"en"},
+		},
+		Host:         "virtual.example",
+		MaxRedirects: 1,
+		NetDial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return netDialer.DialContext(ctx, network, addr)
+		},
+	}
+
+	cases := []struct {
+		location string
+		expected seen
+	}{
+		{"ws://b.example/ws", seen{"b.example", "", "", "en"}},
+		{"ws://sub.a.example/ws", seen{"sub.a.example", "Bearer secret", "session=secret", "en"}},
+		{"/ws", seen{"virtual.example", "Bearer secret", "session=secret", "en"}},
+	}
+	for _, c := range cases {
+		location <- c.location
+		conn, err := dialer.Dial(context.Background(), "ws://a.example/start")
+		if err != nil {
+			t.Errorf("%s: %v", c.location, err)
+			continue
+		}
+		conn.Wait()
+		if got := <-requests; got != c.expected {
+			t.Errorf("%s: wrong request %v", c.location, got)
+		}
+	}
+	if dialer.Header.Get("Authorization") == "" || dialer.Host == "" {
+		t.Error("Dialer was modified")
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	cases := []struct {
+		from, location, expected string
+	}{
+		{"ws://a.example/x", "/y", "ws://a.example/y"},
+		{"ws://a.example/x", "https://b.example/y?q", "wss://b.example/y?q"},
+		{"wss://a.example/x", "wss://b.example/y", "wss://b.example/y"},
+		{"wss://a.example/x", "http://b.example/y", ""},
+		{"wss://a.example/x", "ws://b.example/y", ""},
+		{"ws://a.example/x", "ftp://b.example/y", ""},
+	}
+	for _, c := range cases {
+		from, _ := url.Parse(c.from)
+		target, err := redirectTarget(from, c.location)
+		if c.expected == "" {
+			if err != errRedirectScheme {
+				t.Errorf("%s -> %s: expected errRedirectScheme, got %v",
+					c.from, c.location, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s -> %s: %v", c.from, c.location, err)
+		} else if target.String() != c.expected {
+			t.Errorf("%s -> %s: got %s", c.from, c.location, target)
+		}
+	}
+}
+
+func TestDialerLimits(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		Handle: func(conn *Conn) {
+			// Without reading, pings from the client are not answered.
+			conn.PauseReading()
+			conn.SendText("too long")
+			conn.SendText("ok")
+			time.Sleep(300 * time.Millisecond)
+			conn.ResumeReading()
+			conn.Wait()
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialer := &Dialer{
+		ReadLimit:   4,
+		IdleTimeout: 50 * time.Millisecond,
+		IdleGrace:   50 * time.Millisecond,
+	}
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.Receive(context.Background())
+	if err != ErrTooLarge || string(msg.Data) != "too " {
+		t.Errorf("wrong result %q %v", msg.Data, err)
+	}
+	conn.SetReadLimit(100)
+	msg, err = conn.Receive(context.Background())
+	if err != nil || string(msg.Data) != "ok" {
+		t.Errorf("wrong result %q %v", msg.Data, err)
+	}
+
+	info := conn.CloseInfo()
+	if info.LocalStatus != StatusGoingAway || info.LocalMessage != "idle timeout" {
+		t.Errorf("wrong close info %v", info)
+	}
+}
+
+// pipeListener is a net.Listener which returns a single, given connection.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestNewClientConn(t *testing.T) {
+	a, b := net.Pipe()
+	l := &pipeListener{
+		conns: make(chan net.Conn, 1),
+		done:  make(chan struct{}),
+	}
+	l.conns <- a
+	defer l.Close()
+	go http.Serve(l, &Handler{Handle: echo})
+
+	conn, err := NewClientConn(context.Background(), b, "ws://localhost/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.ReceiveText(100)
+	if err != nil || msg != "hello" {
+		t.Errorf("wrong message %q %v", msg, err)
+	}
+	conn.Close(StatusOK, "")
+	info, _, _ := conn.Wait()
+	if info != ServerClosed {
+		t.Errorf("wrong connection info %d", info)
+	}
+}
+
+func TestDialNetDial(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: echo})
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	var dialed []string
+	dialer := &Dialer{
+		NetDial: func(ctx context.Context, network, a string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+a)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	conn, err := dialer.Dial(context.Background(), "ws://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(StatusOK, "")
+	conn.Wait()
+	if len(dialed) != 1 || dialed[0] != "tcp example.com:80" {
+		t.Errorf("wrong dial calls %q", dialed)
+	}
+}
+
+func TestDialResolver(t *testing.T) {
+	errResolve := errors.New("resolver called")
+	dialer := &Dialer{
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errResolve
+			},
+		},
+	}
+	_, err := dialer.Dial(context.Background(), "ws://server.example:8080/")
+	if err == nil || !strings.Contains(err.Error(), errResolve.Error()) {
+		t.Errorf("expected resolver error, got %v", err)
+	}
+}
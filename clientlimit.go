@@ -0,0 +1,91 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ClientLimit limits the number of simultaneous connections per client.
+// Handshakes which would exceed the limit are rejected with HTTP status
+// 429 (Too Many Requests).  This contains buggy clients which leak
+// connections.
+//
+// To use a ClientLimit, set the ClientLimit field of the Handler.  The
+// fields must not be changed once the limit is in use.
+type ClientLimit struct {
+	// Max is the maximum number of open connections per client.
+	Max int
+
+	// Key, if set, maps a handshake request to the key used to identify
+	// the client.  If Key is nil, the IP address of the client is used,
+	// taking Handler.TrustedProxies into account.
+	Key func(req *http.Request) string
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+// Open returns the number of open connections for the given key.
+func (l *ClientLimit) Open(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open[key]
+}
+
+// key returns the key for the client which sent req, where addr is the
+// address of the client.
+func (l *ClientLimit) key(req *http.Request, addr string) string {
+	if l.Key != nil {
+		return l.Key(req)
+	}
+	if ip := parseHostIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// acquire counts a new connection for key.  If the client has reached
+// the limit, false is returned and the connection must be rejected.
+func (l *ClientLimit) acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[key] >= l.Max {
+		return false
+	}
+	if l.open == nil {
+		l.open = make(map[string]int)
+	}
+	l.open[key]++
+	return true
+}
+
+// release removes a connection counted by acquire.  It is safe to call
+// release on a nil ClientLimit.
+func (l *ClientLimit) release(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[key] <= 1 {
+		delete(l.open, key)
+	} else {
+		l.open[key]--
+	}
+}
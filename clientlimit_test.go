@@ -0,0 +1,68 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientLimit(t *testing.T) {
+	limit := &ClientLimit{
+		Max: 1,
+		Key: func(req *http.Request) string { return "client" },
+	}
+	closed := make(chan bool, 2)
+	server, err := StartTestServerWithHandler(&Handler{
+		ClientLimit: limit,
+		Handle: func(conn *Conn) {
+			conn.Wait()
+			closed <- true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client1, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.Connect()
+	if err != errTestUpgradeFailed {
+		t.Fatalf("second connection not rejected, err=%v", err)
+	}
+
+	// Once the first connection is closed, a new connection is allowed.
+	err = client1.SendFrame(closeFrame, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+	client1.Close()
+
+	client2, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	if n := limit.Open("client"); n != 1 {
+		t.Errorf("%d open connections counted", n)
+	}
+}
@@ -0,0 +1,161 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Wscat is an interactive websocket client.
+//
+// Usage:
+//
+//	wscat [-H "Name: value"]... ws://host:port/path
+//
+// Every line read from standard input is sent to the server as a text
+// message, and all messages received from the server are written to
+// standard output.  Binary messages are shown as a hex dump.  An input
+// line of the form
+//
+//	/close [status [reason]]
+//
+// closes the connection with the given status code (default 1000).  At the
+// end of the input, the connection is closed with status 1000.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+type headerList http.Header
+
+func (h headerList) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headerList) Set(value string) error {
+	idx := strings.IndexByte(value, ':')
+	if idx < 0 {
+		return fmt.Errorf("invalid header %q", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(value[:idx]), strings.TrimSpace(value[idx+1:]))
+	return nil
+}
+
+var (
+	header  = headerList{}
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout for establishing the connection")
+)
+
+func main() {
+	flag.Var(header, "H", "additional header field for the handshake request (can be repeated)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: wscat [options] url")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	dialer := &websocket.Dialer{Header: http.Header(header)}
+	conn, err := dialer.Dial(ctx, flag.Arg(0))
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go readInput(conn)
+
+	for {
+		tp, r, err := conn.ReceiveMessage()
+		if err != nil {
+			break
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			break
+		}
+		if tp == websocket.Text {
+			fmt.Printf("< %s\n", data)
+		} else {
+			fmt.Printf("< binary message, %d bytes\n%s", len(data), hex.Dump(data))
+		}
+	}
+
+	info, status, message := conn.Wait()
+	switch info {
+	case websocket.ServerClosed: // we closed the connection
+		fmt.Printf("connection closed, the server replied with status %d\n", status)
+	case websocket.ClientClosed: // the peer closed the connection
+		fmt.Printf("the server closed the connection, status %d %q\n", status, message)
+	default:
+		fmt.Printf("connection failed (info %d)\n", info)
+		os.Exit(1)
+	}
+}
+
+// readInput sends the lines read from standard input to the server.
+func readInput(conn *websocket.Conn) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "/close") {
+			closeConn(conn, strings.TrimSpace(line[len("/close"):]))
+			return
+		}
+		err := conn.SendText(line)
+		if err != nil {
+			log.Println("send error:", err)
+			return
+		}
+	}
+	conn.Close(websocket.StatusOK, "")
+}
+
+// closeConn closes the connection, using the status code and reason given
+// in args.
+func closeConn(conn *websocket.Conn, args string) {
+	status := websocket.StatusOK
+	var reason string
+	if args != "" {
+		fields := strings.SplitN(args, " ", 2)
+		code, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Println("invalid status code:", fields[0])
+			code = int(websocket.StatusOK)
+		}
+		status = websocket.Status(code)
+		if len(fields) > 1 {
+			reason = fields[1]
+		}
+	}
+	err := conn.Close(status, reason)
+	if err != nil {
+		log.Println("close error:", err)
+		conn.Close(websocket.StatusOK, "")
+	}
+}
@@ -0,0 +1,130 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Wsecho is a websocket echo server for testing clients.
+//
+// All messages received from a client are sent back unchanged.  Messages
+// larger than the limit given by the -max-size flag cause the connection
+// to be closed with status 1009 (message too big).  If more than
+// -max-conns connections are open, new connections are rejected with
+// HTTP status 503.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"seehuhn.de/go/websocket"
+)
+
+var (
+	addr     = flag.String("addr", ":8080", "address to listen at")
+	maxSize  = flag.Int64("max-size", 1<<20, "maximal message size in bytes")
+	maxConns = flag.Int("max-conns", 1000, "maximal number of concurrent connections")
+	verbose  = flag.Bool("v", false, "log connections and messages")
+)
+
+type server struct {
+	mu    sync.Mutex
+	conns int
+
+	ws http.Handler
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	full := s.conns >= *maxConns
+	if !full {
+		s.conns++
+	}
+	s.mu.Unlock()
+	if full {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		s.conns--
+		s.mu.Unlock()
+	}()
+
+	// This calls s.handle, which returns once the connection is closed.
+	s.ws.ServeHTTP(w, req)
+}
+
+func (s *server) handle(conn *websocket.Conn) {
+	if *verbose {
+		log.Println(conn.RemoteAddr, "connected")
+	}
+
+	buf := &bytes.Buffer{}
+	for {
+		tp, r, err := conn.ReceiveMessage()
+		if err != nil {
+			break
+		}
+
+		buf.Reset()
+		n, err := io.Copy(buf, io.LimitReader(r, *maxSize+1))
+		if err != nil {
+			break
+		}
+		if n > *maxSize {
+			io.Copy(io.Discard, r)
+			conn.Close(websocket.StatusTooLarge, "")
+			break
+		}
+		if *verbose {
+			log.Println(conn.RemoteAddr, tp, n, "bytes")
+		}
+
+		w, err := conn.SendMessage(tp)
+		if err != nil {
+			break
+		}
+		w.Write(buf.Bytes())
+		err = w.Close()
+		if err != nil {
+			break
+		}
+	}
+
+	conn.Close(websocket.StatusOK, "")
+	info, status, message := conn.Wait()
+	if *verbose {
+		log.Println(conn.RemoteAddr, "disconnected", info, status, message)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	s := &server{}
+	s.ws = &websocket.Handler{
+		Handle: s.handle,
+		OriginAllowed: func(origin *url.URL) bool {
+			return true
+		},
+	}
+
+	log.Println("listening at", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
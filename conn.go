@@ -18,94 +18,290 @@ package websocket
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Conn represents a websocket connection initiated by a client.  All fields
-// are read-only.  Use a Handler to obtain Conn objects.
+// Conn represents a websocket connection.  All fields are read-only.  Use a
+// Handler to obtain Conn objects for connections initiated by a client, and
+// a Dialer to connect to a websocket server.
 //
 // It is ok to access a Conn from different goroutines concurrently.  The
 // connection must be closed using the Close() method after use, to free all
 // allocated resources.
 type Conn struct {
+	// readLimit is accessed atomically, see SetReadLimit.  It is kept at
+	// the start of the struct to ensure 64-bit alignment.
+	readLimit int64
+
 	ResourceName string
 	Origin       *url.URL
 	RemoteAddr   string
 	Protocol     string
 	RequestData  interface{} // as returned by Handler.AccessAllowed()
 
-	raw net.Conn
-
+	// HandshakeKey is the value of the Sec-WebSocket-Key header field
+	// from the opening handshake.  The value is a random nonce, chosen
+	// by the client, which is known to both sides of the connection.
+	HandshakeKey string
+
+	raw           net.Conn
+	extensions    []Extension // as agreed in the handshake
+	rsvBits       byte        // reserved bits enabled by RSVExtensions
+	isClient      bool        // true if we are the client side of the connection
+	pool          BufferPool
+	lowMemory     bool
+	coalesceDelay time.Duration
+	coalesceBytes int
+	validateText  bool
+	sendRate      int // bytes per second, or 0 for no limit
+	sendBurst     int
+	idleTimeout   time.Duration
+	idleGrace     time.Duration
+	autoDrain     bool
+	validate      func(conn *Conn, tp MessageType, msg []byte) error
+	controlLimit  int               // control frames per second, or 0 for no limit
+	idle          *idleTimer        // nil, unless idleTimeout is positive
+	activity      *activity         // nil, unless Registry.IdleTimeout is set
+	onPing        func(body []byte) // called by the receiver for every ping
+	onClose       func(conn *Conn)
+	onDisconnect  func(conn *Conn, info ConnInfo, status Status, message string)
+	events        *connEvents // non-nil in event-driven mode
+	trace         *traceState
+	errors        errorHook
+	stats         *Stats
+	control       *controlCounters
+	pongLimit     int
+	registry      *Registry
+	budget        *SendBudget
+	slots         *connSlots
+	clientLimit   *ClientLimit
+	clientKey     string
+	tag           string
+	tracer        *spanTracer // nil, unless Handler.Tracer is set
+
+	gate        readGate
 	senderStore chan *sender
+	closeCalled int32 // set atomically by Close, CloseWrite or Detach
+	detaching   int32 // set atomically by Detach
+	draining    int32 // set atomically by StartDraining
+	resume      *handoffFrame
+	handoff     *HandoffState // set by the reader, if Detach was called
 	toUser      <-chan *receiver
 	fromUser    chan<- *receiver
 
+	// unread is the last reader returned by ReceiveMessage, if autoDrain
+	// is set.
+	unreadMu sync.Mutex
+	unread   *autoCloseReader
+
+	// handling is the message currently handled by the application, if
+	// the OnMessageEnd trace hook is used.
+	handlingMu sync.Mutex
+	handling   *messageTrace
+
 	// ReaderDone is closed when the reader goroutine has finished.
 	// After this point, the reader will not access the Conn object
 	// any more and will not send any more control messages.
 	shutdownComplete <-chan struct{}
 
+	// readErr is the network error or protocol violation which terminated
+	// the reader, if any.
+	// The field can be read once toUser is closed.
+	readErr error
+
+	// peerClose holds the *closedError returned once a close frame has
+	// been received from the peer.
+	peerClose atomic.Value
+
 	// the following fields can only be read once shutdownComplete is closed
 	connInfo      ConnInfo
+	violation     Violation
 	clientStatus  Status
 	clientMessage string
+
+	// localStatus and localMessage describe the close frame we sent.
+	// The fields are written before senderStore is closed.
+	localStatus  Status
+	localMessage string
 }
 
 func (conn *Conn) initialize(raw net.Conn, rw *bufio.ReadWriter) {
 	// fill in the remaining fields of the Conn object
 	conn.raw = raw
+	if conn.trace == nil {
+		conn.trace = newTraceState(nil)
+	}
+
+	conn.localStatus = StatusDropped
+	conn.control = &controlCounters{}
 
 	shutdownStarted := make(chan struct{})
 	shutdownComplete := make(chan struct{})
 	conn.shutdownComplete = shutdownComplete
 
+	var out io.Writer = raw
+	if conn.sendRate > 0 {
+		rw.Writer.Flush()
+		out = newRateLimiter(raw, conn.sendRate, conn.sendBurst)
+		rw = bufio.NewReadWriter(rw.Reader, bufio.NewWriterSize(out, rw.Writer.Size()))
+	}
+
 	wb := &sender{
-		w:      rw.Writer,
-		header: [10]byte{},
+		w:    rw.Writer,
+		raw:  out,
+		pool: conn.pool,
+		mask: conn.isClient,
+
+		coalesceDelay: conn.coalesceDelay,
+		coalesceBytes: conn.coalesceBytes,
+		trace:         conn.trace,
+		errors:        &conn.errors,
+		stats:         conn.stats,
+		control:       conn.control,
+		tracer:        conn.tracer,
 
 		shutdownStarted: shutdownStarted,
 	}
 	conn.senderStore = make(chan *sender, 1)
+	wb.store = conn.senderStore
 	conn.senderStore <- wb
 
+	pong := &pendingPong{n: -1}
+	wb.pong = pong
+
+	if conn.idleTimeout > 0 {
+		conn.idle = newIdleTimer(conn, conn.idleTimeout, conn.idleGrace)
+	}
+	if conn.registry != nil && conn.registry.IdleTimeout > 0 {
+		conn.activity = &activity{last: time.Now().UnixNano()}
+	}
+
 	rb := &receiver{
 		r:           rw.Reader,
 		senderStore: conn.senderStore,
-		scratch:     make([]byte, 128),
+		pong:        pong,
+		pool:        conn.pool,
+		lowMemory:   conn.lowMemory,
+		isClient:    conn.isClient,
+		rsvBits:     conn.rsvBits,
+		ctrlLimit:   conn.controlLimit,
+		onPing:      conn.onPing,
+		trace:       conn.trace,
+		stats:       conn.stats,
+		control:     conn.control,
+		pongLimit:   conn.pongLimit,
+		tracer:      conn.tracer,
+		idle:        conn.idle,
+		activity:    conn.activity,
+		detach:      &conn.detaching,
 
 		shutdownStarted: shutdownStarted,
 	}
 	fromUser := make(chan *receiver, 1)
-	fromUser <- rb
 	toUser := make(chan *receiver, 1)
+	if f := conn.resume; f != nil && f.Deliver {
+		// A message was pending when the connection was detached.
+		rb.resumeFrame(f)
+		toUser <- rb
+	} else {
+		if f != nil {
+			rb.resumeFrame(f)
+			rb.discardRest = true
+		}
+		fromUser <- rb
+	}
 	conn.fromUser = fromUser
 	conn.toUser = toUser
+	data := &readManagerData{
+		fromUser:         fromUser,
+		toUser:           toUser,
+		shutdownComplete: shutdownComplete,
+	}
+
+	if conn.events != nil {
+		// In event-driven mode, the reader is started by startEvents.
+		conn.events.token = fromUser
+		conn.events.data = data
+		return
+	}
 
 	// Start the read multiplexer goroutine.  This goroutine will
 	// manages the connection and closes the TCP connection when
 	// the websocket connection is closed.
-	go conn.readManager(&readManagerData{
-		fromUser:         fromUser,
-		toUser:           toUser,
-		shutdownComplete: shutdownComplete,
-	})
+	go conn.readManager(data)
+}
+
+// Tag returns the tag of the Handler which established the connection,
+// see Handler.Tag.
+func (conn *Conn) Tag() string {
+	return conn.tag
+}
+
+// CloseWhenDone closes the connection with status StatusGoingAway, once
+// ctx is done.  This can be used to tie the lifetime of the connection to
+// a request or a server.  If the connection is closed before ctx is done,
+// nothing happens.
+func (conn *Conn) CloseWhenDone(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close(StatusGoingAway, "")
+		case <-conn.shutdownComplete:
+		}
+	}()
 }
 
 // Close terminates a websocket connection and frees all associated resources.
 // The connection cannot be used any more after Close() has been called.
+// Messages which arrive from the peer after Close has been called are
+// discarded; use CloseWrite to receive these messages.
 //
 // The status code indicates whether the connection completed successfully, or
 // due to an error.  Use StatusOK for normal termination, and one of the other
 // status codes in case of errors. Use StatusNotSent to not send a status code.
 //
-// The message can be used to provide additional information to the client for
+// The message can be used to provide additional information to the peer for
 // debugging.  The utf-8 representation of the string can be at most 123 bytes
 // long, otherwise ErrTooLarge is returned.
+//
+// Close can be called any number of times, from any goroutine.  Only the
+// first call with valid arguments starts the closing handshake; all later
+// calls return ErrConnClosed.  ErrConnClosed is also returned if the
+// connection has already been closed by the peer or because of an error.
 func (conn *Conn) Close(code Status, message string) error {
-	if !(code.serverCanSend() || code == StatusNotSent) {
+	return conn.doClose(code, message, closeDiscard)
+}
+
+// CloseWrite sends a close frame to the peer, but keeps delivering messages
+// which the peer sent before it received the close frame.  The caller must
+// keep reading from the connection until ErrConnClosed is returned, at which
+// point the peer has completed the closing handshake.  No more messages can
+// be sent after CloseWrite has been called.
+//
+// The arguments and the return value have the same meaning as for Close.  As
+// for Close, the network connection is closed if the peer does not respond
+// within 3 seconds.
+func (conn *Conn) CloseWrite(code Status, message string) error {
+	return conn.doClose(code, message, closeDeliver)
+}
+
+// Values for Conn.closeCalled, describing what happens to messages which
+// arrive after the close frame has been sent.
+const (
+	closeDiscard int32 = 1 // set by Close
+	closeDeliver int32 = 2 // set by CloseWrite
+	closeDetach  int32 = 3 // set by Detach
+)
+
+func (conn *Conn) doClose(code Status, message string, mode int32) error {
+	if !(conn.canSend(code) || code == StatusNotSent) {
 		return ErrStatusCode
 	}
 
@@ -114,19 +310,28 @@ func (conn *Conn) Close(code Status, message string) error {
 		return ErrTooLarge
 	}
 
+	if !atomic.CompareAndSwapInt32(&conn.closeCalled, 0, mode) {
+		return ErrConnClosed
+	}
+
+	// The reply of the peer must be read to complete the closing handshake.
+	conn.ResumeReading()
+
 	wb := <-conn.senderStore
 	if wb == nil || wb.isShuttingDown() {
 		if wb != nil {
-			conn.senderStore <- wb
+			wb.release()
 		}
 		return ErrConnClosed
 	}
 
+	conn.localStatus = code
+	conn.localMessage = message
 	close(conn.senderStore) // prevent further writes
 	err := wb.sendCloseFrame(code, body)
 	if err != nil {
-		conn.raw.Close()
-		return ErrConnClosed
+		conn.forceStop()
+		return &closedError{cause: err}
 	}
 
 	// Give the client 3 seconds to close the connection, before closing it
@@ -139,13 +344,38 @@ func (conn *Conn) Close(code Status, message string) error {
 				<-timeOut.C
 			}
 		case <-timeOut.C:
-			conn.raw.Close() // force-stop the reader
+			conn.forceStop()
 		}
 	}()
 
 	return nil
 }
 
+// closedErr returns the error to use once the reader has terminated.  This
+// must only be called after toUser has been closed.
+func (conn *Conn) closedErr() error {
+	if conn.readErr != nil {
+		return &closedError{cause: conn.readErr}
+	}
+	return conn.sendClosedErr()
+}
+
+// sendClosedErr returns the error to use when a message cannot be sent
+// because the connection has been closed.  If the peer has sent a close
+// frame, the error records the status code.
+func (conn *Conn) sendClosedErr() error {
+	if err, ok := conn.peerClose.Load().(*closedError); ok {
+		return err
+	}
+	return ErrConnClosed
+}
+
+// discardMessages reports whether Close has been called, so that incoming
+// messages are no longer delivered to the user.
+func (conn *Conn) discardMessages() bool {
+	return atomic.LoadInt32(&conn.closeCalled) == closeDiscard
+}
+
 // ConnInfo describes why a websocket connection was closed.
 type ConnInfo int
 
@@ -170,6 +400,19 @@ const (
 	// ConnDropped indicates that the underlying TCP connection was
 	// closed, and we didn't receive a close frame from the client.
 	ConnDropped
+
+	// ControlFlood indicates that we closed the connection because the
+	// client sent more control frames than allowed by
+	// Handler.ControlFrameLimit or Handler.UnsolicitedPongLimit.
+	ControlFlood
+
+	// Detached indicates that the connection was handed off to a
+	// different process, see [Conn.Detach].
+	Detached
+
+	// InvalidMessage indicates that we closed the connection because a
+	// message was rejected by Handler.Validate.
+	InvalidMessage
 )
 
 // Status describes the reason for the closure of a websocket connection, for
@@ -232,6 +475,19 @@ const (
 	// the connection because it encountered an unexpected condition that
 	// prevented it from fulfilling the request.
 	StatusInternalServerError Status = 1011
+
+	// StatusServiceRestart indicates that the server is restarting.
+	// Clients may reconnect after a short delay.
+	StatusServiceRestart Status = 1012
+
+	// StatusTryAgainLater indicates that the server is temporarily
+	// unable to handle the connection, for example because it is
+	// overloaded.
+	StatusTryAgainLater Status = 1013
+
+	// StatusBadGateway indicates that the server, while acting as a gateway
+	// or proxy, received an invalid response from the upstream server.
+	StatusBadGateway Status = 1014
 )
 
 func (code Status) clientCanSend() bool {
@@ -248,6 +504,69 @@ func (code Status) serverCanSend() bool {
 	return knownValidCode[code]
 }
 
+// Extension describes a websocket extension, as listed in the
+// Sec-WebSocket-Extensions header during the handshake.
+type Extension struct {
+	Name   string
+	Params map[string]string // parameters without a value map to ""
+}
+
+// Extensions returns the extensions which were agreed during the opening
+// handshake, in the order in which they apply to outgoing messages.  The
+// package itself implements no extensions, so the list only contains
+// extensions registered using [RSVExtension].
+func (conn *Conn) Extensions() []Extension {
+	if len(conn.extensions) == 0 {
+		return nil
+	}
+	res := make([]Extension, len(conn.extensions))
+	copy(res, conn.extensions)
+	return res
+}
+
+// LocalNetAddr returns the local address of the underlying network
+// connection.
+func (conn *Conn) LocalNetAddr() net.Addr {
+	return conn.raw.LocalAddr()
+}
+
+// RemoteNetAddr returns the remote address of the underlying network
+// connection.  In contrast to the RemoteAddr field, which is taken from the
+// HTTP request, this is the address of the actual peer of the connection,
+// for example a reverse proxy.
+func (conn *Conn) RemoteNetAddr() net.Addr {
+	return conn.raw.RemoteAddr()
+}
+
+// Network returns the name of the network of the underlying connection,
+// for example "tcp" or "unix".
+func (conn *Conn) Network() string {
+	return conn.raw.LocalAddr().Network()
+}
+
+// IsClient reports whether we are the client side of the connection, i.e.
+// whether the connection was established using a Dialer.
+func (conn *Conn) IsClient() bool {
+	return conn.isClient
+}
+
+// canSend reports whether we can send the given status code to the peer.
+func (conn *Conn) canSend(code Status) bool {
+	if conn.isClient {
+		return code.clientCanSend()
+	}
+	return code.serverCanSend()
+}
+
+// peerCanSend reports whether the peer is allowed to send the given status
+// code.
+func (conn *Conn) peerCanSend(code Status) bool {
+	if conn.isClient {
+		return code.serverCanSend()
+	}
+	return code.clientCanSend()
+}
+
 var knownValidCode = map[Status]bool{
 	StatusOK:              true,
 	StatusGoingAway:       true,
@@ -260,6 +579,9 @@ var knownValidCode = map[Status]bool{
 	StatusTooLarge:        true,
 	// StatusClientMissingExtension is only sent by the client
 	StatusInternalServerError: true,
+	StatusServiceRestart:      true,
+	StatusTryAgainLater:       true,
+	StatusBadGateway:          true,
 }
 
 // Wait blocks until the connection is closed.  The function then returns the
@@ -269,17 +591,84 @@ var knownValidCode = map[Status]bool{
 // If no valid close frame was received from the client, the status code will
 // be StatusDropped.  If we received a close frame, but no status code was
 // included, the status code will be StatusNotSent.  Otherwise, the status code
-// is the status code sent by the client.
+// is the status code sent by the client.  Use CloseInfo to also find the
+// status code we sent.
 func (conn *Conn) Wait() (ConnInfo, Status, string) {
 	<-conn.shutdownComplete
 	return conn.connInfo, conn.clientStatus, conn.clientMessage
 }
 
+// WaitContext is like Wait, but gives up once ctx is done.  In this case,
+// ctx.Err() is returned and the other return values are zero.
+func (conn *Conn) WaitContext(ctx context.Context) (ConnInfo, Status, string, error) {
+	select {
+	case <-conn.shutdownComplete:
+		return conn.connInfo, conn.clientStatus, conn.clientMessage, nil
+	case <-ctx.Done():
+		return 0, 0, "", ctx.Err()
+	}
+}
+
+// CloseInfo describes both directions of the closing handshake of a
+// connection.
+type CloseInfo struct {
+	// Info is the information about the connection, as returned by Wait.
+	Info ConnInfo
+
+	// PeerInitiated is true if the peer sent a close frame before we
+	// did.
+	PeerInitiated bool
+
+	// PeerStatus and PeerMessage are the status code and message sent by
+	// the peer, as returned by Wait.
+	PeerStatus  Status
+	PeerMessage string
+
+	// LocalStatus and LocalMessage are the status code and message sent
+	// by us.  If no close frame was sent, LocalStatus is StatusDropped.
+	// If the close frame did not include a status code, LocalStatus is
+	// StatusNotSent.
+	LocalStatus  Status
+	LocalMessage string
+}
+
+// CloseInfo blocks until the connection is closed, and then returns the
+// details of the closing handshake in both directions.  This is useful
+// for logging, where Wait only reports what the peer sent.
+func (conn *Conn) CloseInfo() CloseInfo {
+	<-conn.shutdownComplete
+	return CloseInfo{
+		Info:          conn.connInfo,
+		PeerInitiated: conn.connInfo == ClientClosed,
+		PeerStatus:    conn.clientStatus,
+		PeerMessage:   conn.clientMessage,
+		LocalStatus:   conn.localStatus,
+		LocalMessage:  conn.localMessage,
+	}
+}
+
+// OnError installs a function which is called for errors in operations
+// which run in the background and have no caller to report to, for
+// example when sending a pong frame, when sending the close frame after
+// the peer closed the connection, or when flushing coalesced writes.  The
+// function is called in a new goroutine for each error.  Calling OnError
+// with nil removes the function.
+func (conn *Conn) OnError(fn func(error)) {
+	conn.errors.fn.Store(fn)
+}
+
+// Done returns a channel which is closed once the connection has been shut
+// down.  After this, Wait returns immediately.
+func (conn *Conn) Done() <-chan struct{} {
+	return conn.shutdownComplete
+}
+
 type frameHeader struct {
 	Length int64
 	Mask   [4]byte
 	Final  bool
 	Opcode MessageType
+	RSV    byte // reserved bits, only non-zero if enabled by an extension
 }
 
 // MessageType encodes the type of an individual websocket message.
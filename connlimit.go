@@ -0,0 +1,87 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// connSlots limits the total number of open connections of a Handler,
+// see Handler.MaxConnections.
+type connSlots struct {
+	sem chan struct{}
+}
+
+// slotsMu protects the lazy initialisation of Handler.slots.
+var slotsMu sync.Mutex
+
+// connSlots returns the connection counter of the handler, or nil if the
+// number of connections is not limited.
+func (handler *Handler) connSlots() *connSlots {
+	if handler.MaxConnections <= 0 {
+		return nil
+	}
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+	if handler.slots == nil {
+		handler.slots = &connSlots{
+			sem: make(chan struct{}, handler.MaxConnections),
+		}
+	}
+	return handler.slots
+}
+
+// acquire reserves a slot for a new connection.  If no slot is free,
+// acquire waits for up to wait, or until ctx is cancelled.
+func (s *connSlots) acquire(ctx context.Context, wait time.Duration) bool {
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot reserved by acquire.  It is safe to call release
+// on a nil connSlots.
+func (s *connSlots) release() {
+	if s == nil {
+		return
+	}
+	<-s.sem
+}
+
+// releaseLimits frees the connection limits held by conn.
+func (conn *Conn) releaseLimits() {
+	conn.slots.release()
+	conn.clientLimit.release(conn.clientKey)
+}
@@ -0,0 +1,104 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxConnections(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		MaxConnections: 1,
+		RetryAfter:     5 * time.Second,
+		Handle: func(conn *Conn) {
+			conn.Wait()
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusOK, "")
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "0000000000000000000000==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("wrong status %d", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "5" {
+		t.Errorf("wrong Retry-After header %q", ra)
+	}
+}
+
+func TestMaxConnectionsWait(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		MaxConnections:     1,
+		MaxConnectionsWait: 5 * time.Second,
+		Handle: func(conn *Conn) {
+			conn.Wait()
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn1, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := DefaultDialer.Dial(context.Background(), url)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second handshake completed while the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn1.Close(StatusOK, "")
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	res.conn.Close(StatusOK, "")
+}
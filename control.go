@@ -0,0 +1,84 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "sync/atomic"
+
+// ControlCounts gives the number of ping and pong frames received on a
+// connection.
+type ControlCounts struct {
+	Pings int64 // ping frames received
+	Pongs int64 // pong frames received
+
+	// UnsolicitedPongs counts the pong frames which did not answer a
+	// ping sent by us.  Since a pong may answer several pings (RFC 6455,
+	// section 5.5.3), a pong is only counted as unsolicited if no ping
+	// has been sent since the previous pong.
+	UnsolicitedPongs int64
+}
+
+// controlCounters holds the counters for ControlCounts.  The fields are
+// accessed atomically.
+type controlCounters struct {
+	pings       int64
+	pongs       int64
+	unsolicited int64
+
+	pingPending int32 // set when a ping is sent, cleared by the next pong
+}
+
+// pingSent records that a ping frame is about to be sent.
+func (cc *controlCounters) pingSent() {
+	if cc != nil {
+		atomic.StoreInt32(&cc.pingPending, 1)
+	}
+}
+
+// ping counts a received ping frame.
+func (cc *controlCounters) ping() {
+	if cc != nil {
+		atomic.AddInt64(&cc.pings, 1)
+	}
+}
+
+// pong counts a received pong frame.  The return values indicate whether
+// the pong was unsolicited, and give the total number of unsolicited pongs.
+func (cc *controlCounters) pong() (bool, int64) {
+	if cc == nil {
+		return false, 0
+	}
+	atomic.AddInt64(&cc.pongs, 1)
+	if atomic.CompareAndSwapInt32(&cc.pingPending, 1, 0) {
+		return false, atomic.LoadInt64(&cc.unsolicited)
+	}
+	return true, atomic.AddInt64(&cc.unsolicited, 1)
+}
+
+// ControlCounts returns the number of ping and pong frames received on the
+// connection so far.  Handler.UnsolicitedPongLimit can be used to
+// disconnect clients which send too many unsolicited pongs.
+func (conn *Conn) ControlCounts() ControlCounts {
+	cc := conn.control
+	if cc == nil {
+		return ControlCounts{}
+	}
+	return ControlCounts{
+		Pings:            atomic.LoadInt64(&cc.pings),
+		Pongs:            atomic.LoadInt64(&cc.pongs),
+		UnsolicitedPongs: atomic.LoadInt64(&cc.unsolicited),
+	}
+}
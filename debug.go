@@ -0,0 +1,107 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FrameDump returns trace hooks which write a one-line summary of every
+// frame sent and received on a connection to w.  For control frames, the
+// payload is included as a hex dump.  Each line starts with prefix.  The
+// hooks can be used in Handler.TraceHooks, Dialer.TraceHooks or
+// Conn.SetTraceHooks.
+//
+// The output looks like this:
+//
+//	ws 15:04:05.000000 <- text len=5 fin
+//	ws 15:04:05.000120 -> text len=5 fin (21µs)
+//	ws 15:04:05.000398 <- ping len=2 fin 68 69 |hi|
+func FrameDump(w io.Writer, prefix string) *TraceHooks {
+	d := &frameDump{w: w, prefix: prefix}
+	return &TraceHooks{
+		OnFrameRead: func(info FrameInfo) {
+			d.printf(info.Time, "<- %s", formatFrame(info))
+		},
+		OnFrameWrite: func(info FrameInfo) {
+			d.printf(info.Time, "-> %s (%s)", formatFrame(info), info.Duration)
+		},
+		OnControlFrame: func(info FrameInfo, body []byte) {
+			d.printf(info.Time, "<- %s%s", formatFrame(info), formatBody(body))
+		},
+		OnClose: func(info ConnInfo, status Status, message string) {
+			d.printf(time.Now(), "closed: info %d, status %d %q", info, status, message)
+		},
+	}
+}
+
+type frameDump struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+}
+
+func (d *frameDump) printf(t time.Time, format string, args ...interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s %s %s\n",
+		d.prefix, t.Format("15:04:05.000000"), fmt.Sprintf(format, args...))
+}
+
+func formatFrame(info FrameInfo) string {
+	s := fmt.Sprintf("%s len=%d", info.Opcode, info.Length)
+	if info.Final {
+		s += " fin"
+	}
+	return s
+}
+
+// formatBody returns a hex dump of the first bytes of body, followed by
+// the printable ASCII characters.  The result starts with a space, unless
+// body is empty.
+func formatBody(body []byte) string {
+	const maxBytes = 32
+
+	if len(body) == 0 {
+		return ""
+	}
+	b := &strings.Builder{}
+	short := body
+	if len(short) > maxBytes {
+		short = short[:maxBytes]
+	}
+	for _, c := range short {
+		fmt.Fprintf(b, " %02x", c)
+	}
+	b.WriteString(" |")
+	for _, c := range short {
+		if c >= 32 && c < 127 {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	b.WriteString("|")
+	if len(body) > maxBytes {
+		b.WriteString(" ...")
+	}
+	return b.String()
+}
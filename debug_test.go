@@ -0,0 +1,78 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatBody(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"hi", " 68 69 |hi|"},
+		{"\x03\xe8ok", " 03 e8 6f 6b |..ok|"},
+		{strings.Repeat("a", 33), strings.Repeat(" 61", 32) + " |" + strings.Repeat("a", 32) + "| ..."},
+	}
+	for _, c := range cases {
+		out := formatBody([]byte(c.in))
+		if out != c.out {
+			t.Errorf("%q: got %q, expected %q", c.in, out, c.out)
+		}
+	}
+}
+
+func TestFrameDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	server, client := Pipe()
+	server.SetTraceHooks(FrameDump(buf, "test"))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.ReceiveText(100)
+		done <- err
+	}()
+	err := server.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	client.Close(StatusOK, "")
+	server.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		"-> text len=5 fin (",
+		"<- close len=2 fin 03 e8 |..|",
+		"-> close len=2 fin (",
+		"closed: info 2, status 1000 \"\"",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("wrong output:\n%s", buf.String())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "test ") || !strings.Contains(line, expected[i]) {
+			t.Errorf("wrong line %q", line)
+		}
+	}
+}
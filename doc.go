@@ -35,5 +35,22 @@ when it is finished with it:
 
 		// use conn to send and receive messages.
 	}
+
+Servers with very large numbers of mostly idle connections can use the
+event-driven mode instead, where no goroutine is kept per connection and
+a callback is called for every received message:
+
+	websocketHandler := &websocket.Handler{
+		OnMessage: func(conn *websocket.Conn, tp websocket.MessageType, r io.Reader) {
+			// read the message from r
+		},
+	}
+
+The package does not implement the permessage-deflate extension (RFC
+7692).  Messages are always sent and received uncompressed, so
+already-compressed payloads such as images or video chunks can be sent
+without any extra options.  Experimental extensions which use the
+reserved bits of the frame header can be negotiated using
+[websocket.RSVExtension].
 */
 package websocket
@@ -0,0 +1,40 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "sync/atomic"
+
+// StartDraining stops the connection from accepting new outbound
+// messages, in preparation for closing it.  This is useful when a client
+// is moved to a different server: once StartDraining has been called,
+// the Send functions, SendQueue.Send and the broadcast functions return
+// [ErrDraining] for this connection, while messages which are already being
+// written, as well as messages already queued in a SendQueue, are still
+// sent.  Control frames are not affected, and receiving continues as
+// normal.
+//
+// A typical shutdown sequence calls StartDraining, then closes the
+// SendQueue (which waits until all queued messages have been sent), and
+// finally closes the connection using Close.
+func (conn *Conn) StartDraining() {
+	atomic.StoreInt32(&conn.draining, 1)
+}
+
+// Draining reports whether StartDraining has been called.
+func (conn *Conn) Draining() bool {
+	return atomic.LoadInt32(&conn.draining) != 0
+}
@@ -0,0 +1,296 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package engineio implements the websocket transport of the engine.io
+// protocol (version 4), which is used by socket.io clients.
+//
+// The package handles the engine.io framing: the open packet, the
+// heart-beat using ping and pong packets, and the prefixing of messages.
+// The socket.io packets themselves are passed to the application as
+// engine.io messages.  Only the websocket transport is supported, so
+// clients must be configured to not use HTTP long-polling, for example
+// using
+//
+//	io(url, {transports: ["websocket"]})
+//
+// in JavaScript.
+package engineio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+// engine.io packet types, used as the first byte of text messages
+const (
+	packetOpen    = '0'
+	packetClose   = '1'
+	packetPing    = '2'
+	packetPong    = '3'
+	packetMessage = '4'
+	packetUpgrade = '5'
+	packetNoop    = '6'
+)
+
+const (
+	defaultPingInterval = 25 * time.Second
+	defaultPingTimeout  = 20 * time.Second
+	defaultMaxPayload   = 1000000
+)
+
+// Handler implements the http.Handler interface.  The handler accepts
+// engine.io connections which use the websocket transport.
+type Handler struct {
+	// WebSocket is used to perform the websocket handshake.  The Handle
+	// field of WebSocket is ignored.  If WebSocket is nil, a
+	// websocket.Handler with default settings is used.
+	WebSocket *websocket.Handler
+
+	// Handle is called for every new engine.io connection.  The
+	// connection must be closed after use.
+	Handle func(conn *Conn)
+
+	// PingInterval is the time between two ping packets sent by the
+	// server.  If this is zero, 25 seconds are used.
+	PingInterval time.Duration
+
+	// PingTimeout is the time the client has to answer a ping packet,
+	// before the connection is closed.  If this is zero, 20 seconds are
+	// used.
+	PingTimeout time.Duration
+
+	// MaxPayload is the maximal message size, in bytes, which the
+	// client is allowed to send.  If this is zero, 1000000 bytes are used.
+	MaxPayload int
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("EIO") != "4" {
+		http.Error(w, "unsupported protocol version", http.StatusBadRequest)
+		return
+	}
+	if query.Get("transport") != "websocket" {
+		http.Error(w, "unsupported transport", http.StatusBadRequest)
+		return
+	}
+
+	ws := h.WebSocket
+	if ws == nil {
+		ws = &websocket.Handler{}
+	}
+	wsConn, err := ws.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+
+	pingInterval := h.PingInterval
+	if pingInterval <= 0 {
+		pingInterval = defaultPingInterval
+	}
+	pingTimeout := h.PingTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = defaultPingTimeout
+	}
+	maxPayload := h.MaxPayload
+	if maxPayload <= 0 {
+		maxPayload = defaultMaxPayload
+	}
+
+	var buf [15]byte
+	_, err = rand.Read(buf[:])
+	if err != nil {
+		wsConn.Close(websocket.StatusInternalServerError, "")
+		return
+	}
+	conn := &Conn{
+		ID:         base64.RawURLEncoding.EncodeToString(buf[:]),
+		ws:         wsConn,
+		maxPayload: maxPayload,
+		pong:       make(chan struct{}, 1),
+	}
+
+	open, _ := json.Marshal(&openPacket{
+		SID:          conn.ID,
+		Upgrades:     []string{},
+		PingInterval: int(pingInterval / time.Millisecond),
+		PingTimeout:  int(pingTimeout / time.Millisecond),
+		MaxPayload:   maxPayload,
+	})
+	err = wsConn.SendText(string(packetOpen) + string(open))
+	if err != nil {
+		wsConn.Close(websocket.StatusGoingAway, "")
+		return
+	}
+
+	go conn.heartbeat(pingInterval, pingTimeout)
+	h.Handle(conn)
+}
+
+type openPacket struct {
+	SID          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int      `json:"pingInterval"`
+	PingTimeout  int      `json:"pingTimeout"`
+	MaxPayload   int      `json:"maxPayload"`
+}
+
+// Conn is an engine.io connection.
+type Conn struct {
+	// ID is the session ID sent to the client in the open packet.
+	ID string
+
+	ws         *websocket.Conn
+	maxPayload int
+	pong       chan struct{}
+
+	readLock sync.Mutex
+}
+
+// Receive returns the next message sent by the client.  Text messages are
+// returned without the engine.io prefix, binary messages are returned
+// unchanged.  Control packets are processed internally.  Once the
+// connection has been closed, websocket.ErrConnClosed is returned.
+//
+// Pong packets are only processed while Receive is running.  To avoid
+// the connection being closed because of a ping timeout, the application
+// must keep calling Receive.
+func (c *Conn) Receive() (websocket.MessageType, []byte, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for {
+		tp, r, err := c.ws.ReceiveMessage()
+		if err != nil {
+			return 0, nil, err
+		}
+		msg, err := io.ReadAll(io.LimitReader(r, int64(c.maxPayload)+1))
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(msg) > c.maxPayload {
+			io.Copy(io.Discard, r)
+			c.ws.Close(websocket.StatusTooLarge, "")
+			return 0, nil, websocket.ErrTooLarge
+		}
+
+		if tp == websocket.Binary {
+			return tp, msg, nil
+		}
+		if len(msg) == 0 {
+			c.ws.Close(websocket.StatusProtocolError, "")
+			return 0, nil, errInvalidPacket
+		}
+		switch msg[0] {
+		case packetMessage:
+			return tp, msg[1:], nil
+		case packetPong:
+			select {
+			case c.pong <- struct{}{}:
+			default:
+			}
+		case packetPing:
+			// Clients don't send pings in version 4 of the protocol, but
+			// answering them does no harm.
+			err = c.ws.SendText(string(packetPong) + string(msg[1:]))
+			if err != nil {
+				return 0, nil, err
+			}
+		case packetClose:
+			c.ws.Close(websocket.StatusOK, "")
+		case packetNoop, packetUpgrade:
+			// ignore
+		default:
+			c.ws.Close(websocket.StatusProtocolError, "")
+			return 0, nil, errInvalidPacket
+		}
+	}
+}
+
+// SendText sends a text message to the client.
+func (c *Conn) SendText(msg string) error {
+	return c.ws.SendText(string(packetMessage) + msg)
+}
+
+// SendBinary sends a binary message to the client.
+func (c *Conn) SendBinary(msg []byte) error {
+	return c.ws.SendBinary(msg)
+}
+
+// Close sends a close packet and closes the connection.
+func (c *Conn) Close() error {
+	c.ws.SendText(string(packetClose))
+	return c.ws.Close(websocket.StatusOK, "")
+}
+
+// WebSocket returns the underlying websocket connection.
+func (c *Conn) WebSocket() *websocket.Conn {
+	return c.ws
+}
+
+// heartbeat sends ping packets to the client, and closes the connection if
+// no pong is received in time.
+func (c *Conn) heartbeat(interval, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.ws.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-done:
+			return
+		}
+
+		// discard pongs which arrived late
+		select {
+		case <-c.pong:
+		default:
+		}
+		err := c.ws.SendText(string(packetPing))
+		if err != nil {
+			return
+		}
+
+		timer.Reset(timeout)
+		select {
+		case <-c.pong:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			c.ws.Close(websocket.StatusGoingAway, "ping timeout")
+			return
+		case <-done:
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+var errInvalidPacket = errors.New("engineio: invalid packet")
@@ -0,0 +1,137 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package engineio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+func echo(conn *Conn) {
+	defer conn.Close()
+	for {
+		tp, msg, err := conn.Receive()
+		if err != nil {
+			return
+		}
+		if tp == websocket.Text {
+			err = conn.SendText(string(msg))
+		} else {
+			err = conn.SendBinary(msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		Handle:       echo,
+		PingInterval: 20 * time.Millisecond,
+		PingTimeout:  50 * time.Millisecond,
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") +
+		"/socket.io/?EIO=4&transport=websocket"
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := conn.ReceiveText(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg[0] != packetOpen {
+		t.Fatalf("expected open packet, got %q", msg)
+	}
+	var open openPacket
+	err = json.Unmarshal([]byte(msg[1:]), &open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open.SID == "" || open.PingInterval != 20 || open.PingTimeout != 50 {
+		t.Errorf("wrong open packet %q", msg)
+	}
+
+	err = conn.SendText(`4["hello",1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = conn.ReceiveText(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != `4["hello",1]` {
+		t.Errorf("wrong message %q", msg)
+	}
+
+	msg, err = conn.ReceiveText(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "2" {
+		t.Fatalf("expected ping, got %q", msg)
+	}
+	err = conn.SendText("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// If we don't answer the next ping, the server closes the connection.
+	msg, err = conn.ReceiveText(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "2" {
+		t.Fatalf("expected ping, got %q", msg)
+	}
+	for {
+		_, err = conn.ReceiveText(1000)
+		if err != nil {
+			break
+		}
+	}
+	_, status, message := conn.Wait()
+	if status != websocket.StatusGoingAway || message != "ping timeout" {
+		t.Errorf("unexpected close %d %q", status, message)
+	}
+	conn.Close(websocket.StatusOK, "")
+}
+
+func TestWrongTransport(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: echo})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/socket.io/?EIO=4&transport=polling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("wrong status %s", resp.Status)
+	}
+}
@@ -0,0 +1,235 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package envelope protects the integrity of websocket messages using
+// HMAC-SHA256 signatures.
+//
+// This is useful where TLS is terminated at an untrusted proxy, so that
+// the transport encryption does not extend all the way from the client to
+// the server.  Both sides share a secret.  For every connection, a
+// separate key is derived from the secret, from the random nonce sent by
+// the client in the opening handshake ([websocket.Conn.HandshakeKey]), and
+// from a random nonce chosen by the server.  Since both sides contribute
+// randomness, messages recorded on one connection cannot be replayed on
+// another connection, in either direction.  Each message is signed
+// together with its direction and sequence number, so that messages cannot
+// be reflected, dropped, reordered or replayed within a connection without
+// detection.
+//
+// Truncation is not detected: if the connection is closed after some
+// messages, the receiver cannot tell whether the peer sent more messages
+// which were suppressed.  Applications which need this guarantee must
+// mark the end of the conversation with a message of their own.
+//
+// The first message on every connection is a binary message from the
+// server, containing the 16 byte server nonce.  Signed binary messages
+// consist of the 32 byte signature, followed by the payload.  Signed text
+// messages consist of the signature in unpadded base64url encoding, a '.'
+// character, and the payload.  Messages are not encrypted.
+package envelope
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+
+	"seehuhn.de/go/websocket"
+)
+
+// DefaultMaxMessageSize is the default value for Conn.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 20
+
+const (
+	macSize        = sha256.Size
+	encodedMACSize = 43 // base64.RawURLEncoding.EncodedLen(macSize)
+
+	fromClient byte = 'c'
+	fromServer byte = 's'
+
+	nonceSize = 16
+)
+
+// Conn signs outgoing and verifies incoming messages on a websocket
+// connection.
+type Conn struct {
+	// MaxMessageSize is the maximal size of received messages, including
+	// the signature.  This must be set before the first call to Receive.
+	MaxMessageSize int
+
+	ws *websocket.Conn
+
+	sendMu  sync.Mutex
+	sendMAC hash.Hash
+	sendDir byte
+	sendSeq uint64
+
+	recvMu  sync.Mutex
+	recvMAC hash.Hash
+	recvDir byte
+	recvSeq uint64
+}
+
+// NewConn returns a new Conn which uses a key derived from secret and from
+// the nonces of both sides.  Both sides of the connection must use the same
+// secret.
+//
+// On the server side, NewConn sends the server nonce to the client.  On the
+// client side, NewConn waits for the server nonce; no other messages must
+// be received on ws before NewConn is called.
+func NewConn(ws *websocket.Conn, secret []byte) (*Conn, error) {
+	nonce := make([]byte, nonceSize)
+	if ws.IsClient() {
+		n, err := ws.ReceiveBinary(nonce)
+		if err == websocket.ErrTooLarge || err == nil && n != nonceSize {
+			ws.Close(websocket.StatusProtocolError, "")
+			return nil, errNonce
+		} else if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		err = ws.SendBinary(nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := DeriveKey(secret, ws.HandshakeKey, nonce)
+	c := &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		ws:             ws,
+		sendMAC:        hmac.New(sha256.New, key),
+		recvMAC:        hmac.New(sha256.New, key),
+		sendDir:        fromServer,
+		recvDir:        fromClient,
+	}
+	if ws.IsClient() {
+		c.sendDir, c.recvDir = c.recvDir, c.sendDir
+	}
+	return c, nil
+}
+
+// DeriveKey returns the per-connection key used by NewConn.
+func DeriveKey(secret []byte, handshakeKey string, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("seehuhn.de/go/websocket/envelope\x00"))
+	mac.Write([]byte(handshakeKey))
+	mac.Write([]byte{0})
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// sign computes the signature of a message.
+func sign(mac hash.Hash, dir byte, seq uint64, tp websocket.MessageType, payload []byte) []byte {
+	var header [10]byte
+	header[0] = dir
+	binary.BigEndian.PutUint64(header[1:9], seq)
+	header[9] = byte(tp)
+
+	mac.Reset()
+	mac.Write(header[:])
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Send signs and sends a message.
+func (c *Conn) Send(tp websocket.MessageType, payload []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	sig := sign(c.sendMAC, c.sendDir, c.sendSeq, tp, payload)
+	var err error
+	switch tp {
+	case websocket.Text:
+		msg := make([]byte, encodedMACSize+1+len(payload))
+		base64.RawURLEncoding.Encode(msg, sig)
+		msg[encodedMACSize] = '.'
+		copy(msg[encodedMACSize+1:], payload)
+		err = c.ws.SendText(string(msg))
+	case websocket.Binary:
+		err = c.ws.SendBinary(append(sig, payload...))
+	default:
+		return websocket.ErrMessageType
+	}
+	if err != nil {
+		return err
+	}
+	c.sendSeq++
+	return nil
+}
+
+// Receive reads the next message and verifies its signature.  The payload
+// is returned without the signature.  If the signature is invalid, the
+// connection is closed and ErrBadSignature is returned.
+func (c *Conn) Receive() (websocket.MessageType, []byte, error) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+
+	tp, r, err := c.ws.ReceiveMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	msg, err := io.ReadAll(io.LimitReader(r, int64(c.MaxMessageSize)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(msg) > c.MaxMessageSize {
+		io.Copy(io.Discard, r)
+		c.ws.Close(websocket.StatusTooLarge, "")
+		return 0, nil, websocket.ErrTooLarge
+	}
+
+	var sig, payload []byte
+	switch tp {
+	case websocket.Text:
+		if len(msg) > encodedMACSize && msg[encodedMACSize] == '.' {
+			sig = make([]byte, macSize)
+			_, err = base64.RawURLEncoding.Decode(sig, msg[:encodedMACSize])
+			payload = msg[encodedMACSize+1:]
+		}
+	case websocket.Binary:
+		if len(msg) >= macSize {
+			sig, payload = msg[:macSize], msg[macSize:]
+		}
+	}
+	expected := sign(c.recvMAC, c.recvDir, c.recvSeq, tp, payload)
+	if sig == nil || err != nil || !hmac.Equal(sig, expected) {
+		c.ws.Close(websocket.StatusPolicyViolation, "invalid signature")
+		return 0, nil, ErrBadSignature
+	}
+	c.recvSeq++
+	return tp, payload, nil
+}
+
+// Close closes the underlying websocket connection.
+func (c *Conn) Close(code websocket.Status, message string) error {
+	return c.ws.Close(code, message)
+}
+
+// ErrBadSignature is returned by Receive if a message has an invalid
+// signature.
+var ErrBadSignature = errors.New("envelope: invalid message signature")
+
+var errNonce = errors.New("envelope: invalid server nonce")
@@ -0,0 +1,232 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+var secret = []byte("shared secret")
+
+func dial(t *testing.T, handle func(*websocket.Conn)) (*websocket.Conn, func()) {
+	t.Helper()
+	server := httptest.NewServer(&websocket.Handler{Handle: handle})
+	ws, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, func() {
+		ws.Close(websocket.StatusOK, "")
+		ws.Wait()
+		server.Close()
+	}
+}
+
+// echo returns all messages to the client, until an error occurs.
+func echo(errors chan<- error) func(*websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		c, err := NewConn(ws, secret)
+		if err != nil {
+			errors <- err
+			return
+		}
+		for {
+			tp, msg, err := c.Receive()
+			if err != nil {
+				errors <- err
+				break
+			}
+			err = c.Send(tp, msg)
+			if err != nil {
+				errors <- err
+				break
+			}
+		}
+		c.Close(websocket.StatusOK, "")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	serverErr := make(chan error, 1)
+	ws, cleanup := dial(t, echo(serverErr))
+	defer cleanup()
+
+	c, err := NewConn(ws, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []struct {
+		tp   websocket.MessageType
+		data string
+	}{
+		{websocket.Text, "hello"},
+		{websocket.Binary, "\x00\x01\x02"},
+		{websocket.Text, ""},
+		{websocket.Binary, ""},
+	}
+	for _, m := range msgs {
+		err := c.Send(m.tp, []byte(m.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, data, err := c.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != m.tp || string(data) != m.data {
+			t.Errorf("wrong message %s %q", tp, data)
+		}
+	}
+
+	err = c.Close(websocket.StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-serverErr; !errors.Is(err, websocket.ErrConnClosed) {
+		t.Errorf("unexpected server error %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	serverErr := make(chan error, 1)
+	ws, cleanup := dial(t, echo(serverErr))
+	defer cleanup()
+
+	nonce := make([]byte, nonceSize)
+	_, err := ws.ReceiveBinary(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A correctly signed first message, which is then sent a second time.
+	key := DeriveKey(secret, ws.HandshakeKey, nonce)
+	payload := []byte("transfer 100 EUR")
+	msg := sign(hmac.New(sha256.New, key), fromClient, 0, websocket.Binary, payload)
+	msg = append(msg, payload...)
+
+	for i := 0; i < 2; i++ {
+		err := ws.SendBinary(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := <-serverErr; err != ErrBadSignature {
+		t.Errorf("expected ErrBadSignature, got %v", err)
+	}
+
+	// the first message was accepted and echoed
+	buf := make([]byte, 100)
+	n, err := ws.ReceiveBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(buf[:n]), string(payload)) {
+		t.Errorf("wrong echo %q", buf[:n])
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	serverErr := make(chan error, 1)
+	ws, cleanup := dial(t, echo(serverErr))
+	defer cleanup()
+
+	c, err := NewConn(ws, []byte("wrong secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Send(websocket.Text, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; err != ErrBadSignature {
+		t.Errorf("expected ErrBadSignature, got %v", err)
+	}
+	_, status, _ := ws.Wait()
+	if status != websocket.StatusPolicyViolation {
+		t.Errorf("wrong status %d", status)
+	}
+}
+
+// recorder records all data written to a network connection.
+type recorder struct {
+	net.Conn
+	sent bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.sent.Write(p)
+	return r.Conn.Write(p)
+}
+
+func TestReplayOtherConn(t *testing.T) {
+	serverErr := make(chan error, 2)
+	server := httptest.NewServer(&websocket.Handler{Handle: echo(serverErr)})
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	// record a complete client session ...
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{Conn: raw}
+	ws, err := websocket.DefaultDialer.DialConn(context.Background(), rec,
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewConn(ws, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Send(websocket.Text, []byte("transfer 100 EUR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ... and replay it on a new connection
+	replay, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	_, err = replay.Write(rec.sent.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverErr; err != ErrBadSignature {
+		t.Errorf("expected ErrBadSignature, got %v", err)
+	}
+
+	c.Close(websocket.StatusOK, "")
+	ws.Wait()
+}
@@ -16,11 +16,19 @@
 
 package websocket
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
 
 var (
 	// ErrConnClosed indicates that the websocket connection has been
-	// closed (either by the server or the client).
+	// closed (either by the server or the client).  If the connection was
+	// closed because of a network error, the returned error wraps the
+	// network error.  If the peer sent a close frame, the returned error
+	// records the status code, see CloseStatus.  Use errors.Is(err,
+	// ErrConnClosed) to test for this condition.
 	ErrConnClosed = errors.New("connection closed")
 
 	// ErrMessageType indicates that an invalid message type has been
@@ -36,7 +44,104 @@ var (
 	// indicate that the client sent a too large message.
 	ErrTooLarge = errors.New("message too large")
 
-	errFrameFormat = errors.New("invalid frame format")
+	// ErrMessageLength is used by the writer returned by SendMessageN to
+	// indicate that the amount of data written does not match the message
+	// length.
+	ErrMessageLength = errors.New("wrong message length")
+
+	// ErrBadHandshake is returned by Dialer.Dial if the server did not
+	// accept the websocket handshake.
+	ErrBadHandshake = errors.New("websocket handshake rejected by server")
+
+	// ErrInvalidUTF8 is returned when sending a text message which is not
+	// valid utf-8, if ValidateOutgoingText is set in the Handler or Dialer.
+	ErrInvalidUTF8 = errors.New("invalid utf-8 in text message")
+
+	// ErrQueueFull is returned by SendQueue.Send, if the message does not
+	// fit into the SendBudget of the Handler, or if the messages of the
+	// queue have been discarded because of the budget.
+	ErrQueueFull = errors.New("send queue full")
 
-	errHandshake = errors.New("websocket handshake failed")
+	// ErrUnknownConn is returned by Registry.Close if there is no open
+	// connection with the given ID.
+	ErrUnknownConn = errors.New("unknown connection ID")
+
+	// ErrReservedBits is returned by Conn.SendRSV if the reserved bits
+	// have not been enabled for the connection by an RSVExtension.
+	ErrReservedBits = errors.New("reserved bits not enabled")
+
+	// ErrSkipMessage can be returned by Handler.Validate to drop a
+	// message, without closing the connection.
+	ErrSkipMessage = errors.New("message skipped")
+
+	// ErrDraining is returned when a message is sent on a connection
+	// after Conn.StartDraining has been called.
+	ErrDraining = errors.New("connection is draining")
 )
+
+// closedError is used in place of ErrConnClosed, if the connection was
+// closed because of a network error or a protocol violation, or after the
+// peer has sent a close frame.
+type closedError struct {
+	cause error
+
+	// status and message are the contents of the close frame sent by the
+	// peer.  If cause is set, status is zero.
+	status  Status
+	message string
+}
+
+func (err *closedError) Error() string {
+	if err.cause != nil {
+		return ErrConnClosed.Error() + ": " + err.cause.Error()
+	}
+	if err.status == StatusNotSent {
+		return ErrConnClosed.Error() + " by peer"
+	}
+	msg := fmt.Sprintf("%s by peer with status %d", ErrConnClosed, err.status)
+	if err.message != "" {
+		msg += fmt.Sprintf(" (%q)", err.message)
+	}
+	return msg
+}
+
+func (err *closedError) Unwrap() error {
+	return err.cause
+}
+
+func (err *closedError) Is(target error) bool {
+	return target == ErrConnClosed
+}
+
+// CloseStatus returns the status code from the close frame sent by the
+// peer, if err was returned by a Receive* or Send* method after the close
+// frame was received.  If the close frame did not include a status code,
+// StatusNotSent is returned.  If err does not record a close frame, for
+// example because the connection was dropped, the result is StatusDropped.
+// This matches the status code returned by Conn.Wait.
+func CloseStatus(err error) Status {
+	var ce *closedError
+	if errors.As(err, &ce) && ce.status != 0 {
+		return ce.status
+	}
+	return StatusDropped
+}
+
+// errorHook holds the callback installed by Conn.OnError.
+type errorHook struct {
+	fn atomic.Value // func(error)
+}
+
+// report passes err to the callback, if err is non-nil and a callback has
+// been installed.  The callback runs in a new goroutine, since report may
+// be called while the sender is held.
+func (h *errorHook) report(op string, err error) {
+	if h == nil || err == nil {
+		return
+	}
+	fn, _ := h.fn.Load().(func(error))
+	if fn == nil {
+		return
+	}
+	go fn(fmt.Errorf("websocket: %s: %w", op, err))
+}
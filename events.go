@@ -0,0 +1,419 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// connEvents holds the state of a connection in event-driven mode, see
+// Handler.OnMessage.
+type connEvents struct {
+	onMessage func(conn *Conn, tp MessageType, r io.Reader)
+
+	// token holds the receiver while no goroutine is reading from the
+	// connection.  A goroutine must take the receiver from token before
+	// reading.
+	token chan *receiver
+	data  *readManagerData
+
+	// pending is set if data was received together with the handshake
+	// request.
+	pending bool
+
+	mu      sync.Mutex
+	stopped bool  // set by forceStop and pollerFailed
+	pollErr error // set by pollerFailed
+
+	// poller is nil if the connection does not use the poller.  In this
+	// case, one goroutine per connection waits for incoming data.
+	poller *poller
+	fd     int
+}
+
+// errIdle is returned by receiver.refill in event-driven mode, if only
+// control frames were available.
+var errIdle = errors.New("no data available")
+
+// startEvents starts the delivery of messages to the OnMessage callback.
+func (conn *Conn) startEvents() {
+	ev := conn.events
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	if ev.stopped {
+		// The connection has been closed already.  The goroutine
+		// started by forceStop completes the shutdown.
+		return
+	}
+
+	rb := <-ev.token
+	p, err := getPoller()
+	if err == nil {
+		err = p.watch(conn)
+	}
+	if err != nil {
+		// Fall back to one goroutine per connection.
+		ev.token <- rb
+		go conn.dispatch()
+		return
+	}
+	rb.pollIdle = true
+	pending := ev.pending || rb.r.Buffered() > 0
+	ev.token <- rb
+
+	if pending {
+		go conn.dispatch()
+	} else {
+		conn.arm()
+	}
+}
+
+// dispatch reads messages from the connection and passes them to the
+// OnMessage callback.  If the connection uses the poller, dispatch returns
+// once all available data has been processed.  Otherwise, dispatch keeps
+// reading until the connection is closed.
+func (conn *Conn) dispatch() {
+	ev := conn.events
+
+	var rb *receiver
+	select {
+	case rb = <-ev.token:
+	default:
+		// Another goroutine is reading from the connection.
+		return
+	}
+
+	for {
+		if rb.connInfo == 0 && rb.header.Opcode != closeFrame {
+			err := rb.refill(false)
+			if err == errIdle {
+				ev.token <- rb
+				conn.arm()
+				return
+			}
+		}
+		if rb.connInfo != 0 || rb.header.Opcode == closeFrame {
+			if rb.connInfo == ConnDropped {
+				ev.mu.Lock()
+				if ev.pollErr != nil {
+					rb.readErr = ev.pollErr
+				}
+				ev.mu.Unlock()
+			}
+			conn.finishRead(rb, ev.data)
+			return
+		}
+
+		if conn.skipMessage(rb) {
+			if ev.poller != nil && rb.r.Buffered() == 0 && rb.connInfo == 0 {
+				ev.token <- rb
+				conn.arm()
+				return
+			}
+			continue
+		}
+
+		r := newAutoCloseReader(rb, ev.token)
+		span := rb.tracer.start("receive", rb.header.Opcode)
+		conn.startMessage(rb)
+		ev.onMessage(conn, rb.header.Opcode, r)
+		conn.endMessage()
+		io.Copy(io.Discard, r) // returns rb to ev.token
+		endSpan(span, nil)
+
+		select {
+		case rb = <-ev.token:
+		default:
+			// The connection was force-closed, and the goroutine started
+			// by forceStop has taken over.
+			return
+		}
+		rb.msgTrace = nil
+		if ev.poller != nil && rb.r.Buffered() == 0 && rb.connInfo == 0 {
+			ev.token <- rb
+			conn.arm()
+			return
+		}
+	}
+}
+
+// arm asks the poller to start a dispatch goroutine once more data
+// arrives.
+func (conn *Conn) arm() {
+	ev := conn.events
+	if ev.poller == nil {
+		go conn.dispatch()
+		return
+	}
+	ev.poller.arm(conn)
+}
+
+// forceStop closes the network connection, to terminate the reader.
+func (conn *Conn) forceStop() {
+	conn.ResumeReading()
+	ev := conn.events
+	if ev == nil {
+		conn.raw.Close()
+		return
+	}
+
+	ev.mu.Lock()
+	ev.stopped = true
+	ev.mu.Unlock()
+
+	// The connection must be removed from the poller before the file
+	// descriptor is closed and can be re-used.
+	conn.stopWatching()
+	conn.raw.Close()
+	go conn.dispatch()
+}
+
+// pollerFailed closes the connection after the poller has failed.  The
+// connection is dropped, with err as the cause.
+func (conn *Conn) pollerFailed(err error) {
+	ev := conn.events
+	ev.mu.Lock()
+	ev.stopped = true
+	ev.pollErr = err
+	ev.mu.Unlock()
+
+	conn.errors.report("waiting for data", err)
+	conn.raw.Close()
+	go conn.dispatch()
+}
+
+// stopWatching removes the connection from the poller, if needed.
+func (conn *Conn) stopWatching() {
+	ev := conn.events
+	if ev == nil {
+		return
+	}
+	ev.mu.Lock()
+	p := ev.poller
+	ev.mu.Unlock()
+	if p != nil {
+		p.unwatch(conn)
+	}
+}
+
+// poller waits for incoming data on idle connections in event-driven mode,
+// using a single goroutine for all connections.  The goroutine only runs
+// while connections are registered.
+type poller struct {
+	fd    int // the epoll or kqueue file descriptor
+	wakeR int // read end of a pipe, used to wake up the goroutine
+	wakeW int
+
+	mu      sync.Mutex
+	conns   map[int]*Conn
+	running bool
+	err     error // set if the poller has failed
+}
+
+var (
+	thePoller     *poller
+	thePollerErr  error
+	thePollerOnce sync.Once
+)
+
+// getPoller returns the poller, starting it on first use.
+func getPoller() (*poller, error) {
+	thePollerOnce.Do(func() {
+		thePoller, thePollerErr = newPoller()
+	})
+	return thePoller, thePollerErr
+}
+
+func newPoller() (*poller, error) {
+	fd, wakeR, wakeW, err := pollCreate()
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{
+		fd:    fd,
+		wakeR: wakeR,
+		wakeW: wakeW,
+		conns: make(map[int]*Conn),
+	}
+	return p, nil
+}
+
+// pollerFailure returns the error which caused the poller to fail, or nil
+// if the poller is working or is not available on this system.
+func pollerFailure() error {
+	p, err := getPoller()
+	if err != nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// watch registers a connection with the poller.  No notifications are
+// delivered until arm is called.
+func (p *poller) watch(conn *Conn) error {
+	sc, ok := conn.raw.(syscall.Conn)
+	if !ok {
+		return errNoPoller
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	err = rc.Control(func(s uintptr) { fd = int(s) })
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	err = pollControl(p.fd, fd, pollAdd)
+	if err != nil {
+		return err
+	}
+	p.conns[fd] = conn
+	conn.events.poller = p
+	conn.events.fd = fd
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+	return nil
+}
+
+// arm re-enables notifications for a connection, after a dispatch
+// goroutine has processed all available data.
+func (p *poller) arm(conn *Conn) {
+	fd := conn.events.fd
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		// The connection has been closed by pollerFailed.
+		go conn.dispatch()
+		return
+	}
+	if p.conns[fd] != conn {
+		// the connection has been closed
+		return
+	}
+	err := pollControl(p.fd, fd, pollArm)
+	if err != nil {
+		// Let the reader find out what went wrong.
+		go conn.dispatch()
+	}
+}
+
+// unwatch removes a connection from the poller.  This must be called
+// before the network connection is closed.
+func (p *poller) unwatch(conn *Conn) {
+	fd := conn.events.fd
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[fd] != conn {
+		return
+	}
+	pollControl(p.fd, fd, pollRemove)
+	delete(p.conns, fd)
+	if len(p.conns) == 0 && p.running {
+		// wake up the goroutine, so that it can terminate
+		pollWake(p.wakeW)
+	}
+}
+
+// run waits for incoming data and starts a dispatch goroutine for every
+// connection which has become readable.  The function returns once no
+// connections are left, or once the poller has failed.
+func (p *poller) run() {
+	fds := make([]int, 128)
+	for {
+		n, err := pollWait(p.fd, fds)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			p.fail(fmt.Errorf("websocket: poller failed: %w", err))
+			n = 0
+		}
+
+		p.mu.Lock()
+		if p.err != nil {
+			p.running = false
+			pollClose(p.fd, p.wakeR, p.wakeW)
+			p.mu.Unlock()
+			return
+		}
+		for _, fd := range fds[:n] {
+			if fd == p.wakeR {
+				pollDrain(p.wakeR)
+			} else if conn := p.conns[fd]; conn != nil {
+				go conn.dispatch()
+			}
+		}
+		if len(p.conns) == 0 {
+			p.running = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+}
+
+// fail shuts down the poller after an error.  All connections registered
+// with the poller are dropped, and new connections in event-driven mode
+// are refused, see Handler.OnMessage.
+func (p *poller) fail(err error) {
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.err = err
+	conns := p.conns
+	p.conns = make(map[int]*Conn)
+	if p.running {
+		// wake up the goroutine, so that it can close the file descriptors
+		pollWake(p.wakeW)
+	} else {
+		pollClose(p.fd, p.wakeR, p.wakeW)
+	}
+	p.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.pollerFailed(err)
+	}
+}
+
+type pollOp int
+
+const (
+	pollAdd pollOp = iota
+	pollArm
+	pollRemove
+)
+
+var errNoPoller = errors.New("poller not available")
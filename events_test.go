@@ -0,0 +1,266 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventMode(t *testing.T) {
+	const numClients = 50
+
+	closed := make(chan Status, numClients)
+	handler := &Handler{
+		OnMessage: func(conn *Conn, tp MessageType, r io.Reader) {
+			body, err := io.ReadAll(r)
+			if err != nil {
+				return
+			}
+			conn.SendText(strings.ToUpper(string(body)))
+		},
+		OnClose: func(conn *Conn) {
+			_, status, _ := conn.Wait()
+			closed <- status
+		},
+	}
+	server, err := StartTestServerWithHandler(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Idle connections must not use any goroutines.
+	clients := make([]*TestClient, numClients)
+	before := runtime.NumGoroutine()
+	for i := range clients {
+		clients[i], err = server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clients[i].Close()
+	}
+	checkGoroutines := func() {
+		t.Helper()
+		var n int
+		for i := 0; i < 100; i++ {
+			n = runtime.NumGoroutine() - before
+			if n < numClients/2 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("%d goroutines for %d idle connections", n, numClients)
+	}
+	checkGoroutines()
+
+	for _, client := range clients {
+		err = client.SendFrame(pingFrame, []byte("ping"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != pongFrame || string(body) != "ping" {
+			t.Errorf("expected pong, got %s %q", tp, body)
+		}
+
+		err = client.SendFrame(Text, []byte("hel"), false)
+		if err == nil {
+			err = client.SendFrame(contFrame, []byte("lo"), true)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, body, err = client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != Text || string(body) != "HELLO" {
+			t.Errorf("wrong response %s %q", tp, body)
+		}
+	}
+	checkGoroutines()
+
+	for _, client := range clients {
+		err = client.SendFrame(closeFrame, []byte{3, 232}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, _, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != closeFrame {
+			t.Errorf("expected close frame, got %s", tp)
+		}
+	}
+	for range clients {
+		if status := <-closed; status != StatusOK {
+			t.Errorf("wrong status %d", status)
+		}
+	}
+}
+
+// TestEventModeServerClose checks that connections in event-driven mode
+// are shut down, if the client does not answer the closing handshake.
+// This takes three seconds.
+func TestEventModeServerClose(t *testing.T) {
+	closed := make(chan ConnInfo, 1)
+	handler := &Handler{
+		Handle: func(conn *Conn) {
+			conn.Close(StatusGoingAway, "")
+		},
+		OnMessage: func(conn *Conn, tp MessageType, r io.Reader) {},
+		OnClose: func(conn *Conn) {
+			info, _, _ := conn.Wait()
+			closed <- info
+		},
+	}
+	server, err := StartTestServerWithHandler(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tp, _, err := client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != closeFrame {
+		t.Errorf("expected close frame, got %s", tp)
+	}
+
+	select {
+	case info := <-closed:
+		if info != ConnDropped {
+			t.Errorf("wrong ConnInfo %d", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("connection not shut down")
+	}
+}
+
+func TestPollerFailure(t *testing.T) {
+	p, err := getPoller()
+	if err != nil {
+		t.Skip("poller not available")
+	}
+	defer func() {
+		// Wait for the poller goroutine to terminate, and then install a
+		// new poller for the remaining tests.
+		for {
+			p.mu.Lock()
+			running := p.running
+			p.mu.Unlock()
+			if !running {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		thePoller, thePollerErr = newPoller()
+	}()
+
+	closed := make(chan ConnInfo, 1)
+	reported := make(chan error, 4)
+	upgradeErr := make(chan error, 2)
+	handler := &Handler{
+		OnMessage: func(conn *Conn, tp MessageType, r io.Reader) {},
+		OnClose: func(conn *Conn) {
+			info, _, _ := conn.Wait()
+			closed <- info
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := handler.Upgrade(w, r)
+		if err == nil {
+			conn.OnError(func(err error) {
+				select {
+				case reported <- err:
+				default:
+				}
+			})
+		}
+		upgradeErr <- err
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	client, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close(StatusOK, "")
+	if err := <-upgradeErr; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		p.mu.Lock()
+		n := len(p.conns)
+		p.mu.Unlock()
+		if n > 0 {
+			break
+		} else if i >= 1000 {
+			t.Fatal("connection not registered with the poller")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	failure := errors.New("test failure")
+	p.fail(failure)
+
+	select {
+	case info := <-closed:
+		if info != ConnDropped {
+			t.Errorf("wrong ConnInfo %d", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	// Closing the connection may report further errors.
+	for found := false; !found; {
+		select {
+		case err := <-reported:
+			found = errors.Is(err, failure)
+		case <-time.After(5 * time.Second):
+			t.Fatal("poller failure not reported")
+		}
+	}
+
+	client2, err := DefaultDialer.Dial(context.Background(), url)
+	if err == nil {
+		client2.Close(StatusOK, "")
+	}
+	if err := <-upgradeErr; !errors.Is(err, failure) {
+		t.Errorf("expected upgrade to fail, got %v", err)
+	}
+}
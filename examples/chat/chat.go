@@ -20,6 +20,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"sync"
@@ -32,8 +33,10 @@ import (
 type Chat struct {
 	send chan<- *Message
 
+	// changed receives a value whenever the list of members changes
+	changed chan struct{}
+
 	sync.Mutex
-	change  *sync.Cond
 	members *members
 }
 
@@ -42,9 +45,9 @@ func NewChat() *Chat {
 	c := make(chan *Message, 1)
 	chat := &Chat{
 		send:    c,
+		changed: make(chan struct{}, 1),
 		members: &members{},
 	}
-	chat.change = sync.NewCond(&chat.Mutex)
 
 	go chat.receiveMessages()
 	go chat.broadcastMessages(c)
@@ -52,43 +55,20 @@ func NewChat() *Chat {
 }
 
 func (chat *Chat) receiveMessages() {
-	lock := sync.Mutex{}
-	nextMembers := &members{}
-	nextCtx, abortReceive := context.WithCancel(context.Background())
-
-	go func() {
-		// Starting with the empty list, every time the members' list
-		// changes, update our copy of the list and interrupt the
-		// the current read by cancelling the context.
-		chat.Lock()
-		for {
-			chat.change.Wait()
-
-			oldAbortReceive := abortReceive
-
-			lock.Lock()
-			nextCtx, abortReceive = context.WithCancel(context.Background())
-			nextMembers = chat.members.Copy()
-			lock.Unlock()
-
-			oldAbortReceive()
-		}
-	}()
-
 	for {
-		lock.Lock()
-		members := nextMembers
-		ctx := nextCtx
-		lock.Unlock()
+		chat.Lock()
+		members := chat.members.Copy()
+		chat.Unlock()
 
-		idx, msgText, err := websocket.SelectText(ctx, 1024, members.conns)
-		if idx < 0 {
+		res, err := websocket.Select(context.Background(), members.conns, chat.changed)
+		if res.Chan >= 0 || err != nil {
 			// members list was updated
 			continue
 		}
 
-		conn := members.conns[idx]
-		name := members.names[idx]
+		conn := members.conns[res.Conn]
+		name := members.names[res.Conn]
+		msgText, err := readText(res.Type, res.Reader, 1024)
 		switch {
 		case err != nil:
 			chat.Remove(conn)
@@ -111,6 +91,26 @@ func (chat *Chat) receiveMessages() {
 	}
 }
 
+// readText reads a text message of at most maxLength bytes.  The reader is
+// always drained.
+func readText(tp websocket.MessageType, r io.Reader, maxLength int) (string, error) {
+	defer io.Copy(io.Discard, r)
+	if tp != websocket.Text {
+		return "", websocket.ErrMessageType
+	}
+	buf, err := io.ReadAll(io.LimitReader(r, int64(maxLength)))
+	return string(buf), err
+}
+
+// notify informs receiveMessages that the list of members has changed.
+func (chat *Chat) notify() {
+	select {
+	case chat.changed <- struct{}{}:
+	default:
+		// a notification is already pending
+	}
+}
+
 func (chat *Chat) broadcastMessages(messages <-chan *Message) {
 	for msg := range messages {
 		msgJSON, err := msg.asJSON()
@@ -156,7 +156,7 @@ func (chat *Chat) Add(conn *websocket.Conn) {
 	if !alreadyPresent {
 		chat.members.names = append(chat.members.names, name)
 		chat.members.conns = append(chat.members.conns, conn)
-		chat.change.Broadcast()
+		chat.notify()
 	}
 	chat.Unlock()
 
@@ -189,7 +189,7 @@ func (chat *Chat) Remove(conn *websocket.Conn) {
 		}
 		chat.members.conns = chat.members.conns[:n]
 		chat.members.names = chat.members.names[:n]
-		chat.change.Broadcast()
+		chat.notify()
 	}
 	chat.Unlock()
 
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"io"
 	"log"
@@ -32,7 +33,7 @@ func echo(conn *websocket.Conn) {
 
 	for {
 		tp, r, err := conn.ReceiveMessage()
-		if err == websocket.ErrConnClosed {
+		if errors.Is(err, websocket.ErrConnClosed) {
 			break
 		} else if err != nil {
 			log.Println("read error:", err)
@@ -55,7 +56,7 @@ func echo(conn *websocket.Conn) {
 		}
 
 		err = w.Close()
-		if err != nil && err != websocket.ErrConnClosed {
+		if err != nil && !errors.Is(err, websocket.ErrConnClosed) {
 			log.Println("close error:", err)
 		}
 	}
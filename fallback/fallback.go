@@ -0,0 +1,502 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package fallback provides a replacement transport for clients which
+// cannot use websockets, for example because a proxy server blocks the
+// protocol upgrade.
+//
+// A [Handler] accepts websocket connections as well as fallback sessions,
+// and passes both to the same Handle function using the [Conn] interface.
+// Fallback sessions only transport text messages.  They use the following
+// HTTP requests, where URL is the URL of the handler:
+//
+//	POST URL                     open a session; the response body is the session ID
+//	GET URL?session=ID&ack=N     receive messages (see below)
+//	POST URL?session=ID&ack=N    send the request body as a message
+//	DELETE URL?session=ID        close the session
+//
+// Messages to the client are numbered consecutively, starting at 0.  A
+// message is kept by the server until the client acknowledges it, so that
+// no messages are lost if a response fails to reach the client.  The
+// optional ack parameter gives the number of messages the client has
+// received so far; this acknowledges all messages before message N.
+// A GET request returns the unacknowledged messages, starting with
+// message N (or with the first unacknowledged message, if ack is
+// missing).  At most 64 messages are kept for each session; if this limit
+// is reached, SendText blocks until the client acknowledges some messages.
+//
+// If the GET request has an "Accept: text/event-stream" header, messages
+// are delivered as server-sent events, with one event per message.  The ID
+// of each event is the ack value which acknowledges this message, and a
+// "Last-Event-ID" header is treated like the ack parameter.  Since an
+// event stream cannot acknowledge messages, the server ends the stream
+// when the limit of unacknowledged messages is reached; the client then
+// reconnects to acknowledge the messages received.  When the session is
+// closed, an event of type "close" is sent, with the status code and
+// message as data.
+//
+// Otherwise, the request is answered using long polling: the response is a
+// JSON array containing the messages, and is delayed until at least one
+// message is available.  The response status is 410 (Gone) once the
+// session has been closed.
+package fallback
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Conn is the subset of the [websocket.Conn] methods which is available for
+// both websocket connections and fallback sessions.
+type Conn interface {
+	SendText(msg string) error
+	ReceiveText(maxLength int) (string, error)
+	Close(code websocket.Status, message string) error
+}
+
+var _ Conn = (*websocket.Conn)(nil)
+
+const (
+	defaultIdleTimeout = 30 * time.Second
+	pollTimeout        = 25 * time.Second
+	keepAliveInterval  = 15 * time.Second
+	maxPostSize        = 1 << 20
+	queueLength        = 64
+)
+
+// Handler implements the http.Handler interface.  Requests to upgrade to
+// the websocket protocol are handled by the WebSocket handler, all other
+// requests are treated as requests for fallback sessions.
+type Handler struct {
+	// WebSocket is used to accept websocket connections.  The Handle field
+	// of WebSocket is ignored.  If WebSocket is nil, a websocket.Handler
+	// with default settings is used.  The AccessAllowed function of
+	// WebSocket, if set, is also applied to requests which open a
+	// fallback session.
+	WebSocket *websocket.Handler
+
+	// Handle is called for every new websocket connection and for every
+	// new fallback session.  The connection must be closed after use.
+	Handle func(conn Conn)
+
+	// IdleTimeout is the time after which a fallback session is closed, if
+	// the client has not made any requests.  If this is zero, 30 seconds
+	// are used.
+	IdleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*pollConn
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if isUpgrade(req) {
+		ws := h.WebSocket
+		if ws == nil {
+			ws = &websocket.Handler{}
+		}
+		conn, err := ws.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		h.Handle(conn)
+		return
+	}
+
+	id := req.URL.Query().Get("session")
+	if id == "" {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.open(w, req)
+		return
+	}
+
+	h.mu.Lock()
+	c := h.sessions[id]
+	h.mu.Unlock()
+	if c == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	c.startRequest()
+	defer c.endRequest()
+
+	ack := req.URL.Query().Get("ack")
+	if ack == "" && req.Method == http.MethodGet {
+		ack = req.Header.Get("Last-Event-ID")
+	}
+	next, ok := c.acknowledge(ack)
+	if !ok {
+		http.Error(w, "invalid ack", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+			c.serveEvents(w, req, next)
+		} else {
+			c.servePoll(w, req, next)
+		}
+	case http.MethodPost:
+		c.receive(w, req)
+	case http.MethodDelete:
+		c.Close(websocket.StatusOK, "")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func isUpgrade(req *http.Request) bool {
+	for _, value := range req.Header.Values("Upgrade") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "websocket") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *Handler) open(w http.ResponseWriter, req *http.Request) {
+	if h.WebSocket != nil && h.WebSocket.AccessAllowed != nil {
+		ok, _ := h.WebSocket.AccessAllowed(req)
+		if !ok {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	var buf [16]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(buf[:])
+
+	idleTimeout := h.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	c := &pollConn{
+		id:          id,
+		h:           h,
+		idleTimeout: idleTimeout,
+		changed:     make(chan struct{}),
+		in:          make(chan string),
+		closed:      make(chan struct{}),
+	}
+	c.idle = time.AfterFunc(idleTimeout, func() {
+		c.Close(websocket.StatusGoingAway, "session timed out")
+	})
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*pollConn)
+	}
+	h.sessions[id] = c
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, id)
+
+	go h.Handle(c)
+}
+
+// pollConn is a fallback session.
+type pollConn struct {
+	id          string
+	h           *Handler
+	idleTimeout time.Duration
+
+	in chan string // messages from the client
+
+	mu           sync.Mutex
+	out          []string      // unacknowledged messages to the client
+	first        uint64        // sequence number of out[0]
+	changed      chan struct{} // closed when out or isClosed change
+	active       int           // number of requests in progress
+	idle         *time.Timer
+	isClosed     bool
+	closed       chan struct{}
+	closeStatus  websocket.Status
+	closeMessage string
+}
+
+// SendText queues a text message for delivery to the client.  If too many
+// messages are queued, SendText blocks until the client has acknowledged
+// some of them.
+func (c *pollConn) SendText(msg string) error {
+	for {
+		c.mu.Lock()
+		if c.isClosed {
+			c.mu.Unlock()
+			return websocket.ErrConnClosed
+		}
+		if len(c.out) < queueLength {
+			c.out = append(c.out, msg)
+			c.notify()
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		<-changed
+	}
+}
+
+// notify wakes up all goroutines waiting for changes of the session state.
+// This must be called with c.mu held.
+func (c *pollConn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// acknowledge discards the messages acknowledged by the given ack value
+// and returns the sequence number of the first message to deliver next.
+// If ack is empty, no messages are discarded.  The return value ok is
+// false if ack is malformed or out of range.
+func (c *pollConn) acknowledge(ack string) (next uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ack == "" {
+		return c.first, true
+	}
+	n, err := strconv.ParseUint(ack, 10, 64)
+	if err != nil || n < c.first || n-c.first > uint64(len(c.out)) {
+		return 0, false
+	}
+	if n > c.first {
+		c.out = append([]string(nil), c.out[n-c.first:]...)
+		c.first = n
+		c.notify()
+	}
+	return n, true
+}
+
+// pending returns the queued messages starting at sequence number next,
+// together with a channel which is closed when the session state changes.
+// The return value full indicates that SendText is blocked until more
+// messages are acknowledged.
+func (c *pollConn) pending(next uint64) (msgs []string, full, isClosed bool, changed <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if next >= c.first && next-c.first < uint64(len(c.out)) {
+		msgs = append(msgs, c.out[next-c.first:]...)
+	}
+	return msgs, len(c.out) >= queueLength, c.isClosed, c.changed
+}
+
+// ReceiveText returns the next message sent by the client.  If the message
+// is longer than maxLength bytes, the message is truncated and
+// websocket.ErrTooLarge is returned.
+func (c *pollConn) ReceiveText(maxLength int) (string, error) {
+	select {
+	case msg := <-c.in:
+		if len(msg) > maxLength {
+			msg = msg[:maxLength]
+			for len(msg) > 0 && !utf8.ValidString(msg) {
+				msg = msg[:len(msg)-1]
+			}
+			return msg, websocket.ErrTooLarge
+		}
+		return msg, nil
+	case <-c.closed:
+		return "", websocket.ErrConnClosed
+	}
+}
+
+// Close closes the session.  Messages which have not yet been delivered to
+// the client are discarded.
+func (c *pollConn) Close(code websocket.Status, message string) error {
+	c.mu.Lock()
+	if c.isClosed {
+		c.mu.Unlock()
+		return websocket.ErrConnClosed
+	}
+	c.isClosed = true
+	c.closeStatus = code
+	c.closeMessage = message
+	c.idle.Stop()
+	close(c.closed)
+	c.notify()
+	c.mu.Unlock()
+
+	c.h.mu.Lock()
+	delete(c.h.sessions, c.id)
+	c.h.mu.Unlock()
+	return nil
+}
+
+func (c *pollConn) startRequest() {
+	c.mu.Lock()
+	c.active++
+	c.idle.Stop()
+	c.mu.Unlock()
+}
+
+func (c *pollConn) endRequest() {
+	c.mu.Lock()
+	c.active--
+	if c.active == 0 && !c.isClosed {
+		c.idle.Reset(c.idleTimeout)
+	}
+	c.mu.Unlock()
+}
+
+// receive handles a message sent by the client.
+func (c *pollConn) receive(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxPostSize+1))
+	if err != nil {
+		return
+	}
+	if len(body) > maxPostSize {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !utf8.Valid(body) {
+		http.Error(w, "invalid utf-8", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case c.in <- string(body):
+		w.WriteHeader(http.StatusNoContent)
+	case <-c.closed:
+		http.Error(w, "session closed", http.StatusGone)
+	case <-req.Context().Done():
+	}
+}
+
+// servePoll answers a long-polling request.
+func (c *pollConn) servePoll(w http.ResponseWriter, req *http.Request, next uint64) {
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+
+	msgs := []string{}
+wait:
+	for {
+		pending, _, isClosed, changed := c.pending(next)
+		if isClosed {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		if len(pending) > 0 {
+			msgs = pending
+			break
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			break wait
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// serveEvents delivers messages as server-sent events.
+func (c *pollConn) serveEvents(w http.ResponseWriter, req *http.Request, next uint64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		msgs, full, isClosed, changed := c.pending(next)
+		for _, msg := range msgs {
+			next++
+			writeEvent(w, "", strconv.FormatUint(next, 10), msg)
+		}
+		if isClosed {
+			c.mu.Lock()
+			status, message := c.closeStatus, c.closeMessage
+			c.mu.Unlock()
+			writeEvent(w, "close", "", fmt.Sprintf("%d %s", status, message))
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		if full && len(msgs) == 0 {
+			// Wait for the client to reconnect and acknowledge the
+			// messages received so far.
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			io.WriteString(w, ":\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// lineBreaks normalises all line endings recognised by the event stream
+// format to "\n".
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// writeEvent writes one server-sent event.  Empty event types and IDs are
+// omitted.
+func writeEvent(w io.Writer, event, id, data string) {
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	for _, line := range strings.Split(lineBreaks.Replace(data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	io.WriteString(w, "\n")
+}
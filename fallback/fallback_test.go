@@ -0,0 +1,217 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package fallback
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+// upper sends every message back to the client, converted to upper case.
+func upper(conn Conn) {
+	for {
+		msg, err := conn.ReceiveText(1024)
+		if err != nil {
+			break
+		}
+		err = conn.SendText(strings.ToUpper(msg))
+		if err != nil {
+			break
+		}
+	}
+	conn.Close(websocket.StatusOK, "")
+}
+
+func TestWebSocket(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: upper})
+	defer server.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := conn.ReceiveText(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "HELLO" {
+		t.Errorf("wrong message %q", msg)
+	}
+	conn.Close(websocket.StatusOK, "")
+	conn.Wait()
+}
+
+func openSession(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Post(url, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	id, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(id) == 0 {
+		t.Fatalf("cannot open session: %s", resp.Status)
+	}
+	return url + "?session=" + string(id)
+}
+
+func post(t *testing.T, url, msg string) {
+	t.Helper()
+	resp, err := http.Post(url, "text/plain", strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("cannot send message: %s", resp.Status)
+	}
+}
+
+func poll(t *testing.T, url string, ack int) []string {
+	t.Helper()
+	resp, err := http.Get(url + "&ack=" + strconv.Itoa(ack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cannot receive messages: %s", resp.Status)
+	}
+	var msgs []string
+	err = json.NewDecoder(resp.Body).Decode(&msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestLongPolling(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: upper})
+	defer server.Close()
+
+	url := openSession(t, server.URL)
+	post(t, url, "hello")
+	post(t, url, "world")
+
+	var received []string
+	for len(received) < 2 {
+		received = append(received, poll(t, url, len(received))...)
+	}
+	if received[0] != "HELLO" || received[1] != "WORLD" {
+		t.Errorf("wrong messages %q", received)
+	}
+
+	// Unacknowledged messages are delivered again, for example if a
+	// response was lost.
+	again := poll(t, url, 1)
+	if len(again) != 1 || again[0] != "WORLD" {
+		t.Errorf("wrong messages %q", again)
+	}
+
+	post(t, url, "again")
+	again = poll(t, url, 2)
+	if len(again) != 1 || again[0] != "AGAIN" {
+		t.Errorf("wrong messages %q", again)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("closed session: got %s", resp.Status)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server := httptest.NewServer(&Handler{Handle: upper})
+	defer server.Close()
+
+	url := openSession(t, server.URL)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "")
+	}
+
+	post(t, url, "two\nlines")
+	if ev := readEvent(); ev != "id: 1\ndata: TWO\ndata: LINES\n" {
+		t.Errorf("wrong event %q", ev)
+	}
+	post(t, url, "a\r\nb\rc")
+	if ev := readEvent(); ev != "id: 2\ndata: A\ndata: B\ndata: C\n" {
+		t.Errorf("wrong event %q", ev)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, url, nil)
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(rest), "event: close\ndata: 1000 \n\n") {
+		t.Errorf("missing close event: %q", rest)
+	}
+}
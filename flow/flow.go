@@ -0,0 +1,277 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package flow adds credit-based flow control to websocket connections.
+//
+// Websocket connections only have the flow control of the underlying TCP
+// connection, which lets a fast sender fill large buffers before it is
+// slowed down.  With this package, each side grants the peer a window of
+// credit, in bytes.  Sending a message uses up credit, and messages can
+// only be sent while enough credit is available.  Once the application
+// has consumed received messages, the receiving side returns the credit
+// to the peer, similar to WINDOW_UPDATE frames in HTTP/2.
+//
+// Both sides of a connection must use this package.  Data messages are
+// prefixed with the byte 'd', and credit is returned using text messages
+// of the form "w<increment>", where the increment is a decimal number.
+// Each side starts by granting its initial window to the peer.
+package flow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Subprotocol is the websocket sub-protocol name for connections which use
+// flow control.
+const Subprotocol = "flow.v1"
+
+// maxCreditMessageSize is the maximal length of a credit message: "w"
+// followed by a positive int64 in decimal.
+const maxCreditMessageSize = 20
+
+// Conn is a websocket connection with flow control.
+type Conn struct {
+	ws     *websocket.Conn
+	window int64 // the window we grant to the peer
+
+	mu         sync.Mutex
+	changed    chan struct{} // closed when the state below changes
+	sendWindow int64         // remaining credit granted by the peer
+	peerWindow int64         // initial window of the peer, 0 if not known yet
+	recvWindow int64         // remaining credit granted to the peer
+	consumed   int64         // consumed bytes, not yet returned to the peer
+	queue      []message
+	err        error
+}
+
+type message struct {
+	tp   websocket.MessageType
+	data []byte
+}
+
+// NewConn adds flow control to ws.  The peer is allowed to have at most
+// window bytes of data in flight or in the receive queue; this also limits
+// the size of individual messages.  NewConn starts a goroutine which reads
+// all messages from ws; the Receive* methods of ws must not be used any
+// more.
+func NewConn(ws *websocket.Conn, window int) (*Conn, error) {
+	if window <= 0 {
+		return nil, errWindow
+	}
+	c := &Conn{
+		ws:         ws,
+		window:     int64(window),
+		changed:    make(chan struct{}),
+		recvWindow: int64(window),
+	}
+	err := c.sendCredit(int64(window))
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Send sends a message, after waiting until the peer has granted enough
+// credit.  If the message is larger than the peer's window, so that it can
+// never be sent, websocket.ErrTooLarge is returned.  If ctx is cancelled
+// while waiting for credit, ctx.Err() is returned and the message is not
+// sent.
+func (c *Conn) Send(ctx context.Context, tp websocket.MessageType, msg []byte) error {
+	if tp != websocket.Text && tp != websocket.Binary {
+		return websocket.ErrMessageType
+	}
+	l := int64(len(msg))
+	for {
+		c.mu.Lock()
+		if c.err != nil {
+			c.mu.Unlock()
+			return c.err
+		}
+		if c.peerWindow > 0 && l > c.peerWindow {
+			c.mu.Unlock()
+			return websocket.ErrTooLarge
+		}
+		if c.peerWindow > 0 && c.sendWindow >= l {
+			c.sendWindow -= l
+			c.mu.Unlock()
+			break
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	w, err := c.ws.SendMessageN(tp, l+1)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte{'d'})
+	if err == nil {
+		_, err = w.Write(msg)
+	}
+	closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Receive returns the next message sent by the peer.  Once the message has
+// been returned, the credit is given back to the peer.  If the connection
+// has been closed, websocket.ErrConnClosed is returned.
+func (c *Conn) Receive(ctx context.Context) (websocket.MessageType, []byte, error) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			msg := c.queue[0]
+			c.queue[0] = message{}
+			c.queue = c.queue[1:]
+
+			// Return credit in batches, but don't let the peer wait
+			// once we have caught up.
+			var credit int64
+			c.consumed += int64(len(msg.data))
+			if c.consumed >= c.window/2 || len(c.queue) == 0 && c.consumed > 0 {
+				credit = c.consumed
+				c.consumed = 0
+				c.recvWindow += credit
+			}
+			c.mu.Unlock()
+
+			if credit > 0 {
+				c.sendCredit(credit)
+			}
+			return msg.tp, msg.data, nil
+		}
+		if c.err != nil {
+			c.mu.Unlock()
+			return 0, nil, c.err
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the underlying websocket connection.
+func (c *Conn) Close(code websocket.Status, message string) error {
+	return c.ws.Close(code, message)
+}
+
+func (c *Conn) sendCredit(credit int64) error {
+	return c.ws.SendText("w" + strconv.FormatInt(credit, 10))
+}
+
+// notify wakes up all goroutines waiting for a state change.  This must be
+// called with c.mu held.
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Conn) readLoop() {
+	var err error
+	for {
+		var tp websocket.MessageType
+		var r io.Reader
+		tp, r, err = c.ws.ReceiveMessage()
+		if err != nil {
+			break
+		}
+
+		c.mu.Lock()
+		limit := c.recvWindow
+		c.mu.Unlock()
+
+		// Credit messages are short, but may arrive when no credit is left.
+		if limit < maxCreditMessageSize {
+			limit = maxCreditMessageSize
+		}
+		var data []byte
+		data, err = io.ReadAll(io.LimitReader(r, limit+2))
+		if err != nil {
+			break
+		}
+		if len(data) == 0 || int64(len(data)) > limit+1 {
+			io.Copy(io.Discard, r)
+			err = errProtocol
+			break
+		}
+
+		switch {
+		case data[0] == 'd':
+			c.mu.Lock()
+			if int64(len(data)-1) > c.recvWindow {
+				c.mu.Unlock()
+				err = errProtocol
+				break
+			}
+			c.recvWindow -= int64(len(data) - 1)
+			c.queue = append(c.queue, message{tp: tp, data: data[1:]})
+			c.notify()
+			c.mu.Unlock()
+		case data[0] == 'w' && tp == websocket.Text:
+			var credit int64
+			credit, err = strconv.ParseInt(string(data[1:]), 10, 64)
+			if err != nil || credit <= 0 {
+				err = errProtocol
+				break
+			}
+			c.mu.Lock()
+			if c.peerWindow == 0 {
+				c.peerWindow = credit
+			}
+			c.sendWindow += credit
+			c.notify()
+			c.mu.Unlock()
+		default:
+			err = errProtocol
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if err == errProtocol {
+		c.ws.Close(websocket.StatusPolicyViolation, "flow control violation")
+	}
+	c.mu.Lock()
+	c.err = websocket.ErrConnClosed
+	c.notify()
+	c.mu.Unlock()
+}
+
+var (
+	errWindow   = errors.New("flow: window size must be positive")
+	errProtocol = errors.New("flow: protocol violation")
+)
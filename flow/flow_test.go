@@ -0,0 +1,106 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package flow
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+func TestFlowControl(t *testing.T) {
+	const window = 1000
+
+	serverConn := make(chan *Conn, 1)
+	server := httptest.NewServer(&websocket.Handler{
+		Handle: func(ws *websocket.Conn) {
+			c, err := NewConn(ws, window)
+			if err != nil {
+				t.Error(err)
+				ws.Close(websocket.StatusInternalServerError, "")
+				return
+			}
+			serverConn <- c
+		},
+	})
+	defer server.Close()
+
+	ws, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewConn(ws, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-serverConn
+
+	ctx := context.Background()
+	msg := make([]byte, 600)
+	err = client.Send(ctx, websocket.Binary, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server has not consumed the first message yet, so the second
+	// message exceeds the remaining credit.
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	err = client.Send(short, websocket.Binary, msg)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected send to block, got %v", err)
+	}
+
+	// messages larger than the window can never be sent
+	err = client.Send(ctx, websocket.Binary, make([]byte, window+1))
+	if err != websocket.ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	tp, data, err := s.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != websocket.Binary || len(data) != len(msg) {
+		t.Errorf("wrong message: %s, %d bytes", tp, len(data))
+	}
+
+	// Once the message has been consumed, the credit is returned.
+	err = client.Send(ctx, websocket.Text, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, data, err = s.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != websocket.Text || string(data) != "hello" {
+		t.Errorf("wrong message: %s %q", tp, data)
+	}
+
+	client.Close(websocket.StatusOK, "")
+	_, _, err = s.Receive(ctx)
+	if err != websocket.ErrConnClosed {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	s.Close(websocket.StatusOK, "")
+}
@@ -0,0 +1,110 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientAddr returns the address of the client which sent req.  If the
+// direct peer is one of the TrustedProxies, the address is taken from the
+// Forwarded or X-Forwarded-For header.  Otherwise, req.RemoteAddr is
+// returned.
+func (handler *Handler) clientAddr(req *http.Request) string {
+	addr := req.RemoteAddr
+	if len(handler.TrustedProxies) == 0 || !handler.isTrustedProxy(addr) {
+		return addr
+	}
+
+	var hops []string
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		hops = forwardedFor(values)
+	} else {
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	}
+
+	// The entries are appended by each proxy, so the right-most entry
+	// which is not a trusted proxy is the client address.
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if parseHostIP(hop) == nil {
+			// an obfuscated identifier, or garbage
+			break
+		}
+		addr = hop
+		if !handler.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// isTrustedProxy reports whether the host part of addr is listed in
+// TrustedProxies.
+func (handler *Handler) isTrustedProxy(addr string) bool {
+	ip := parseHostIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range handler.TrustedProxies {
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && network.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(entry); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostIP extracts the IP address from an address of the form "ip",
+// "ip:port", "[ipv6]" or "[ipv6]:port".  If addr is not of this form, nil
+// is returned.
+func parseHostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.ParseIP(addr)
+}
+
+// forwardedFor extracts the "for" parameters from the values of Forwarded
+// header fields, as described in RFC 7239.
+func forwardedFor(values []string) []string {
+	var res []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				i := strings.IndexByte(pair, '=')
+				if i < 0 || !strings.EqualFold(pair[:i], "for") {
+					continue
+				}
+				res = append(res, strings.Trim(pair[i+1:], `"`))
+			}
+		}
+	}
+	return res
+}
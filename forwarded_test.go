@@ -0,0 +1,55 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	handler := &Handler{
+		TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"},
+	}
+	type testCase struct {
+		remote string
+		header string
+		value  string
+		result string
+	}
+	testCases := []testCase{
+		{"198.51.100.7:1000", "X-Forwarded-For", "203.0.113.5", "198.51.100.7:1000"},
+		{"192.0.2.1:1000", "", "", "192.0.2.1:1000"},
+		{"192.0.2.1:1000", "X-Forwarded-For", "203.0.113.5", "203.0.113.5"},
+		{"192.0.2.1:1000", "X-Forwarded-For", "203.0.113.5, 10.1.2.3", "203.0.113.5"},
+		{"192.0.2.1:1000", "X-Forwarded-For", "1.2.3.4, 203.0.113.5, 10.1.2.3", "203.0.113.5"},
+		{"192.0.2.1:1000", "Forwarded", `for="[2001:db8::1]:4711"`, "[2001:db8::1]:4711"},
+		{"192.0.2.1:1000", "Forwarded", "for=203.0.113.5;proto=https, for=10.0.0.1", "203.0.113.5"},
+		{"192.0.2.1:1000", "Forwarded", "for=_hidden, for=10.0.0.1", "10.0.0.1"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		if got := handler.clientAddr(req); got != tc.result {
+			t.Errorf("%s %s: %q: got %q, expected %q",
+				tc.remote, tc.header, tc.value, got, tc.result)
+		}
+	}
+}
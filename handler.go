@@ -17,12 +17,20 @@
 package websocket
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
-	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,6 +44,27 @@ type Handler struct {
 	// If OriginAllowed is not set, a same-origin policy is used.
 	OriginAllowed func(origin *url.URL) bool
 
+	// InsecureSkipOriginCheck, if set, disables the origin check, so
+	// that web pages from any origin can open websocket connections.
+	// This makes the server vulnerable to cross-site websocket hijacking,
+	// unless AccessAllowed performs suitable checks.  OriginAllowed is
+	// ignored if InsecureSkipOriginCheck is set.  A warning is logged
+	// the first time a connection is accepted with this option.
+	InsecureSkipOriginCheck bool
+
+	// RequireOrigin, if set, rejects handshake requests without an Origin
+	// header with status 403 (Forbidden).  Browsers always send the
+	// Origin header, so this can be used for endpoints which are only
+	// meant to be used from web pages.  By default, requests without an
+	// Origin header are accepted, since they cannot originate from a
+	// cross-site web page.
+	RequireOrigin bool
+
+	// Tag, if set, is attached to all connections established by the
+	// Handler, see Conn.Tag.  This allows to tell apart the connections
+	// of different Handlers which share a Registry.
+	Tag string
+
 	// AccessAllowed can be set to a function which determines whether
 	// the given request is allowed to establish a WebSocket connection
 	// (true indicates that the request should go ahead, false indicates
@@ -64,16 +93,235 @@ type Handler struct {
 	// this list, or null (no Sec-WebSocket-Protocol header sent) if none of
 	// the client-requested subprotocols are supported.
 	Subprotocols []string
+
+	// BufferPool, if set, is used to obtain buffers for control frame
+	// payloads and other small data.  The pool is shared by all connections
+	// established by the handler.
+	BufferPool BufferPool
+
+	// LowMemory reduces the amount of memory used by idle connections, at
+	// the cost of more system calls on busy connections.  This is useful
+	// for servers with a large number of mostly idle connections.
+	LowMemory bool
+
+	// CoalesceDelay, if positive, enables write coalescing: messages are not
+	// sent immediately, but are kept in the write buffer for at most
+	// CoalesceDelay, so that several small messages can be sent using a
+	// single system call.  Control frames are always sent immediately.
+	CoalesceDelay time.Duration
+
+	// CoalesceBytes is the amount of buffered data which causes the write
+	// buffer to be flushed immediately, when write coalescing is enabled.
+	// If this is zero, the buffer is only flushed once it is full or once
+	// the coalescing delay has expired.
+	CoalesceBytes int
+
+	// SendRateLimit, if positive, limits the rate at which data is sent on
+	// each connection, in bytes per second.  Bursts of up to SendBurst
+	// bytes are sent at full speed.  If SendBurst is zero, a burst size of
+	// 16 KiB is used.  This prevents a single connection, for example one
+	// receiving a very large message, from using all of the available
+	// uplink bandwidth.  Control frames are subject to the same limit.
+	SendRateLimit int
+	SendBurst     int
+
+	// IdleTimeout, if positive, closes connections on which no data has
+	// been received for the given duration.  Before the connection is
+	// closed, a ping frame is sent.  If neither a pong frame nor any other
+	// data arrives within IdleGrace, the connection is closed with status
+	// StatusGoingAway.  If IdleGrace is zero, a grace period of 10 seconds
+	// is used.
+	IdleTimeout time.Duration
+	IdleGrace   time.Duration
+
+	// ControlFrameLimit, if positive, limits the number of ping and pong
+	// frames a client may send per second.  Clients which exceed the
+	// limit are disconnected with status StatusPolicyViolation, and Wait
+	// reports [ControlFlood].
+	ControlFrameLimit int
+
+	// UnsolicitedPongLimit, if positive, is the number of unsolicited pong
+	// frames a client may send over the lifetime of the connection, see
+	// [ControlCounts].  Clients which exceed the limit are disconnected
+	// with status StatusPolicyViolation, and Wait reports
+	// [ControlFlood].
+	UnsolicitedPongLimit int
+
+	// ReadLimit is the maximal length of messages returned by
+	// Conn.Receive.  If ReadLimit is zero, DefaultReadLimit is used.  The
+	// limit can be changed later using Conn.SetReadLimit.
+	ReadLimit int
+
+	// Validate, if set, is called with every complete incoming message,
+	// before the message is passed to the application.  Each message is
+	// read into memory for this, up to the read limit (see ReadLimit);
+	// longer messages close the connection with status StatusTooLarge.
+	// If Validate returns an error, the message is dropped and the
+	// connection is closed with status StatusInvalidData, using the error
+	// text as the close message.  Wait then reports [InvalidMessage], and
+	// the Receive functions return an error which wraps both
+	// [ErrConnClosed] and the error from Validate.  To reject a message
+	// without closing the connection, Validate can send a reply to the
+	// client and return [ErrSkipMessage].
+	//
+	// Validate is called from the reader goroutine.  The msg slice must
+	// not be modified.
+	Validate func(conn *Conn, tp MessageType, msg []byte) error
+
+	// AutoDrain, if set, allows to call ReceiveMessage (or any other
+	// Receive function) before the reader returned by a previous call to
+	// ReceiveMessage has been drained.  The unread part of the previous
+	// message is then discarded, instead of the call blocking forever.
+	// The previous reader must not be used concurrently with the new call.
+	AutoDrain bool
+
+	// CloseOnRequestDone, if set, ties the lifetime of the connection to
+	// the context of the upgrade request: once the context is done, the
+	// connection is closed with status StatusGoingAway, see
+	// [Conn.CloseWhenDone].  Note that net/http cancels the request
+	// context when ServeHTTP returns, so that with this option the
+	// connection is closed once Handle returns.  The option is not
+	// useful in event-driven mode.
+	CloseOnRequestDone bool
+
+	// ValidateOutgoingText, if set, makes SendText and SendMessage(Text)
+	// check that the message is valid utf-8.  Invalid messages are rejected
+	// with [ErrInvalidUTF8], instead of being sent to the client, which
+	// would close the connection with StatusInvalidData.
+	ValidateOutgoingText bool
+
+	// RSVExtensions lists experimental extensions which use the reserved
+	// bits of the frame header.  Without a matching entry, frames with
+	// reserved bits set are treated as a protocol violation.
+	RSVExtensions []RSVExtension
+
+	// ConnConfig, if set, is called with the underlying network connection
+	// after the websocket handshake has completed, before any websocket
+	// frames are sent or received.  This can be used to set socket options,
+	// for example after converting conn to *net.TCPConn.  If ConnConfig
+	// returns an error, the network connection is closed and Upgrade
+	// returns the error.
+	//
+	// For HTTPS servers, conn is a *tls.Conn.  The TCP connection can be
+	// obtained using its NetConn method (available since Go 1.18), as is
+	// done for TCPUserTimeout.  With older Go versions, socket options
+	// must be set before the TLS layer is added, for example in the
+	// ConnContext function of the http.Server, or by a custom
+	// net.Listener.
+	ConnConfig func(conn net.Conn) error
+
+	// TCPUserTimeout, if positive, sets the TCP_USER_TIMEOUT socket
+	// option on the network connection.  Once sent data has remained
+	// unacknowledged for this long, the kernel closes the connection.
+	// This detects clients which vanished without closing the
+	// connection, at much lower cost than sending pings.  The option is
+	// only supported on Linux, and is ignored on other systems and for
+	// connections which do not use TCP.
+	TCPUserTimeout time.Duration
+
+	// OnMessage, if set, enables the event-driven mode.  In this mode, no
+	// goroutines are kept for idle connections.  Instead, a single
+	// goroutine waits for incoming data on all connections (using epoll
+	// on Linux, and kqueue on macOS and the BSD systems), and OnMessage is
+	// called in a new goroutine when a message arrives.  The message
+	// must be read from r before OnMessage returns; remaining data is
+	// discarded.  The messages of one connection are delivered in order,
+	// one at a time.  This reduces memory use for servers with very large
+	// numbers of mostly idle connections.
+	//
+	// In event-driven mode, Handle is optional.  If set, Handle should
+	// return quickly, and OnMessage is only called after Handle has
+	// returned.  The Receive* methods of the connection must not be used.
+	// Connections which cannot be polled, for example TLS connections,
+	// use one goroutine per connection instead.
+	//
+	// If waiting for data fails, the connections which use the poller are
+	// closed with ConnDropped, and the error is passed to the function
+	// installed by Conn.OnError.  After this, Upgrade returns the error
+	// for new connections in event-driven mode.
+	OnMessage func(conn *Conn, tp MessageType, r io.Reader)
+
+	// OnClose, if set, is called once a connection has been closed and
+	// the status information is available via Conn.Wait.
+	OnClose func(conn *Conn)
+
+	// OnConnect and OnDisconnect, if set, are called when a connection
+	// is established and when it has been closed, respectively.  This
+	// can be used for audit logging, without wrapping Handle.  The remote
+	// address and the negotiated subprotocol are available in the
+	// RemoteAddr and Protocol fields of conn.  OnConnect is called before
+	// Handle, before any frames are read from the connection, and must not
+	// send or receive messages.  OnDisconnect receives the values
+	// returned by Conn.Wait.
+	OnConnect    func(conn *Conn)
+	OnDisconnect func(conn *Conn, info ConnInfo, status Status, message string)
+
+	// TraceHooks, if set, is used to trace the frames sent and received
+	// on all connections established by the handler.  The hooks of an
+	// individual connection can be changed using Conn.SetTraceHooks.
+	TraceHooks *TraceHooks
+
+	// Stats, if set, is used to count connections and messages.  See
+	// [Stats] for how to publish the counters using expvar.
+	Stats *Stats
+
+	// Registry, if set, keeps track of the open connections, so that
+	// individual connections can be closed by ID.
+	Registry *Registry
+
+	// SendBudget, if set, limits the total amount of data held in the
+	// SendQueues of all connections established by the handler.
+	SendBudget *SendBudget
+
+	// ClientLimit, if set, limits the number of simultaneous connections
+	// per client.
+	ClientLimit *ClientLimit
+
+	// TrustedProxies lists the IP addresses and networks (in CIDR
+	// notation) of reverse proxies in front of the server.  If a handshake
+	// request arrives from one of these addresses, the RemoteAddr field
+	// of the connection is set to the client address given in the
+	// Forwarded header (or, if this is missing, in the X-Forwarded-For
+	// header), and this address is also used by ClientLimit.  The port
+	// number is only included if the proxy supplied one.
+	TrustedProxies []string
+
+	// MaxConnections, if positive, limits the total number of open
+	// connections.  When the limit is reached, new handshakes wait for up
+	// to MaxConnectionsWait for a connection to close.  If no connection
+	// becomes available, the handshake is rejected with HTTP status 503
+	// (Service Unavailable), and a Retry-After header suggesting to retry
+	// after RetryAfter.  If RetryAfter is less than one second, one second
+	// is used.
+	MaxConnections     int
+	MaxConnectionsWait time.Duration
+	RetryAfter         time.Duration
+
+	// Tracer, if set, is used to create spans for the handshake and for
+	// the messages sent and received, for use with a distributed tracing
+	// system.
+	Tracer Tracer
+
+	onPing func(body []byte) // used by ProxyHandler
+	slots  *connSlots        // see MaxConnections, allocated on first use
 }
 
 const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // from RFC 6455
 
 func (handler *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	conn, err := handler.Upgrade(w, req)
+	conn, err := handler.upgrade(w, req, true)
 	if err != nil {
 		return
 	}
 
+	if conn.events != nil {
+		if handler.Handle != nil {
+			handler.Handle(conn)
+		}
+		conn.startEvents()
+		return
+	}
+
 	// start the user handler
 	handler.Handle(conn)
 }
@@ -85,33 +333,183 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // websocket handshake.  The returned connection object can be used
 // to send and receive messages on the connection, or handler.Handle
 // can be called manually on the connection object.
+//
+// In event-driven mode, the delivery of messages to OnMessage starts
+// before Upgrade returns.
+//
+// If the handshake fails, an error response is written to w and a
+// *HandshakeError is returned.
 func (handler *Handler) Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	return handler.doUpgrade(w, req, true)
+}
+
+// UpgradeNoRespond is like Upgrade, but if the handshake fails, no error
+// response is written and w is left untouched.  The returned
+// *HandshakeError gives the HTTP status code and the header fields which
+// should be used for the response, so that the caller can render its own
+// error page.
+func (handler *Handler) UpgradeNoRespond(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	return handler.doUpgrade(w, req, false)
+}
+
+func (handler *Handler) doUpgrade(w http.ResponseWriter, req *http.Request, respond bool) (*Conn, error) {
+	conn, err := handler.upgrade(w, req, respond)
+	if err != nil {
+		return nil, err
+	}
+	if conn.events != nil {
+		conn.startEvents()
+	}
+	return conn, nil
+}
+
+// HandshakeError is returned by Handler.Upgrade and Handler.UpgradeNoRespond
+// if the websocket handshake fails.
+type HandshakeError struct {
+	// Status is the HTTP status code for the error response.
+	Status int
+
+	// Header contains header fields to include in the error response,
+	// for example Retry-After or Sec-WebSocket-Version.
+	Header http.Header
+}
+
+func (err *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed (%d %s)",
+		err.Status, http.StatusText(err.Status))
+}
+
+// respond writes the error response to w.
+func (err *HandshakeError) respond(w http.ResponseWriter) {
+	headers := w.Header()
+	for key, values := range err.Header {
+		headers[key] = values
+	}
+	if err.Status == http.StatusInternalServerError {
+		http.Error(w, "internal server error", err.Status)
+	} else {
+		http.Error(w, "websocket handshake failed", err.Status)
+	}
+}
+
+func (handler *Handler) upgrade(w http.ResponseWriter, req *http.Request, respond bool) (conn *Conn, err error) {
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return nil, errors.New("connection hijacking not supported")
+		herr := &HandshakeError{Status: http.StatusInternalServerError}
+		if respond {
+			herr.respond(w)
+		}
+		return nil, herr
+	}
+
+	var tracer *spanTracer
+	var span Span
+	if handler.Tracer != nil {
+		var ctx context.Context
+		ctx, span = handler.Tracer.StartHandshake(req)
+		defer func() { endSpan(span, err) }()
+		tracer = &spanTracer{tracer: handler.Tracer, ctx: ctx}
 	}
 
-	conn, status := handler.handshake(w, req)
+	headers := make(http.Header)
+	conn, status := handler.handshake(headers, req)
 	if status != http.StatusSwitchingProtocols {
-		http.Error(w, "websocket handshake failed", status)
-		return nil, errHandshake
+		handler.Stats.handshakeRejected()
+		herr := &HandshakeError{Status: status, Header: headers}
+		if respond {
+			herr.respond(w)
+		}
+		return nil, herr
 	}
+	conn.tracer = tracer
 
+	for key, values := range headers {
+		w.Header()[key] = values
+	}
 	w.WriteHeader(status)
 	raw, rw, err := hijacker.Hijack()
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		conn.releaseLimits()
+		if respond {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
 		return nil, err
 	}
 	raw.SetDeadline(time.Time{})
+	if handler.TCPUserTimeout > 0 {
+		err = setUserTimeout(raw, handler.TCPUserTimeout)
+		if err != nil {
+			conn.releaseLimits()
+			raw.Close()
+			return nil, err
+		}
+	}
+	if handler.ConnConfig != nil {
+		err = handler.ConnConfig(raw)
+		if err != nil {
+			conn.releaseLimits()
+			raw.Close()
+			return nil, err
+		}
+	}
+	if conn.events != nil {
+		err = pollerFailure()
+		if err != nil {
+			conn.releaseLimits()
+			raw.Close()
+			return nil, err
+		}
+		conn.events.pending = rw.Reader.Buffered() > 0
+	}
+	if handler.LowMemory {
+		rw = shrinkBuffers(raw, rw)
+	}
 
+	if handler.Registry != nil {
+		handler.Registry.add(conn)
+		conn.registry = handler.Registry
+	}
+	if handler.OnConnect != nil {
+		handler.OnConnect(conn)
+	}
 	conn.initialize(raw, rw)
+	handler.Stats.connOpened()
+	if handler.Registry != nil {
+		handler.Registry.watchIdle(conn)
+	}
+	if handler.CloseOnRequestDone {
+		conn.CloseWhenDone(req.Context())
+	}
 
 	return conn, nil
 }
 
-func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Conn, int) {
+// lowMemoryBufferSize is the size of the read and write buffers used
+// in low-memory mode.  Frames which do not fit into the write buffer
+// are written without copying, see sender.sendFrame.
+const lowMemoryBufferSize = 256
+
+// shrinkBuffers replaces the buffers obtained when hijacking the HTTP
+// connection with smaller ones.  Data which has already been read into
+// the old read buffer is preserved.
+func shrinkBuffers(raw net.Conn, rw *bufio.ReadWriter) *bufio.ReadWriter {
+	var r io.Reader = raw
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered := make([]byte, n)
+		rw.Reader.Read(buffered)
+		r = io.MultiReader(bytes.NewReader(buffered), raw)
+	}
+	rw.Writer.Flush()
+
+	return bufio.NewReadWriter(
+		bufio.NewReaderSize(r, lowMemoryBufferSize),
+		bufio.NewWriterSize(raw, lowMemoryBufferSize))
+}
+
+// handshake checks the handshake request and prepares the connection.  The
+// header fields for the response are stored in headers.  If the returned
+// status is not http.StatusSwitchingProtocols, the handshake failed.
+func (handler *Handler) handshake(headers http.Header, req *http.Request) (*Conn, int) {
 	// This code is organised following the steps in section 4.2 of RFC 6455,
 	// see https://www.rfc-editor.org/rfc/rfc6455#section-4.2 .
 
@@ -159,7 +557,6 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 	// |Sec-WebSocket-Version|.  The value of this header field MUST be 13.
 	version := req.Header.Get("Sec-Websocket-Version")
 	if version != "13" {
-		headers := w.Header()
 		headers.Set("Upgrade", "websocket")
 		headers.Set("Connection", "Upgrade")
 		headers.Set("Sec-WebSocket-Version", "13")
@@ -167,6 +564,9 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 	}
 
 	subprotocol := handler.chooseSubprotocol(req)
+	remoteAddr := handler.clientAddr(req)
+	extensions, rsvBits := acceptRSVExtensions(handler.RSVExtensions,
+		req.Header.Values("Sec-Websocket-Extensions"))
 
 	// protect against CSRF attacks
 	var origin *url.URL
@@ -178,7 +578,12 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 		origin = originURI
 
 		var originAllowed bool
-		if handler.OriginAllowed != nil {
+		if handler.InsecureSkipOriginCheck {
+			originAllowed = true
+			insecureOriginWarning.Do(func() {
+				log.Print("websocket: warning: origin check disabled by Handler.InsecureSkipOriginCheck")
+			})
+		} else if handler.OriginAllowed != nil {
 			originAllowed = handler.OriginAllowed(origin)
 		} else {
 			originAllowed = strings.EqualFold(origin.Host, req.Host)
@@ -186,6 +591,8 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 		if !originAllowed {
 			return nil, http.StatusForbidden
 		}
+	} else if handler.RequireOrigin {
+		return nil, http.StatusForbidden
 	}
 
 	// access control
@@ -198,28 +605,77 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 		requestData = data
 	}
 
+	// limit the total number of connections
+	slots := handler.connSlots()
+	if slots != nil && !slots.acquire(req.Context(), handler.MaxConnectionsWait) {
+		retry := int(handler.RetryAfter / time.Second)
+		if retry < 1 {
+			retry = 1
+		}
+		headers.Set("Retry-After", strconv.Itoa(retry))
+		return nil, http.StatusServiceUnavailable
+	}
+
+	// limit the number of connections per client
+	var clientKey string
+	if l := handler.ClientLimit; l != nil {
+		clientKey = l.key(req, remoteAddr)
+		if !l.acquire(clientKey) {
+			slots.release()
+			return nil, http.StatusTooManyRequests
+		}
+	}
+
 	// if we reach this point, we accept the connection
 
 	conn := &Conn{
 		ResourceName: resourceName,
 		Origin:       origin,
-		RemoteAddr:   req.RemoteAddr,
+		RemoteAddr:   remoteAddr,
 		Protocol:     subprotocol,
 		RequestData:  requestData,
+		HandshakeKey: secWebsocketKey,
+
+		extensions:    extensions,
+		rsvBits:       rsvBits,
+		pool:          handler.BufferPool,
+		lowMemory:     handler.LowMemory,
+		coalesceDelay: handler.CoalesceDelay,
+		coalesceBytes: handler.CoalesceBytes,
+		validateText:  handler.ValidateOutgoingText,
+		sendRate:      handler.SendRateLimit,
+		sendBurst:     handler.SendBurst,
+		idleTimeout:   handler.IdleTimeout,
+		idleGrace:     handler.IdleGrace,
+		controlLimit:  handler.ControlFrameLimit,
+		pongLimit:     handler.UnsolicitedPongLimit,
+		autoDrain:     handler.AutoDrain,
+		readLimit:     int64(handler.ReadLimit),
+		validate:      handler.Validate,
+		onPing:        handler.onPing,
+		onClose:       handler.OnClose,
+		onDisconnect:  handler.OnDisconnect,
+		trace:         newTraceState(handler.TraceHooks),
+		stats:         handler.Stats,
+		budget:        handler.SendBudget,
+		slots:         slots,
+		clientLimit:   handler.ClientLimit,
+		clientKey:     clientKey,
+		tag:           handler.Tag,
+	}
+	if handler.OnMessage != nil {
+		conn.events = &connEvents{onMessage: handler.OnMessage}
 	}
 
-	h := sha1.New()
-	h.Write([]byte(secWebsocketKey))
-	h.Write([]byte(websocketGUID))
-	secWebsocketAccept := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	headers := w.Header()
 	headers.Set("Upgrade", "websocket")
 	headers.Set("Connection", "Upgrade")
-	headers.Set("Sec-WebSocket-Accept", secWebsocketAccept)
+	headers.Set("Sec-WebSocket-Accept", acceptKey(secWebsocketKey))
 	if subprotocol != "" {
 		headers.Set("Sec-WebSocket-Protocol", subprotocol)
 	}
+	if len(extensions) > 0 {
+		headers.Set("Sec-WebSocket-Extensions", extensionNames(extensions))
+	}
 	if handler.ServerName != "" {
 		headers.Set("Server", handler.ServerName)
 	}
@@ -227,6 +683,19 @@ func (handler *Handler) handshake(w http.ResponseWriter, req *http.Request) (*Co
 	return conn, http.StatusSwitchingProtocols
 }
 
+// insecureOriginWarning makes sure that the warning about
+// InsecureSkipOriginCheck is only logged once.
+var insecureOriginWarning sync.Once
+
+// acceptKey computes the value of the Sec-WebSocket-Accept header field
+// from the value of the Sec-WebSocket-Key header field.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
 func (handler *Handler) chooseSubprotocol(req *http.Request) string {
 	serverProtos := handler.Subprotocols
 	if len(serverProtos) == 0 {
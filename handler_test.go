@@ -1,6 +1,20 @@
 package websocket
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
 
 func TestContainsToken(t *testing.T) {
 	type testCase struct {
@@ -23,3 +37,412 @@ func TestContainsToken(t *testing.T) {
 		}
 	}
 }
+
+func TestConnConfig(t *testing.T) {
+	configured := make(chan net.Conn, 1)
+	handled := make(chan bool, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			handled <- true
+			conn.Close(StatusOK, "")
+		},
+		ConnConfig: func(conn net.Conn) error {
+			configured <- conn
+			return errors.New("rejected")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	raw := <-configured
+	if _, ok := raw.(*net.UnixConn); !ok {
+		t.Errorf("wrong connection type %T", raw)
+	}
+
+	// The connection must be closed, without calling the handler.
+	_, _, err = client.ReadFrame()
+	if err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	select {
+	case <-handled:
+		t.Error("handler called after ConnConfig failed")
+	default:
+	}
+}
+
+func TestConnectDisconnect(t *testing.T) {
+	events := make(chan string, 3)
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			events <- "handle"
+			conn.Close(StatusGoingAway, "bye")
+		},
+		OnConnect: func(conn *Conn) {
+			events <- "connect " + conn.ResourceName
+		},
+		OnDisconnect: func(conn *Conn, info ConnInfo, status Status, message string) {
+			events <- fmt.Sprintf("disconnect %d %d %q", info, status, message)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tp, _, err := client.ReadFrame()
+	if err != nil || tp != closeFrame {
+		t.Fatalf("expected close frame, got %s %v", tp, err)
+	}
+	err = client.SendFrame(closeFrame, []byte{0x03, 0xe8}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"connect /chat",
+		"handle",
+		fmt.Sprintf("disconnect %d %d \"\"", ServerClosed, StatusOK),
+	}
+	for _, want := range expected {
+		if got := <-events; got != want {
+			t.Errorf("got %q, expected %q", got, want)
+		}
+	}
+}
+
+func TestControlFrameLimit(t *testing.T) {
+	info := make(chan ConnInfo, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		ControlFrameLimit: 5,
+		Handle: func(conn *Conn) {
+			i, _, _ := conn.Wait()
+			info <- i
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 10; i++ {
+		err = client.SendFrame(pingFrame, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pongs := 0
+	for {
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp == pongFrame {
+			pongs++
+			continue
+		}
+		if tp != closeFrame {
+			t.Fatalf("unexpected frame %s", tp)
+		}
+		if len(body) < 2 || 256*Status(body[0])+Status(body[1]) != StatusPolicyViolation {
+			t.Errorf("wrong close frame %v", body)
+		}
+		break
+	}
+	if pongs > 5 {
+		t.Errorf("%d pongs sent", pongs)
+	}
+	if i := <-info; i != ControlFlood {
+		t.Errorf("wrong connection info %d", i)
+	}
+}
+
+func TestUpgradeNoRespond(t *testing.T) {
+	handler := &Handler{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := handler.UpgradeNoRespond(w, req)
+		var herr *HandshakeError
+		if !errors.As(err, &herr) {
+			t.Errorf("expected *HandshakeError, got %v", err)
+			return
+		}
+		w.Header().Set("X-Version", herr.Header.Get("Sec-Websocket-Version"))
+		w.WriteHeader(herr.Status)
+		io.WriteString(w, "custom error page")
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("wrong status %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("X-Version"); v != "13" {
+		t.Errorf("wrong version header %q", v)
+	}
+	if resp.Header.Get("Sec-Websocket-Version") != "" {
+		t.Error("handshake headers were written")
+	}
+	if string(body) != "custom error page" {
+		t.Errorf("wrong body %q", body)
+	}
+}
+
+func TestInsecureSkipOriginCheck(t *testing.T) {
+	logBuf := &bytes.Buffer{}
+	log.SetOutput(logBuf)
+	defer log.SetOutput(os.Stderr)
+	insecureOriginWarning = sync.Once{}
+
+	handler := &Handler{Handle: echo}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialer := &Dialer{
+		Header: http.Header{"Origin": []string{"http://other.example.com"}},
+	}
+	_, err := dialer.Dial(context.Background(), url)
+	if err != ErrBadHandshake {
+		t.Errorf("expected ErrBadHandshake, got %v", err)
+	}
+
+	handler.InsecureSkipOriginCheck = true
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(StatusOK, "")
+	conn.Wait()
+
+	if !strings.Contains(logBuf.String(), "InsecureSkipOriginCheck") {
+		t.Errorf("no warning logged: %q", logBuf.String())
+	}
+}
+
+func TestRequireOrigin(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		RequireOrigin: true,
+		Handle:        echo,
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	_, err := DefaultDialer.Dial(context.Background(), url)
+	if err != ErrBadHandshake {
+		t.Errorf("expected ErrBadHandshake, got %v", err)
+	}
+
+	dialer := &Dialer{
+		Header: http.Header{"Origin": []string{server.URL}},
+	}
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(StatusOK, "")
+	conn.Wait()
+}
+
+func TestCloseOnRequestDone(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		CloseOnRequestDone: true,
+		Handle:             func(conn *Conn) {},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, status, _ := conn.Wait()
+	if status != StatusGoingAway {
+		t.Errorf("wrong status %d", status)
+	}
+}
+
+func TestUnsolicitedPongLimit(t *testing.T) {
+	stats := &Stats{}
+	counts := make(chan ControlCounts, 1)
+	info := make(chan ConnInfo, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		UnsolicitedPongLimit: 2,
+		Stats:                stats,
+		Handle: func(conn *Conn) {
+			conn.SendPing([]byte("x"))
+			i, _, _ := conn.Wait()
+			counts <- conn.ControlCounts()
+			info <- i
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tp, body, err := client.ReadFrame()
+	if err != nil || tp != pingFrame {
+		t.Fatalf("expected ping, got %s %v", tp, err)
+	}
+	err = client.SendFrame(pongFrame, body, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SendFrame(pingFrame, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = client.SendFrame(pongFrame, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for {
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp == pongFrame {
+			continue
+		}
+		if tp != closeFrame || len(body) < 2 ||
+			256*Status(body[0])+Status(body[1]) != StatusPolicyViolation {
+			t.Errorf("wrong frame %s %v", tp, body)
+		}
+		break
+	}
+
+	want := ControlCounts{Pings: 1, Pongs: 4, UnsolicitedPongs: 3}
+	if got := <-counts; got != want {
+		t.Errorf("wrong counts %v", got)
+	}
+	if i := <-info; i != ControlFlood {
+		t.Errorf("wrong connection info %d", i)
+	}
+	snap := stats.Snapshot()
+	if snap.Pings != 1 || snap.Pongs != 4 || snap.UnsolicitedPongs != 3 {
+		t.Errorf("wrong stats %v", snap)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	errBad := errors.New("bad message")
+	recvErr := make(chan error, 1)
+	info := make(chan ConnInfo, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		Validate: func(conn *Conn, tp MessageType, msg []byte) error {
+			switch string(msg) {
+			case "skip":
+				conn.SendText("rejected")
+				return ErrSkipMessage
+			case "bad":
+				return errBad
+			}
+			return nil
+		},
+		Handle: func(conn *Conn) {
+			for {
+				msg, err := conn.Receive(context.Background())
+				if err != nil {
+					recvErr <- err
+					break
+				}
+				if msg.Type == Text {
+					conn.SendText(string(msg.Data))
+				} else {
+					conn.SendBinary(msg.Data)
+				}
+			}
+			i, _, _ := conn.Wait()
+			info <- i
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// fragmented messages are validated as a whole
+	client.SendFrame(Text, []byte("he"), false)
+	client.SendFrame(contFrame, []byte("llo"), true)
+	client.SendFrame(Text, []byte("skip"), true)
+	client.SendFrame(Binary, []byte("ok"), true)
+	client.SendFrame(Text, []byte("bad"), true)
+
+	expected := []struct {
+		tp   MessageType
+		body string
+	}{
+		{Text, "hello"},
+		{Text, "rejected"},
+		{Binary, "ok"},
+		{closeFrame, "\x03\xefbad message"},
+	}
+	for _, exp := range expected {
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != exp.tp || string(body) != exp.body {
+			t.Errorf("expected %s %q, got %s %q", exp.tp, exp.body, tp, body)
+		}
+	}
+
+	err = <-recvErr
+	if !errors.Is(err, ErrConnClosed) || !errors.Is(err, errBad) {
+		t.Errorf("wrong error %v", err)
+	}
+	client.SendFrame(closeFrame, nil, true)
+	if i := <-info; i != InvalidMessage {
+		t.Errorf("wrong connection info %d", i)
+	}
+}
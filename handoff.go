@@ -0,0 +1,220 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDetach is returned by Conn.Detach if the connection cannot be handed
+// off to another process.
+var ErrDetach = errors.New("connection cannot be detached")
+
+// HandoffState holds the state needed to continue a websocket connection
+// in a different process, see Conn.Detach and Resume.  The state can be
+// serialised using MarshalBinary.
+type HandoffState struct {
+	ResourceName string
+	RemoteAddr   string
+	Protocol     string
+	HandshakeKey string
+	IsClient     bool
+
+	// Buffered holds data which was received from the peer, but not yet
+	// processed.
+	Buffered []byte
+
+	frame *handoffFrame
+}
+
+// handoffFrame describes the data frame which was being read when the
+// connection was detached.
+type handoffFrame struct {
+	Opcode  MessageType
+	Final   bool
+	RSV     byte
+	Length  int64
+	Mask    [4]byte
+	Pos     int64
+	Deliver bool // if false, the rest of the message is discarded
+}
+
+type handoffData struct {
+	HandoffState
+	Frame *handoffFrame `json:",omitempty"`
+}
+
+// MarshalBinary encodes the state, for transfer to a different process.
+func (s *HandoffState) MarshalBinary() ([]byte, error) {
+	return json.Marshal(&handoffData{HandoffState: *s, Frame: s.frame})
+}
+
+// UnmarshalBinary decodes a state encoded by MarshalBinary.
+func (s *HandoffState) UnmarshalBinary(data []byte) error {
+	var d handoffData
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return err
+	}
+	*s = d.HandoffState
+	s.frame = d.Frame
+	return nil
+}
+
+// Detach stops using the connection, without closing it, so that it can
+// be handed off to a different process for a zero-downtime restart.  The
+// returned file holds a duplicate of the network socket, which can be
+// passed to the new process, for example using the ExtraFiles field of
+// exec.Cmd, or over a unix domain socket.  The new process continues the
+// connection using Resume.  A listening socket can be passed on in the same
+// way, and re-created using net.FileListener.
+//
+// Detach waits until pending data has been sent.  If a message is
+// partially received when the connection is detached, the rest of the
+// message is discarded by the new process.  No messages must be sent or
+// received while Detach runs.
+//
+// After Detach returns, Wait reports [Detached].  ErrDetach is returned
+// for connections in event-driven mode, and for connections which are not
+// backed by an operating system socket, for example TLS connections.
+func (conn *Conn) Detach() (*os.File, *HandoffState, error) {
+	filer, ok := conn.raw.(interface{ File() (*os.File, error) })
+	if !ok || conn.events != nil {
+		return nil, nil, ErrDetach
+	}
+	if !atomic.CompareAndSwapInt32(&conn.closeCalled, 0, closeDetach) {
+		return nil, nil, ErrConnClosed
+	}
+
+	// Send pending data, and prevent further writes.
+	wb := <-conn.senderStore
+	if wb == nil {
+		return nil, nil, ErrConnClosed
+	}
+	wb.sendPendingPong()
+	err := wb.w.Flush()
+	close(conn.senderStore)
+	if err != nil {
+		conn.forceStop()
+		return nil, nil, &closedError{cause: err}
+	}
+
+	// Interrupt the reader.  The reader stops at the next frame boundary,
+	// or once a message held by the user has been returned.
+	atomic.StoreInt32(&conn.detaching, 1)
+	conn.raw.SetReadDeadline(time.Now())
+	conn.ResumeReading()
+	select {
+	case rb := <-conn.toUser:
+		conn.fromUser <- rb
+	default:
+	}
+	<-conn.shutdownComplete
+	if conn.connInfo != Detached {
+		return nil, nil, ErrConnClosed
+	}
+
+	conn.raw.SetReadDeadline(time.Time{})
+	f, err := filer.File()
+	conn.raw.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, conn.handoff, nil
+}
+
+// Resume continues a websocket connection which was detached by a
+// different process, see Conn.Detach.  The connection uses default
+// settings.  Resume duplicates the file descriptor; the caller should
+// close f once Resume has returned.
+func Resume(f *os.File, state *HandoffState) (*Conn, error) {
+	raw, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &Conn{
+		ResourceName: state.ResourceName,
+		RemoteAddr:   state.RemoteAddr,
+		Protocol:     state.Protocol,
+		HandshakeKey: state.HandshakeKey,
+
+		isClient: state.IsClient,
+		resume:   state.frame,
+	}
+	var r io.Reader = raw
+	if len(state.Buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(state.Buffered), raw)
+	}
+	conn.initialize(raw, bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(raw)))
+	return conn, nil
+}
+
+// handoffState records the reader state, once the reader has stopped
+// because of Detach.
+func (conn *Conn) handoffState(rb *receiver) *HandoffState {
+	state := &HandoffState{
+		ResourceName: conn.ResourceName,
+		RemoteAddr:   conn.RemoteAddr,
+		Protocol:     conn.Protocol,
+		HandshakeKey: conn.HandshakeKey,
+		IsClient:     conn.isClient,
+	}
+	if n := rb.r.Buffered(); n > 0 {
+		buf, _ := rb.r.Peek(n)
+		state.Buffered = append([]byte(nil), buf...)
+	}
+
+	h := rb.header
+	isData := h.Opcode < 8
+	if rb.msg != nil {
+		// The message was read ahead for Handler.Validate and cannot be
+		// handed off.
+	} else if isData && rb.pos < h.Length {
+		state.frame = &handoffFrame{
+			Opcode:  h.Opcode,
+			Final:   h.Final,
+			RSV:     h.RSV,
+			Length:  h.Length,
+			Mask:    h.Mask,
+			Pos:     rb.pos,
+			Deliver: h.Opcode != contFrame && rb.pos == 0,
+		}
+	} else if rb.midMessage {
+		// Only continuation frames remain, and these are discarded.
+		state.frame = &handoffFrame{Opcode: contFrame}
+	}
+	return state
+}
+
+// detached reports whether err was caused by Detach interrupting the
+// reader.
+func (rb *receiver) detached(err error) bool {
+	if rb.detach == nil || atomic.LoadInt32(rb.detach) == 0 {
+		return false
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
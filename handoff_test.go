@@ -0,0 +1,126 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDetach(t *testing.T) {
+	for _, pending := range []bool{false, true} {
+		testDetach(t, pending)
+	}
+}
+
+// testDetach detaches a connection and resumes it.  If pending is set, a
+// message has been received by the reader, but not by the user, before the
+// connection is detached.
+func testDetach(t *testing.T, pending bool) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Conn{ResourceName: "/handoff"}
+	server.initialize(s, bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s)))
+	client := &Conn{ResourceName: "/handoff", isClient: true}
+	client.initialize(c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)))
+	defer client.Close(StatusOK, "")
+
+	err = client.SendText("one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.ReceiveText(100)
+	if err != nil || msg != "one" {
+		t.Fatalf("wrong message %q, err=%v", msg, err)
+	}
+
+	if pending {
+		err = client.SendText("two")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	f, state, err := server.Detach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _, _ := server.Wait(); info != Detached {
+		t.Errorf("wrong connection info %d", info)
+	}
+	data, err := state.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state2 := &HandoffState{}
+	err = state2.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server2, err := Resume(f, state2)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server2.ResourceName != "/handoff" {
+		t.Errorf("wrong resource name %q", server2.ResourceName)
+	}
+
+	if !pending {
+		err = client.SendText("two")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err = server2.ReceiveText(100)
+	if err != nil || msg != "two" {
+		t.Fatalf("wrong message %q, err=%v", msg, err)
+	}
+	err = server2.SendText("three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = client.ReceiveText(100)
+	if err != nil || msg != "three" {
+		t.Fatalf("wrong message %q, err=%v", msg, err)
+	}
+
+	err = server2.Close(StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, status, _ := client.Wait()
+	if status != StatusOK {
+		t.Errorf("wrong status %d", status)
+	}
+}
@@ -0,0 +1,150 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultIdleGrace is used if Handler.IdleGrace is not set.
+const defaultIdleGrace = 10 * time.Second
+
+// idleTimer closes a connection once no data has been received for a
+// while, see Handler.IdleTimeout.  Before the connection is closed, a ping
+// frame is sent to give the peer a chance to prove that it is still there.
+type idleTimer struct {
+	// last and pingSent are kept at the start of the struct, to ensure
+	// 64-bit alignment for the atomic operations on 32-bit platforms.
+	last     int64 // time of the last received frame, in ns, accessed atomically
+	pingSent int64 // only used by check
+
+	conn    *Conn
+	timeout time.Duration
+	grace   time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newIdleTimer(conn *Conn, timeout, grace time.Duration) *idleTimer {
+	if grace <= 0 {
+		grace = defaultIdleGrace
+	}
+	it := &idleTimer{
+		conn:    conn,
+		timeout: timeout,
+		grace:   grace,
+		last:    time.Now().UnixNano(),
+	}
+	it.mu.Lock()
+	it.timer = time.AfterFunc(timeout, it.check)
+	it.mu.Unlock()
+	return it
+}
+
+// touch records that data has been received.
+func (it *idleTimer) touch() {
+	if it == nil {
+		return
+	}
+	atomic.StoreInt64(&it.last, time.Now().UnixNano())
+}
+
+// stop disables the timer, once the connection has been closed.
+func (it *idleTimer) stop() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	it.stopped = true
+	it.timer.Stop()
+	it.mu.Unlock()
+}
+
+func (it *idleTimer) check() {
+	last := atomic.LoadInt64(&it.last)
+	if it.pingSent != 0 {
+		if last < it.pingSent {
+			// neither a pong nor data has arrived during the grace period
+			it.conn.Close(StatusGoingAway, "idle timeout")
+			return
+		}
+		it.pingSent = 0
+	}
+
+	now := time.Now().UnixNano()
+	idle := time.Duration(now - last)
+	if idle < it.timeout {
+		it.schedule(it.timeout - idle)
+		return
+	}
+
+	wb := <-it.conn.senderStore
+	if wb == nil {
+		return
+	}
+	if !wb.isShuttingDown() {
+		err := wb.sendFrame(pingFrame, nil, true)
+		it.conn.errors.report("sending ping", err)
+	}
+	wb.release()
+	it.pingSent = now
+	it.schedule(it.grace)
+}
+
+// activity records the time of the last received frame, for use by
+// Registry.IdleTimeout.  The fields are accessed atomically.
+type activity struct {
+	last int64 // time of the last received frame, in ns
+	ping int64 // time the last idle ping was sent, in ns
+}
+
+// touch records that data has been received.
+func (a *activity) touch() {
+	if a != nil {
+		atomic.StoreInt64(&a.last, time.Now().UnixNano())
+	}
+}
+
+// sendIdlePing sends a ping frame to an idle connection.  If the sender
+// is in use, no ping is sent.
+func (conn *Conn) sendIdlePing() {
+	select {
+	case wb := <-conn.senderStore:
+		if wb == nil {
+			return
+		}
+		if !wb.isShuttingDown() {
+			err := wb.sendFrame(pingFrame, nil, true)
+			conn.errors.report("sending ping", err)
+		}
+		wb.release()
+	default:
+		// the sender is in use
+	}
+}
+
+func (it *idleTimer) schedule(d time.Duration) {
+	it.mu.Lock()
+	if !it.stopped {
+		it.timer.Reset(d)
+	}
+	it.mu.Unlock()
+}
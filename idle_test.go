@@ -0,0 +1,98 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	for _, answer := range []bool{false, true} {
+		closed := make(chan Status, 1)
+		server, err := StartTestServerWithHandler(&Handler{
+			IdleTimeout: 50 * time.Millisecond,
+			IdleGrace:   50 * time.Millisecond,
+			Handle: func(conn *Conn) {
+				_, status, _ := conn.Wait()
+				closed <- status
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != pingFrame {
+			t.Fatalf("expected ping, got %s", tp)
+		}
+		if d := time.Since(start); d < 40*time.Millisecond {
+			t.Errorf("ping sent too early, after %s", d)
+		}
+
+		if answer {
+			err = client.SendFrame(pongFrame, body, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The connection stays open, and the next ping follows after
+			// another idle period.
+			tp, _, err = client.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tp != pingFrame {
+				t.Fatalf("expected second ping, got %s", tp)
+			}
+			err = client.SendFrame(closeFrame, nil, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tp, body, err = client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != closeFrame {
+			t.Fatalf("expected close frame, got %s", tp)
+		}
+		if answer {
+			client.Close()
+			if s := <-closed; s != StatusNotSent {
+				t.Errorf("wrong client status %d", s)
+			}
+		} else {
+			if len(body) < 2 || Status(body[0])<<8|Status(body[1]) != StatusGoingAway {
+				t.Errorf("wrong close frame body %q", body)
+			}
+			client.SendFrame(closeFrame, nil, true)
+			client.Close()
+			<-closed
+		}
+		server.Close()
+	}
+}
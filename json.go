@@ -0,0 +1,119 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrTrailingData is returned by ReceiveJSON and DecodeMessage if a
+// message contains more data after the decoded value.
+var ErrTrailingData = errors.New("trailing data after message value")
+
+// ReceiveJSON reads the next message from the connection and decodes it
+// as a JSON value into v.  Text and binary messages are accepted.
+//
+// The decoder keeps the complete JSON value in memory.  If the value is
+// longer than the read limit (see Handler.ReadLimit), [ErrTooLarge] is
+// returned.  If the message contains anything but white space after the
+// JSON value, ErrTrailingData is returned.  In all cases, the rest of the
+// message is discarded, so that the next message can be received.
+func (conn *Conn) ReceiveJSON(v interface{}) error {
+	_, r, err := conn.ReceiveMessage()
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(&limitReader{r: r, n: int64(conn.receiveLimit())})
+	err = dec.Decode(v)
+	if err != nil {
+		io.Copy(io.Discard, r)
+		return err
+	}
+	return FinishMessage(r, dec.Buffered(), true)
+}
+
+// limitReader reads at most n bytes from r.  If r has more data, ErrTooLarge
+// is returned.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.n <= 0 {
+		var buf [1]byte
+		n, err := lr.r.Read(buf[:])
+		if n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lr.n {
+		p = p[:lr.n]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	return n, err
+}
+
+// FinishMessage discards the rest of a message, after a decoder has read
+// a value from the reader r returned by ReceiveMessage.  The argument
+// buffered gives the data which the decoder has read ahead, for example
+// the value of json.Decoder.Buffered, and can be nil if the decoder does
+// not read ahead.  If strict is set, and the rest of the message contains
+// anything but white space, ErrTrailingData is returned.
+//
+// Some decoders, for example xml.Decoder, read ahead without giving access
+// to the buffered data.  In this case, wrap the message reader in a
+// bufio.Reader, and pass the bufio.Reader to both the decoder and
+// FinishMessage.
+//
+// The reader returned by ReceiveMessage can be passed directly to
+// json.NewDecoder or xml.NewDecoder, but since decoders stop reading after
+// the value, the rest of the message must always be consumed before the
+// next message can be received.
+func FinishMessage(r io.Reader, buffered io.Reader, strict bool) error {
+	if buffered != nil {
+		r = io.MultiReader(buffered, r)
+	}
+	if !strict {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	var err error
+	for {
+		var c byte
+		c, err = br.ReadByte()
+		if err != nil {
+			break
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			err = ErrTrailingData
+			break
+		}
+	}
+	if err == io.EOF {
+		return nil
+	}
+	io.Copy(io.Discard, br)
+	return err
+}
@@ -0,0 +1,111 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestReceiveJSON(t *testing.T) {
+	server, client := Pipe()
+
+	go func() {
+		client.SendText(`{"a": 1, "b": "x"}` + "\n")
+		client.SendText(`{"a": 2} garbage`)
+
+		// a fragmented message
+		w, _ := client.SendMessage(Binary)
+		w.Write([]byte(`{"a":`))
+		w.Flush()
+		w.Write([]byte(` 3}`))
+		w.Close()
+
+		client.SendText(`<v><a>4</a></v> `)
+		client.SendText(`<v><a>5</a></v> x`)
+	}()
+
+	type value struct {
+		A int    `json:"a" xml:"a"`
+		B string `json:"b"`
+	}
+
+	var v value
+	err := server.ReceiveJSON(&v)
+	if err != nil || v.A != 1 || v.B != "x" {
+		t.Errorf("wrong value %v, err=%v", v, err)
+	}
+	err = server.ReceiveJSON(&v)
+	if err != ErrTrailingData || v.A != 2 {
+		t.Errorf("wrong value %v, err=%v", v, err)
+	}
+	err = server.ReceiveJSON(&v)
+	if err != nil || v.A != 3 {
+		t.Errorf("wrong value %v, err=%v", v, err)
+	}
+
+	for i, expected := range []error{nil, ErrTrailingData} {
+		_, r, err := server.ReceiveMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(r)
+		err = xml.NewDecoder(br).Decode(&v)
+		if err != nil || v.A != 4+i {
+			t.Errorf("wrong value %v, err=%v", v, err)
+		}
+		err = FinishMessage(br, nil, true)
+		if err != expected {
+			t.Errorf("expected %v, got %v", expected, err)
+		}
+	}
+
+	server.Close(StatusOK, "")
+	client.Wait()
+}
+
+func TestReceiveJSONLimit(t *testing.T) {
+	server, client := Pipe()
+	server.SetReadLimit(16)
+
+	go func() {
+		client.SendText(`{"b": "` + strings.Repeat("x", 100) + `"}`)
+		client.SendText(`{"a": 1}` + strings.Repeat(" ", 100))
+		client.SendText(`{"a": 2, "b": "` + strings.Repeat("x", 16) + `"}`)
+		client.SendText(`{"a": 3}`)
+	}()
+
+	var v struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	for i, expected := range []error{ErrTooLarge, nil, ErrTooLarge, nil} {
+		v.A = 0
+		err := server.ReceiveJSON(&v)
+		if err != expected {
+			t.Errorf("%d: expected %v, got %v", i, expected, err)
+		}
+		if expected == nil && v.A != i {
+			t.Errorf("%d: wrong value %d", i, v.A)
+		}
+	}
+
+	server.Close(StatusOK, "")
+	client.Wait()
+}
@@ -0,0 +1,191 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mqtt transports MQTT control packets over websocket connections.
+//
+// MQTT over websockets uses the "mqtt" sub-protocol and binary websocket
+// messages.  A message may contain several control packets, and a control
+// packet may be split over several messages.  [Conn] reassembles complete
+// control packets from the received messages, and sends each outgoing
+// control packet as a single websocket message.
+//
+// To pass the packets to a broker implementation, use [Bridge]:
+//
+//	handler := &websocket.Handler{
+//		Handle:       mqtt.Bridge(newSession),
+//		Subprotocols: mqtt.Subprotocols,
+//	}
+//
+// Broker libraries which work on a net.Conn can instead use
+// tunnel.NewConn to access the underlying byte stream.  Such a connection
+// supports read deadlines, for example to implement the keep alive
+// timeout, but no write deadlines.
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/tunnel"
+)
+
+// Subprotocols lists the websocket sub-protocol name used for MQTT, for use
+// in websocket.Handler.Subprotocols.
+var Subprotocols = []string{"mqtt"}
+
+// DefaultMaxPacketSize is the default value for Conn.MaxPacketSize.
+const DefaultMaxPacketSize = 1 << 20
+
+// Conn reads and writes MQTT control packets on a websocket connection.
+type Conn struct {
+	// MaxPacketSize is the maximal size of a control packet accepted by
+	// ReadPacket, including the fixed header.  This must be set before
+	// the first call to ReadPacket.
+	MaxPacketSize int
+
+	ws *websocket.Conn
+	r  *bufio.Reader
+}
+
+// NewConn returns a new Conn which transports MQTT control packets over
+// ws.
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{
+		MaxPacketSize: DefaultMaxPacketSize,
+		ws:            ws,
+		r:             bufio.NewReader(tunnel.NewConn(ws)),
+	}
+}
+
+// ReadPacket returns the next control packet sent by the client, including
+// the fixed header.  Once the connection has been closed, io.EOF is
+// returned.  If the connection is closed in the middle of a packet,
+// io.ErrUnexpectedEOF is returned.
+//
+// ReadPacket must not be called concurrently from different goroutines.
+func (c *Conn) ReadPacket() ([]byte, error) {
+	first, err := c.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// The "remaining length" is encoded as a variable byte integer of at
+	// most four bytes.
+	var header [5]byte
+	header[0] = first
+	var length int
+	n := 1
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		header[n] = b
+		length |= int(b&0x7F) << (7 * (n - 1))
+		n++
+		if b&0x80 == 0 {
+			break
+		}
+		if n == len(header) {
+			c.ws.Close(websocket.StatusProtocolError, "")
+			return nil, errMalformed
+		}
+	}
+	if n+length > c.MaxPacketSize {
+		c.ws.Close(websocket.StatusTooLarge, "")
+		return nil, errTooLarge
+	}
+
+	packet := make([]byte, n+length)
+	copy(packet, header[:n])
+	_, err = io.ReadFull(c.r, packet[n:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// WritePacket sends a control packet to the client.  The packet must
+// include the fixed header.  It is safe to call WritePacket concurrently
+// from different goroutines.
+func (c *Conn) WritePacket(packet []byte) error {
+	return c.ws.SendBinary(packet)
+}
+
+// Close closes the websocket connection.
+func (c *Conn) Close() error {
+	return c.ws.Close(websocket.StatusOK, "")
+}
+
+// WebSocket returns the underlying websocket connection.
+func (c *Conn) WebSocket() *websocket.Conn {
+	return c.ws
+}
+
+// Session is the interface between the bridge and an MQTT broker.  A
+// Session represents the broker side of a single client connection.
+type Session interface {
+	// HandlePacket is called for every complete control packet received
+	// from the client.  Packets are passed in the order in which they were
+	// received, and the next packet is not read until HandlePacket
+	// returns.  If HandlePacket returns an error, the connection is closed.
+	HandlePacket(packet []byte) error
+
+	// Close is called once the connection has been closed, either by the
+	// client or because of an error.
+	Close()
+}
+
+// Bridge returns a function which can be used for the Handle field of a
+// websocket.Handler.  For every new websocket connection, newSession is
+// called to create a broker session.  The session can send packets to the
+// client using c.WritePacket.
+func Bridge(newSession func(c *Conn) (Session, error)) func(*websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		c := NewConn(ws)
+		session, err := newSession(c)
+		if err != nil {
+			ws.Close(websocket.StatusInternalServerError, "")
+			return
+		}
+		defer session.Close()
+
+		for {
+			packet, err := c.ReadPacket()
+			if err != nil {
+				break
+			}
+			err = session.HandlePacket(packet)
+			if err != nil {
+				ws.Close(websocket.StatusPolicyViolation, "")
+				break
+			}
+		}
+		c.Close()
+	}
+}
+
+var (
+	errMalformed = errors.New("mqtt: malformed remaining length")
+	errTooLarge  = errors.New("mqtt: control packet too large")
+)
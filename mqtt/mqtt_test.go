@@ -0,0 +1,101 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+// echoSession sends every packet back to the client.
+type echoSession struct {
+	c      *Conn
+	closed chan struct{}
+}
+
+func (s *echoSession) HandlePacket(packet []byte) error {
+	return s.c.WritePacket(packet)
+}
+
+func (s *echoSession) Close() {
+	close(s.closed)
+}
+
+func TestBridge(t *testing.T) {
+	closed := make(chan struct{})
+	server := httptest.NewServer(&websocket.Handler{
+		Handle: Bridge(func(c *Conn) (Session, error) {
+			return &echoSession{c: c, closed: closed}, nil
+		}),
+		Subprotocols: Subprotocols,
+	})
+	defer server.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pingreq := []byte{0xC0, 0}
+	publish := append([]byte{0x30, 200, 1}, make([]byte, 200)...)
+	for i := range publish[3:] {
+		publish[3+i] = byte(i)
+	}
+
+	// two packets in one message
+	err = conn.SendBinary(append(append([]byte{}, pingreq...), pingreq...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one packet split over three messages, with the split inside the
+	// remaining length
+	for _, part := range [][]byte{publish[:2], publish[2:50], publish[50:]} {
+		err = conn.SendBinary(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, 1024)
+	for _, expected := range [][]byte{pingreq, pingreq, publish} {
+		n, err := conn.ReceiveBinary(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			t.Errorf("wrong packet % x", buf[:n])
+		}
+	}
+
+	// text messages are not allowed
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+	_, status, _ := conn.Wait()
+	if status != websocket.StatusUnsupportedType {
+		t.Errorf("wrong status %d", status)
+	}
+	conn.Close(websocket.StatusOK, "")
+}
@@ -0,0 +1,144 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package ndjson transports streams of newline-delimited JSON values over
+// websocket connections.
+//
+// The stream consists of the contents of all text messages sent over the
+// connection, concatenated.  A sender can either send one long message,
+// which stays open while values are streamed, or a sequence of messages,
+// each containing one or more values.  Message boundaries carry no
+// meaning, and values may span messages.
+package ndjson
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Reader reads the concatenated contents of the text messages received on a
+// websocket connection.  Once the connection is closed, Read returns
+// io.EOF.  Receiving a binary message is an error, and Read returns
+// websocket.ErrMessageType.
+type Reader struct {
+	r *websocket.StreamReader
+}
+
+// NewReader returns a new Reader which reads from conn.
+func NewReader(conn *websocket.Conn) *Reader {
+	return &Reader{r: websocket.NewStreamReader(conn, websocket.Text)}
+}
+
+// NewDecoder returns a json.Decoder which reads the values sent over conn.
+func NewDecoder(conn *websocket.Conn) *json.Decoder {
+	return json.NewDecoder(NewReader(conn))
+}
+
+func (r *Reader) Read(buf []byte) (int, error) {
+	return r.r.Read(buf)
+}
+
+// autoFlushSize is the amount of buffered data which causes Encode to send
+// the data without waiting for Flush.
+const autoFlushSize = 32 * 1024
+
+// Encoder writes JSON values to a websocket connection, one value per line.
+// Values are buffered until Flush is called, or until enough data has
+// accumulated.
+type Encoder struct {
+	conn *websocket.Conn
+	w    websocket.MessageWriter // the open message in stream mode, or nil
+
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+// NewEncoder returns an Encoder which sends each batch of values as a
+// separate text message.  Other messages can be sent on the connection
+// between batches.
+func NewEncoder(conn *websocket.Conn) *Encoder {
+	e := &Encoder{conn: conn}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}
+
+// NewStreamEncoder returns an Encoder which sends all values as part of a
+// single text message.  The message is ended by calling Close.
+//
+// While the message is open, the encoder holds the sender of the
+// connection: no other messages can be sent, and a close frame from the
+// peer cannot be answered.  In particular, Conn.Close and Conn.Wait block
+// until Close has been called on the encoder.
+func NewStreamEncoder(conn *websocket.Conn) (*Encoder, error) {
+	w, err := conn.SendMessage(websocket.Text)
+	if err != nil {
+		return nil, err
+	}
+	e := NewEncoder(conn)
+	e.w = w
+	return e, nil
+}
+
+// Encode appends the JSON encoding of v, followed by a newline character,
+// to the stream.
+func (e *Encoder) Encode(v interface{}) error {
+	err := e.enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	if e.buf.Len() >= autoFlushSize {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush sends all buffered values to the peer.
+func (e *Encoder) Flush() error {
+	if e.w == nil {
+		if e.buf.Len() == 0 {
+			return nil
+		}
+		err := e.conn.SendText(e.buf.String())
+		e.buf.Reset()
+		return err
+	}
+
+	if e.buf.Len() > 0 {
+		_, err := e.w.Write(e.buf.Bytes())
+		e.buf.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// Close sends all buffered values.  For encoders created by
+// NewStreamEncoder, this also ends the message.  The connection is not
+// closed.
+func (e *Encoder) Close() error {
+	err := e.Flush()
+	if e.w != nil {
+		closeErr := e.w.Close()
+		if err == nil {
+			err = closeErr
+		}
+		e.w = nil
+	}
+	return err
+}
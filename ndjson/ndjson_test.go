@@ -0,0 +1,98 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ndjson
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+type event struct {
+	Seq  int    `json:"seq"`
+	Text string `json:"text"`
+}
+
+func TestStream(t *testing.T) {
+	next := make(chan struct{})
+	server := httptest.NewServer(&websocket.Handler{
+		Handle: func(conn *websocket.Conn) {
+			defer conn.Close(websocket.StatusOK, "")
+
+			// one long message, flushed after every value
+			enc, err := NewStreamEncoder(conn)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := 0; i < 3; i++ {
+				enc.Encode(&event{Seq: i, Text: "stream"})
+				err = enc.Flush()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				<-next
+			}
+			enc.Close()
+
+			// a batch of values in a separate message
+			enc = NewEncoder(conn)
+			for i := 3; i < 6; i++ {
+				enc.Encode(&event{Seq: i, Text: "batch"})
+			}
+			err = enc.Flush()
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	defer server.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusOK, "")
+
+	dec := NewDecoder(conn)
+	for i := 0; i < 6; i++ {
+		var e event
+		err := dec.Decode(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Seq != i {
+			t.Errorf("expected seq %d, got %d", i, e.Seq)
+		}
+		if i < 3 {
+			// The server only sends the next value once we have
+			// received this one.
+			next <- struct{}{}
+		}
+	}
+	var e event
+	err = dec.Decode(&e)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "sync"
+
+// readGate is used to pause the reader, see Conn.PauseReading.
+type readGate struct {
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{} // closed when reading is resumed
+}
+
+// PauseReading stops reading frames from the network connection, once the
+// current message has been read.  While reading is paused, incoming data is
+// left in the operating system buffers, so that TCP flow control slows down
+// the peer.  Control frames are not processed either, so pings are not
+// answered until reading is resumed.
+//
+// Reading is resumed automatically when the connection is closed.  In
+// event-driven mode, PauseReading has no effect.
+func (conn *Conn) PauseReading() {
+	g := &conn.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resume = make(chan struct{})
+	}
+}
+
+// ResumeReading resumes reading after a call to PauseReading.
+func (conn *Conn) ResumeReading() {
+	g := &conn.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resume)
+	}
+}
+
+// wait blocks while reading is paused.
+func (g *readGate) wait() {
+	g.mu.Lock()
+	if !g.paused {
+		g.mu.Unlock()
+		return
+	}
+	resume := g.resume
+	g.mu.Unlock()
+	<-resume
+}
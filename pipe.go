@@ -0,0 +1,57 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+)
+
+// Pipe returns two websocket connections which are connected to each other
+// in memory, using net.Pipe.  The connection server behaves like a
+// connection obtained from a Handler, and client behaves like a connection
+// obtained from a Dialer.  This can be used to test code which uses
+// websocket connections, without starting an HTTP server.
+//
+// Since net.Pipe does not buffer data, sending a large message blocks
+// until the peer reads the message.
+func Pipe() (server, client *Conn) {
+	a, b := net.Pipe()
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	server = &Conn{
+		ResourceName: "/",
+		RemoteAddr:   "pipe",
+		HandshakeKey: key,
+	}
+	server.initialize(a, bufio.NewReadWriter(bufio.NewReader(a), bufio.NewWriter(a)))
+
+	client = &Conn{
+		ResourceName: "/",
+		RemoteAddr:   "pipe",
+		HandshakeKey: key,
+		isClient:     true,
+	}
+	client.initialize(b, bufio.NewReadWriter(bufio.NewReader(b), bufio.NewWriter(b)))
+
+	return server, client
+}
@@ -0,0 +1,332 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	server, client := Pipe()
+	if server.IsClient() || !client.IsClient() {
+		t.Fatal("wrong roles")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		msg, err := server.ReceiveText(100)
+		if err == nil {
+			err = server.SendText(msg + "!")
+		}
+		done <- err
+	}()
+
+	err := client.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := client.ReceiveText(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "hello!" {
+		t.Errorf("wrong message %q", msg)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Close(StatusOK, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, status, message := server.Wait()
+	if info != ClientClosed || status != StatusOK || message != "bye" {
+		t.Errorf("wrong close information %d %d %q", info, status, message)
+	}
+	client.Wait()
+}
+
+func TestWaitContext(t *testing.T) {
+	server, client := Pipe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, err := server.WaitContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-server.Done():
+		t.Error("connection shut down too early")
+	default:
+	}
+
+	err = client.Close(StatusOK, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, status, message, err := server.WaitContext(context.Background())
+	if err != nil || info != ClientClosed || status != StatusOK || message != "bye" {
+		t.Errorf("wrong result %d %d %q %v", info, status, message, err)
+	}
+	<-server.Done()
+	<-client.Done()
+}
+
+func TestCloseInfo(t *testing.T) {
+	server, client := Pipe()
+
+	err := server.Close(StatusGoingAway, "restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := server.CloseInfo()
+	want := CloseInfo{
+		Info:         ServerClosed,
+		PeerStatus:   StatusGoingAway,
+		LocalStatus:  StatusGoingAway,
+		LocalMessage: "restart",
+	}
+	if got != want {
+		t.Errorf("wrong server info %v", got)
+	}
+
+	got = client.CloseInfo()
+	want = CloseInfo{
+		Info:          ClientClosed,
+		PeerInitiated: true,
+		PeerStatus:    StatusGoingAway,
+		PeerMessage:   "restart",
+		LocalStatus:   StatusGoingAway,
+	}
+	if got != want {
+		t.Errorf("wrong client info %v", got)
+	}
+}
+
+func TestCloseStatus(t *testing.T) {
+	for _, serverCloses := range []bool{true, false} {
+		server, client := Pipe()
+		closer, peer := client, server
+		if serverCloses {
+			closer, peer = server, client
+		}
+
+		err := closer.Close(4000, "bye")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closer.Wait()
+		peer.Wait()
+
+		for i, conn := range []*Conn{closer, peer} {
+			_, err = conn.ReceiveText(100)
+			if !errors.Is(err, ErrConnClosed) {
+				t.Errorf("%t/%d: wrong receive error %v", serverCloses, i, err)
+			}
+			if status := CloseStatus(err); status != 4000 {
+				t.Errorf("%t/%d: wrong receive status %d", serverCloses, i, status)
+			}
+
+			err = conn.SendText("late")
+			if !errors.Is(err, ErrConnClosed) {
+				t.Errorf("%t/%d: wrong send error %v", serverCloses, i, err)
+			}
+			if status := CloseStatus(err); status != 4000 {
+				t.Errorf("%t/%d: wrong send status %d", serverCloses, i, status)
+			}
+		}
+	}
+
+	server, client := Pipe()
+	server.Close(StatusNotSent, "")
+	client.Wait()
+	_, err := client.ReceiveBinary(make([]byte, 10))
+	if status := CloseStatus(err); status != StatusNotSent {
+		t.Errorf("wrong status %d for close frame without status", status)
+	}
+
+	server, client = Pipe()
+	server.raw.Close()
+	_, err = client.ReceiveBinary(make([]byte, 10))
+	if !errors.Is(err, ErrConnClosed) {
+		t.Errorf("wrong error %v", err)
+	}
+	if status := CloseStatus(err); status != StatusDropped {
+		t.Errorf("wrong status %d for dropped connection", status)
+	}
+	if status := CloseStatus(nil); status != StatusDropped {
+		t.Errorf("wrong status %d for nil", status)
+	}
+}
+
+func TestCloseWhenDone(t *testing.T) {
+	server, client := Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	server.CloseWhenDone(ctx)
+	cancel()
+
+	_, status, _ := client.Wait()
+	if status != StatusGoingAway {
+		t.Errorf("wrong status %d", status)
+	}
+	server.Wait()
+}
+
+func TestPauseReading(t *testing.T) {
+	server, client := Pipe()
+	server.PauseReading()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.SendBinary(make([]byte, 100000))
+	}()
+
+	// Since nothing is read from the connection, the client cannot
+	// complete sending.
+	select {
+	case err := <-done:
+		t.Fatalf("send completed while reading was paused, err=%v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.ResumeReading()
+	msg, err := server.ReceiveBinaryAlloc(200000)
+	if err != nil || len(msg) != 100000 {
+		t.Errorf("wrong message: %d bytes, err=%v", len(msg), err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	// Closing the connection resumes reading, to complete the closing
+	// handshake.
+	server.PauseReading()
+	err = server.Close(StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	info, _, _, err := server.WaitContext(ctx)
+	if err != nil || info != ServerClosed {
+		t.Errorf("wrong close information %d, err=%v", info, err)
+	}
+	client.Wait()
+}
+
+// TestOnError checks that a failure to send the close frame, after the
+// peer closed the connection, is reported to the OnError callback.
+func TestOnError(t *testing.T) {
+	a, b := net.Pipe()
+	server := &Conn{ResourceName: "/"}
+	errs := make(chan error, 1)
+	server.OnError(func(err error) { errs <- err })
+	server.initialize(a, bufio.NewReadWriter(bufio.NewReader(a), bufio.NewWriter(a)))
+
+	// a masked close frame without body
+	_, err := b.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("wrong error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error not reported")
+	}
+	server.Wait()
+}
+
+func TestStartDraining(t *testing.T) {
+	server, client := Pipe()
+
+	received := make(chan []string, 1)
+	go func() {
+		var msgs []string
+		for {
+			msg, err := client.ReceiveText(100)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, msg)
+		}
+		received <- msgs
+	}()
+
+	w, err := server.SendMessage(Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewSendQueue(server)
+	err = q.Send(Text, []byte("queued"), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.StartDraining()
+	if !server.Draining() {
+		t.Error("Draining returned false")
+	}
+	if err := server.SendText("new"); err != ErrDraining {
+		t.Errorf("SendText: expected ErrDraining, got %v", err)
+	}
+	if err := q.Send(Text, []byte("new"), PriorityNormal); err != ErrDraining {
+		t.Errorf("SendQueue.Send: expected ErrDraining, got %v", err)
+	}
+	errs := BroadcastText(context.Background(), []*Conn{server}, "new")
+	if errs[0] != ErrDraining {
+		t.Errorf("BroadcastText: expected ErrDraining, got %v", errs[0])
+	}
+
+	// messages which were started or queued before are still sent
+	w.Write([]byte("started"))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = q.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.SendPing(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Close(StatusGoingAway, "moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := <-received
+	if len(msgs) != 2 || msgs[0] != "started" || msgs[1] != "queued" {
+		t.Errorf("wrong messages %q", msgs)
+	}
+}
@@ -0,0 +1,103 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package websocket
+
+import "syscall"
+
+func pollCreate() (fd, wakeR, wakeW int, err error) {
+	fd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return -1, -1, -1, err
+	}
+	var pipe [2]int
+	err = syscall.Pipe2(pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, -1, -1, err
+	}
+	event := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(pipe[0]),
+	}
+	err = syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, pipe[0], event)
+	if err != nil {
+		syscall.Close(fd)
+		syscall.Close(pipe[0])
+		syscall.Close(pipe[1])
+		return -1, -1, -1, err
+	}
+	return fd, pipe[0], pipe[1], nil
+}
+
+func pollControl(pfd, fd int, op pollOp) error {
+	var ctl int
+	event := &syscall.EpollEvent{Fd: int32(fd)}
+	switch op {
+	case pollAdd:
+		// Register the file descriptor without asking for any events.
+		ctl = syscall.EPOLL_CTL_ADD
+		event.Events = syscall.EPOLLONESHOT
+	case pollArm:
+		ctl = syscall.EPOLL_CTL_MOD
+		event.Events = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+	case pollRemove:
+		ctl = syscall.EPOLL_CTL_DEL
+	}
+	return syscall.EpollCtl(pfd, ctl, fd, event)
+}
+
+var epollEvents []syscall.EpollEvent // only used by the poller goroutine
+
+func pollWait(pfd int, fds []int) (int, error) {
+	if len(epollEvents) < len(fds) {
+		epollEvents = make([]syscall.EpollEvent, len(fds))
+	}
+	n, err := syscall.EpollWait(pfd, epollEvents[:len(fds)], -1)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		fds[i] = int(epollEvents[i].Fd)
+	}
+	return n, nil
+}
+
+// pollWake wakes up the poller goroutine.
+func pollWake(wakeW int) {
+	syscall.Write(wakeW, []byte{0})
+}
+
+// pollDrain discards all data from the wakeup pipe.
+func pollDrain(wakeR int) {
+	var buf [16]byte
+	for {
+		n, _ := syscall.Read(wakeR, buf[:])
+		if n < len(buf) {
+			break
+		}
+	}
+}
+
+// pollClose closes the file descriptors of a poller.
+func pollClose(fd, wakeR, wakeW int) {
+	syscall.Close(fd)
+	syscall.Close(wakeR)
+	syscall.Close(wakeW)
+}
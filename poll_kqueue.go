@@ -0,0 +1,110 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package websocket
+
+import "syscall"
+
+func pollCreate() (fd, wakeR, wakeW int, err error) {
+	fd, err = syscall.Kqueue()
+	if err != nil {
+		return -1, -1, -1, err
+	}
+	syscall.CloseOnExec(fd)
+	var pipe [2]int
+	err = syscall.Pipe(pipe[:])
+	if err != nil {
+		syscall.Close(fd)
+		return -1, -1, -1, err
+	}
+	for _, p := range pipe {
+		syscall.CloseOnExec(p)
+		syscall.SetNonblock(p, true)
+	}
+	var change [1]syscall.Kevent_t
+	syscall.SetKevent(&change[0], pipe[0], syscall.EVFILT_READ, syscall.EV_ADD)
+	_, err = syscall.Kevent(fd, change[:], nil, nil)
+	if err != nil {
+		syscall.Close(fd)
+		syscall.Close(pipe[0])
+		syscall.Close(pipe[1])
+		return -1, -1, -1, err
+	}
+	return fd, pipe[0], pipe[1], nil
+}
+
+func pollControl(pfd, fd int, op pollOp) error {
+	var flags int
+	switch op {
+	case pollAdd:
+		// Filters are only installed when the connection is armed.
+		return nil
+	case pollArm:
+		flags = syscall.EV_ADD | syscall.EV_ONESHOT
+	case pollRemove:
+		flags = syscall.EV_DELETE
+	}
+	var change [1]syscall.Kevent_t
+	syscall.SetKevent(&change[0], fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(pfd, change[:], nil, nil)
+	if op == pollRemove && err == syscall.ENOENT {
+		// one-shot filters are removed once they have fired
+		err = nil
+	}
+	return err
+}
+
+var kqueueEvents []syscall.Kevent_t // only used by the poller goroutine
+
+func pollWait(pfd int, fds []int) (int, error) {
+	if len(kqueueEvents) < len(fds) {
+		kqueueEvents = make([]syscall.Kevent_t, len(fds))
+	}
+	n, err := syscall.Kevent(pfd, nil, kqueueEvents[:len(fds)], nil)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		fds[i] = int(kqueueEvents[i].Ident)
+	}
+	return n, nil
+}
+
+// pollWake wakes up the poller goroutine.
+func pollWake(wakeW int) {
+	syscall.Write(wakeW, []byte{0})
+}
+
+// pollDrain discards all data from the wakeup pipe.
+func pollDrain(wakeR int) {
+	var buf [16]byte
+	for {
+		n, _ := syscall.Read(wakeR, buf[:])
+		if n < len(buf) {
+			break
+		}
+	}
+}
+
+// pollClose closes the file descriptors of a poller.
+func pollClose(fd, wakeR, wakeW int) {
+	syscall.Close(fd)
+	syscall.Close(wakeR)
+	syscall.Close(wakeW)
+}
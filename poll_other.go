@@ -0,0 +1,41 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package websocket
+
+// On this system, connections in event-driven mode use one goroutine per
+// connection.
+
+func pollCreate() (fd, wakeR, wakeW int, err error) {
+	return -1, -1, -1, errNoPoller
+}
+
+func pollControl(pfd, fd int, op pollOp) error {
+	return errNoPoller
+}
+
+func pollWait(pfd int, fds []int) (int, error) {
+	return 0, errNoPoller
+}
+
+func pollWake(wakeW int) {}
+
+func pollDrain(wakeR int) {}
+
+func pollClose(fd, wakeR, wakeW int) {}
@@ -0,0 +1,54 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+// BufferPool is a pool of byte slices, shared between all connections of a
+// Handler.  The buffers are used for small, short-lived data like control
+// frame payloads.  Implementations must be safe for concurrent use by
+// multiple goroutines.
+type BufferPool interface {
+	// Get returns a buffer from the pool.  Buffers with a capacity of less
+	// than 128 bytes are not used, and are returned to the pool immediately.
+	Get() []byte
+
+	// Put returns a buffer to the pool.  The buffer is not accessed any
+	// more after Put has been called.
+	Put([]byte)
+}
+
+const minPoolBufferSize = 128
+
+// getBuffer returns a buffer of length n, where n is at most
+// minPoolBufferSize.  If pool is non-nil, the buffer is taken from
+// the pool.
+func getBuffer(pool BufferPool, n int) []byte {
+	if pool != nil {
+		buf := pool.Get()
+		if cap(buf) >= minPoolBufferSize {
+			return buf[:n]
+		}
+		pool.Put(buf)
+	}
+	return make([]byte, n, minPoolBufferSize)
+}
+
+// putBuffer returns a buffer obtained from getBuffer to the pool.
+func putBuffer(pool BufferPool, buf []byte) {
+	if pool != nil && buf != nil {
+		pool.Put(buf)
+	}
+}
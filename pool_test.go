@@ -0,0 +1,89 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+type countingPool struct {
+	sync.Mutex
+	gets, puts int
+}
+
+func (p *countingPool) Get() []byte {
+	p.Lock()
+	p.gets++
+	p.Unlock()
+	return make([]byte, 256)
+}
+
+func (p *countingPool) Put(buf []byte) {
+	p.Lock()
+	p.puts++
+	p.Unlock()
+}
+
+// TestBufferPool checks that all buffers taken from the pool are
+// returned once the connection is closed.
+func TestBufferPool(t *testing.T) {
+	client, server := net.Pipe()
+	rw := bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))
+
+	pool := &countingPool{}
+	conn := &Conn{pool: pool}
+	conn.initialize(server, rw)
+
+	go func() {
+		var buf []byte
+		buf = appendFrame(buf, pingFrame, []byte("ping"), true)
+		buf = appendFrame(buf, closeFrame, []byte{1000 / 256, 1000 % 256}, true)
+		client.Write(buf)
+	}()
+
+	r := bufio.NewReader(client)
+	for _, expected := range []MessageType{pongFrame, closeFrame} {
+		h := make([]byte, 2)
+		_, err := io.ReadFull(r, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp := MessageType(h[0] & 15); tp != expected {
+			t.Fatalf("expected %s, got %s", expected, tp)
+		}
+		_, err = io.CopyN(io.Discard, r, int64(h[1]&127))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.Close()
+
+	_, status, _ := conn.Wait()
+	if status != StatusOK {
+		t.Errorf("wrong status %d", status)
+	}
+
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.gets == 0 || pool.gets != pool.puts {
+		t.Errorf("%d buffers taken from the pool, %d returned", pool.gets, pool.puts)
+	}
+}
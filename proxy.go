@@ -0,0 +1,210 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"io"
+	"net/http"
+)
+
+const maxCopyBufferSize = 32 * 1024
+
+// CopyMessage reads the next message from src and sends it to dst, without
+// holding the complete message in memory.  The message type is preserved.
+// The function returns the number of bytes copied.
+//
+// Unfragmented messages are sent to dst as a single frame.  Fragmented
+// messages are sent to dst as they arrive.  If src fails while a message is
+// copied, the message cannot be completed and dst is closed as well.
+//
+// Since the payload of every frame sent by a client is masked, and frames
+// sent by the server are not, the data must pass through user space.  This
+// rules out the use of splice(2) for relaying messages.
+func CopyMessage(dst, src *Conn) (int64, error) {
+	n, readErr, writeErr := copyMessage(dst, src)
+	if readErr != nil {
+		return n, readErr
+	}
+	return n, writeErr
+}
+
+// copyMessage implements CopyMessage.  Errors on src and dst are reported
+// separately.  If writing to dst fails, the remainder of the message is
+// read from src and discarded.
+func copyMessage(dst, src *Conn) (n int64, readErr, writeErr error) {
+	rb, ok := <-src.toUser
+	if !ok {
+		return 0, ErrConnClosed, nil
+	}
+	tp := rb.header.Opcode
+	r := newAutoCloseReader(rb, src.fromUser)
+
+	bufSize := maxCopyBufferSize
+	var w io.WriteCloser
+	if rb.header.Final {
+		w, writeErr = dst.SendMessageN(tp, rb.header.Length)
+		if rb.header.Length < int64(bufSize) {
+			bufSize = int(rb.header.Length) + 1
+		}
+	} else {
+		w, writeErr = dst.SendMessage(tp)
+	}
+	buf := make([]byte, bufSize)
+	if writeErr != nil {
+		// We need to read the complete message, so that the next
+		// read doesn't block.
+		_, readErr = io.CopyBuffer(io.Discard, r, buf)
+		return 0, readErr, writeErr
+	}
+
+	for {
+		k, err := r.Read(buf)
+		if k > 0 && writeErr == nil {
+			_, writeErr = w.Write(buf[:k])
+			if writeErr == nil {
+				n += int64(k)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	if readErr != nil {
+		// The message is incomplete, so dst cannot be used any more.
+		dst.forceStop()
+		w.Close()
+		return n, readErr, ErrConnClosed
+	}
+	closeErr := w.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	return n, nil, writeErr
+}
+
+// ProxyHandler implements the http.Handler interface.  The handler accepts
+// websocket connections from clients and relays all messages between each
+// client and a new connection to an upstream websocket server.
+//
+// Messages are copied one at a time, without holding complete messages
+// in memory.  A slow reader on one side stalls the sender on the other
+// side, so that no unbounded buffering occurs.  When one side closes the
+// connection, the status code and message are passed on to the other side.
+//
+// Ping frames are answered by the proxy and are also forwarded to the other
+// side, unless a message is being sent to that side at the time.  Pong
+// frames are not forwarded.
+type ProxyHandler struct {
+	// Handler is used to accept connections from clients.  The Handle
+	// field is ignored.
+	Handler
+
+	// UpstreamURL is the URL of the upstream websocket server.
+	UpstreamURL string
+
+	// Dialer is used to connect to the upstream server.
+	// If this is nil, DefaultDialer is used.
+	Dialer *Dialer
+}
+
+func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	dialer := p.Dialer
+	if dialer == nil {
+		dialer = DefaultDialer
+	}
+
+	// Pings from the upstream server can only be forwarded once the client
+	// connection is established.
+	var client *Conn
+	clientReady := make(chan struct{})
+	d := *dialer
+	d.onPing = func(body []byte) {
+		select {
+		case <-clientReady:
+			client.forwardPing(body)
+		default:
+		}
+	}
+	upstream, err := d.Dial(req.Context(), p.UpstreamURL)
+	if err != nil {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+
+	// The copy of the handler must share the connection counter.
+	p.Handler.connSlots()
+	h := p.Handler
+	h.onPing = upstream.forwardPing
+	client, err = h.Upgrade(w, req)
+	if err != nil {
+		upstream.Close(StatusGoingAway, "")
+		return
+	}
+	close(clientReady)
+
+	done := make(chan struct{})
+	go func() {
+		relay(upstream, client)
+		close(done)
+	}()
+	relay(client, upstream)
+	<-done
+}
+
+// relay copies messages from src to dst, until one of the connections is
+// closed.
+func relay(dst, src *Conn) {
+	for {
+		_, readErr, writeErr := copyMessage(dst, src)
+		if readErr != nil {
+			_, status, message := src.Wait()
+			if status == StatusDropped {
+				status = StatusGoingAway
+			}
+			err := dst.Close(status, message)
+			if err == ErrStatusCode || err == ErrTooLarge {
+				dst.Close(StatusNotSent, "")
+			}
+			return
+		}
+		if writeErr != nil {
+			src.Close(StatusGoingAway, "")
+			return
+		}
+	}
+}
+
+// forwardPing sends a ping frame with the given payload, if the sender is
+// available.  Otherwise the ping is dropped.
+func (conn *Conn) forwardPing(body []byte) {
+	select {
+	case wb := <-conn.senderStore:
+		if wb == nil {
+			return
+		}
+		if !wb.isShuttingDown() {
+			wb.sendFrame(pingFrame, body, true)
+		}
+		wb.release()
+	default:
+		// the sender is in use
+	}
+}
@@ -0,0 +1,153 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCopyMessage(t *testing.T) {
+	conns := make(chan *Conn, 2)
+	server, err := StartTestServer(func(c *Conn) {
+		conns <- c
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client1, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+	src := <-conns
+
+	client2, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	dst := <-conns
+
+	// an unfragmented message
+	err = client1.SendFrame(Text, []byte("hello"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a fragmented message
+	err = client1.SendFrame(Binary, []byte{1, 2, 3}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client1.SendFrame(contFrame, []byte{4, 5}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = CopyMessage(dst, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tp, body, err := client2.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != Text || string(body) != "hello" {
+		t.Errorf("wrong first message: %s %q", tp, body)
+	}
+
+	var data []byte
+	for _, expected := range []MessageType{Binary, contFrame, contFrame} {
+		tp, body, err := client2.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != expected {
+			t.Errorf("expected %s frame, got %s", expected, tp)
+		}
+		data = append(data, body...)
+	}
+	if !bytes.Equal(data, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("wrong second message: %v", data)
+	}
+
+	src.Close(StatusOK, "")
+	dst.Close(StatusOK, "")
+}
+
+func TestProxyHandler(t *testing.T) {
+	type closeInfo struct {
+		status  Status
+		message string
+	}
+	upstreamClosed := make(chan closeInfo, 1)
+	upstream := httptest.NewServer(&Handler{
+		Handle: func(c *Conn) {
+			echo(c)
+			_, status, message := c.Wait()
+			upstreamClosed <- closeInfo{status, message}
+		},
+	})
+	defer upstream.Close()
+
+	proxy := httptest.NewServer(&ProxyHandler{
+		UpstreamURL: "ws" + strings.TrimPrefix(upstream.URL, "http"),
+	})
+	defer proxy.Close()
+
+	conn, err := DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(proxy.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		msg := strings.Repeat("hello ", 10000*i+1)
+		err = conn.SendText(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := conn.ReceiveText(len(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != msg {
+			t.Errorf("%d: wrong message received", i)
+		}
+	}
+
+	err = conn.Close(3000, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := <-upstreamClosed
+	if got.status != 3000 || got.message != "bye" {
+		t.Errorf("upstream got status %d %q", got.status, got.message)
+	}
+	_, status, _ := conn.Wait()
+	if status != 3000 && status != StatusOK {
+		t.Errorf("unexpected status %d", status)
+	}
+}
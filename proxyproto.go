@@ -0,0 +1,334 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ProxyListener wraps a net.Listener, for servers behind a load balancer
+// which uses the PROXY protocol (for example HAProxy, or an AWS Network
+// Load Balancer in TCP mode).  Every accepted connection must start with a
+// PROXY protocol header, version 1 or 2.  The header is removed from the
+// data stream, and the RemoteAddr method of the connection returns the
+// client address given in the header.  As a result, the RemoteAddr field
+// of websocket connections contains the address of the client, rather than
+// the address of the load balancer.
+//
+// The header is read on first use of the connection, so that slow
+// clients do not block Accept.
+//
+// See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt for a
+// description of the protocol.
+type ProxyListener struct {
+	net.Listener
+
+	// Timeout limits the time for receiving the PROXY header.  If Timeout
+	// is zero, 10 seconds are used.
+	Timeout time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &proxyConn{Conn: c, timeout: timeout}, nil
+}
+
+// ProxyHeader contains the information from a PROXY protocol header, see
+// ProxyListener.
+type ProxyHeader struct {
+	// Source and Destination are the addresses of the original
+	// connection, or nil if the load balancer did not provide them, for
+	// example for health checks.
+	Source      net.Addr
+	Destination net.Addr
+
+	// Authority is the host name requested by the client, for example
+	// using TLS SNI.  This is only available with version 2 of the
+	// protocol.
+	Authority string
+
+	// TLS is set, if the client connected to the load balancer using TLS.
+	// TLSVersion gives the version of TLS used, for example "TLSv1.3".
+	// These fields are only available with version 2 of the protocol.
+	TLS        bool
+	TLSVersion string
+}
+
+// ProxyHeader returns the PROXY protocol header received on the
+// connection, or nil if the connection was not accepted by a
+// ProxyListener.
+func (conn *Conn) ProxyHeader() *ProxyHeader {
+	if pc, ok := conn.raw.(*proxyConn); ok {
+		return pc.header()
+	}
+	return nil
+}
+
+// proxyConn is a connection accepted by a ProxyListener.
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once sync.Once
+	hdr  *ProxyHeader
+	err  error
+}
+
+func (c *proxyConn) header() *ProxyHeader {
+	c.once.Do(c.readHeader)
+	return c.hdr
+}
+
+func (c *proxyConn) Read(buf []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(buf)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if hdr := c.header(); hdr != nil && hdr.Source != nil {
+		return hdr.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if hdr := c.header(); hdr != nil && hdr.Destination != nil {
+		return hdr.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SyscallConn gives access to the underlying connection, so that the
+// connection can be used in event-driven mode.
+func (c *proxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errNoPoller
+	}
+	return sc.SyscallConn()
+}
+
+// readHeader reads the PROXY header.  The header is read without
+// buffering, so that no data following the header is consumed.
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	c.hdr, c.err = readProxyHeader(c.Conn)
+	c.Conn.SetReadDeadline(time.Time{})
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+var (
+	errProxyHeader = errors.New("invalid PROXY protocol header")
+
+	proxySigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+func readProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	// Both versions of the header are at least 12 bytes long.
+	start := make([]byte, 12)
+	_, err := io.ReadFull(r, start)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxySigV2) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyHeaderV1(r, start)
+	}
+	return nil, errProxyHeader
+}
+
+// readProxyHeaderV1 reads the rest of a version 1 header, which is a
+// single line of at most 107 bytes.
+func readProxyHeaderV1(r io.Reader, start []byte) (*ProxyHeader, error) {
+	line := start
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			return nil, errProxyHeader
+		}
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &ProxyHeader{}, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, errProxyHeader
+	}
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyHeader{Source: src, Destination: dst}, nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyHeaderV2 reads the rest of a version 2 header, after the
+// signature.
+func readProxyHeaderV2(r io.Reader) (*ProxyHeader, error) {
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	verCmd, family := buf[0], buf[1]
+	if verCmd>>4 != 2 {
+		return nil, errProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(buf[2:]))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &ProxyHeader{}
+	if verCmd&15 == 0 {
+		// LOCAL command: the connection was established by the proxy
+		// itself, and the addresses must be ignored.
+		return hdr, nil
+	} else if verCmd&15 != 1 {
+		return nil, errProxyHeader
+	}
+
+	var tlvs []byte
+	switch family >> 4 {
+	case 1: // IPv4
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		hdr.Source, hdr.Destination = proxyAddrsV2(family, body[:12], 4)
+		tlvs = body[12:]
+	case 2: // IPv6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		hdr.Source, hdr.Destination = proxyAddrsV2(family, body[:36], 16)
+		tlvs = body[36:]
+	case 3: // unix
+		if len(body) < 216 {
+			return nil, errProxyHeader
+		}
+		tlvs = body[216:]
+	default: // unspecified
+		return hdr, nil
+	}
+
+	err = parseProxyTLVs(hdr, tlvs)
+	if err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// proxyAddrsV2 decodes the source and destination addresses of a version
+// 2 header.  The addresses are followed by the source and destination
+// ports.
+func proxyAddrsV2(family byte, body []byte, ipLen int) (net.Addr, net.Addr) {
+	srcIP := net.IP(append([]byte(nil), body[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if family&15 == 2 {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+// TLV types used in version 2 headers.
+const (
+	pp2TypeAuthority     = 0x02
+	pp2TypeSSL           = 0x20
+	pp2SubtypeSSLVersion = 0x21
+	pp2ClientSSL         = 0x01
+)
+
+func parseProxyTLVs(hdr *ProxyHeader, data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return errProxyHeader
+		}
+		tp := data[0]
+		n := int(binary.BigEndian.Uint16(data[1:]))
+		if len(data) < 3+n {
+			return errProxyHeader
+		}
+		value := data[3 : 3+n]
+		data = data[3+n:]
+
+		switch tp {
+		case pp2TypeAuthority:
+			hdr.Authority = string(value)
+		case pp2TypeSSL:
+			// one byte of client flags, and four bytes with the
+			// verification result, followed by sub-TLVs
+			if len(value) < 5 {
+				return errProxyHeader
+			}
+			hdr.TLS = value[0]&pp2ClientSSL != 0
+			sub := &ProxyHeader{}
+			err := parseProxyTLVs(sub, value[5:])
+			if err != nil {
+				return err
+			}
+			hdr.TLSVersion = sub.TLSVersion
+		case pp2SubtypeSSLVersion:
+			hdr.TLSVersion = string(value)
+		}
+	}
+	return nil
+}
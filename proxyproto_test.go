@@ -0,0 +1,130 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	r := strings.NewReader("PROXY TCP4 203.0.113.5 192.0.2.1 5000 443\r\nGET")
+	hdr, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Source.String() != "203.0.113.5:5000" || hdr.Destination.String() != "192.0.2.1:443" {
+		t.Errorf("wrong addresses %s %s", hdr.Source, hdr.Destination)
+	}
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "GET" {
+		t.Errorf("wrong remaining data %q", rest)
+	}
+
+	for _, bad := range []string{
+		"PROXY TCP4 203.0.113.5 192.0.2.1 5000\r\n",
+		"PROXY TCP4 203.0.113.5 192.0.2.1 5000 70000\r\n",
+		"GET / HTTP/1.1\r\n",
+	} {
+		_, err := readProxyHeader(strings.NewReader(bad))
+		if err != errProxyHeader {
+			t.Errorf("%q: expected errProxyHeader, got %v", bad, err)
+		}
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	buf := &bytes.Buffer{}
+	buf.Write(proxySigV2)
+	buf.Write([]byte{0x21, 0x21})
+	tlvs := []byte{
+		pp2TypeAuthority, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'o', 'r', 'g',
+		pp2TypeSSL, 0, 12, pp2ClientSSL, 0, 0, 0, 0,
+		pp2SubtypeSSLVersion, 0, 4, 'T', 'L', 'S', 'x',
+	}
+	body := make([]byte, 36)
+	copy(body, net.ParseIP("2001:db8::1"))
+	copy(body[16:], net.ParseIP("2001:db8::2"))
+	body[32], body[33] = 0x13, 0x88 // 5000
+	body[34], body[35] = 0x01, 0xbb // 443
+	body = append(body, tlvs...)
+	buf.Write([]byte{0, byte(len(body))})
+	buf.Write(body)
+	buf.WriteString("GET")
+
+	hdr, err := readProxyHeader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Source.String() != "[2001:db8::1]:5000" || hdr.Destination.String() != "[2001:db8::2]:443" {
+		t.Errorf("wrong addresses %s %s", hdr.Source, hdr.Destination)
+	}
+	if hdr.Authority != "example.org" || !hdr.TLS || hdr.TLSVersion != "TLSx" {
+		t.Errorf("wrong header %+v", hdr)
+	}
+	if buf.String() != "GET" {
+		t.Errorf("wrong remaining data %q", buf.String())
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	remote := make(chan string, 1)
+	go http.Serve(&ProxyListener{Listener: l}, &Handler{
+		Handle: func(conn *Conn) {
+			remote <- conn.RemoteAddr + " " + conn.ProxyHeader().Destination.String()
+			conn.Close(StatusOK, "")
+		},
+	})
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Write([]byte("PROXY TCP4 203.0.113.5 192.0.2.1 5000 443\r\n" +
+		"GET /chat HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: 0000000000000000000000==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong status %d", resp.StatusCode)
+	}
+
+	if got := <-remote; got != "203.0.113.5:5000 192.0.2.1:443" {
+		t.Errorf("wrong addresses %q", got)
+	}
+}
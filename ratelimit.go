@@ -0,0 +1,84 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"io"
+	"time"
+)
+
+// defaultSendBurst is used if Handler.SendBurst is not set.
+const defaultSendBurst = 16 * 1024
+
+// rateLimiter is an io.Writer which limits the rate at which data is
+// written to the underlying writer, using a token bucket.  The sender
+// serialises all writes, so no locking is needed.
+type rateLimiter struct {
+	w      io.Writer
+	rate   float64 // bytes per second
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(w io.Writer, rate, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = defaultSendBurst
+	}
+	return &rateLimiter{
+		w:      w,
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (rl *rateLimiter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if chunk > rl.burst {
+			chunk = rl.burst
+		}
+
+		rl.refill()
+		if missing := float64(chunk) - rl.tokens; missing > 0 {
+			time.Sleep(time.Duration(missing / rl.rate * float64(time.Second)))
+			rl.refill()
+		}
+
+		n, err := rl.w.Write(p[:chunk])
+		rl.tokens -= float64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[chunk:]
+	}
+	return total, nil
+}
+
+// refill adds the tokens which have accumulated since the last call.
+func (rl *rateLimiter) refill() {
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if limit := float64(rl.burst); rl.tokens > limit {
+		rl.tokens = limit
+	}
+	rl.last = now
+}
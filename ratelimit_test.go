@@ -0,0 +1,64 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendRateLimit(t *testing.T) {
+	const (
+		rate  = 400000
+		burst = 20000
+		size  = 100000
+	)
+	server, err := StartTestServerWithHandler(&Handler{
+		SendRateLimit: rate,
+		SendBurst:     burst,
+		Handle: func(conn *Conn) {
+			conn.SendBinary(make([]byte, size))
+			conn.Close(StatusOK, "")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	tp, body, err := client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if tp != Binary || len(body) != size {
+		t.Errorf("wrong frame %s, %d bytes", tp, len(body))
+	}
+
+	// The first burst is sent immediately, the rest at the given rate.
+	minTime := time.Duration(float64(size-burst) / rate * float64(time.Second))
+	if elapsed < minTime*9/10 {
+		t.Errorf("data sent too fast: %s < %s", elapsed, minTime)
+	}
+}
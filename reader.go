@@ -19,8 +19,12 @@ package websocket
 import (
 	"bufio"
 	"context"
+	"errors"
 	"io"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 )
 
@@ -32,9 +36,40 @@ import (
 type receiver struct {
 	r           *bufio.Reader
 	senderStore chan *sender
-	scratch     []byte // buffer for headers and control frame payloads
+	scratch     []byte // control frame payloads, allocated on demand
 	header      frameHeader
 	pos         int64
+	pool        BufferPool
+	lowMemory   bool
+	pong        *pendingPong
+	isClient    bool // if true, we expect unmasked frames from the server
+	rsvBits     byte // reserved bits which may be set in received frames
+	onPing      func(body []byte)
+	pollIdle    bool // if true, refill returns errIdle instead of blocking
+	trace       *traceState
+	stats       *Stats
+	control     *controlCounters
+	tracer      *spanTracer
+	msgType     MessageType // type of the current message, for OnMessageSize
+	msgSize     int64       // size of the current message, for OnMessageSize
+	readErr     error       // the error which caused ConnDropped, ProtocolViolation or InvalidMessage
+	violation   Violation
+	idle        *idleTimer
+	activity    *activity
+	midMessage  bool   // set if more fragments of the current message follow
+	detach      *int32 // points to Conn.detaching
+	discardRest bool   // set by Resume, if a partial message must be skipped
+	msg         []byte // the current message, if read ahead for Handler.Validate
+	msgTrace    *messageTrace
+
+	// rate limiting for incoming control frames, see
+	// Handler.ControlFrameLimit
+	ctrlLimit int
+	ctrlCount int
+	ctrlStart time.Time
+
+	// see Handler.UnsolicitedPongLimit
+	pongLimit int
 
 	connInfo        ConnInfo
 	shutdownStarted chan<- struct{}
@@ -58,48 +93,94 @@ func (conn *Conn) readManager(data *readManagerData) {
 	//   2. A read error occurs while reading from the connection.
 	//      In this case, rb.connInfo is set to ConnDropped.
 	//   3. We fail the connection.  In this case, rb.connInfo is set
-	//      to either [ProtocolViolation] or [WrongMessageType].
+	//      to [ProtocolViolation], [WrongMessageType], [ControlFlood] or
+	//      [InvalidMessage].
 	var rb *receiver
+	var span Span
 	for {
 		rb = <-data.fromUser
+		rb.msgTrace = nil
+		endSpan(span, nil)
+		span = nil
 		if rb.connInfo != 0 || rb.header.Opcode == closeFrame {
 			break
 		}
+		if atomic.LoadInt32(&conn.detaching) != 0 {
+			rb.failConnection(Detached)
+			break
+		}
+		if rb.discardRest {
+			rb.discardRest = false
+			_, err := io.Copy(io.Discard, &frameReader{rb: rb})
+			if err != nil {
+				break
+			}
+		}
+
+		conn.gate.wait()
 
 		// Wait until a new data frame is available.
 		// We don't need to check the returned error value, since in case
 		// of error, rb.connInfo is non-zero or rb.header.Opcode == closeFrame.
 		rb.refill(false)
+		for rb.connInfo == 0 && rb.header.Opcode != closeFrame {
+			if conn.discardMessages() {
+				// Close has been called, nobody is interested in the message.
+				_, err := io.Copy(io.Discard, &frameReader{rb: rb})
+				if err != nil {
+					break
+				}
+			} else if !conn.skipMessage(rb) {
+				break
+			}
+			rb.refill(false)
+		}
 		if rb.connInfo != 0 || rb.header.Opcode == closeFrame {
 			break
 		}
+		span = rb.tracer.start("receive", rb.header.Opcode)
 		data.toUser <- rb
 	}
 
-	// Notify the user that no more data will be incoming.
-	close(data.toUser)
+	conn.finishRead(rb, data)
+}
 
+// finishRead performs the closing handshake, once the reader has stopped.
+func (conn *Conn) finishRead(rb *receiver, data *readManagerData) {
 	// Determine the client status code and message.
 	clientStatus := StatusDropped
 	var clientMessage string
-	if rb.header.Opcode == closeFrame {
+	if rb.header.Opcode == closeFrame && rb.connInfo == 0 {
 		body := rb.scratch[:rb.header.Length]
 		switch len(body) {
 		case 0:
 			clientStatus = StatusNotSent
 		case 1:
-			rb.failConnection(ProtocolViolation)
+			rb.violate(ViolationClosePayload)
 		default:
 			s := 256*Status(body[0]) + Status(body[1])
-			if s.clientCanSend() && utf8.Valid(body[2:]) {
+			if conn.peerCanSend(s) && utf8.Valid(body[2:]) {
 				clientStatus = s
 				clientMessage = string(body[2:])
 			} else {
-				rb.failConnection(ProtocolViolation)
+				rb.violate(ViolationClosePayload)
 			}
 		}
+		if rb.connInfo == 0 {
+			conn.peerClose.Store(&closedError{
+				status:  clientStatus,
+				message: clientMessage,
+			})
+		}
 	}
 
+	// Notify the user that no more data will be incoming.
+	conn.readErr = rb.readErr
+	close(data.toUser)
+
+	putBuffer(rb.pool, rb.scratch)
+	rb.scratch = nil
+
 	wb := <-conn.senderStore
 	if wb != nil {
 		// We haven't sent a close frame yet, so we send one now.
@@ -108,16 +189,27 @@ func (conn *Conn) readManager(data *readManagerData) {
 		close(conn.senderStore)
 
 		var closeStatus Status
+		var closeBody []byte
 		if rb.connInfo == 0 {
 			closeStatus = clientStatus
 		} else if rb.connInfo == WrongMessageType {
 			closeStatus = StatusUnsupportedType
+		} else if rb.connInfo == ControlFlood {
+			closeStatus = StatusPolicyViolation
+		} else if rb.connInfo == InvalidMessage {
+			closeStatus = StatusInvalidData
+			if errors.Is(rb.readErr, ErrTooLarge) {
+				closeStatus = StatusTooLarge
+			}
+			closeBody = closeReason(rb.readErr)
 		} else {
 			closeStatus = StatusProtocolError
 		}
 
-		// TODO(voss): what to do in case of send errors?
-		wb.sendCloseFrame(closeStatus, nil)
+		conn.localStatus = closeStatus
+		conn.localMessage = string(closeBody)
+		err := wb.sendCloseFrame(closeStatus, closeBody)
+		conn.errors.report("sending close frame", err)
 
 		if rb.connInfo == 0 {
 			rb.connInfo = ClientClosed
@@ -129,28 +221,61 @@ func (conn *Conn) readManager(data *readManagerData) {
 	// Close the TCP connection.
 	// The connection may already be closed at this point, but since we ignore
 	// errors here, this is not a problem.
-	conn.raw.Close()
+	conn.stopWatching()
+	if rb.connInfo == Detached {
+		// Detach passes the connection on to a different process.
+		conn.handoff = conn.handoffState(rb)
+	} else {
+		conn.raw.Close()
+	}
+	conn.idle.stop()
+	conn.stats.connClosed()
+	if conn.registry != nil {
+		conn.registry.remove(conn)
+	}
+	conn.releaseLimits()
 
 	conn.connInfo = rb.connInfo
+	conn.violation = rb.violation
 	conn.clientStatus = clientStatus
 	conn.clientMessage = clientMessage
+	if hooks := conn.trace.get(); hooks != nil && hooks.OnClose != nil {
+		hooks.OnClose(conn.connInfo, clientStatus, clientMessage)
+	}
 	close(data.shutdownComplete)
+
+	if conn.onDisconnect != nil {
+		conn.onDisconnect(conn, conn.connInfo, clientStatus, clientMessage)
+	}
+	if conn.onClose != nil {
+		conn.onClose(conn)
+	}
 }
 
 // Refill reads data from the connection until a data frame is available.
 // Control frames are processed as they are encountered.
 // If an error is returned, rb.connInfo is set to the appropriate value.
+//
+// If rb.pollIdle is set, and no more data is buffered after a control frame
+// has been processed, refill returns errIdle instead of waiting for the
+// next frame.
 func (rb *receiver) refill(isCont bool) error {
 	if rb.header.Opcode == closeFrame {
 		return ErrConnClosed
 	}
+	controlSeen := false
 	for {
+		if controlSeen && rb.pollIdle && !isCont && rb.r.Buffered() == 0 {
+			return errIdle
+		}
 		err := rb.readFrameHeader()
+		rb.idle.touch()
+		rb.activity.touch()
 		if err != nil {
-			if err == errFrameFormat {
-				rb.failConnection(ProtocolViolation)
+			if pe, ok := err.(*ProtocolError); ok {
+				err = rb.violate(pe.Violation)
 			} else {
-				rb.failConnection(ConnDropped)
+				rb.dropConnection(err)
 			}
 			return err
 		}
@@ -159,140 +284,253 @@ func (rb *receiver) refill(isCont bool) error {
 			if rb.header.Length > 125 {
 				// All control frames MUST have a payload length of 125 bytes or less
 				// and MUST NOT be fragmented.
-				rb.failConnection(ProtocolViolation)
-				return ErrConnClosed
+				return rb.violate(ViolationControlFrame)
+			}
+			if rb.scratch == nil {
+				rb.scratch = getBuffer(rb.pool, minPoolBufferSize)
 			}
 			_, err = io.ReadFull(rb.r, rb.scratch[:rb.header.Length])
 			if err != nil {
-				rb.failConnection(ConnDropped)
+				rb.dropConnection(err)
 				return err
 			}
 			rb.unmask(rb.scratch[:rb.header.Length])
+			if rb.controlFlood() {
+				rb.failConnection(ControlFlood)
+				return ErrConnClosed
+			}
+			if hooks := rb.trace.get(); hooks != nil && hooks.OnControlFrame != nil {
+				hooks.OnControlFrame(rb.frameInfo(), rb.scratch[:rb.header.Length])
+			}
+		} else if hooks := rb.trace.get(); hooks != nil && hooks.OnFrameRead != nil {
+			hooks.OnFrameRead(rb.frameInfo())
 		}
 
 		switch rb.header.Opcode {
 		case Text, Binary:
 			if isCont {
-				rb.failConnection(ProtocolViolation)
-				return ErrConnClosed
+				return rb.violate(ViolationContinuation)
 			}
+			rb.midMessage = !rb.header.Final
+			rb.stats.messageReceived()
+			rb.traceMessageSize()
 			return nil
 
 		case contFrame:
 			if !isCont {
-				rb.failConnection(ProtocolViolation)
-				return ErrConnClosed
+				return rb.violate(ViolationContinuation)
 			}
+			rb.midMessage = !rb.header.Final
+			rb.traceMessageSize()
 			return nil
 
 		case closeFrame:
 			return ErrConnClosed
 
 		case pingFrame:
-			// TODO(voss): can we make this less ugly?
-			// TODO(voss): what to do if there is an error sending the pong?
-			body := make([]byte, rb.header.Length)
-			copy(body, rb.scratch[:rb.header.Length])
+			// Queue the pong frame.  If the sender is available, the frame
+			// is sent immediately.  Otherwise, it is sent by whoever holds
+			// the sender, once the sender is released.
+			rb.pong.set(rb.scratch[:rb.header.Length])
 			select {
 			case wb := <-rb.senderStore:
-				// If the sender is available, send the pong frame immediately.
 				if wb != nil {
-					wb.sendFrame(pongFrame, body, true)
-					rb.senderStore <- wb
+					wb.release()
 				}
 			default:
-				// Otherwise, send the pong frame in a separate goroutine.
-				go func() {
-					wb := <-rb.senderStore
-					if wb != nil {
-						wb.sendFrame(pongFrame, body, true)
-						rb.senderStore <- wb
-					}
-				}()
+				// the sender is in use
 			}
+			if rb.onPing != nil {
+				rb.onPing(rb.scratch[:rb.header.Length])
+			}
+			rb.control.ping()
+			rb.stats.controlReceived(pingFrame, false)
 
 		case pongFrame:
-			// we don't send ping frames and we ignore pong frames
+			unsolicited, total := rb.control.pong()
+			rb.stats.controlReceived(pongFrame, unsolicited)
+			if rb.pongLimit > 0 && total > int64(rb.pongLimit) {
+				rb.failConnection(ControlFlood)
+				return ErrConnClosed
+			}
 
 		default:
-			rb.failConnection(ProtocolViolation)
-			return ErrConnClosed
+			return rb.violate(ViolationOpcode)
+		}
+
+		controlSeen = true
+		if rb.lowMemory {
+			// Only ping and pong frames get here.  Since these are rare,
+			// we don't keep the buffer around between frames.
+			putBuffer(rb.pool, rb.scratch)
+			rb.scratch = nil
 		}
 	}
 }
 
-func (rb *receiver) readFrameHeader() error {
-	b0, err := rb.r.ReadByte()
-	if err != nil {
-		return err
+// controlFlood counts an incoming ping or pong frame, and reports whether
+// the control frame limit has been exceeded.
+func (rb *receiver) controlFlood() bool {
+	if rb.ctrlLimit <= 0 || rb.header.Opcode == closeFrame {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(rb.ctrlStart) >= time.Second {
+		rb.ctrlStart = now
+		rb.ctrlCount = 0
 	}
-	b1, err := rb.r.ReadByte()
+	rb.ctrlCount++
+	return rb.ctrlCount > rb.ctrlLimit
+}
+
+// readFrameHeader reads the next frame header.  The header is only
+// consumed once it is complete, and control frames are only consumed once
+// the payload has been buffered, too.  Thus, if the read is interrupted
+// by Conn.Detach, no data is lost.
+func (rb *receiver) readFrameHeader() error {
+	buf, err := rb.r.Peek(2)
 	if err != nil {
 		return err
 	}
+	b0, b1 := buf[0], buf[1]
 
 	final := b0 & 128
-	reserved := b0 & (7 << 4)
-	if reserved != 0 {
-		return errFrameFormat
+	reserved := b0 & rsvMask
+	if reserved&^rb.rsvBits != 0 {
+		return &ProtocolError{Violation: ViolationReservedBits}
 	}
 	opcode := b0 & 15
 
+	// Frames sent by the client must be masked, frames sent by the server
+	// must not be masked.
 	mask := b1 & 128
-	if mask == 0 {
-		return errFrameFormat
+	if (mask != 0) == rb.isClient {
+		return &ProtocolError{Violation: ViolationMasking}
 	}
 
 	// read the length
 	l8 := b1 & 127
-	lengthBytes := 1
+	lengthBytes := 0
 	if l8 == 127 {
 		lengthBytes = 8
 	} else if l8 == 126 {
 		lengthBytes = 2
 	}
-	if lengthBytes > 1 {
-		n, _ := io.ReadFull(rb.r, rb.scratch[:lengthBytes])
-		if n < lengthBytes {
-			return errFrameFormat
-		}
-	} else {
-		rb.scratch[0] = l8
+	headerLength := 2 + lengthBytes
+	if mask != 0 {
+		headerLength += 4
+	}
+	buf, err = rb.r.Peek(headerLength)
+	if err == io.EOF && len(buf) < 2+lengthBytes {
+		return &ProtocolError{Violation: ViolationFrameLength}
+	} else if err != nil {
+		return err
 	}
-	var length uint64
-	for i := 0; i < lengthBytes; i++ {
-		length = length<<8 | uint64(rb.scratch[i])
+	length := uint64(l8)
+	if lengthBytes > 0 {
+		length = 0
+		for _, b := range buf[2 : 2+lengthBytes] {
+			length = length<<8 | uint64(b)
+		}
 	}
 	if length&(1<<63) != 0 {
-		return errFrameFormat
+		return &ProtocolError{Violation: ViolationFrameLength}
 	}
 
-	if opcode >= 8 && (final == 0 || length > 125) {
-		return errFrameFormat
+	if opcode >= 8 {
+		if final == 0 || length > 125 {
+			return &ProtocolError{Violation: ViolationControlFrame}
+		}
+		_, err = rb.r.Peek(headerLength + int(length))
+		if err != nil {
+			return err
+		}
 	}
 
 	rb.header.Final = final != 0
 	rb.header.Opcode = MessageType(opcode)
+	rb.header.RSV = reserved
 	rb.header.Length = int64(length)
-
-	// read the masking key
-	_, err = io.ReadFull(rb.r, rb.header.Mask[:])
-	if err != nil {
-		return err
+	if mask != 0 {
+		copy(rb.header.Mask[:], buf[2+lengthBytes:])
 	}
+	rb.r.Discard(headerLength)
 
 	rb.pos = 0
 
 	return nil
 }
 
+// resumeFrame restores the state of a frame from a HandoffState.
+func (rb *receiver) resumeFrame(f *handoffFrame) {
+	rb.header = frameHeader{
+		Length: f.Length,
+		Mask:   f.Mask,
+		Final:  f.Final,
+		Opcode: f.Opcode,
+		RSV:    f.RSV,
+	}
+	rb.pos = f.Pos
+	rb.midMessage = !f.Final
+}
+
+// traceMessageSize adds the length of the current data frame to the
+// message size, and reports the size once the final frame is reached.
+func (rb *receiver) traceMessageSize() {
+	hooks := rb.trace.get()
+	if hooks == nil || hooks.OnMessageSize == nil {
+		return
+	}
+	if rb.header.Opcode != contFrame {
+		rb.msgType = rb.header.Opcode
+		rb.msgSize = 0
+	}
+	rb.msgSize += rb.header.Length
+	if rb.header.Final {
+		hooks.OnMessageSize(Inbound, rb.msgType, rb.msgSize)
+	}
+}
+
+func (rb *receiver) frameInfo() FrameInfo {
+	return FrameInfo{
+		Opcode: rb.header.Opcode,
+		Length: rb.header.Length,
+		Final:  rb.header.Final,
+		Time:   time.Now(),
+	}
+}
+
+// unmask removes the masking from the payload data in buf, and advances
+// the read position accordingly.
 func (rb *receiver) unmask(buf []byte) {
+	if rb.isClient {
+		// frames sent by the server are not masked
+		rb.pos += int64(len(buf))
+		return
+	}
 	for i := range buf {
 		buf[i] ^= rb.header.Mask[rb.pos&3]
 		rb.pos++
 	}
 }
 
+// dropConnection terminates the reader after a network error.
+func (rb *receiver) dropConnection(err error) {
+	if rb.connInfo != 0 {
+		// The connection has already failed for a different reason.
+		return
+	}
+	if rb.detached(err) {
+		rb.failConnection(Detached)
+		return
+	}
+	if err != io.EOF {
+		// A plain EOF carries no information beyond ConnDropped.
+		rb.readErr = err
+	}
+	rb.failConnection(ConnDropped)
+}
+
 func (rb *receiver) failConnection(reason ConnInfo) {
 	if rb.shutdownStarted != nil {
 		// prevent further writes
@@ -311,9 +549,20 @@ type frameReader struct {
 
 func (fr *frameReader) Read(buf []byte) (int, error) {
 	rb := fr.rb
+	if rb.msg != nil {
+		n := copy(buf, rb.msg[rb.pos:])
+		rb.pos += int64(n)
+		rb.msgTrace.count(n)
+		if rb.pos >= rb.header.Length {
+			rb.msg = nil
+			return n, io.EOF
+		}
+		return n, nil
+	}
 	for rb.pos >= rb.header.Length && !rb.header.Final {
 		err := rb.refill(true)
 		if err != nil {
+			rb.msgTrace.fail(err)
 			return 0, err
 		}
 	}
@@ -325,8 +574,12 @@ func (fr *frameReader) Read(buf []byte) (int, error) {
 	}
 	n, err := rb.r.Read(buf[:amount])
 	rb.unmask(buf[:n])
+	rb.idle.touch()
+	rb.activity.touch()
+	rb.msgTrace.count(n)
 	if err != nil {
-		rb.failConnection(ConnDropped)
+		rb.dropConnection(err)
+		rb.msgTrace.fail(err)
 		return n, err
 	}
 
@@ -358,13 +611,80 @@ func (fr *frameReader) ReadAll(buf []byte) (int, error) {
 	}
 	if k > 0 {
 		err = ErrTooLarge
+		fr.rb.msgTrace.fail(err)
 	}
 	return n, err
 }
 
+// readAlloc reads a complete message from the frameReader into a newly
+// allocated buffer.  The length of the current frame is used to choose the
+// buffer size, so that unfragmented messages need only one allocation.
+// For fragmented messages, the buffer grows as needed.  If the message is
+// longer than maxSize bytes, the first maxSize bytes are returned together
+// with ErrTooLarge, and the rest of the message is discarded.
+func (fr *frameReader) readAlloc(maxSize int) ([]byte, error) {
+	rb := fr.rb
+	buf := make([]byte, 0, fr.sizeHint(0, maxSize))
+	for {
+		if len(buf) == cap(buf) {
+			if rb.header.Final && rb.pos >= rb.header.Length {
+				return buf, nil
+			}
+			if len(buf) >= maxSize {
+				k, err := io.Copy(io.Discard, fr)
+				if err == nil && k > 0 {
+					err = ErrTooLarge
+					rb.msgTrace.fail(err)
+				}
+				return buf, err
+			}
+			newBuf := make([]byte, len(buf), fr.sizeHint(len(buf), maxSize))
+			copy(newBuf, buf)
+			buf = newBuf
+		}
+
+		n, err := fr.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		} else if err != nil {
+			return buf, err
+		}
+	}
+}
+
+// sizeHint returns the capacity to use for a message buffer which
+// currently holds n bytes.
+func (fr *frameReader) sizeHint(n, maxSize int) int {
+	rb := fr.rb
+	size := int64(n) + rb.header.Length - rb.pos
+	if !rb.header.Final {
+		// more frames will follow
+		if grow := int64(2*n + 512); size < grow {
+			size = grow
+		}
+	}
+	if size > int64(maxSize) {
+		size = int64(maxSize)
+	}
+	return int(size)
+}
+
 type autoCloseReader struct {
-	fr  *frameReader
-	err error
+	fr   *frameReader
+	size int64 // total message length, or -1 if the message is fragmented
+	err  error
+}
+
+func newAutoCloseReader(rb *receiver, fromUser chan<- *receiver) *autoCloseReader {
+	size := int64(-1)
+	if rb.header.Final {
+		size = rb.header.Length - rb.pos
+	}
+	return &autoCloseReader{
+		fr:   &frameReader{rb: rb, fromUser: fromUser},
+		size: size,
+	}
 }
 
 func (ac *autoCloseReader) Read(buf []byte) (int, error) {
@@ -381,32 +701,141 @@ func (ac *autoCloseReader) Read(buf []byte) (int, error) {
 	return n, err
 }
 
+// Remaining returns the number of bytes left in the current frame.  If
+// Final returns false, more fragments follow after these bytes.
+func (ac *autoCloseReader) Remaining() int64 {
+	if ac.err != nil {
+		return 0
+	}
+	rb := ac.fr.rb
+	return rb.header.Length - rb.pos
+}
+
+// Final reports whether the current frame is the last fragment of the
+// message.  If Final returns true, Remaining gives the number of bytes
+// left in the message.
+func (ac *autoCloseReader) Final() bool {
+	if ac.err != nil {
+		return true
+	}
+	return ac.fr.rb.header.Final
+}
+
+// Size returns the total length of the message in bytes, as announced in
+// the header of the first frame.  If the message is fragmented, the total
+// length is not known in advance and -1 is returned.  The value does not
+// change while the message is read.
+func (ac *autoCloseReader) Size() int64 {
+	return ac.size
+}
+
+// RSV returns the reserved bits of the current frame.  These can only be
+// non-zero if the bits were enabled using an RSVExtension.
+func (ac *autoCloseReader) RSV() byte {
+	if ac.err != nil {
+		return 0
+	}
+	return ac.fr.rb.header.RSV
+}
+
+// MessageReader is implemented by the io.Reader returned by ReceiveMessage
+// and ReceiveOneMessage.  The methods can be used to pre-size buffers, to
+// reject oversized messages before reading them, and to detect unfragmented
+// messages.
+type MessageReader interface {
+	io.Reader
+
+	// Remaining returns the number of bytes left in the current frame.
+	Remaining() int64
+
+	// Final reports whether the current frame is the last fragment of
+	// the message.
+	Final() bool
+
+	// Size returns the total length of the message, or -1 if the message
+	// is fragmented.
+	Size() int64
+
+	// RSV returns the reserved bits of the current frame, see
+	// RSVExtension.
+	RSV() byte
+}
+
 // ReceiveMessage returns an io.Reader which can be used to read the next
 // message from the connection.  The first return value gives the message type
-// received (Text or Binary).
+// received (Text or Binary).  The reader implements MessageReader; for
+// unfragmented messages, its Size method gives the message length before
+// any data is read.
 //
 // No more messages can be received until the returned io.Reader has been
 // drained.  In order to avoid deadlocks, the reader must always read the
-// complete message.
+// complete message.  The reader can be passed to a streaming decoder, such
+// as json.Decoder or xml.Decoder; use FinishMessage to consume the rest of
+// the message after decoding.
+//
+// If Handler.AutoDrain or Dialer.AutoDrain is set, the next call to one of
+// the Receive functions discards the unread part of the message instead.
 func (conn *Conn) ReceiveMessage() (MessageType, io.Reader, error) {
-	b, ok := <-conn.toUser
+	b, ok := conn.nextReceiver()
 	if !ok {
-		return 0, nil, ErrConnClosed
+		return 0, nil, conn.closedErr()
 	}
 
-	fr := &frameReader{rb: b, fromUser: conn.fromUser}
-	ac := &autoCloseReader{fr: fr}
+	ac := newAutoCloseReader(b, conn.fromUser)
+	conn.setUnread(ac)
 
 	return b.header.Opcode, ac, nil
 }
 
+// nextReceiver waits for the next message to arrive.  If auto-draining is
+// enabled, the rest of a message left unread by the caller is discarded
+// first.
+func (conn *Conn) nextReceiver() (*receiver, bool) {
+	conn.drainUnread()
+	conn.endMessage()
+	rb, ok := <-conn.toUser
+	if ok {
+		conn.startMessage(rb)
+	}
+	return rb, ok
+}
+
+// setUnread records the reader returned to the caller, so that a later
+// call to drainUnread can discard the rest of the message.
+func (conn *Conn) setUnread(ac *autoCloseReader) {
+	if !conn.autoDrain {
+		return
+	}
+	conn.unreadMu.Lock()
+	conn.unread = ac
+	conn.unreadMu.Unlock()
+}
+
+// drainUnread discards the remainder of the message most recently returned
+// by ReceiveMessage or ReceiveOneMessage.  This only has an effect if
+// auto-draining is enabled.
+func (conn *Conn) drainUnread() {
+	if !conn.autoDrain {
+		return
+	}
+	conn.unreadMu.Lock()
+	ac := conn.unread
+	conn.unread = nil
+	conn.unreadMu.Unlock()
+	if ac != nil {
+		io.Copy(io.Discard, ac)
+	}
+}
+
 // ReceiveOneMessage listens on all given connections until a new message
 // arrives.  The function returns the index of the connection, the message type,
-// and a reader which can be used to read the message contents.
+// and a reader which can be used to read the message contents.  The reader
+// implements MessageReader.
 //
 // No more messages can be received on this connection until the returned
 // io.Reader has been drained.  In order to avoid deadlocks, the caller must
-// always read the complete message.
+// always read the complete message, unless auto-draining is enabled for the
+// connection, see ReceiveMessage.
 //
 // If the context expires or is cancelled, the error is either
 // context.DeadlineExceeded or context.Cancelled.
@@ -418,12 +847,79 @@ func ReceiveOneMessage(ctx context.Context, clients []*Conn) (int, MessageType,
 		return -1, 0, nil, err
 	}
 
-	fr := &frameReader{rb: rb, fromUser: clients[idx].fromUser}
-	ac := &autoCloseReader{fr: fr}
+	ac := newAutoCloseReader(rb, clients[idx].fromUser)
+	clients[idx].setUnread(ac)
 
 	return idx, rb.header.Opcode, ac, nil
 }
 
+// Message is a message returned by Conn.Receive.
+type Message struct {
+	Type MessageType // Text or Binary
+	Data []byte
+}
+
+// DefaultReadLimit is the default value for Handler.ReadLimit.
+const DefaultReadLimit = 1 << 20
+
+// SetReadLimit changes the maximal length of messages returned by Receive,
+// for messages which arrive after the call.  If limit is zero,
+// DefaultReadLimit is used.  The initial value is given by
+// Handler.ReadLimit or Dialer.ReadLimit.
+func (conn *Conn) SetReadLimit(limit int) {
+	atomic.StoreInt64(&conn.readLimit, int64(limit))
+}
+
+// receiveLimit returns the maximal length of messages returned by Receive.
+func (conn *Conn) receiveLimit() int {
+	limit := atomic.LoadInt64(&conn.readLimit)
+	if limit <= 0 {
+		return DefaultReadLimit
+	}
+	return int(limit)
+}
+
+// Receive reads the next message from the connection, which can be either
+// a text or a binary message.  Text messages are checked to be valid
+// utf-8.
+//
+// If the message is longer than the read limit (see Handler.ReadLimit),
+// the first part of the message is returned together with [ErrTooLarge].
+// The rest of the message is discarded, the connection stays functional.
+//
+// If ctx is done before a message arrives, ctx.Err() is returned.
+func (conn *Conn) Receive(ctx context.Context) (Message, error) {
+	conn.drainUnread()
+	conn.endMessage()
+	var rb *receiver
+	var ok bool
+	select {
+	case rb, ok = <-conn.toUser:
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+	if !ok {
+		return Message{}, conn.closedErr()
+	}
+	conn.startMessage(rb)
+	defer func() { conn.fromUser <- rb }()
+
+	tp := rb.header.Opcode
+	r := &frameReader{rb: rb, fromUser: conn.fromUser}
+	buf, err := r.readAlloc(conn.receiveLimit())
+	if err != nil && err != ErrTooLarge {
+		return Message{}, err
+	}
+	if tp == Text {
+		n, ok := validUTF8Prefix(buf, err == ErrTooLarge)
+		if !ok {
+			return Message{}, rb.violate(ViolationUTF8)
+		}
+		buf = buf[:n]
+	}
+	return Message{Type: tp, Data: buf}, err
+}
+
 // ReceiveBinary reads a binary message from the connection.  If the next
 // received message is not binary, the channel is closed with status
 // StatusProtocolError and [ErrConnClosed] is returned.
@@ -432,9 +928,9 @@ func ReceiveOneMessage(ctx context.Context, clients []*Conn) (int, MessageType,
 // the message and [ErrTooLarge] is returned.  The rest of the message is
 // discarded, the connection stays functional.
 func (conn *Conn) ReceiveBinary(buf []byte) (int, error) {
-	b, ok := <-conn.toUser
+	b, ok := conn.nextReceiver()
 	if !ok {
-		return 0, ErrConnClosed
+		return 0, conn.closedErr()
 	}
 	return conn.doReceiveBinary(buf, b)
 }
@@ -470,11 +966,42 @@ func (conn *Conn) doReceiveBinary(buf []byte, rb *receiver) (int, error) {
 	r := &frameReader{rb: rb, fromUser: conn.fromUser}
 	n, err := r.ReadAll(buf)
 	if err != nil && err != ErrTooLarge {
-		rb.failConnection(ConnDropped)
+		rb.dropConnection(err)
 	}
 	return n, err
 }
 
+// ReceiveBinaryAlloc reads a binary message from the connection, and
+// returns the message in a newly allocated buffer.  In contrast to
+// ReceiveBinary, the caller does not need to choose a buffer size in
+// advance: only as much memory as the message needs is allocated.  If the
+// next received message is not binary, the channel is closed with status
+// StatusProtocolError and [ErrConnClosed] is returned.
+//
+// If the message is longer than maxSize bytes, the first maxSize bytes of
+// the message are returned together with [ErrTooLarge].  The rest of the
+// message is discarded, the connection stays functional.
+func (conn *Conn) ReceiveBinaryAlloc(maxSize int) ([]byte, error) {
+	rb, ok := conn.nextReceiver()
+	if !ok {
+		return nil, conn.closedErr()
+	}
+	defer func() { conn.fromUser <- rb }()
+
+	if rb.header.Opcode != Binary {
+		rb.failConnection(WrongMessageType)
+		return nil, ErrConnClosed
+	}
+
+	r := &frameReader{rb: rb, fromUser: conn.fromUser}
+	buf, err := r.readAlloc(maxSize)
+	if err != nil && err != ErrTooLarge {
+		rb.dropConnection(err)
+		return nil, err
+	}
+	return buf, err
+}
+
 // ReceiveText reads a text message from the connection.  If the next received
 // message is not a text message, the channel is closed with status
 // StatusProtocolError and [ErrConnClosed] is returned.
@@ -483,9 +1010,9 @@ func (conn *Conn) doReceiveBinary(buf []byte, rb *receiver) (int, error) {
 // bytes, the text is truncated and ErrTooLarge is returned. The rest of the
 // message is discarded, the connection stays functional.
 func (conn *Conn) ReceiveText(maxLength int) (string, error) {
-	b, ok := <-conn.toUser
+	b, ok := conn.nextReceiver()
 	if !ok {
-		return "", ErrConnClosed
+		return "", conn.closedErr()
 	}
 	return conn.doReceiveText(maxLength, b)
 }
@@ -518,53 +1045,127 @@ func (conn *Conn) doReceiveText(maxLength int, rb *receiver) (string, error) {
 		return "", ErrConnClosed
 	}
 
-	if rb.header.Final && rb.header.Length <= int64(maxLength) {
-		maxLength = int(rb.header.Length)
+	// The buffer grows as needed, so that a generous maxLength does not
+	// cause large allocations for short messages.
+	r := &frameReader{rb: rb, fromUser: conn.fromUser}
+	buf, err := r.readAlloc(maxLength)
+	if err != nil && err != ErrTooLarge {
+		return "", err
+	}
+
+	n, ok := validUTF8Prefix(buf, err == ErrTooLarge)
+	if !ok {
+		return "", rb.violate(ViolationUTF8)
+	}
+	return string(buf[:n]), err
+}
+
+// ReceiveTextInto reads a text message from the connection into buf, and
+// returns the length of the message in bytes.  In contrast to ReceiveText,
+// this does not allocate memory, and buf can be re-used for the next message.
+//
+// If the next received message is not a text message, the channel is closed
+// with status StatusProtocolError and [ErrConnClosed] is returned.
+//
+// If the message is longer than buf, the buffer contains the start of the
+// message, truncated to a whole number of utf-8 encoded runes, and
+// [ErrTooLarge] is returned.  The rest of the message is discarded, the
+// connection stays functional.
+func (conn *Conn) ReceiveTextInto(buf []byte) (int, error) {
+	rb, ok := conn.nextReceiver()
+	if !ok {
+		return 0, conn.closedErr()
+	}
+	defer func() { conn.fromUser <- rb }()
+
+	return conn.readText(buf, rb)
+}
+
+// readText reads a text message into buf and checks that the message
+// is valid utf-8.
+func (conn *Conn) readText(buf []byte, rb *receiver) (int, error) {
+	if rb.header.Opcode != Text {
+		rb.failConnection(WrongMessageType)
+		return 0, ErrConnClosed
 	}
-	buf := make([]byte, maxLength)
 
 	r := &frameReader{rb: rb, fromUser: conn.fromUser}
 	n, err := r.ReadAll(buf)
 	if err != nil && err != ErrTooLarge {
-		return "", err
+		return 0, err
 	}
 
-	// check for incomplete/invalid utf-8
+	n, ok := validUTF8Prefix(buf[:n], err == ErrTooLarge)
+	if !ok {
+		return 0, rb.violate(ViolationUTF8)
+	}
+	return n, err
+}
+
+// validUTF8Prefix checks that buf contains valid utf-8, and returns the
+// length of the valid data.  If truncated is set, buf contains only the
+// start of the message, and an incomplete rune at the end of buf is
+// removed.
+func validUTF8Prefix(buf []byte, truncated bool) (int, bool) {
+	n := len(buf)
 	idx := 0
 	for idx < n {
 		r, size := utf8.DecodeRune(buf[idx:n])
-		if r == utf8.RuneError {
-			if err == ErrTooLarge && idx > n-utf8.UTFMax && utf8.RuneStart(buf[idx]) {
+		if r == utf8.RuneError && size <= 1 {
+			if truncated && idx > n-utf8.UTFMax && utf8.RuneStart(buf[idx]) {
 				// the last rune might be incomplete
-				n = idx
-				break
+				return idx, true
 			}
-
-			rb.connInfo = ProtocolViolation
-			return "", ErrConnClosed
+			return 0, false
 		}
 		idx += size
 	}
-
-	return string(buf[:n]), err
+	return n, true
 }
 
 func selectChannel(ctx context.Context, clients []*Conn) (int, *receiver, error) {
+	sel, err := selectAny(ctx, clients, nil)
+	return sel.conn, sel.rb, err
+}
+
+// selected describes the outcome of selectAny.  Exactly one of conn and
+// ch is non-negative.
+type selected struct {
+	conn int
+	rb   *receiver
+
+	ch    int
+	value reflect.Value
+	ok    bool
+}
+
+// selectAny waits until a message arrives on one of the clients, or until
+// a value can be received from one of the channels in chans.
+func selectAny(ctx context.Context, clients []*Conn, chans []interface{}) (selected, error) {
 	numClients := len(clients)
-	if numClients > 65535 {
+	numCases := numClients + len(chans)
+	if numCases > 65535 {
 		// select supports at most 65536 cases, and we need one for the context
 		panic("too many clients")
 	}
 
 	// set up channels for the select statement
-	cases := make([]reflect.SelectCase, numClients+1)
+	cases := make([]reflect.SelectCase, numCases+1)
 	for i, conn := range clients {
+		conn.drainUnread()
+		conn.endMessage()
 		cases[i] = reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(conn.toUser),
 		}
 	}
-	cases[numClients] = reflect.SelectCase{
+	for i, ch := range chans {
+		cases[numClients+i] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ch),
+		}
+	}
+	cases[numCases] = reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(ctx.Done()),
 	}
@@ -573,22 +1174,149 @@ func selectChannel(ctx context.Context, clients []*Conn) (int, *receiver, error)
 	for {
 		idx, recv, recvOK := reflect.Select(cases)
 
-		if idx == numClients {
+		if idx == numCases {
 			// the context was cancelled
-			return -1, nil, ctx.Err()
+			return selected{conn: -1, ch: -1}, ctx.Err()
+		}
+
+		if idx >= numClients {
+			return selected{conn: -1, ch: idx - numClients, value: recv, ok: recvOK}, nil
 		}
 
 		if !recvOK {
 			// the connection was closed
 			numClosed++
-			if numClosed == numClients {
-				return -1, nil, ErrConnClosed
+			if numClosed == numClients && len(chans) == 0 {
+				return selected{conn: -1, ch: -1}, ErrConnClosed
 			}
 			cases[idx].Chan = reflect.ValueOf((<-chan *receiver)(nil))
 			continue
 		}
 
 		rb := recv.Interface().(*receiver)
-		return idx, rb, nil
+		clients[idx].startMessage(rb)
+		return selected{conn: idx, rb: rb, ch: -1}, nil
+	}
+}
+
+// SelectResult describes the outcome of Select.
+type SelectResult struct {
+	// Conn is the index of the connection on which a message was
+	// received, or -1 if one of the extra channels became ready.
+	Conn int
+
+	// Type and Reader give the message type and the message contents, if
+	// Conn is non-negative.  The reader implements MessageReader.
+	Type   MessageType
+	Reader io.Reader
+
+	// Chan is the index of the extra channel which became ready, or -1 if
+	// a message was received.  Value is the value received from the
+	// channel, and OK is false if the value was received because the
+	// channel was closed.
+	Chan  int
+	Value interface{}
+	OK    bool
+}
+
+// Select is like ReceiveOneMessage, but in addition to the connections
+// listens on the given extra channels.  Each element of chans must be a
+// channel which can be received from, for example a shutdown or a
+// notification channel.  This allows to change the set of connections
+// without cancelling the context.
+//
+// As for ReceiveOneMessage, no more messages can be received on the
+// connection until the returned reader has been drained.  Once all
+// connections are closed, Select keeps waiting on the extra channels.
+//
+// If the context expires or is cancelled, the error is either
+// context.DeadlineExceeded or context.Cancelled.
+//
+// Select panics if an element of chans is not a channel, or if more than
+// 65535 connections and channels are given.
+func Select(ctx context.Context, clients []*Conn, chans ...interface{}) (SelectResult, error) {
+	sel, err := selectAny(ctx, clients, chans)
+	if err != nil {
+		return SelectResult{Conn: -1, Chan: -1}, err
+	}
+	if sel.ch >= 0 {
+		res := SelectResult{Conn: -1, Chan: sel.ch, OK: sel.ok}
+		if sel.ok {
+			res.Value = sel.value.Interface()
+		}
+		return res, nil
+	}
+
+	conn := clients[sel.conn]
+	ac := newAutoCloseReader(sel.rb, conn.fromUser)
+	conn.setUnread(ac)
+	return SelectResult{
+		Conn:   sel.conn,
+		Type:   sel.rb.header.Opcode,
+		Reader: ac,
+		Chan:   -1,
+	}, nil
+}
+
+// StreamReader reads the concatenated contents of all messages of one type
+// received on a connection, as a single byte stream.  Message boundaries are
+// not visible to the reader.  Once the connection is closed, Read returns
+// io.EOF.  If a message of a different type is received, the connection is
+// closed with status StatusUnsupportedType and Read returns ErrMessageType.
+//
+// A StreamReader can be used concurrently from different goroutines.
+type StreamReader struct {
+	conn *Conn
+	tp   MessageType
+
+	mu  sync.Mutex
+	r   io.Reader // the current message, or nil
+	err error
+}
+
+// NewStreamReader returns a new StreamReader which reads the contents of
+// messages of type tp from conn.
+func NewStreamReader(conn *Conn, tp MessageType) *StreamReader {
+	return &StreamReader{conn: conn, tp: tp}
+}
+
+// Read reads data from the connection.  Read only returns zero bytes if
+// buf is empty or if an error occurs.
+func (r *StreamReader) Read(buf []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.err == nil {
+		if r.r == nil {
+			tp, msg, err := r.conn.ReceiveMessage()
+			if errors.Is(err, ErrConnClosed) {
+				r.err = io.EOF
+				break
+			} else if err != nil {
+				r.err = err
+				break
+			}
+			if tp != r.tp {
+				io.Copy(io.Discard, msg)
+				r.conn.Close(StatusUnsupportedType, "")
+				r.err = ErrMessageType
+				break
+			}
+			r.r = msg
+		}
+
+		n, err := r.r.Read(buf)
+		if err == io.EOF {
+			r.r = nil
+			err = nil
+			if n == 0 && len(buf) > 0 {
+				continue
+			}
+		} else if err != nil {
+			r.r = nil
+			r.err = err
+		}
+		return n, err
 	}
+	return 0, r.err
 }
@@ -18,8 +18,14 @@ package websocket
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"runtime"
+	"strconv"
 	"testing"
+	"time"
 
 	"go.uber.org/goleak"
 )
@@ -49,7 +55,7 @@ func TestReceiveBinary(t *testing.T) {
 		}
 
 		n, err = conn.ReceiveBinary(buf)
-		if err != ErrConnClosed || n != 0 {
+		if !errors.Is(err, ErrConnClosed) || n != 0 {
 			errorsInServer <- fmt.Sprintf("not properly closed: buf=[% x], err=%s", buf[:n], err)
 		}
 
@@ -186,7 +192,7 @@ func TestReceivePartial(t *testing.T) {
 		n, err = r.Read(buf)
 		if err == nil {
 			errorsInServer <- fmt.Sprintf("Read: expected error, got %d bytes", n)
-		} else if err != ErrConnClosed {
+		} else if !errors.Is(err, ErrConnClosed) {
 			errorsInServer <- "Read: unexpected error" + err.Error()
 		}
 		if n != 0 {
@@ -257,7 +263,7 @@ func TestReceiveWrongType(t *testing.T) {
 		buf := make([]byte, 128)
 
 		n, err := conn.ReceiveBinary(buf)
-		if err != ErrConnClosed || n != 0 {
+		if !errors.Is(err, ErrConnClosed) || n != 0 {
 			errorsInServer <- fmt.Sprintf("wrong type: buf=[% x], err=%s", buf[:n], err)
 		}
 
@@ -315,9 +321,13 @@ func TestInvalidCont(t *testing.T) {
 		}
 
 		s, err = conn.ReceiveText(128)
-		if err != ErrConnClosed || s != "" {
+		if !errors.Is(err, ErrConnClosed) || s != "" {
 			errorsInServer <- fmt.Sprintf("ReceiveText: %q, %s", s, err)
 		}
+		var pe *ProtocolError
+		if !errors.As(err, &pe) || pe.Violation != ViolationContinuation {
+			errorsInServer <- fmt.Sprintf("wrong error %s", err)
+		}
 
 		err = conn.Close(StatusOK, "OK")
 		if err != ErrConnClosed {
@@ -392,7 +402,7 @@ func TestTooLong(t *testing.T) {
 		status := StatusOK
 		for {
 			n, err := conn.ReceiveBinary(buf)
-			if err == ErrConnClosed {
+			if errors.Is(err, ErrConnClosed) {
 				return
 			} else if err != ErrTooLarge {
 				serverError = "errTooLarge not reported"
@@ -436,3 +446,589 @@ func TestTooLong(t *testing.T) {
 		t.Error(serverError)
 	}
 }
+
+// TestPingWhileSending tests that pings received while the sender is in use
+// are answered once the sender becomes available, without starting
+// additional goroutines.
+func TestPingWhileSending(t *testing.T) {
+	const numPings = 1000
+
+	ready := make(chan struct{})
+	pingsSent := make(chan struct{})
+	errorsInServer := make(chan string, 10)
+	handler := func(conn *Conn) {
+		defer close(errorsInServer)
+
+		w, err := conn.SendMessage(Text)
+		if err != nil {
+			errorsInServer <- "SendMessage: " + err.Error()
+			return
+		}
+		before := runtime.NumGoroutine()
+		close(ready)
+		<-pingsSent
+		time.Sleep(50 * time.Millisecond)
+		if after := runtime.NumGoroutine(); after > before {
+			errorsInServer <- fmt.Sprintf("%d goroutines started", after-before)
+		}
+
+		w.Write([]byte("hello"))
+		err = w.Close()
+		if err != nil {
+			errorsInServer <- "Close: " + err.Error()
+		}
+	}
+
+	server, err := StartTestServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	<-ready
+	for i := 0; i < numPings; i++ {
+		err = client.SendFrame(pingFrame, []byte(strconv.Itoa(i)), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(pingsSent)
+
+	lastPong := ""
+	text := ""
+	for lastPong != strconv.Itoa(numPings-1) || text != "hello" {
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch tp {
+		case pongFrame:
+			lastPong = string(body)
+		case Text, contFrame:
+			text += string(body)
+		default:
+			t.Fatalf("unexpected %s frame", tp)
+		}
+	}
+
+	for err := range errorsInServer {
+		t.Error("server: " + err)
+	}
+}
+
+func TestReceiveTextInto(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	errorsInServer := make(chan string, 10)
+	handler := func(conn *Conn) {
+		// server code
+		buf := make([]byte, 8)
+
+		n, err := conn.ReceiveTextInto(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			errorsInServer <- fmt.Sprintf("read 1 failed: %q, err=%s", buf[:n], err)
+		}
+
+		// The message is too long, and the last rune must not be split.
+		n, err = conn.ReceiveTextInto(buf)
+		if err != ErrTooLarge || string(buf[:n]) != "1234567" {
+			errorsInServer <- fmt.Sprintf("read 2 failed: %q, err=%s", buf[:n], err)
+		}
+
+		n, err = conn.ReceiveTextInto(buf)
+		if err != nil || string(buf[:n]) != "�" {
+			errorsInServer <- fmt.Sprintf("read 3 failed: %q, err=%s", buf[:n], err)
+		}
+
+		n, err = conn.ReceiveTextInto(buf)
+		if !errors.Is(err, ErrConnClosed) || n != 0 {
+			errorsInServer <- fmt.Sprintf("not properly closed: %q, err=%s", buf[:n], err)
+		}
+
+		close(errorsInServer)
+	}
+
+	server, err := StartTestServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// fake client
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"hello", "1234567ä", "�"} {
+		err = client.SendFrame(Text, []byte(msg), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	for err := range errorsInServer {
+		t.Error("server: " + err)
+	}
+}
+
+func TestReceiveBinaryAlloc(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	long := bytes.Repeat([]byte("0123456789"), 100)
+
+	errorsInServer := make(chan string, 10)
+	handler := func(conn *Conn) {
+		// server code
+		buf, err := conn.ReceiveBinaryAlloc(2000)
+		if err != nil || !bytes.Equal(buf, long) || cap(buf) != len(long) {
+			errorsInServer <- fmt.Sprintf("read 1 failed: len=%d, cap=%d, err=%s", len(buf), cap(buf), err)
+		}
+
+		// a fragmented message
+		buf, err = conn.ReceiveBinaryAlloc(2000)
+		if err != nil || !bytes.Equal(buf, long) {
+			errorsInServer <- fmt.Sprintf("read 2 failed: len=%d, err=%s", len(buf), err)
+		}
+
+		buf, err = conn.ReceiveBinaryAlloc(2000)
+		if err != nil || len(buf) != 0 {
+			errorsInServer <- fmt.Sprintf("read 3 failed: %q, err=%s", buf, err)
+		}
+
+		buf, err = conn.ReceiveBinaryAlloc(15)
+		if err != ErrTooLarge || !bytes.Equal(buf, long[:15]) {
+			errorsInServer <- fmt.Sprintf("read 4 failed: %q, err=%s", buf, err)
+		}
+
+		buf, err = conn.ReceiveBinaryAlloc(2000)
+		if err != nil || string(buf) != "ok" {
+			errorsInServer <- fmt.Sprintf("read 5 failed: %q, err=%s", buf, err)
+		}
+
+		buf, err = conn.ReceiveBinaryAlloc(2000)
+		if !errors.Is(err, ErrConnClosed) || buf != nil {
+			errorsInServer <- fmt.Sprintf("not properly closed: %q, err=%s", buf, err)
+		}
+
+		close(errorsInServer)
+	}
+
+	server, err := StartTestServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// fake client
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []struct {
+		op    MessageType
+		body  []byte
+		final bool
+	}{
+		{Binary, long, true},
+		{Binary, long[:10], false},
+		{contFrame, long[10:600], false},
+		{contFrame, nil, false},
+		{contFrame, long[600:], true},
+		{Binary, nil, true},
+		{Binary, long[:10], false},
+		{contFrame, long[10:], true},
+		{Binary, []byte("ok"), true},
+	}
+	for _, f := range frames {
+		err = client.SendFrame(f.op, f.body, f.final)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	for err := range errorsInServer {
+		t.Error("server: " + err)
+	}
+}
+
+// TestReceiveTextGrow checks that ReceiveText does not allocate maxLength
+// bytes for short, fragmented messages.
+func TestReceiveTextGrow(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	errorsInServer := make(chan string, 10)
+	handler := func(conn *Conn) {
+		// server code
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		msg, err := conn.ReceiveText(1 << 30)
+		runtime.ReadMemStats(&after)
+		if err != nil || msg != "hello" {
+			errorsInServer <- fmt.Sprintf("read 1 failed: %q, err=%s", msg, err)
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			errorsInServer <- fmt.Sprintf("read 1 allocated %d bytes", alloc)
+		}
+
+		// The message is too long, and the last rune must not be split.
+		msg, err = conn.ReceiveText(8)
+		if err != ErrTooLarge || msg != "1234567" {
+			errorsInServer <- fmt.Sprintf("read 2 failed: %q, err=%s", msg, err)
+		}
+
+		close(errorsInServer)
+	}
+
+	server, err := StartTestServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// fake client
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []struct {
+		op    MessageType
+		body  string
+		final bool
+	}{
+		{Text, "he", false},
+		{contFrame, "llo", true},
+		{Text, "12345", false},
+		{contFrame, "67ä", true},
+	}
+	for _, f := range frames {
+		err = client.SendFrame(f.op, []byte(f.body), f.final)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	for err := range errorsInServer {
+		t.Error("server: " + err)
+	}
+}
+
+// TestReceiveTextReplacementChar checks that a literal U+FFFD in a text
+// message is accepted by ReceiveText, while invalid utf-8 is rejected.
+func TestReceiveTextReplacementChar(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	errorsInServer := make(chan string, 10)
+	handler := func(conn *Conn) {
+		msg, err := conn.ReceiveText(100)
+		if err != nil || msg != "a\uFFFDb" {
+			errorsInServer <- fmt.Sprintf("read 1 failed: %q, err=%s", msg, err)
+		}
+
+		msg, err = conn.ReceiveText(100)
+		if !errors.Is(err, ErrConnClosed) {
+			errorsInServer <- fmt.Sprintf("invalid utf-8 accepted: %q, err=%s", msg, err)
+		}
+		conn.Wait()
+		if v := conn.Violation(); v != ViolationUTF8 {
+			errorsInServer <- fmt.Sprintf("wrong violation %s", v)
+		}
+
+		close(errorsInServer)
+	}
+
+	server, err := StartTestServer(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"a\uFFFDb", "a\xffb"} {
+		err = client.SendFrame(Text, []byte(msg), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for err := range errorsInServer {
+		t.Error("server: " + err)
+	}
+
+	err = client.Close()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamReader(t *testing.T) {
+	type result struct {
+		data string
+		err  error
+	}
+	res := make(chan result, 1)
+	server, err := StartTestServer(func(conn *Conn) {
+		data, err := io.ReadAll(NewStreamReader(conn, Text))
+		res <- result{string(data), err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, part := range []string{"ab", "", "cd"} {
+		err = client.SendFrame(Text, []byte(part), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = client.SendFrame(Binary, []byte("ef"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-res
+	if r.data != "abcd" || r.err != ErrMessageType {
+		t.Errorf("got %q, %v", r.data, r.err)
+	}
+	tp, body, err := client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != closeFrame || len(body) < 2 || Status(body[0])<<8|Status(body[1]) != StatusUnsupportedType {
+		t.Errorf("expected close frame, got %s %q", tp, body)
+	}
+}
+
+func TestMessageReader(t *testing.T) {
+	server, client := Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := client.SendBinary([]byte("0123456789"))
+		if err != nil {
+			done <- err
+			return
+		}
+		w, err := client.SendMessage(Binary)
+		if err != nil {
+			done <- err
+			return
+		}
+		w.Write([]byte("abc"))
+		w.Flush()
+		w.Write([]byte("de"))
+		done <- w.Close()
+	}()
+
+	// an unfragmented message
+	_, r, err := server.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := r.(MessageReader)
+	if mr.Remaining() != 10 || !mr.Final() || mr.Size() != 10 {
+		t.Errorf("wrong state %d %t %d", mr.Remaining(), mr.Final(), mr.Size())
+	}
+	buf := make([]byte, 4)
+	io.ReadFull(mr, buf)
+	if mr.Remaining() != 6 || mr.Size() != 10 {
+		t.Errorf("wrong remaining length %d", mr.Remaining())
+	}
+	io.Copy(io.Discard, mr)
+	if mr.Remaining() != 0 || !mr.Final() {
+		t.Errorf("wrong state after EOF %d %t", mr.Remaining(), mr.Final())
+	}
+
+	// a fragmented message
+	_, r, err = server.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr = r.(MessageReader)
+	if mr.Remaining() != 3 || mr.Final() || mr.Size() != -1 {
+		t.Errorf("wrong state %d %t %d", mr.Remaining(), mr.Final(), mr.Size())
+	}
+	body, err := io.ReadAll(mr)
+	if err != nil || string(body) != "abcde" {
+		t.Errorf("wrong body %q, err=%v", body, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	client.Close(StatusOK, "")
+	server.Wait()
+	client.Wait()
+}
+
+func TestAutoDrain(t *testing.T) {
+	result := make(chan string, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		AutoDrain: true,
+		Handle: func(conn *Conn) {
+			defer conn.Close(StatusOK, "")
+
+			_, r, err := conn.ReceiveMessage()
+			if err != nil {
+				result <- err.Error()
+				return
+			}
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(r, buf)
+			if err != nil {
+				result <- err.Error()
+				return
+			}
+
+			// The rest of the first message is discarded.
+			text, err := conn.ReceiveText(100)
+			if err != nil {
+				result <- err.Error()
+				return
+			}
+			result <- string(buf) + " " + text
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.SendFrame(Text, []byte("one two"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SendFrame(Text, []byte("three"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := <-result; res != "one three" {
+		t.Errorf("wrong result %q", res)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	server, client := Pipe()
+	server.readLimit = 5
+
+	done := make(chan error, 1)
+	go func() {
+		for _, msg := range []string{"text", "bin", "long message", "x"} {
+			var err error
+			if msg == "bin" {
+				err = client.SendBinary([]byte(msg))
+			} else {
+				err = client.SendText(msg)
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	ctx := context.Background()
+	expected := []struct {
+		msg Message
+		err error
+	}{
+		{Message{Text, []byte("text")}, nil},
+		{Message{Binary, []byte("bin")}, nil},
+		{Message{Text, []byte("long ")}, ErrTooLarge},
+		{Message{Text, []byte("x")}, nil},
+	}
+	for _, e := range expected {
+		msg, err := server.Receive(ctx)
+		if msg.Type != e.msg.Type || !bytes.Equal(msg.Data, e.msg.Data) || err != e.err {
+			t.Errorf("wrong message %s %q, err=%v", msg.Type, msg.Data, err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := server.Receive(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	client.Close(StatusOK, "")
+	server.Wait()
+	client.Wait()
+}
+
+func TestSelect(t *testing.T) {
+	server, client := Pipe()
+	ctx := context.Background()
+
+	extra := make(chan int, 1)
+	extra <- 7
+	res, err := Select(ctx, []*Conn{server}, extra)
+	if err != nil || res.Conn != -1 || res.Chan != 0 || res.Value != 7 || !res.OK {
+		t.Errorf("wrong result %v, err=%v", res, err)
+	}
+
+	go client.SendText("hello")
+	res, err = Select(ctx, []*Conn{server}, extra)
+	if err != nil || res.Conn != 0 || res.Chan != -1 || res.Type != Text {
+		t.Fatalf("wrong result %v, err=%v", res, err)
+	}
+	body, err := io.ReadAll(res.Reader)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("wrong body %q, err=%v", body, err)
+	}
+
+	// closed connections are skipped, while extra channels are still
+	// watched
+	client.Close(StatusOK, "")
+	server.Wait()
+	close(extra)
+	res, err = Select(ctx, []*Conn{server}, extra)
+	if err != nil || res.Chan != 0 || res.OK || res.Value != nil {
+		t.Errorf("wrong result %v, err=%v", res, err)
+	}
+}
@@ -0,0 +1,272 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package record records websocket sessions, and replays them later.
+// This can be used to reproduce protocol problems reported by users.
+//
+// A recording contains all data received from the client, including the
+// HTTP handshake, together with the time when the data arrived.  Since the
+// raw data is stored, malformed frames are recorded as well.  To record
+// all connections of a server, wrap the listener:
+//
+//	ln, err := net.Listen("tcp", ":8080")
+//	...
+//	ln = record.NewListener(ln, "/var/tmp/sessions")
+//	http.Serve(ln, handler)
+//
+// Use [Replay] to send a recorded session to a handler again, and [Frames]
+// to inspect the websocket frames in a recording.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// magic is written at the start of every recording.
+const magic = "wsrec1\n"
+
+// A recording consists of the magic string, followed by a sequence of
+// chunks.  Each chunk consists of the time since the start of the
+// connection in microseconds (uvarint), the length of the data (uvarint),
+// and the data.
+
+// NewListener wraps ln such that all data received on accepted
+// connections is recorded.  Each connection is recorded to a new file in
+// the directory dir.  If a file cannot be created, the connection is
+// served without recording.
+func NewListener(ln net.Listener, dir string) net.Listener {
+	return &listener{Listener: ln, dir: dir}
+}
+
+type listener struct {
+	net.Listener
+	dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.seq++
+	seq := l.seq
+	l.mu.Unlock()
+
+	start := time.Now()
+	name := fmt.Sprintf("%s-%04d.wsrec", start.Format("20060102-150405"), seq)
+	fd, err := os.Create(filepath.Join(l.dir, name))
+	if err != nil {
+		return conn, nil
+	}
+	w := bufio.NewWriter(fd)
+	w.WriteString(magic)
+	return &recordConn{Conn: conn, fd: fd, w: w, start: start}, nil
+}
+
+type recordConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	fd    *os.File
+	w     *bufio.Writer
+	start time.Time
+	buf   [2 * binary.MaxVarintLen64]byte
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if c.w != nil {
+			t := time.Since(c.start) / time.Microsecond
+			k := binary.PutUvarint(c.buf[:], uint64(t))
+			k += binary.PutUvarint(c.buf[k:], uint64(n))
+			c.w.Write(c.buf[:k])
+			c.w.Write(b[:n])
+			c.w.Flush()
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *recordConn) Close() error {
+	c.mu.Lock()
+	if c.w != nil {
+		c.w.Flush()
+		c.fd.Close()
+		c.w = nil
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// chunk is a piece of data received from the client.
+type chunk struct {
+	t    time.Duration // since the start of the connection
+	data []byte
+}
+
+func readChunks(r io.Reader) ([]chunk, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic))
+	_, err := io.ReadFull(br, head)
+	if err != nil || string(head) != magic {
+		return nil, errFormat
+	}
+
+	var chunks []chunk
+	for {
+		t, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return chunks, nil
+		} else if err != nil {
+			return nil, errFormat
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil || n > 1<<30 {
+			return nil, errFormat
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return nil, errFormat
+		}
+		chunks = append(chunks, chunk{
+			t:    time.Duration(t) * time.Microsecond,
+			data: data,
+		})
+	}
+}
+
+// Frame is a websocket frame from a recording.
+type Frame struct {
+	Time    time.Duration // time since the start of the connection
+	Final   bool
+	RSV     byte // the three reserved bits, in the lowest three bits
+	Opcode  byte
+	Payload []byte // unmasked
+}
+
+// Frames reads a recording and returns the websocket frames sent by the
+// client.  If the recording ends in the middle of a frame, the frames
+// before the incomplete frame are returned together with
+// io.ErrUnexpectedEOF.
+func Frames(r io.Reader) ([]*Frame, error) {
+	chunks, err := readChunks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var offsets []int // start of each chunk in data
+	for _, c := range chunks {
+		offsets = append(offsets, len(data))
+		data = append(data, c.data...)
+	}
+	timeAt := func(pos int) time.Duration {
+		var t time.Duration
+		for i, offs := range offsets {
+			if offs > pos {
+				break
+			}
+			t = chunks[i].t
+		}
+		return t
+	}
+
+	pos := bytes.Index(data, []byte("\r\n\r\n"))
+	if pos < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	pos += 4
+
+	var frames []*Frame
+	for pos < len(data) {
+		f, n := parseFrame(data[pos:])
+		if f == nil {
+			return frames, io.ErrUnexpectedEOF
+		}
+		f.Time = timeAt(pos)
+		frames = append(frames, f)
+		pos += n
+	}
+	return frames, nil
+}
+
+// parseFrame decodes the frame at the start of buf, and returns the frame
+// and its length in bytes.  If buf does not contain a complete frame,
+// nil is returned.
+func parseFrame(buf []byte) (*Frame, int) {
+	if len(buf) < 2 {
+		return nil, 0
+	}
+	f := &Frame{
+		Final:  buf[0]&128 != 0,
+		RSV:    (buf[0] >> 4) & 7,
+		Opcode: buf[0] & 15,
+	}
+	masked := buf[1]&128 != 0
+	l := uint64(buf[1] & 127)
+	pos := 2
+	switch l {
+	case 126:
+		if len(buf) < pos+2 {
+			return nil, 0
+		}
+		l = uint64(binary.BigEndian.Uint16(buf[pos:]))
+		pos += 2
+	case 127:
+		if len(buf) < pos+8 {
+			return nil, 0
+		}
+		l = binary.BigEndian.Uint64(buf[pos:])
+		pos += 8
+	}
+	var mask [4]byte
+	if masked {
+		if len(buf) < pos+4 {
+			return nil, 0
+		}
+		copy(mask[:], buf[pos:])
+		pos += 4
+	}
+	if l > uint64(len(buf)-pos) {
+		return nil, 0
+	}
+	f.Payload = make([]byte, l)
+	for i := range f.Payload {
+		f.Payload[i] = buf[pos+i] ^ mask[i%4]
+	}
+	return f, pos + int(l)
+}
+
+var errFormat = errors.New("record: invalid recording")
@@ -0,0 +1,138 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	handler := &websocket.Handler{
+		Handle: func(conn *websocket.Conn) {
+			defer conn.Close(websocket.StatusOK, "")
+			for {
+				msg, err := conn.ReceiveText(100)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				received = append(received, msg)
+				mu.Unlock()
+			}
+		},
+	}
+
+	dir := t.TempDir()
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = NewListener(server.Listener, dir)
+	server.Start()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+server.URL[len("http"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{"one", "two", "three"}
+	for _, msg := range messages {
+		err = conn.SendText(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.Close(websocket.StatusOK, "")
+	conn.Wait()
+	server.Close()
+
+	mu.Lock()
+	if !reflect.DeepEqual(received, messages) {
+		t.Fatalf("wrong messages %q", received)
+	}
+	received = nil
+	mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.wsrec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, found %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := Frames(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatalf("expected 4 frames, got %d", len(frames))
+	}
+	for i, msg := range messages {
+		f := frames[i]
+		if f.Opcode != 1 || !f.Final || string(f.Payload) != msg {
+			t.Errorf("wrong frame %d: %v", i, f)
+		}
+		if i > 0 && f.Time < frames[i-1].Time+10*time.Millisecond {
+			t.Errorf("wrong time for frame %d: %s", i, f.Time)
+		}
+	}
+	if f := frames[3]; f.Opcode != 8 || !bytes.Equal(f.Payload, []byte{0x03, 0xe8}) {
+		t.Errorf("wrong close frame %v", f)
+	}
+
+	for _, realTime := range []bool{false, true} {
+		start := time.Now()
+		err = Replay(bytes.NewReader(data), handler, realTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := time.Since(start)
+		if realTime && d < 20*time.Millisecond || !realTime && d > 500*time.Millisecond {
+			t.Errorf("replay took %s, realTime=%t", d, realTime)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(received, messages) {
+			t.Errorf("wrong messages after replay %q", received)
+		}
+		received = nil
+		mu.Unlock()
+	}
+}
+
+func TestInvalidRecording(t *testing.T) {
+	for _, data := range []string{"", "hello", magic + "\x01\x05abc"} {
+		_, err := Frames(bytes.NewReader([]byte(data)))
+		if err != errFormat {
+			t.Errorf("%q: wrong error %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package record
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// replayTimeout is the time Replay waits for the server to close the
+// connection, after all recorded data has been sent.
+const replayTimeout = time.Second
+
+// Replay reads a recording from r and sends the recorded data to handler,
+// as if it came from a client.  If realTime is true, the original timing
+// is reproduced.  Otherwise the data is sent as fast as possible.  Data
+// sent by the handler is discarded.
+//
+// Replay returns once all data has been sent and the handler has closed
+// the connection.  If the handler does not close the connection within
+// one second, the connection is closed from the client side.
+func Replay(r io.Reader, handler http.Handler, realTime bool) error {
+	chunks, err := readChunks(r)
+	if err != nil {
+		return err
+	}
+
+	client, server := net.Pipe()
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(newPipeListener(server))
+	defer srv.Close()
+
+	serverDone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, client)
+		close(serverDone)
+	}()
+
+	start := time.Now()
+	for _, c := range chunks {
+		if realTime {
+			time.Sleep(time.Until(start.Add(c.t)))
+		}
+		_, err := client.Write(c.data)
+		if err != nil {
+			// the server has closed the connection
+			break
+		}
+	}
+
+	timer := time.NewTimer(replayTimeout)
+	select {
+	case <-serverDone:
+		timer.Stop()
+	case <-timer.C:
+	}
+	client.Close()
+	return nil
+}
+
+// pipeListener is a net.Listener which returns a single connection.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	addr      net.Addr
+}
+
+func newPipeListener(conn net.Conn) *pipeListener {
+	l := &pipeListener{
+		conns:  make(chan net.Conn, 1),
+		closed: make(chan struct{}),
+		addr:   conn.LocalAddr(),
+	}
+	l.conns <- conn
+	return l
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
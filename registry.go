@@ -0,0 +1,265 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry keeps track of the open connections of one or more Handlers,
+// and assigns a unique ID to each connection.  This allows to close a
+// specific connection, for example to disconnect an abusive user or to
+// force a client to authenticate again.
+//
+// To use a Registry, set the Registry field of the Handler.  The zero value
+// is an empty Registry, ready for use.  A Registry can be shared by several
+// Handlers, for example one for public traffic and one serving a Unix
+// socket for internal traffic.  Set Handler.Tag to tell the connections of
+// the different Handlers apart.
+type Registry struct {
+	// IdleTimeout, if positive, makes the registry close connections on
+	// which no data has been received for the given duration.  A single
+	// goroutine scans all connections every IdleTimeout/2.  Idle
+	// connections are first sent a ping frame, and are closed with status
+	// StatusGoingAway if nothing has been received by the next scan.
+	// The goroutine only runs while connections are registered.
+	//
+	// In contrast to Handler.IdleTimeout, which uses a timer for every
+	// connection, this is cheap for large numbers of connections.
+	// IdleTimeout must be set before the first connection is added.
+	IdleTimeout time.Duration
+
+	mu      sync.Mutex
+	next    uint64
+	conns   map[string]*Conn
+	ids     map[*Conn]string
+	idle    map[*Conn]*activity // connections watched by the reaper
+	reaping bool
+}
+
+func (r *Registry) add(conn *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[string]*Conn)
+		r.ids = make(map[*Conn]string)
+	}
+	r.next++
+	id := strconv.FormatUint(r.next, 10)
+	r.conns[id] = conn
+	r.ids[conn] = id
+}
+
+// watchIdle adds a connection to the set of connections checked by the
+// reaper.  This must be called after conn.initialize.
+func (r *Registry) watchIdle(conn *Conn) {
+	if conn.activity == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[conn]; !ok {
+		// the connection has been closed already
+		return
+	}
+	if r.idle == nil {
+		r.idle = make(map[*Conn]*activity)
+	}
+	r.idle[conn] = conn.activity
+	if !r.reaping {
+		r.reaping = true
+		go r.reap()
+	}
+}
+
+func (r *Registry) remove(conn *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.ids[conn]
+	if ok {
+		delete(r.ids, conn)
+		delete(r.conns, id)
+		delete(r.idle, conn)
+	}
+}
+
+// ID returns the ID of the given connection.  If the connection is not
+// in the registry, the empty string is returned.
+func (r *Registry) ID(conn *Conn) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ids[conn]
+}
+
+// Lookup returns the connection with the given ID, or nil if there is no
+// open connection with this ID.
+func (r *Registry) Lookup(id string) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[id]
+}
+
+// IDs returns the IDs of all open connections, in the order the
+// connections were established.
+func (r *Registry) IDs() []string {
+	r.mu.Lock()
+	ids := make([]string, 0, len(r.conns))
+	for id := range r.conns {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Conns returns all open connections, in the order the connections were
+// established.  The result can be used for broadcasting messages to all
+// connections of the Handlers which share the registry.  Use Conn.Tag to
+// select the connections of one Handler.
+func (r *Registry) Conns() []*Conn {
+	ids := r.IDs()
+	conns := make([]*Conn, 0, len(ids))
+	for _, id := range ids {
+		if conn := r.Lookup(id); conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// Close closes the connection with the given ID, using the given status
+// code and message.  If there is no open connection with this ID,
+// [ErrUnknownConn] is returned.
+func (r *Registry) Close(id string, code Status, message string) error {
+	conn := r.Lookup(id)
+	if conn == nil {
+		return ErrUnknownConn
+	}
+	return conn.Close(code, message)
+}
+
+// reap periodically closes idle connections, see Registry.IdleTimeout.  The
+// function returns once no connections are left.
+func (r *Registry) reap() {
+	interval := r.IdleTimeout / 2
+	if interval <= 0 {
+		interval = r.IdleTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.Lock()
+		if len(r.idle) == 0 {
+			r.reaping = false
+			r.mu.Unlock()
+			return
+		}
+		idle := make(map[*Conn]*activity, len(r.idle))
+		for conn, a := range r.idle {
+			idle[conn] = a
+		}
+		r.mu.Unlock()
+
+		r.closeIdle(idle, time.Now())
+	}
+}
+
+// closeIdle sends a ping to every connection which has been idle for
+// longer than r.IdleTimeout, and closes connections which have not
+// responded to the ping sent during a previous scan.
+func (r *Registry) closeIdle(idle map[*Conn]*activity, now time.Time) {
+	for conn, a := range idle {
+		last := atomic.LoadInt64(&a.last)
+		if now.Sub(time.Unix(0, last)) < r.IdleTimeout {
+			continue
+		}
+		if ping := atomic.LoadInt64(&a.ping); ping > last {
+			// nothing has been received since the ping
+			go conn.Close(StatusGoingAway, "idle timeout")
+			continue
+		}
+		atomic.StoreInt64(&a.ping, now.UnixNano())
+		conn.sendIdlePing()
+	}
+}
+
+// ServeHTTP implements an administrative HTTP endpoint for the registry.
+// A GET request returns a plain text list of the open connections, one
+// per line, giving the ID, the remote address and the resource name,
+// followed by the tag of the connection if the tag is not empty.  A
+// POST request closes the connection given by the form value "id".  The
+// optional form values "status" and "message" give the status code and
+// message for the close frame; the default status code is 1008 (policy
+// violation).
+//
+// The endpoint must be protected against unauthorised access, for example
+// by wrapping it in an authentication handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, id := range r.IDs() {
+			conn := r.Lookup(id)
+			if conn == nil {
+				continue
+			}
+			if conn.tag != "" {
+				fmt.Fprintf(w, "%s %s %s %s\n", id, conn.RemoteAddr, conn.ResourceName, conn.tag)
+			} else {
+				fmt.Fprintf(w, "%s %s %s\n", id, conn.RemoteAddr, conn.ResourceName)
+			}
+		}
+
+	case http.MethodPost:
+		code := StatusPolicyViolation
+		if s := req.FormValue("status"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "invalid status code", http.StatusBadRequest)
+				return
+			}
+			code = Status(n)
+		}
+		err := r.Close(req.FormValue("id"), code, req.FormValue("message"))
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrUnknownConn:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrStatusCode, ErrTooLarge:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
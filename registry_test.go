@@ -0,0 +1,206 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := &Registry{}
+	connected := make(chan *Conn, 2)
+	closed := make(chan *Conn, 2)
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			connected <- conn
+		},
+		OnClose: func(conn *Conn) {
+			closed <- conn
+		},
+		Registry: registry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var clients []*TestClient
+	var conns []*Conn
+	for i := 0; i < 2; i++ {
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		conns = append(conns, <-connected)
+	}
+
+	if ids := registry.IDs(); !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Fatalf("wrong IDs %q", ids)
+	}
+	if registry.ID(conns[1]) != "2" || registry.Lookup("1") != conns[0] {
+		t.Error("wrong ID mapping")
+	}
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "1 ") {
+		t.Errorf("wrong connection list %q", lines)
+	}
+
+	form := url.Values{"id": {"1"}, "status": {"4000"}, "message": {"kicked"}}
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	registry.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("wrong status %d", w.Code)
+	}
+
+	tp, body, err := clients[0].ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != closeFrame || string(body) != "\x0f\xa0kicked" {
+		t.Errorf("wrong close frame %s %q", tp, body)
+	}
+	clients[0].SendFrame(closeFrame, body[:2], true)
+	if conn := <-closed; conn != conns[0] {
+		t.Error("wrong connection closed")
+	}
+	if ids := registry.IDs(); !reflect.DeepEqual(ids, []string{"2"}) {
+		t.Errorf("wrong IDs after close %q", ids)
+	}
+
+	err = registry.Close("1", StatusOK, "")
+	if err != ErrUnknownConn {
+		t.Errorf("wrong error %v", err)
+	}
+	req = httptest.NewRequest("POST", "/?id=7", nil)
+	w = httptest.NewRecorder()
+	registry.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("wrong status %d", w.Code)
+	}
+}
+
+func TestRegistryIdleTimeout(t *testing.T) {
+	registry := &Registry{IdleTimeout: 50 * time.Millisecond}
+	info := make(chan ConnInfo, 1)
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			i, _, _ := conn.Wait()
+			info <- i
+		},
+		Registry: registry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The test client does not answer pings.
+	tp, _, err := client.ReadFrame()
+	if err != nil || tp != pingFrame {
+		t.Fatalf("expected ping, got %s %v", tp, err)
+	}
+	tp, body, err := client.ReadFrame()
+	if err != nil || tp != closeFrame || len(body) < 2 ||
+		256*Status(body[0])+Status(body[1]) != StatusGoingAway {
+		t.Fatalf("expected close frame, got %s %q %v", tp, body, err)
+	}
+	err = client.SendFrame(closeFrame, body[:2], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := <-info; i != ServerClosed {
+		t.Errorf("wrong connection info %d", i)
+	}
+
+	// the reaper stops once all connections are gone
+	time.Sleep(100 * time.Millisecond)
+	registry.mu.Lock()
+	reaping := registry.reaping
+	registry.mu.Unlock()
+	if reaping {
+		t.Error("reaper still running")
+	}
+}
+
+func TestRegistryShared(t *testing.T) {
+	registry := &Registry{}
+	connected := make(chan *Conn, 2)
+	handle := func(conn *Conn) {
+		connected <- conn
+	}
+	var clients []*TestClient
+	for _, tag := range []string{"public", "internal"} {
+		server, err := StartTestServerWithHandler(&Handler{
+			Handle:   handle,
+			Registry: registry,
+			Tag:      tag,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		<-connected
+	}
+
+	conns := registry.Conns()
+	if len(conns) != 2 || conns[0].Tag() != "public" || conns[1].Tag() != "internal" {
+		t.Fatalf("wrong connections %v", conns)
+	}
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], " internal") {
+		t.Errorf("wrong connection list %q", lines)
+	}
+
+	for _, conn := range conns {
+		conn.Close(StatusOK, "")
+	}
+	for _, client := range clients {
+		client.ReadFrame()
+		client.SendFrame(closeFrame, []byte{3, 232}, true)
+	}
+	for _, conn := range conns {
+		conn.Wait()
+	}
+}
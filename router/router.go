@@ -0,0 +1,199 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package router dispatches JSON messages received over a websocket
+// connection to handler functions, based on the "type" field of the
+// message.
+//
+// Handlers are registered using Router.Handle.  A handler is a function of
+// the form
+//
+//	func(conn *websocket.Conn, msg T) error
+//
+// where T is any type which can be decoded by encoding/json.  The complete
+// message is decoded into a new value of type T before the handler is
+// called.  Middleware can be added for all message types using Router.Use,
+// or for a single message type when the handler is registered.
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"seehuhn.de/go/websocket"
+)
+
+// DefaultMaxMessageSize is the default value for Router.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 20
+
+// Message is a received message, as passed to middleware.
+type Message struct {
+	Type string          // the value of the "type" field
+	Data json.RawMessage // the complete message
+}
+
+// HandlerFunc processes a message.
+type HandlerFunc func(conn *websocket.Conn, msg *Message) error
+
+// Middleware wraps a HandlerFunc, for example to add logging or access
+// control.  The middleware can decide not to call next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Router dispatches messages to handlers, based on the message type.
+// The methods Handle and Use must not be called concurrently with Serve
+// or Dispatch.
+type Router struct {
+	// MaxMessageSize is the maximal size of messages read by Serve.  If
+	// this is zero, DefaultMaxMessageSize is used.
+	MaxMessageSize int
+
+	// Unknown, if set, is called for messages where no handler is
+	// registered for the message type, or where the type field is
+	// missing.  If Unknown is nil, such messages cause an
+	// *UnknownTypeError.
+	Unknown HandlerFunc
+
+	routes     map[string]HandlerFunc
+	middleware []Middleware
+}
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	connType  = reflect.TypeOf((*websocket.Conn)(nil))
+)
+
+// Handle registers fn as the handler for messages of type tp.  The
+// function fn must have the signature
+//
+//	func(conn *websocket.Conn, msg T) error
+//
+// for some type T, otherwise Handle panics.  If middleware is given, it is
+// only applied to messages of this type, inside the middleware installed
+// using Use.
+func (r *Router) Handle(tp string, fn interface{}, middleware ...Middleware) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 1 ||
+		t.In(0) != connType || t.Out(0) != errorType {
+		panic(fmt.Sprintf("router: invalid handler type %s for %q", t, tp))
+	}
+	argType := t.In(1)
+
+	h := func(conn *websocket.Conn, msg *Message) error {
+		arg := reflect.New(argType)
+		err := json.Unmarshal(msg.Data, arg.Interface())
+		if err != nil {
+			return &DecodeError{Type: msg.Type, Err: err}
+		}
+		res := v.Call([]reflect.Value{reflect.ValueOf(conn), arg.Elem()})
+		err, _ = res[0].Interface().(error)
+		return err
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	if r.routes == nil {
+		r.routes = make(map[string]HandlerFunc)
+	}
+	r.routes[tp] = h
+}
+
+// Use adds middleware which applies to all messages, including messages
+// passed to Unknown.  Middleware added first is called first.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Dispatch passes a single message to the handler for its type.  This can
+// be used in event-driven mode, see websocket.Handler.OnMessage.  The
+// return value is the error returned by the handler.
+func (r *Router) Dispatch(conn *websocket.Conn, data []byte) error {
+	var header struct {
+		Type string `json:"type"`
+	}
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	msg := &Message{Type: header.Type, Data: data}
+
+	h, ok := r.routes[header.Type]
+	if !ok {
+		h = r.Unknown
+		if h == nil {
+			h = unknownType
+		}
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h(conn, msg)
+}
+
+// Serve reads text messages from conn and dispatches them, until an error
+// occurs.  If a handler returns an error, Serve stops and returns the
+// error; the connection is left open.  Once the connection is closed,
+// websocket.ErrConnClosed is returned.
+func (r *Router) Serve(conn *websocket.Conn) error {
+	maxSize := r.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	for {
+		msg, err := conn.ReceiveText(maxSize)
+		if err != nil {
+			return err
+		}
+		err = r.Dispatch(conn, []byte(msg))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func unknownType(conn *websocket.Conn, msg *Message) error {
+	return &UnknownTypeError{Type: msg.Type}
+}
+
+// UnknownTypeError is returned by Dispatch if no handler is registered for
+// the message type, and Router.Unknown is not set.
+type UnknownTypeError struct {
+	Type string
+}
+
+func (err *UnknownTypeError) Error() string {
+	return fmt.Sprintf("router: unknown message type %q", err.Type)
+}
+
+// DecodeError is returned by Dispatch if a message is not valid JSON, or
+// cannot be decoded into the argument type of the handler.
+type DecodeError struct {
+	Type string // the message type, if known
+	Err  error
+}
+
+func (err *DecodeError) Error() string {
+	if err.Type == "" {
+		return "router: invalid message: " + err.Err.Error()
+	}
+	return fmt.Sprintf("router: invalid %q message: %s", err.Type, err.Err)
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,110 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package router
+
+import (
+	"errors"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+type chatMessage struct {
+	Room string `json:"room"`
+	Text string `json:"text"`
+}
+
+func TestRouter(t *testing.T) {
+	var log []string
+	r := &Router{}
+	r.Use(func(next HandlerFunc) HandlerFunc {
+		return func(conn *websocket.Conn, msg *Message) error {
+			log = append(log, "use:"+msg.Type)
+			return next(conn, msg)
+		}
+	})
+	r.Handle("chat", func(conn *websocket.Conn, msg chatMessage) error {
+		log = append(log, "chat:"+msg.Room+":"+msg.Text)
+		return nil
+	}, func(next HandlerFunc) HandlerFunc {
+		return func(conn *websocket.Conn, msg *Message) error {
+			log = append(log, "mw:"+msg.Type)
+			return next(conn, msg)
+		}
+	})
+	errStop := errors.New("stop")
+	r.Handle("stop", func(conn *websocket.Conn, msg *struct{}) error {
+		return errStop
+	})
+
+	err := r.Dispatch(nil, []byte(`{"type":"chat","room":"a","text":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"use:chat", "mw:chat", "chat:a:hi"}
+	if len(log) != len(expected) {
+		t.Fatalf("wrong log %q", log)
+	}
+	for i := range expected {
+		if log[i] != expected[i] {
+			t.Errorf("wrong log %q", log)
+			break
+		}
+	}
+
+	var unknown *UnknownTypeError
+	err = r.Dispatch(nil, []byte(`{"type":"other"}`))
+	if !errors.As(err, &unknown) || unknown.Type != "other" {
+		t.Errorf("expected UnknownTypeError, got %v", err)
+	}
+	var decode *DecodeError
+	err = r.Dispatch(nil, []byte(`{"type":"chat","room":1}`))
+	if !errors.As(err, &decode) || decode.Type != "chat" {
+		t.Errorf("expected DecodeError, got %v", err)
+	}
+
+	r.Unknown = func(conn *websocket.Conn, msg *Message) error {
+		return nil
+	}
+	if err := r.Dispatch(nil, []byte(`{}`)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	server, client := websocket.Pipe()
+	go func() {
+		client.SendText(`{"type":"chat","room":"b","text":"x"}`)
+		client.SendText(`{"type":"stop"}`)
+	}()
+	if err := r.Serve(server); err != errStop {
+		t.Errorf("expected errStop, got %v", err)
+	}
+	if last := log[len(log)-2]; last != "chat:b:x" {
+		t.Errorf("wrong log %q", log)
+	}
+	client.Close(websocket.StatusOK, "")
+	server.Wait()
+}
+
+func TestHandleInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for invalid handler")
+		}
+	}()
+	r := &Router{}
+	r.Handle("x", func(msg string) {})
+}
@@ -0,0 +1,199 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "strings"
+
+// Reserved bits in the first byte of a frame header.  These bits can only
+// be used by extensions, see [RSVExtension].
+const (
+	RSV1 byte = 0x40
+	RSV2 byte = 0x20
+	RSV3 byte = 0x10
+
+	rsvMask = RSV1 | RSV2 | RSV3
+)
+
+// RSVExtension claims reserved bits in the frame header for an
+// experimental extension.  Received frames which carry claimed bits are
+// delivered unchanged to the application, and the bits can be inspected
+// using the RSV method of [MessageReader].  Frames with reserved bits can
+// be sent using Conn.SendRSV.  The package does not interpret the payload
+// of such frames in any way.
+//
+// If Name is non-empty, the bits are only enabled if the extension is
+// agreed during the opening handshake: the client lists Name in the
+// Sec-WebSocket-Extensions header, and the server confirms it.  Extension
+// parameters are ignored.  If Name is empty, the bits are always enabled;
+// this is only useful if both ends of the connection are under the
+// control of the developer.
+type RSVExtension struct {
+	Name string
+	Bits byte // a combination of RSV1, RSV2 and RSV3
+}
+
+// parseExtensions parses the values of Sec-WebSocket-Extensions header
+// fields.  Quoted parameter values containing "," or ";" are not
+// supported.
+func parseExtensions(headers []string) []Extension {
+	var res []Extension
+	for _, h := range headers {
+		for _, item := range strings.Split(h, ",") {
+			parts := strings.Split(item, ";")
+			name := strings.TrimSpace(parts[0])
+			if name == "" {
+				continue
+			}
+			ext := Extension{Name: name}
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if p == "" {
+					continue
+				}
+				key, val := p, ""
+				if i := strings.IndexByte(p, '='); i >= 0 {
+					key = strings.TrimSpace(p[:i])
+					val = strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+				}
+				if ext.Params == nil {
+					ext.Params = make(map[string]string)
+				}
+				ext.Params[key] = val
+			}
+			res = append(res, ext)
+		}
+	}
+	return res
+}
+
+// acceptRSVExtensions selects the extensions offered by the client which
+// are registered in claims.  The function returns the accepted extensions
+// and the reserved bits enabled for the connection.
+func acceptRSVExtensions(claims []RSVExtension, offered []string) ([]Extension, byte) {
+	var bits byte
+	var accepted []Extension
+	if len(claims) == 0 {
+		return nil, 0
+	}
+	offers := parseExtensions(offered)
+	for _, claim := range claims {
+		if claim.Name == "" {
+			bits |= claim.Bits & rsvMask
+			continue
+		}
+		for _, offer := range offers {
+			if offer.Name == claim.Name && !hasExtension(accepted, claim.Name) {
+				accepted = append(accepted, Extension{Name: claim.Name})
+				bits |= claim.Bits & rsvMask
+				break
+			}
+		}
+	}
+	return accepted, bits
+}
+
+// confirmRSVExtensions checks the extensions accepted by the server, as
+// listed in the Sec-WebSocket-Extensions header of the response.  The
+// function returns false if the server accepted an extension which the
+// client did not offer.
+func confirmRSVExtensions(claims []RSVExtension, headers []string) ([]Extension, byte, bool) {
+	var bits byte
+	for _, claim := range claims {
+		if claim.Name == "" {
+			bits |= claim.Bits & rsvMask
+		}
+	}
+
+	var accepted []Extension
+	for _, ext := range parseExtensions(headers) {
+		found := false
+		for _, claim := range claims {
+			if claim.Name != "" && claim.Name == ext.Name {
+				bits |= claim.Bits & rsvMask
+				found = true
+				break
+			}
+		}
+		if !found || hasExtension(accepted, ext.Name) {
+			return nil, 0, false
+		}
+		accepted = append(accepted, ext)
+	}
+	return accepted, bits, true
+}
+
+// offerRSVExtensions returns the value of the Sec-WebSocket-Extensions
+// header field for the client handshake, or "" if no extension is offered.
+func offerRSVExtensions(claims []RSVExtension) string {
+	var names []string
+	for _, claim := range claims {
+		if claim.Name != "" {
+			names = append(names, claim.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func hasExtension(list []Extension, name string) bool {
+	for _, ext := range list {
+		if ext.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func extensionNames(list []Extension) string {
+	names := make([]string, len(list))
+	for i, ext := range list {
+		names[i] = ext.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// SendRSV sends msg as a single, unfragmented frame with the given reserved
+// bits set in the frame header.  The bits must have been enabled for the
+// connection using an [RSVExtension], otherwise ErrReservedBits is
+// returned.  The payload is sent unchanged.
+func (conn *Conn) SendRSV(tp MessageType, rsv byte, msg []byte) error {
+	if tp != Text && tp != Binary {
+		return ErrMessageType
+	}
+	if rsv&^conn.rsvBits != 0 {
+		return ErrReservedBits
+	}
+	if conn.Draining() {
+		return ErrDraining
+	}
+
+	wb := <-conn.senderStore
+	if wb == nil {
+		return conn.sendClosedErr()
+	}
+
+	var err error
+	if !wb.isShuttingDown() {
+		wb.rsv = rsv
+		err = wb.sendFrame(tp, msg, true)
+		wb.rsv = 0
+	} else {
+		err = conn.sendClosedErr()
+	}
+
+	wb.release()
+	return err
+}
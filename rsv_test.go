@@ -0,0 +1,143 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRSVExtension(t *testing.T) {
+	claims := []RSVExtension{{Name: "x-test", Bits: RSV1}}
+	server := httptest.NewServer(&Handler{
+		RSVExtensions: claims,
+		Handle: func(conn *Conn) {
+			defer conn.Close(StatusOK, "")
+			tp, r, err := conn.ReceiveMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rsv := r.(MessageReader).RSV()
+			msg, err := io.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			err = conn.SendRSV(tp, rsv, msg)
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialer := &Dialer{RSVExtensions: claims}
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusOK, "")
+
+	ext := conn.Extensions()
+	if len(ext) != 1 || ext[0].Name != "x-test" {
+		t.Errorf("wrong extensions %v", ext)
+	}
+	if err := conn.SendRSV(Binary, RSV2, []byte("x")); err != ErrReservedBits {
+		t.Errorf("expected ErrReservedBits, got %v", err)
+	}
+
+	err = conn.SendRSV(Binary, RSV1, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, r, err := conn.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsv := r.(MessageReader).RSV(); rsv != RSV1 {
+		t.Errorf("wrong reserved bits %x", rsv)
+	}
+	msg, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != Binary || string(msg) != "hello" {
+		t.Errorf("wrong message %d %q", tp, msg)
+	}
+}
+
+// TestRSVNotNegotiated checks that frames with reserved bits are rejected
+// if the extension was not agreed in the handshake.
+func TestRSVNotNegotiated(t *testing.T) {
+	server := httptest.NewServer(&Handler{
+		RSVExtensions: []RSVExtension{{Name: "x-test", Bits: RSV1}},
+		Handle: func(conn *Conn) {
+			_, r, err := conn.ReceiveMessage()
+			if err == nil {
+				io.Copy(io.Discard, r)
+			}
+		},
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.Extensions()) != 0 {
+		t.Errorf("unexpected extensions %v", conn.Extensions())
+	}
+
+	// bypass the check in SendRSV
+	conn.rsvBits = RSV1
+	err = conn.SendRSV(Binary, RSV1, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, status, _ := conn.Wait()
+	if status != StatusProtocolError {
+		t.Errorf("wrong status %d", status)
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	ext := parseExtensions([]string{
+		`permessage-deflate; client_max_window_bits, x-a;k="v"`,
+		"x-b",
+	})
+	if len(ext) != 3 {
+		t.Fatalf("wrong number of extensions %d", len(ext))
+	}
+	if ext[0].Name != "permessage-deflate" || len(ext[0].Params) != 1 {
+		t.Errorf("wrong extension %v", ext[0])
+	}
+	if v, ok := ext[0].Params["client_max_window_bits"]; !ok || v != "" {
+		t.Errorf("wrong parameter %q", v)
+	}
+	if ext[1].Name != "x-a" || ext[1].Params["k"] != "v" {
+		t.Errorf("wrong extension %v", ext[1])
+	}
+	if ext[2].Name != "x-b" || ext[2].Params != nil {
+		t.Errorf("wrong extension %v", ext[2])
+	}
+}
@@ -0,0 +1,314 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Priority determines the order in which messages in a SendQueue are sent.
+type Priority int
+
+// Message priorities for use with SendQueue.  Control frames, like pong
+// frames, are always sent before any queued messages.
+const (
+	PriorityBulk Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+// SendQueue sends messages on a connection in the background.  Queued
+// messages with higher priority are sent before messages with lower
+// priority; messages of equal priority are sent in order.
+//
+// A message which has started to be sent cannot be interrupted, since the
+// websocket protocol does not allow to interleave messages.  Large bulk
+// messages can therefore still delay messages of higher priority.
+//
+// It is safe to use a SendQueue from different goroutines concurrently.
+type SendQueue struct {
+	conn   *Conn
+	budget *SendBudget // nil, unless Handler.SendBudget is set
+
+	mu      sync.Mutex
+	queues  [numPriorities][]queuedMessage
+	n       int
+	bytes   int64 // total size of the queued messages
+	closed  bool
+	err     error // the first error encountered while sending
+	expired int64 // the number of messages discarded by SendBefore
+
+	// flow control, see SetWaterMarks
+	high, low int64
+	full      bool
+	writable  chan struct{} // closed when the queue is no longer full
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+type queuedMessage struct {
+	tp       MessageType
+	data     []byte
+	deadline time.Time
+}
+
+// NewSendQueue creates a new SendQueue for conn.  The queue must be closed
+// after use, to stop the background goroutine.
+func NewSendQueue(conn *Conn) *SendQueue {
+	q := &SendQueue{
+		conn:   conn,
+		budget: conn.budget,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if q.budget != nil {
+		q.budget.add(q)
+	}
+	go q.run()
+	return q
+}
+
+// Send adds a message to the queue and returns immediately.  The argument
+// tp gives the message type (Text or Binary).  The caller must not modify
+// msg after Send has been called.
+//
+// If a previous message could not be sent, the error for this message is
+// returned, and the message is not queued.  If the queue has been closed,
+// ErrConnClosed is returned.  If the SendBudget of the Handler is exhausted
+// and no room can be made, ErrQueueFull is returned.
+func (q *SendQueue) Send(tp MessageType, msg []byte, pri Priority) error {
+	return q.SendBefore(tp, msg, pri, time.Time{})
+}
+
+// SendBefore is like Send, but the message is discarded if it has not
+// started to be sent by the given deadline.  This is useful for messages
+// which become useless after some time, like price quotes in a real-time
+// feed.  The number of discarded messages is reported by Expired.
+// A zero deadline means that the message does not expire.
+func (q *SendQueue) SendBefore(tp MessageType, msg []byte, pri Priority, deadline time.Time) error {
+	if tp != Text && tp != Binary {
+		return ErrMessageType
+	}
+	if pri < PriorityBulk || pri > PriorityHigh {
+		panic("websocket: invalid priority")
+	}
+	if tp == Text && q.conn.validateText && !utf8.Valid(msg) {
+		return ErrInvalidUTF8
+	}
+	if q.conn.Draining() {
+		return ErrDraining
+	}
+
+	size := int64(len(msg))
+	if q.budget != nil {
+		err := q.budget.reserve(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	q.mu.Lock()
+	err := q.err
+	if err == nil && q.closed {
+		err = ErrConnClosed
+	}
+	if err != nil {
+		q.mu.Unlock()
+		q.budget.release(size)
+		return err
+	}
+	q.queues[pri] = append(q.queues[pri], queuedMessage{tp: tp, data: msg, deadline: deadline})
+	q.n++
+	q.bytes += size
+	if q.high > 0 && !q.full && q.bytes >= q.high {
+		q.full = true
+		q.writable = make(chan struct{})
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SetWaterMarks enables flow control for the queue.  Once high or more
+// bytes are queued, the queue is considered full until the amount of
+// queued data has dropped to low bytes or less.  Send does not block when
+// the queue is full; producers can use Full and Writable to stop generating
+// messages until the peer has caught up.
+func (q *SendQueue) SetWaterMarks(high, low int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.high = high
+	q.low = low
+	q.checkDrained()
+}
+
+// Full reports whether the queue is full, see SetWaterMarks.
+func (q *SendQueue) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.full
+}
+
+// Writable returns a channel which is closed once the queue is no longer
+// full.  If the queue is not full, the returned channel is already closed.
+func (q *SendQueue) Writable() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.full {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	return q.writable
+}
+
+// checkDrained clears the full state, once enough data has been sent.
+// This must be called with q.mu held.
+func (q *SendQueue) checkDrained() {
+	if q.full && (q.bytes <= q.low || q.high <= 0) {
+		q.full = false
+		close(q.writable)
+	}
+}
+
+// Expired returns the number of messages which were discarded because
+// their deadline had passed, see SendBefore.
+func (q *SendQueue) Expired() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.expired
+}
+
+// Len returns the number of messages which are waiting to be sent.
+func (q *SendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Close stops accepting new messages, and waits until all queued messages
+// have been sent.  The return value is the first error encountered while
+// sending, if any.  Close does not close the connection.
+func (q *SendQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	<-q.done
+	return q.err
+}
+
+func (q *SendQueue) run() {
+	defer close(q.done)
+	if q.budget != nil {
+		defer q.budget.remove(q)
+	}
+
+	for {
+		q.mu.Lock()
+		msg, ok := q.next()
+		for !ok {
+			if q.closed {
+				q.mu.Unlock()
+				return
+			}
+			q.mu.Unlock()
+			<-q.wake
+			q.mu.Lock()
+			msg, ok = q.next()
+		}
+		if !msg.deadline.IsZero() && time.Now().After(msg.deadline) {
+			q.expired++
+			q.mu.Unlock()
+			q.budget.release(int64(len(msg.data)))
+			continue
+		}
+		q.mu.Unlock()
+
+		err := q.conn.sendBytes(msg.tp, msg.data)
+		q.budget.release(int64(len(msg.data)))
+		if err != nil {
+			q.mu.Lock()
+			if q.err == nil {
+				q.err = err
+			}
+			q.mu.Unlock()
+			q.budget.release(q.drop(false))
+			return
+		}
+	}
+}
+
+// queuedBytes returns the total size of the queued messages.
+func (q *SendQueue) queuedBytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// drop discards all queued messages and returns the number of bytes freed.
+// If fail is set, the queue stops accepting messages and the connection is
+// closed.
+func (q *SendQueue) drop(fail bool) int64 {
+	q.mu.Lock()
+	freed := q.bytes
+	q.queues = [numPriorities][]queuedMessage{}
+	q.n = 0
+	q.bytes = 0
+	q.checkDrained()
+	if fail && q.err == nil {
+		q.err = ErrQueueFull
+	}
+	q.mu.Unlock()
+
+	if fail {
+		go q.conn.Close(StatusPolicyViolation, "slow consumer")
+	}
+	return freed
+}
+
+// next removes the message with the highest priority from the queue.
+// This must be called with q.mu held.
+func (q *SendQueue) next() (queuedMessage, bool) {
+	for pri := numPriorities - 1; pri >= 0; pri-- {
+		queue := q.queues[pri]
+		if len(queue) == 0 {
+			continue
+		}
+		msg := queue[0]
+		queue[0] = queuedMessage{}
+		q.queues[pri] = queue[1:]
+		q.n--
+		q.bytes -= int64(len(msg.data))
+		q.checkDrained()
+		return msg, true
+	}
+	return queuedMessage{}, false
+}
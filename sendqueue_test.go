@@ -0,0 +1,231 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendQueuePriority(t *testing.T) {
+	server, client := Pipe()
+	q := NewSendQueue(server)
+
+	// The first message is large, so that the queue goroutine blocks
+	// until the client reads it.
+	big := make([]byte, 1<<20)
+	err := q.Send(Binary, big, PriorityBulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for q.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	q.Send(Text, []byte("normal"), PriorityNormal)
+	q.Send(Text, []byte("bulk"), PriorityBulk)
+	q.Send(Text, []byte("high"), PriorityHigh)
+	if q.Len() != 3 {
+		t.Errorf("wrong queue length %d", q.Len())
+	}
+
+	buf := make([]byte, len(big))
+	n, err := client.ReceiveBinary(buf)
+	if err != nil || n != len(big) {
+		t.Fatalf("wrong first message: %d bytes, err=%v", n, err)
+	}
+	for _, expected := range []string{"high", "normal", "bulk"} {
+		msg, err := client.ReceiveText(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg != expected {
+			t.Errorf("expected %q, got %q", expected, msg)
+		}
+	}
+
+	err = q.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	err = q.Send(Text, []byte("late"), PriorityHigh)
+	if err != ErrConnClosed {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+
+	client.Close(StatusOK, "")
+	server.Wait()
+}
+
+func TestSendBudget(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{SlowConsumerDrop, SlowConsumerClose} {
+		budget := &SendBudget{Limit: 1000, Policy: policy}
+
+		var servers, clients [2]*Conn
+		var queues [2]*SendQueue
+		var senders [2]*sender
+		for i := range servers {
+			servers[i], clients[i] = Pipe()
+			go func(c *Conn) {
+				for {
+					_, err := c.ReceiveBinaryAlloc(1 << 20)
+					if err != nil {
+						return
+					}
+				}
+			}(clients[i])
+			servers[i].budget = budget
+			queues[i] = NewSendQueue(servers[i])
+			// block the queue goroutine
+			senders[i] = <-servers[i].senderStore
+		}
+
+		// The first message is taken by the queue goroutine, the following
+		// ones stay in the queue.
+		queues[0].Send(Binary, make([]byte, 100), PriorityNormal)
+		for queues[0].Len() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		queues[0].Send(Binary, make([]byte, 500), PriorityNormal)
+		queues[1].Send(Binary, make([]byte, 300), PriorityNormal)
+		if used := budget.Used(); used != 900 {
+			t.Errorf("wrong budget use %d", used)
+		}
+
+		// This exceeds the budget, and the messages of the first queue
+		// are discarded.
+		err := queues[1].Send(Binary, make([]byte, 300), PriorityNormal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if queues[0].Len() != 0 || queues[1].Len() != 2 {
+			t.Errorf("wrong queue lengths %d, %d", queues[0].Len(), queues[1].Len())
+		}
+		if used := budget.Used(); used != 700 {
+			t.Errorf("wrong budget use %d", used)
+		}
+
+		err = queues[1].Send(Binary, make([]byte, 2000), PriorityNormal)
+		if err != ErrQueueFull {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+
+		err = queues[0].Send(Binary, make([]byte, 10), PriorityNormal)
+		if policy == SlowConsumerClose && err != ErrQueueFull {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		} else if policy == SlowConsumerDrop && err != nil {
+			t.Error(err)
+		}
+
+		for i := range servers {
+			senders[i].release()
+			queues[i].Close()
+			servers[i].Close(StatusOK, "")
+			clients[i].Wait()
+		}
+		if used := budget.Used(); used != 0 {
+			t.Errorf("budget not released: %d", used)
+		}
+		_, status, _ := clients[0].Wait()
+		if policy == SlowConsumerClose && status != StatusPolicyViolation {
+			t.Errorf("wrong status %d", status)
+		}
+	}
+}
+
+func TestSendQueueWaterMarks(t *testing.T) {
+	server, client := Pipe()
+	go func() {
+		for {
+			_, err := client.ReceiveBinaryAlloc(1000)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	q := NewSendQueue(server)
+	q.SetWaterMarks(250, 100)
+	wb := <-server.senderStore // block the queue goroutine
+
+	q.Send(Binary, make([]byte, 100), PriorityNormal)
+	for q.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if q.Full() {
+			t.Errorf("%d: queue full too early", i)
+		}
+		q.Send(Binary, make([]byte, 100), PriorityNormal)
+	}
+	if !q.Full() {
+		t.Error("queue not full")
+	}
+	writable := q.Writable()
+	select {
+	case <-writable:
+		t.Error("full queue is writable")
+	default:
+	}
+
+	wb.release()
+	select {
+	case <-writable:
+	case <-time.After(time.Second):
+		t.Fatal("queue did not drain")
+	}
+	if q.Full() {
+		t.Error("queue still full")
+	}
+
+	q.Close()
+	server.Close(StatusOK, "")
+	client.Wait()
+}
+
+func TestSendQueueDeadline(t *testing.T) {
+	server, client := Pipe()
+	q := NewSendQueue(server)
+	wb := <-server.senderStore // block the queue goroutine
+
+	q.Send(Text, []byte("first"), PriorityNormal)
+	for q.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	now := time.Now()
+	q.SendBefore(Text, []byte("stale"), PriorityNormal, now.Add(10*time.Millisecond))
+	q.SendBefore(Text, []byte("fresh"), PriorityNormal, now.Add(time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	wb.release()
+
+	for _, expected := range []string{"first", "fresh"} {
+		msg, err := client.ReceiveText(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg != expected {
+			t.Errorf("expected %q, got %q", expected, msg)
+		}
+	}
+	q.Close()
+	if n := q.Expired(); n != 1 {
+		t.Errorf("wrong number of expired messages %d", n)
+	}
+
+	client.Close(StatusOK, "")
+	server.Wait()
+}
@@ -0,0 +1,206 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package seq adds sequence numbers to the messages sent over a websocket
+// connection, to support gap detection and resuming a conversation on a
+// new connection.
+//
+// Every message carries the sequence number assigned by the sender.  The
+// first message on a connection has sequence number 1, unless a different
+// starting point is set using SetNextSend.  Binary messages consist of the
+// sequence number as an 8 byte big-endian integer, followed by the
+// payload.  Text messages consist of the sequence number in decimal, a ':'
+// character, and the payload.
+package seq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"seehuhn.de/go/websocket"
+)
+
+// DefaultMaxMessageSize is the default value for Conn.MaxMessageSize.
+const DefaultMaxMessageSize = 1 << 20
+
+// Conn adds sequence numbers to outgoing messages, and checks the sequence
+// numbers of incoming messages.
+type Conn struct {
+	// MaxMessageSize is the maximal size of received messages, including
+	// the sequence number.  This must be set before the first call to
+	// Receive.
+	MaxMessageSize int
+
+	ws *websocket.Conn
+
+	sendMu   sync.Mutex
+	lastSent uint64
+
+	recvMu   sync.Mutex
+	lastRecv uint64
+}
+
+// NewConn returns a new Conn which sends and receives messages on ws.
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{
+		MaxMessageSize: DefaultMaxMessageSize,
+		ws:             ws,
+	}
+}
+
+// SetNextSend sets the sequence number of the next message sent.  This can
+// be used to continue the numbering of a previous connection.
+func (c *Conn) SetNextSend(seq uint64) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	c.lastSent = seq - 1
+}
+
+// SetLastReceived sets the sequence number of the last message received,
+// so that the next message is expected to have sequence number seq+1.
+// This can be used to continue the numbering of a previous connection.
+func (c *Conn) SetLastReceived(seq uint64) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	c.lastRecv = seq
+}
+
+// LastSent returns the sequence number of the last message sent, or 0 if
+// no messages have been sent yet.
+func (c *Conn) LastSent() uint64 {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.lastSent
+}
+
+// LastReceived returns the sequence number of the last message received,
+// or 0 if no messages have been received yet.
+func (c *Conn) LastReceived() uint64 {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	return c.lastRecv
+}
+
+// Send sends a message with the next sequence number.  The sequence number
+// is returned.
+func (c *Conn) Send(tp websocket.MessageType, payload []byte) (uint64, error) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	seq := c.lastSent + 1
+	var err error
+	switch tp {
+	case websocket.Text:
+		msg := strconv.AppendUint(nil, seq, 10)
+		msg = append(msg, ':')
+		msg = append(msg, payload...)
+		err = c.ws.SendText(string(msg))
+	case websocket.Binary:
+		msg := make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint64(msg, seq)
+		copy(msg[8:], payload)
+		err = c.ws.SendBinary(msg)
+	default:
+		return 0, websocket.ErrMessageType
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.lastSent = seq
+	return seq, nil
+}
+
+// Receive reads the next message.  The payload is returned without the
+// sequence number.
+//
+// If the sequence number is not the successor of the previous one, the
+// message is returned together with a *GapError.  Afterwards, LastReceived
+// returns the sequence number of this message.  If a message has no valid
+// sequence number, the connection is closed and ErrFormat is returned.
+func (c *Conn) Receive() (websocket.MessageType, uint64, []byte, error) {
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+
+	tp, r, err := c.ws.ReceiveMessage()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	msg, err := io.ReadAll(io.LimitReader(r, int64(c.MaxMessageSize)+1))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(msg) > c.MaxMessageSize {
+		io.Copy(io.Discard, r)
+		c.ws.Close(websocket.StatusTooLarge, "")
+		return 0, 0, nil, websocket.ErrTooLarge
+	}
+
+	var seq uint64
+	var payload []byte
+	ok := false
+	switch tp {
+	case websocket.Text:
+		for i, b := range msg {
+			if b == ':' {
+				seq, err = strconv.ParseUint(string(msg[:i]), 10, 64)
+				payload = msg[i+1:]
+				ok = err == nil && i > 0 && (msg[0] != '0' || i == 1)
+				break
+			}
+		}
+	case websocket.Binary:
+		if len(msg) >= 8 {
+			seq = binary.BigEndian.Uint64(msg)
+			payload = msg[8:]
+			ok = true
+		}
+	}
+	if !ok {
+		c.ws.Close(websocket.StatusInvalidData, "missing sequence number")
+		return 0, 0, nil, ErrFormat
+	}
+
+	expected := c.lastRecv + 1
+	c.lastRecv = seq
+	if seq != expected {
+		return tp, seq, payload, &GapError{Expected: expected, Got: seq}
+	}
+	return tp, seq, payload, nil
+}
+
+// Close closes the underlying websocket connection.
+func (c *Conn) Close(code websocket.Status, message string) error {
+	return c.ws.Close(code, message)
+}
+
+// GapError is returned by Receive if messages were missing, duplicated or
+// reordered.
+type GapError struct {
+	Expected uint64 // the expected sequence number
+	Got      uint64 // the sequence number of the received message
+}
+
+func (err *GapError) Error() string {
+	return fmt.Sprintf("seq: expected message %d, got %d", err.Expected, err.Got)
+}
+
+// ErrFormat is returned by Receive if a message does not start with a valid
+// sequence number.
+var ErrFormat = errors.New("seq: missing sequence number")
@@ -0,0 +1,86 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package seq
+
+import (
+	"errors"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+func TestSeq(t *testing.T) {
+	server, client := websocket.Pipe()
+	s := NewConn(server)
+	c := NewConn(client)
+
+	done := make(chan error, 1)
+	go func() {
+		for _, tp := range []websocket.MessageType{websocket.Text, websocket.Binary} {
+			_, err := s.Send(tp, []byte("hello"))
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		s.SetNextSend(10)
+		_, err := s.Send(websocket.Text, []byte("later"))
+		done <- err
+	}()
+
+	for i, tp := range []websocket.MessageType{websocket.Text, websocket.Binary} {
+		rtp, seq, payload, err := c.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtp != tp || seq != uint64(i+1) || string(payload) != "hello" {
+			t.Errorf("wrong message %s %d %q", rtp, seq, payload)
+		}
+	}
+	_, seq, payload, err := c.Receive()
+	var gap *GapError
+	if !errors.As(err, &gap) || gap.Expected != 3 || gap.Got != 10 {
+		t.Errorf("expected a gap error, got %v", err)
+	}
+	if seq != 10 || string(payload) != "later" {
+		t.Errorf("wrong message %d %q", seq, payload)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s.LastSent() != 10 || c.LastReceived() != 10 {
+		t.Errorf("wrong last sequence numbers %d, %d", s.LastSent(), c.LastReceived())
+	}
+
+	s.Close(websocket.StatusOK, "")
+	client.Wait()
+}
+
+func TestBadFormat(t *testing.T) {
+	server, client := websocket.Pipe()
+	c := NewConn(client)
+
+	go server.SendText("no sequence number")
+	_, _, _, err := c.Receive()
+	if err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	_, status, _ := server.Wait()
+	if status != websocket.StatusInvalidData {
+		t.Errorf("wrong status %d", status)
+	}
+}
@@ -0,0 +1,63 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package websocket
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is the TCP_USER_TIMEOUT socket option, which is missing
+// from the syscall package.
+const tcpUserTimeout = 0x12
+
+// setUserTimeout sets the TCP_USER_TIMEOUT socket option on a TCP
+// connection.  Other connections are left unchanged.
+func setUserTimeout(conn net.Conn, timeout time.Duration) error {
+	if nc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		// a TLS connection
+		conn = nc.NetConn()
+	}
+	if _, isTCP := conn.LocalAddr().(*net.TCPAddr); !isTCP {
+		return nil
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	ms := int(timeout / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, ms)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,60 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTCPUserTimeout(t *testing.T) {
+	timeout := make(chan int, 1)
+	server := httptest.NewServer(&Handler{
+		TCPUserTimeout: 5 * time.Second,
+		ConnConfig: func(conn net.Conn) error {
+			rc, err := conn.(*net.TCPConn).SyscallConn()
+			if err != nil {
+				return err
+			}
+			return rc.Control(func(fd uintptr) {
+				ms, _ := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout)
+				timeout <- ms
+			})
+		},
+		Handle: echo,
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, err := DefaultDialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(StatusOK, "")
+
+	if ms := <-timeout; ms != 5000 {
+		t.Errorf("wrong TCP_USER_TIMEOUT %d", ms)
+	}
+}
@@ -0,0 +1,153 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats collects counters for the connections of a Handler.  To use
+// Stats, set the Stats field of the Handler, and call Publish to make the
+// counters available via the expvar package:
+//
+//	stats := &websocket.Stats{}
+//	stats.Publish("websocket")
+//	handler := &websocket.Handler{Handle: handle, Stats: stats}
+//
+// The same Stats object can be used for several handlers.
+type Stats struct {
+	// The counters are accessed atomically, and are kept at the start of
+	// the struct to ensure 64-bit alignment.
+	open        int64
+	handshakes  int64
+	rejects     int64
+	received    int64
+	sent        int64
+	pings       int64
+	pongs       int64
+	unsolicited int64
+
+	mu        sync.Mutex // protects lastCount and lastTime
+	lastCount int64
+	lastTime  time.Time
+}
+
+// StatsSnapshot contains the values of the counters in a [Stats] object.
+type StatsSnapshot struct {
+	Open       int64 `json:"open"`       // currently open connections
+	Handshakes int64 `json:"handshakes"` // successful handshakes
+	Rejects    int64 `json:"rejects"`    // rejected handshakes
+	Received   int64 `json:"received"`   // messages received
+	Sent       int64 `json:"sent"`       // messages sent
+
+	Pings            int64 `json:"pings"`             // ping frames received
+	Pongs            int64 `json:"pongs"`             // pong frames received
+	UnsolicitedPongs int64 `json:"unsolicited_pongs"` // see ControlCounts
+
+	// MessagesPerSecond is the number of messages received and sent per
+	// second, averaged over the time since the previous call to
+	// Snapshot.
+	MessagesPerSecond float64 `json:"messages_per_second"`
+}
+
+// Snapshot returns the current values of the counters.
+func (s *Stats) Snapshot() StatsSnapshot {
+	res := StatsSnapshot{
+		Open:       atomic.LoadInt64(&s.open),
+		Handshakes: atomic.LoadInt64(&s.handshakes),
+		Rejects:    atomic.LoadInt64(&s.rejects),
+		Received:   atomic.LoadInt64(&s.received),
+		Sent:       atomic.LoadInt64(&s.sent),
+
+		Pings:            atomic.LoadInt64(&s.pings),
+		Pongs:            atomic.LoadInt64(&s.pongs),
+		UnsolicitedPongs: atomic.LoadInt64(&s.unsolicited),
+	}
+
+	total := res.Received + res.Sent
+	now := time.Now()
+	s.mu.Lock()
+	if !s.lastTime.IsZero() {
+		if dt := now.Sub(s.lastTime).Seconds(); dt > 0 {
+			res.MessagesPerSecond = float64(total-s.lastCount) / dt
+		}
+	}
+	s.lastCount = total
+	s.lastTime = now
+	s.mu.Unlock()
+
+	return res
+}
+
+// Publish makes the counters available as the expvar variable with the
+// given name.  Like expvar.Publish, this panics if the name is already in
+// use.
+func (s *Stats) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Snapshot()
+	}))
+}
+
+// The following methods can be called on a nil *Stats.
+
+func (s *Stats) connOpened() {
+	if s != nil {
+		atomic.AddInt64(&s.handshakes, 1)
+		atomic.AddInt64(&s.open, 1)
+	}
+}
+
+func (s *Stats) connClosed() {
+	if s != nil {
+		atomic.AddInt64(&s.open, -1)
+	}
+}
+
+func (s *Stats) handshakeRejected() {
+	if s != nil {
+		atomic.AddInt64(&s.rejects, 1)
+	}
+}
+
+func (s *Stats) messageReceived() {
+	if s != nil {
+		atomic.AddInt64(&s.received, 1)
+	}
+}
+
+func (s *Stats) messageSent() {
+	if s != nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+}
+
+func (s *Stats) controlReceived(opcode MessageType, unsolicited bool) {
+	if s == nil {
+		return
+	}
+	if opcode == pingFrame {
+		atomic.AddInt64(&s.pings, 1)
+		return
+	}
+	atomic.AddInt64(&s.pongs, 1)
+	if unsolicited {
+		atomic.AddInt64(&s.unsolicited, 1)
+	}
+}
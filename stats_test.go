@@ -0,0 +1,100 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	stats := &Stats{}
+	done := make(chan struct{})
+	handler := &Handler{
+		Handle: func(conn *Conn) {
+			for {
+				msg, err := conn.ReceiveText(100)
+				if err != nil {
+					break
+				}
+				conn.SendText(msg)
+			}
+			conn.Wait()
+			close(done)
+		},
+		Stats: stats,
+	}
+	server, err := StartTestServerWithHandler(handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// a request without the websocket headers is rejected
+	httpServer := httptest.NewServer(handler)
+	resp, err := http.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	httpServer.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		err = client.SendFrame(Text, []byte("hello"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := stats.Snapshot()
+	if s.Open != 1 || s.Handshakes != 1 || s.Rejects != 1 || s.Received != 2 || s.Sent != 2 {
+		t.Errorf("wrong counters %+v", s)
+	}
+
+	err = client.SendFrame(closeFrame, []byte{0x03, 0xe8}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if s := stats.Snapshot(); s.Open != 0 || s.Handshakes != 1 {
+		t.Errorf("wrong counters after close %+v", s)
+	}
+
+	name := fmt.Sprintf("websocket-test-%p", stats)
+	stats.Publish(name)
+	var published StatsSnapshot
+	err = json.Unmarshal([]byte(expvar.Get(name).String()), &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published.Received != 2 || published.Sent != 2 {
+		t.Errorf("wrong published counters %+v", published)
+	}
+}
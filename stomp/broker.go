@@ -0,0 +1,412 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package stomp implements a minimal STOMP 1.2 message broker over
+// websocket connections.
+//
+// Each websocket message carries exactly one STOMP frame.  The broker
+// supports the CONNECT/STOMP, SEND, SUBSCRIBE, UNSUBSCRIBE, ACK, NACK and
+// DISCONNECT frames, and sends RECEIPT frames on request.  Transactions
+// and heart-beats are not supported.  Messages sent to a destination are
+// delivered to all sessions currently subscribed to that destination;
+// messages are not stored and are not redelivered.
+//
+// Use a Broker as the Handle function of a websocket handler:
+//
+//	broker := &stomp.Broker{}
+//	handler := &websocket.Handler{
+//		Handle:       broker.Handle,
+//		Subprotocols: stomp.Subprotocols,
+//	}
+//
+// See https://stomp.github.io/stomp-specification-1.2.html for the
+// protocol specification.
+package stomp
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Subprotocols lists the websocket sub-protocol names used by STOMP 1.2
+// clients, for use in websocket.Handler.Subprotocols.
+var Subprotocols = []string{"v12.stomp"}
+
+// defaultMaxFrameSize is used if Broker.MaxFrameSize is zero.
+const defaultMaxFrameSize = 64 * 1024
+
+// Broker routes STOMP messages between websocket clients.  The zero value
+// is a broker without restrictions, ready for use.
+type Broker struct {
+	// MaxFrameSize is the maximal size of frames accepted from clients, in
+	// bytes.  If this is zero, a limit of 64 KiB is used.
+	MaxFrameSize int
+
+	// OnSend, if set, is called for every SEND frame received from a client,
+	// before the message is delivered to the subscribers.  If OnSend
+	// returns an error, the message is not delivered, an ERROR frame is
+	// sent to the client and the connection is closed.
+	OnSend func(conn *websocket.Conn, f *Frame) error
+
+	mu     sync.Mutex
+	subs   map[string]map[*subscription]bool // indexed by destination
+	nextID uint64
+}
+
+type subscription struct {
+	s           *session
+	id          string
+	destination string
+	ack         string // "auto", "client" or "client-individual"
+}
+
+type session struct {
+	b    *Broker
+	conn *websocket.Conn
+	subs map[string]*subscription // indexed by subscription id
+
+	mu      sync.Mutex
+	unacked []pendingAck // in the order the messages were sent
+}
+
+type pendingAck struct {
+	id  string
+	sub *subscription
+}
+
+// Handle runs a STOMP session on conn.  The function returns once the
+// client has disconnected, and closes conn.
+func (b *Broker) Handle(conn *websocket.Conn) {
+	s := &session{
+		b:    b,
+		conn: conn,
+		subs: make(map[string]*subscription),
+	}
+	defer s.unsubscribeAll()
+
+	status := websocket.StatusOK
+	connected := false
+	for {
+		f, err := s.readFrame()
+		if errors.Is(err, websocket.ErrConnClosed) {
+			return
+		} else if err != nil {
+			s.sendError(err.Error())
+			status = websocket.StatusProtocolError
+			break
+		} else if f == nil {
+			continue
+		}
+
+		if !connected {
+			err = s.connect(f)
+			if err != nil {
+				s.sendError(err.Error())
+				break
+			}
+			connected = true
+			continue
+		}
+
+		if f.Command == "DISCONNECT" {
+			s.sendReceipt(f)
+			break
+		}
+		err = s.handle(f)
+		if err != nil {
+			s.sendError(err.Error())
+			break
+		}
+		s.sendReceipt(f)
+	}
+	conn.Close(status, "")
+}
+
+// Publish sends a message to all clients subscribed to the given
+// destination.  The given header fields are included in the MESSAGE
+// frames.
+func (b *Broker) Publish(destination string, header []HeaderField, body []byte) {
+	b.mu.Lock()
+	subs := make([]*subscription, 0, len(b.subs[destination]))
+	for sub := range b.subs[destination] {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.s.deliver(sub, header, body)
+	}
+}
+
+func (b *Broker) messageID() string {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.mu.Unlock()
+	return strconv.FormatUint(id, 10)
+}
+
+func (s *session) readFrame() (*Frame, error) {
+	maxSize := s.b.MaxFrameSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFrameSize
+	}
+
+	_, r, err := s.conn.ReceiveMessage()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		io.Copy(io.Discard, r)
+		return nil, errFrameTooLarge
+	}
+	return Decode(data)
+}
+
+func (s *session) connect(f *Frame) error {
+	if f.Command != "CONNECT" && f.Command != "STOMP" {
+		return errNotConnected
+	}
+	versions := strings.Split(f.Get("accept-version"), ",")
+	supported := false
+	for _, v := range versions {
+		if v == "1.2" {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return errVersion
+	}
+
+	reply := &Frame{Command: "CONNECTED"}
+	reply.Add("version", "1.2")
+	reply.Add("heart-beat", "0,0")
+	return s.send(reply)
+}
+
+func (s *session) handle(f *Frame) error {
+	switch f.Command {
+	case "SEND":
+		destination := f.Get("destination")
+		if destination == "" {
+			return errMissingHeader("destination")
+		}
+		if _, ok := f.lookup("transaction"); ok {
+			return errTransaction
+		}
+		if s.b.OnSend != nil {
+			err := s.b.OnSend(s.conn, f)
+			if err != nil {
+				return err
+			}
+		}
+		var header []HeaderField
+		for _, h := range f.Header {
+			switch h.Key {
+			case "destination", "receipt", "content-length":
+				// set by the broker
+			default:
+				header = append(header, h)
+			}
+		}
+		s.b.Publish(destination, header, f.Body)
+
+	case "SUBSCRIBE":
+		id := f.Get("id")
+		if id == "" {
+			return errMissingHeader("id")
+		}
+		destination := f.Get("destination")
+		if destination == "" {
+			return errMissingHeader("destination")
+		}
+		if s.subs[id] != nil {
+			return errors.New("duplicate subscription id " + id)
+		}
+		ack := f.Get("ack")
+		switch ack {
+		case "":
+			ack = "auto"
+		case "auto", "client", "client-individual":
+			// pass
+		default:
+			return errors.New("invalid ack mode " + ack)
+		}
+		sub := &subscription{
+			s:           s,
+			id:          id,
+			destination: destination,
+			ack:         ack,
+		}
+		s.subs[id] = sub
+		s.b.mu.Lock()
+		if s.b.subs == nil {
+			s.b.subs = make(map[string]map[*subscription]bool)
+		}
+		if s.b.subs[destination] == nil {
+			s.b.subs[destination] = make(map[*subscription]bool)
+		}
+		s.b.subs[destination][sub] = true
+		s.b.mu.Unlock()
+
+	case "UNSUBSCRIBE":
+		id := f.Get("id")
+		sub := s.subs[id]
+		if sub == nil {
+			return errors.New("unknown subscription id " + id)
+		}
+		s.unsubscribe(sub)
+
+	case "ACK", "NACK":
+		id := f.Get("id")
+		if id == "" {
+			return errMissingHeader("id")
+		}
+		if _, ok := f.lookup("transaction"); ok {
+			return errTransaction
+		}
+		if !s.acknowledge(id) {
+			return errors.New("unknown message id " + id)
+		}
+
+	case "BEGIN", "COMMIT", "ABORT":
+		return errTransaction
+
+	default:
+		return errors.New("unknown command " + f.Command)
+	}
+	return nil
+}
+
+func (s *session) deliver(sub *subscription, header []HeaderField, body []byte) {
+	id := s.b.messageID()
+	f := &Frame{Command: "MESSAGE"}
+	f.Add("subscription", sub.id)
+	f.Add("message-id", id)
+	f.Add("destination", sub.destination)
+	if sub.ack != "auto" {
+		f.Add("ack", id)
+		s.mu.Lock()
+		s.unacked = append(s.unacked, pendingAck{id: id, sub: sub})
+		s.mu.Unlock()
+	}
+	f.Header = append(f.Header, header...)
+	f.Body = body
+	s.send(f)
+}
+
+// acknowledge processes an ACK or NACK frame.  Since messages are never
+// redelivered, both frames have the same effect.
+func (s *session) acknowledge(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := -1
+	for i, p := range s.unacked {
+		if p.id == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+
+	sub := s.unacked[idx].sub
+	keep := s.unacked[:0]
+	for i, p := range s.unacked {
+		// In "client" mode, the acknowledgement is cumulative.
+		if i == idx || i < idx && p.sub == sub && sub.ack == "client" {
+			continue
+		}
+		keep = append(keep, p)
+	}
+	s.unacked = keep
+	return true
+}
+
+func (s *session) unsubscribe(sub *subscription) {
+	delete(s.subs, sub.id)
+
+	s.b.mu.Lock()
+	delete(s.b.subs[sub.destination], sub)
+	if len(s.b.subs[sub.destination]) == 0 {
+		delete(s.b.subs, sub.destination)
+	}
+	s.b.mu.Unlock()
+
+	s.mu.Lock()
+	keep := s.unacked[:0]
+	for _, p := range s.unacked {
+		if p.sub != sub {
+			keep = append(keep, p)
+		}
+	}
+	s.unacked = keep
+	s.mu.Unlock()
+}
+
+func (s *session) unsubscribeAll() {
+	for _, sub := range s.subs {
+		s.unsubscribe(sub)
+	}
+}
+
+func (s *session) send(f *Frame) error {
+	data := f.Encode()
+	if utf8.Valid(data) {
+		return s.conn.SendText(string(data))
+	}
+	return s.conn.SendBinary(data)
+}
+
+func (s *session) sendReceipt(f *Frame) {
+	receipt, ok := f.lookup("receipt")
+	if !ok {
+		return
+	}
+	reply := &Frame{Command: "RECEIPT"}
+	reply.Add("receipt-id", receipt)
+	s.send(reply)
+}
+
+func (s *session) sendError(message string) {
+	reply := &Frame{Command: "ERROR"}
+	reply.Add("message", message)
+	s.send(reply)
+}
+
+func errMissingHeader(key string) error {
+	return errors.New("missing " + key + " header")
+}
+
+var (
+	errFrameTooLarge = errors.New("frame too large")
+	errNotConnected  = errors.New("expected CONNECT frame")
+	errVersion       = errors.New("only STOMP version 1.2 is supported")
+	errTransaction   = errors.New("transactions are not supported")
+)
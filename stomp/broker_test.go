@@ -0,0 +1,132 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stomp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func (c *testClient) send(f *Frame) {
+	c.t.Helper()
+	err := c.conn.SendText(string(f.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) expect(command string) *Frame {
+	c.t.Helper()
+	msg, err := c.conn.ReceiveText(1024)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	f, err := Decode([]byte(msg))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if f.Command != command {
+		c.t.Fatalf("expected %s, got %s %v", command, f.Command, f.Header)
+	}
+	return f
+}
+
+func connect(t *testing.T, url string) *testClient {
+	t.Helper()
+	dialer := &websocket.Dialer{
+		Header: http.Header{"Sec-Websocket-Protocol": {"v12.stomp"}},
+	}
+	conn, err := dialer.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Protocol != "v12.stomp" {
+		t.Errorf("wrong subprotocol %q", conn.Protocol)
+	}
+	c := &testClient{t: t, conn: conn}
+	c.send(&Frame{Command: "CONNECT", Header: []HeaderField{
+		{"accept-version", "1.0,1.1,1.2"}, {"host", "localhost"},
+	}})
+	f := c.expect("CONNECTED")
+	if f.Get("version") != "1.2" {
+		t.Errorf("wrong version %q", f.Get("version"))
+	}
+	return c
+}
+
+func TestBroker(t *testing.T) {
+	broker := &Broker{}
+	server := httptest.NewServer(&websocket.Handler{
+		Handle:       broker.Handle,
+		Subprotocols: Subprotocols,
+	})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	sub := connect(t, url)
+	sub.send(&Frame{Command: "SUBSCRIBE", Header: []HeaderField{
+		{"id", "0"}, {"destination", "/topic/a"}, {"ack", "client"},
+		{"receipt", "r1"},
+	}})
+	if f := sub.expect("RECEIPT"); f.Get("receipt-id") != "r1" {
+		t.Errorf("wrong receipt id %q", f.Get("receipt-id"))
+	}
+
+	pub := connect(t, url)
+	pub.send(&Frame{Command: "SEND", Header: []HeaderField{
+		{"destination", "/topic/a"}, {"content-type", "text/plain"},
+	}, Body: []byte("hello")})
+
+	msg := sub.expect("MESSAGE")
+	if msg.Get("subscription") != "0" || msg.Get("destination") != "/topic/a" ||
+		msg.Get("content-type") != "text/plain" || string(msg.Body) != "hello" {
+		t.Errorf("wrong message %v %q", msg.Header, msg.Body)
+	}
+
+	sub.send(&Frame{Command: "ACK", Header: []HeaderField{
+		{"id", msg.Get("ack")}, {"receipt", "r2"},
+	}})
+	sub.expect("RECEIPT")
+
+	// the message has already been acknowledged
+	sub.send(&Frame{Command: "ACK", Header: []HeaderField{
+		{"id", msg.Get("ack")},
+	}})
+	sub.expect("ERROR")
+	sub.conn.Wait()
+	sub.conn.Close(websocket.StatusOK, "")
+
+	pub.send(&Frame{Command: "DISCONNECT", Header: []HeaderField{
+		{"receipt", "bye"},
+	}})
+	pub.expect("RECEIPT")
+	_, status, _ := pub.conn.Wait()
+	if status != websocket.StatusOK {
+		t.Errorf("wrong status %d", status)
+	}
+	pub.conn.Close(websocket.StatusOK, "")
+}
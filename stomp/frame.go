@@ -0,0 +1,216 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Frame is a STOMP frame.
+type Frame struct {
+	Command string
+	Header  []HeaderField
+	Body    []byte
+}
+
+// HeaderField is a single STOMP header.
+type HeaderField struct {
+	Key, Value string
+}
+
+// Get returns the value of the header with the given key.  If the header is
+// repeated, the first value is returned, as required by the specification.
+// If the header is not present, the empty string is returned.
+func (f *Frame) Get(key string) string {
+	value, _ := f.lookup(key)
+	return value
+}
+
+func (f *Frame) lookup(key string) (string, bool) {
+	for _, h := range f.Header {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
+
+// Add appends a header to the frame.
+func (f *Frame) Add(key, value string) {
+	f.Header = append(f.Header, HeaderField{key, value})
+}
+
+// Encode returns the wire representation of the frame.  A content-length
+// header is added, if the body is non-empty and the frame does not already
+// have one.
+func (f *Frame) Encode() []byte {
+	escape := f.Command != "CONNECT" && f.Command != "CONNECTED"
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	for _, h := range f.Header {
+		if escape {
+			buf.WriteString(headerEscaper.Replace(h.Key))
+			buf.WriteByte(':')
+			buf.WriteString(headerEscaper.Replace(h.Value))
+		} else {
+			buf.WriteString(h.Key)
+			buf.WriteByte(':')
+			buf.WriteString(h.Value)
+		}
+		buf.WriteByte('\n')
+	}
+	if _, ok := f.lookup("content-length"); !ok && len(f.Body) > 0 {
+		buf.WriteString("content-length:")
+		buf.WriteString(strconv.Itoa(len(f.Body)))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// Decode parses a STOMP frame.  The data must contain exactly one frame,
+// optionally followed by end-of-line characters.  If data consists only
+// of end-of-line characters (a heart-beat), nil is returned without an
+// error.
+func Decode(data []byte) (*Frame, error) {
+	// skip heart-beats
+	data = bytes.TrimLeft(data, "\r\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	line, data, err := nextLine(data)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errMalformed
+	}
+	f := &Frame{Command: line}
+	unescape := f.Command != "CONNECT" && f.Command != "CONNECTED"
+
+	for {
+		line, data, err = nextLine(data)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx < 0 {
+			return nil, errMalformed
+		}
+		key, value := line[:idx], line[idx+1:]
+		if unescape {
+			key, err = unescapeHeader(key)
+			if err == nil {
+				value, err = unescapeHeader(value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		f.Add(key, value)
+	}
+
+	var body []byte
+	if l, ok := f.lookup("content-length"); ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 || n >= len(data) || data[n] != 0 {
+			return nil, errMalformed
+		}
+		body = data[:n]
+		data = data[n+1:]
+	} else {
+		idx := bytes.IndexByte(data, 0)
+		if idx < 0 {
+			return nil, errMalformed
+		}
+		body = data[:idx]
+		data = data[idx+1:]
+	}
+	if len(bytes.TrimLeft(data, "\r\n")) > 0 {
+		return nil, errMalformed
+	}
+	if len(body) > 0 {
+		f.Body = append([]byte(nil), body...)
+	}
+	return f, nil
+}
+
+// nextLine splits off the next line from data.  Lines may be terminated by
+// either "\n" or "\r\n".
+func nextLine(data []byte) (string, []byte, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx < 0 {
+		return "", nil, errMalformed
+	}
+	line := data[:idx]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), data[idx+1:], nil
+}
+
+var headerEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\r", "\\r",
+	"\n", "\\n",
+	":", "\\c",
+)
+
+func unescapeHeader(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return "", errMalformed
+		}
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		default:
+			// undefined escape sequences are fatal errors
+			return "", errMalformed
+		}
+	}
+	return b.String(), nil
+}
+
+var errMalformed = errors.New("malformed STOMP frame")
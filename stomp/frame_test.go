@@ -0,0 +1,93 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stomp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		{Command: "CONNECT", Header: []HeaderField{
+			{"accept-version", "1.2"}, {"host", "example.com"},
+		}},
+		{Command: "SEND", Header: []HeaderField{
+			{"destination", "/queue/a"}, {"content-length", "5"},
+		}, Body: []byte("a\x00b\nc")},
+		{Command: "MESSAGE", Header: []HeaderField{
+			{"key:with\\special\nchars\r", "value:with\\special\nchars"},
+			{"repeated", "1"}, {"repeated", "2"},
+		}, Body: []byte("hello")},
+		{Command: "RECEIPT", Header: []HeaderField{{"receipt-id", "77"}}},
+	}
+	for _, f := range frames {
+		data := f.Encode()
+		g, err := Decode(data)
+		if err != nil {
+			t.Errorf("%s: %v", f.Command, err)
+			continue
+		}
+		// Encode adds content-length for frames with a body.
+		if _, ok := f.lookup("content-length"); !ok && len(f.Body) > 0 {
+			g.Header = g.Header[:len(g.Header)-1]
+		}
+		if !reflect.DeepEqual(f, g) {
+			t.Errorf("%s: round trip failed: %v", f.Command, g)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// stomp.js uses \n line endings, but \r\n must be accepted, too
+	f, err := Decode([]byte("SEND\r\ndestination:/a\r\nx:1\r\n\r\nbody\x00\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != "SEND" || f.Get("destination") != "/a" || f.Get("x") != "1" ||
+		!bytes.Equal(f.Body, []byte("body")) {
+		t.Errorf("wrong frame %v", f)
+	}
+
+	f, err = Decode([]byte("\n"))
+	if f != nil || err != nil {
+		t.Errorf("heart-beat: got %v, %v", f, err)
+	}
+
+	// The CONNECT frame does not use escape sequences.
+	f, err = Decode([]byte("CONNECT\npasscode:a\\b\n\n\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Get("passcode") != "a\\b" {
+		t.Errorf("wrong passcode %q", f.Get("passcode"))
+	}
+
+	for _, bad := range []string{
+		"SEND\ndestination:/a\n\nbody",                     // missing NUL
+		"SEND\nno-colon\n\n\x00",                           // invalid header
+		"SEND\nkey:\\t\n\n\x00",                            // invalid escape
+		"SEND\ncontent-length:10\n\nshort\x00",             // wrong length
+		"SEND\ndestination:/a\n\nbody\x00trailing garbage", // extra data
+	} {
+		_, err := Decode([]byte(bad))
+		if err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
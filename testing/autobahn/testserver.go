@@ -18,6 +18,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -120,7 +121,7 @@ func echo(conn *websocket.Conn) {
 
 	for {
 		tp, r, err := conn.ReceiveMessage()
-		if err == websocket.ErrConnClosed {
+		if errors.Is(err, websocket.ErrConnClosed) {
 			break
 		} else if err != nil {
 			log.Println("read error:", err)
@@ -145,7 +146,7 @@ func echo(conn *websocket.Conn) {
 		}
 
 		err = w.Close()
-		if err != nil && err != websocket.ErrConnClosed {
+		if err != nil && !errors.Is(err, websocket.ErrConnClosed) {
 			log.Println("close error:", err)
 		}
 	}
@@ -0,0 +1,372 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package conformance
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/wstest"
+)
+
+// echo returns all messages to the client.  Text messages are checked
+// for valid utf-8, since ReceiveMessage leaves this to the application.
+func echo(conn *websocket.Conn) {
+	defer conn.Close(websocket.StatusOK, "")
+	for {
+		tp, r, err := conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return
+		}
+		if tp == websocket.Text && !utf8.Valid(data) {
+			conn.Close(websocket.StatusInvalidData, "")
+			return
+		}
+		w, err := conn.SendMessage(tp)
+		if err != nil {
+			return
+		}
+		w.Write(data)
+		w.Close()
+	}
+}
+
+// echoText is like echo, but uses ReceiveText, which checks for
+// valid utf-8 itself.
+func echoText(conn *websocket.Conn) {
+	defer conn.Close(websocket.StatusOK, "")
+	for {
+		msg, err := conn.ReceiveText(1 << 20)
+		if err != nil {
+			return
+		}
+		err = conn.SendText(msg)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// testCase sends frames to the server, and then checks the response.
+// If close is zero, the server must echo the message want; otherwise
+// the server must close the connection with one of the status codes in
+// close.
+type testCase struct {
+	name   string
+	frames []*wstest.Frame
+	want   []byte
+	close  []uint16
+}
+
+func text(s string, final bool) *wstest.Frame {
+	return &wstest.Frame{Opcode: wstest.Text, Payload: []byte(s), Final: final}
+}
+
+func cont(s string, final bool) *wstest.Frame {
+	return &wstest.Frame{Opcode: wstest.Continuation, Payload: []byte(s), Final: final}
+}
+
+func control(op wstest.Opcode, payload []byte) *wstest.Frame {
+	return &wstest.Frame{Opcode: op, Payload: payload, Final: true}
+}
+
+func closeFrame(status uint16, reason string) *wstest.Frame {
+	payload := append([]byte{byte(status >> 8), byte(status)}, reason...)
+	return control(wstest.Close, payload)
+}
+
+func run(t *testing.T, handle func(*websocket.Conn), cases []testCase) {
+	server := httptest.NewServer(&websocket.Handler{Handle: handle})
+	defer server.Close()
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := wstest.Dial(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			for _, f := range tc.frames {
+				err = client.WriteFrame(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tc.close == nil {
+				_, data, err := client.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, tc.want) {
+					t.Fatalf("wrong echo: %q", data)
+				}
+				status, err := client.CloseHandshake(1000, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status != 1000 {
+					t.Errorf("wrong close status %d", status)
+				}
+				return
+			}
+
+			for {
+				_, _, err = client.ReadMessage()
+				if err != nil {
+					break
+				}
+			}
+			closeErr, ok := err.(*wstest.CloseError)
+			if !ok {
+				t.Fatalf("expected close frame, got %v", err)
+			}
+			for _, status := range tc.close {
+				if closeErr.Status == status {
+					return
+				}
+			}
+			t.Errorf("wrong close status %d, expected one of %d",
+				closeErr.Status, tc.close)
+		})
+	}
+}
+
+func TestFraming(t *testing.T) {
+	var cases []testCase
+	for _, n := range []int{0, 125, 126, 127, 128, 65535, 65536} {
+		msg := strings.Repeat("*", n)
+		cases = append(cases, testCase{
+			name:   "1.1/text_" + strconv.Itoa(n),
+			frames: []*wstest.Frame{text(msg, true)},
+			want:   []byte(msg),
+		})
+		bin := bytes.Repeat([]byte{0xfe}, n)
+		cases = append(cases, testCase{
+			name:   "1.2/binary_" + strconv.Itoa(n),
+			frames: []*wstest.Frame{{Opcode: wstest.Binary, Payload: bin, Final: true}},
+			want:   bin,
+		})
+	}
+	run(t, echo, cases)
+}
+
+func TestPingPong(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	for _, n := range []int{0, 10, 125} {
+		client, err := wstest.Dial(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := bytes.Repeat([]byte{0xfe}, n)
+		err = client.WriteFrame(control(wstest.Ping, payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Opcode != wstest.Pong || !f.Final || !bytes.Equal(f.Payload, payload) {
+			t.Errorf("2.x/%d: wrong pong frame %v", n, f)
+		}
+		client.CloseHandshake(1000, "")
+	}
+
+	run(t, echo, []testCase{
+		{
+			name:   "2.5/ping_too_long",
+			frames: []*wstest.Frame{control(wstest.Ping, make([]byte, 126))},
+			close:  []uint16{1002},
+		},
+		{
+			name: "2.6/unsolicited_pong",
+			frames: []*wstest.Frame{
+				control(wstest.Pong, []byte("x")),
+				text("hello", true),
+			},
+			want: []byte("hello"),
+		},
+	})
+}
+
+func TestReserved(t *testing.T) {
+	var cases []testCase
+	for rsv := byte(1); rsv < 8; rsv++ {
+		cases = append(cases, testCase{
+			name:   "3.x/rsv_" + strconv.Itoa(int(rsv)),
+			frames: []*wstest.Frame{{Opcode: wstest.Text, RSV: rsv, Payload: []byte("x"), Final: true}},
+			close:  []uint16{1002},
+		})
+	}
+	for _, op := range []wstest.Opcode{3, 4, 5, 6, 7, 11, 12, 13, 14, 15} {
+		cases = append(cases, testCase{
+			name:   "4.x/opcode_" + strconv.Itoa(int(op)),
+			frames: []*wstest.Frame{{Opcode: op, Final: true}},
+			close:  []uint16{1002},
+		})
+	}
+	run(t, echo, cases)
+}
+
+func TestFragmentation(t *testing.T) {
+	run(t, echo, []testCase{
+		{
+			name:   "5.3/two_fragments",
+			frames: []*wstest.Frame{text("frag", false), cont("ment", true)},
+			want:   []byte("fragment"),
+		},
+		{
+			name: "5.6/ping_between_fragments",
+			frames: []*wstest.Frame{
+				text("frag", false),
+				control(wstest.Ping, []byte("p")),
+				cont("ment", true),
+			},
+			want: []byte("fragment"),
+		},
+		{
+			name: "5.15/many_fragments",
+			frames: []*wstest.Frame{
+				text("a", false), cont("", false), cont("b", false), cont("c", true),
+			},
+			want: []byte("abc"),
+		},
+		{
+			name:   "5.9/unexpected_continuation",
+			frames: []*wstest.Frame{cont("x", true)},
+			close:  []uint16{1002},
+		},
+		{
+			name:   "5.18/text_inside_fragmented_message",
+			frames: []*wstest.Frame{text("a", false), text("b", true)},
+			close:  []uint16{1002},
+		},
+		{
+			name: "5.1/fragmented_ping",
+			frames: []*wstest.Frame{
+				{Opcode: wstest.Ping, Payload: []byte("a")},
+				cont("b", true),
+			},
+			close: []uint16{1002},
+		},
+	})
+}
+
+var invalidUTF8 = []string{
+	"\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80", // surrogate
+	"\xf4\x90\x80\x80",     // above U+10FFFF
+	"\xc0\xaf",             // overlong encoding
+	"\xce",                 // truncated
+	"hello\xffworld",       // invalid byte
+	"\xed\xbf\xbf\xed\xa0", // surrogate, then truncated
+}
+
+func TestUTF8(t *testing.T) {
+	valid := "κόσμε \U0001f600 �"
+	cases := []testCase{
+		{
+			name:   "6.2/valid",
+			frames: []*wstest.Frame{text(valid, true)},
+			want:   []byte(valid),
+		},
+		{
+			name:   "6.2/valid_split_rune",
+			frames: []*wstest.Frame{text(valid[:1], false), cont(valid[1:], true)},
+			want:   []byte(valid),
+		},
+	}
+	for i, s := range invalidUTF8 {
+		cases = append(cases, testCase{
+			name:   "6.x/invalid_" + strconv.Itoa(i),
+			frames: []*wstest.Frame{text(s, true)},
+			close:  []uint16{1002, 1007},
+		})
+	}
+
+	t.Run("ReceiveMessage", func(t *testing.T) {
+		run(t, echo, cases)
+	})
+	t.Run("ReceiveText", func(t *testing.T) {
+		run(t, echoText, cases)
+	})
+}
+
+func TestClose(t *testing.T) {
+	var cases []testCase
+	for _, status := range []uint16{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 1012, 1013, 1014, 3000, 3999, 4000, 4999} {
+		cases = append(cases, testCase{
+			name:   "7.7/valid_" + strconv.Itoa(int(status)),
+			frames: []*wstest.Frame{closeFrame(status, "")},
+			close:  []uint16{1000, status},
+		})
+	}
+	for _, status := range []uint16{0, 999, 1004, 1005, 1006, 1015, 1016, 1100, 2000, 2999, 5000, 65535} {
+		cases = append(cases, testCase{
+			name:   "7.9/invalid_" + strconv.Itoa(int(status)),
+			frames: []*wstest.Frame{closeFrame(status, "")},
+			close:  []uint16{1002},
+		})
+	}
+	cases = append(cases,
+		testCase{
+			name:   "7.3.1/empty",
+			frames: []*wstest.Frame{control(wstest.Close, nil)},
+			close:  []uint16{1005},
+		},
+		testCase{
+			name:   "7.3.2/one_byte",
+			frames: []*wstest.Frame{control(wstest.Close, []byte{0x03})},
+			close:  []uint16{1002},
+		},
+		testCase{
+			name:   "7.3.3/reason",
+			frames: []*wstest.Frame{closeFrame(1000, "bye")},
+			close:  []uint16{1000},
+		},
+		testCase{
+			name:   "7.3.6/reason_too_long",
+			frames: []*wstest.Frame{closeFrame(1000, strings.Repeat("*", 124))},
+			close:  []uint16{1002},
+		},
+		testCase{
+			name:   "7.5.1/invalid_utf8_reason",
+			frames: []*wstest.Frame{closeFrame(1000, "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80")},
+			close:  []uint16{1002, 1007},
+		},
+		testCase{
+			name:   "7.1.5/text_after_close",
+			frames: []*wstest.Frame{closeFrame(1000, ""), text("x", true)},
+			close:  []uint16{1000},
+		},
+	)
+	run(t, echo, cases)
+}
@@ -0,0 +1,25 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package conformance contains protocol tests for the websocket server,
+// which run with "go test" and do not need any external tools.
+//
+// The tests follow the core cases of the Autobahn test suite: framing,
+// ping and pong, reserved bits and opcodes, fragmentation, invalid utf-8
+// and close codes.  The case numbers in the test names refer to the
+// Autobahn cases.  For the full Autobahn suite, see the program in
+// testing/autobahn, which requires Docker.
+package conformance
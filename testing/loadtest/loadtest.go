@@ -0,0 +1,282 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package loadtest generates load on a websocket echo server, and
+// measures the round-trip latency of messages.
+//
+// Run opens the configured number of connections, sends messages of a
+// given size at a given rate on each connection, and waits for the echo
+// of each message before sending the next one.  The returned Result
+// contains latency percentiles and the number of memory allocations, so
+// that performance regressions can be detected numerically:
+//
+//	res, err := loadtest.Run(ctx, &loadtest.Config{
+//		URL:         "ws://localhost:8080/",
+//		Conns:       100,
+//		MessageSize: 1024,
+//		Duration:    10 * time.Second,
+//	})
+//	...
+//	fmt.Println(res)
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Config describes a load test.
+type Config struct {
+	// URL is the address of the echo server.
+	URL string
+
+	// Dialer is used to open the connections.  If this is nil,
+	// websocket.DefaultDialer is used.
+	Dialer *websocket.Dialer
+
+	// Conns is the number of concurrent connections.  The default is 1.
+	Conns int
+
+	// MessageSize is the size of the messages in bytes.
+	MessageSize int
+
+	// Text, if set, causes text messages to be sent instead of binary
+	// messages.
+	Text bool
+
+	// Rate is the number of messages per second sent on each connection.
+	// If this is zero, each connection sends the next message as soon
+	// as the echo of the previous message has arrived.
+	Rate float64
+
+	// Duration limits the length of the test.  Messages limits the
+	// number of messages sent on each connection.  At least one of the
+	// two must be set.
+	Duration time.Duration
+	Messages int
+}
+
+// Result contains the results of a load test.
+type Result struct {
+	Conns    int           // number of connections which were established
+	Messages int           // number of messages echoed by the server
+	Errors   int           // number of connections which failed
+	Elapsed  time.Duration // duration of the test
+
+	// Latency percentiles of the message round-trip times.
+	P50, P90, P99, Max time.Duration
+
+	// Allocs is the number of heap allocations in the process during
+	// the test.  If the server runs in the same process, its
+	// allocations are included.
+	Allocs uint64
+}
+
+// AllocsPerMessage returns the average number of heap allocations per
+// echoed message.
+func (r *Result) AllocsPerMessage() float64 {
+	if r.Messages == 0 {
+		return 0
+	}
+	return float64(r.Allocs) / float64(r.Messages)
+}
+
+func (r *Result) String() string {
+	rate := float64(r.Messages) / r.Elapsed.Seconds()
+	return fmt.Sprintf("%d conns, %d errors, %d messages in %s (%.0f/s)\n"+
+		"latency: p50 %s, p90 %s, p99 %s, max %s\n"+
+		"allocs: %d (%.1f per message)",
+		r.Conns, r.Errors, r.Messages, r.Elapsed.Round(time.Millisecond), rate,
+		r.P50, r.P90, r.P99, r.Max,
+		r.Allocs, r.AllocsPerMessage())
+}
+
+// Run performs a load test.  Run returns once the configured duration
+// has passed or the configured number of messages has been sent, or
+// when ctx is cancelled.  An error is returned if the configuration is
+// invalid, or if no connection could be established.
+func Run(ctx context.Context, cfg *Config) (*Result, error) {
+	if cfg.Duration <= 0 && cfg.Messages <= 0 {
+		return nil, errNoLimit
+	}
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	numConns := cfg.Conns
+	if numConns <= 0 {
+		numConns = 1
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	res := &Result{}
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for i := 0; i < numConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dialer.Dial(ctx, cfg.URL)
+			if err != nil {
+				mu.Lock()
+				res.Errors++
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			res.Conns++
+			mu.Unlock()
+
+			// Abort the pending round trip when the context is
+			// cancelled.
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close(websocket.StatusOK, "")
+				case <-done:
+				}
+			}()
+			lat, err := drive(ctx, conn, cfg)
+			close(done)
+			if ctx.Err() != nil {
+				err = nil
+			}
+			conn.Close(websocket.StatusOK, "")
+			conn.Wait()
+
+			mu.Lock()
+			latencies = append(latencies, lat...)
+			if err != nil {
+				res.Errors++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	res.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	res.Allocs = after.Mallocs - before.Mallocs
+
+	if res.Conns == 0 {
+		return nil, firstErr
+	}
+
+	res.Messages = len(latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.P50 = percentile(latencies, 0.50)
+	res.P90 = percentile(latencies, 0.90)
+	res.P99 = percentile(latencies, 0.99)
+	res.Max = percentile(latencies, 1)
+	return res, nil
+}
+
+// drive sends messages on a single connection, and returns the measured
+// round-trip times.
+func drive(ctx context.Context, conn *websocket.Conn, cfg *Config) ([]time.Duration, error) {
+	msg := make([]byte, cfg.MessageSize)
+	for i := range msg {
+		msg[i] = 'a' + byte(i%26)
+	}
+	text := string(msg)
+	buf := make([]byte, cfg.MessageSize+1)
+
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var latencies []time.Duration
+	for cfg.Messages <= 0 || len(latencies) < cfg.Messages {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return latencies, nil
+			}
+		} else if ctx.Err() != nil {
+			return latencies, nil
+		}
+
+		t0 := time.Now()
+		var err error
+		var n int
+		if cfg.Text {
+			err = conn.SendText(text)
+			if err == nil {
+				var res string
+				res, err = conn.ReceiveText(len(buf))
+				n = len(res)
+			}
+		} else {
+			err = conn.SendBinary(msg)
+			if err == nil {
+				n, err = conn.ReceiveBinary(buf)
+			}
+		}
+		if err != nil {
+			return latencies, err
+		}
+		if n != len(msg) {
+			return latencies, errEcho
+		}
+		latencies = append(latencies, time.Since(t0))
+	}
+	return latencies, nil
+}
+
+// percentile returns the p-quantile of the sorted slice x.
+func percentile(x []time.Duration, p float64) time.Duration {
+	if len(x) == 0 {
+		return 0
+	}
+	idx := int(p*float64(len(x))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(x) {
+		idx = len(x) - 1
+	}
+	return x[idx]
+}
+
+var (
+	errNoLimit = errors.New("loadtest: neither Duration nor Messages is set")
+	errEcho    = errors.New("loadtest: wrong echo from server")
+)
@@ -0,0 +1,107 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package loadtest
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+func echo(conn *websocket.Conn) {
+	defer conn.Close(websocket.StatusOK, "")
+	for {
+		tp, r, err := conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		w, err := conn.SendMessage(tp)
+		if err != nil {
+			io.Copy(io.Discard, r)
+			return
+		}
+		io.Copy(w, r)
+		w.Close()
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	res, err := Run(context.Background(), &Config{
+		URL:         url,
+		Conns:       5,
+		MessageSize: 100,
+		Messages:    20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conns != 5 || res.Errors != 0 || res.Messages != 100 {
+		t.Errorf("wrong result:\n%s", res)
+	}
+	if res.P50 <= 0 || res.P50 > res.P90 || res.P90 > res.P99 || res.P99 > res.Max {
+		t.Errorf("invalid percentiles:\n%s", res)
+	}
+	if res.Allocs == 0 {
+		t.Error("allocations not counted")
+	}
+
+	res, err = Run(context.Background(), &Config{
+		URL:         url,
+		Conns:       2,
+		MessageSize: 10,
+		Text:        true,
+		Rate:        100,
+		Duration:    100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Errors != 0 || res.Messages < 2 || res.Messages > 22 {
+		t.Errorf("wrong result:\n%s", res)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	x := make([]time.Duration, 100)
+	for i := range x {
+		x[i] = time.Duration(i + 1)
+	}
+	for _, c := range []struct {
+		p   float64
+		out time.Duration
+	}{{0, 1}, {0.5, 50}, {0.9, 90}, {0.99, 99}, {1, 100}} {
+		if got := percentile(x, c.p); got != c.out {
+			t.Errorf("percentile(%g) = %d, expected %d", c.p, got, c.out)
+		}
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	_, err := Run(context.Background(), &Config{URL: "ws://localhost/"})
+	if err != errNoLimit {
+		t.Errorf("wrong error %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// TraceHooks contains functions which are called when frames are sent or
+// received on a connection.  This can be used to diagnose protocol
+// problems.  All fields are optional.
+//
+// The hooks are called synchronously, by the goroutine which reads or
+// writes the frame.  They must return quickly and must not call methods
+// of the connection.
+type TraceHooks struct {
+	// OnFrameRead is called after the header of a data frame has been
+	// received.  The payload is read later, when the user reads the
+	// message.
+	OnFrameRead func(info FrameInfo)
+
+	// OnFrameWrite is called after a frame has been written to the
+	// connection.  This includes control frames.
+	OnFrameWrite func(info FrameInfo)
+
+	// OnControlFrame is called after a ping, pong or close frame has been
+	// received.  The payload must not be used after the function returns.
+	OnControlFrame func(info FrameInfo, body []byte)
+
+	// OnMessageSize is called once for every data message sent or
+	// received, with the total size of the message payload in bytes.
+	// This can be used to feed a histogram of message sizes.  For
+	// received messages, the function is called once the header of the
+	// last frame of the message has been read.
+	OnMessageSize func(dir Direction, tp MessageType, size int64)
+
+	// OnClose is called once the connection has been shut down, with the
+	// values which will be returned by Conn.Wait.  Calls to Conn.Wait
+	// return after OnClose has finished.
+	OnClose func(info ConnInfo, status Status, message string)
+
+	// OnMessageStart is called when a received message is passed to the
+	// application, and OnMessageEnd is called once the application has
+	// finished handling the message, see [MessageInfo].  Together, the
+	// two functions can be used to measure the time spent processing
+	// each message.
+	OnMessageStart func(tp MessageType)
+	OnMessageEnd   func(info MessageInfo)
+}
+
+// MessageInfo describes the handling of a received message, for use in
+// [TraceHooks].
+//
+// A message is handled from the time a Receive function returns the message
+// (or OnMessage is called) until the application asks for the next message
+// on the same connection (or OnMessage returns).  Thus, the duration
+// includes the time the application spends processing the message, no
+// matter whether the message is streamed using ReceiveMessage or read
+// using one of the buffered Receive functions.
+type MessageInfo struct {
+	Type     MessageType
+	Bytes    int64 // number of payload bytes read, including discarded data
+	Start    time.Time
+	Duration time.Duration
+
+	// Err is non-nil if the message could not be read completely, for
+	// example because it was too large or because the connection failed.
+	Err error
+}
+
+// FrameInfo describes a websocket frame, for use in [TraceHooks].
+type FrameInfo struct {
+	Opcode MessageType
+	Length int64 // length of the frame payload in bytes
+	Final  bool  // true if this is the last frame of a message
+
+	// Time is the time when the frame header was read, or when writing
+	// the frame was started.
+	Time time.Time
+
+	// Duration is the time taken to write the frame.  This is zero for
+	// frames which were received.
+	Duration time.Duration
+}
+
+// Direction indicates whether a message was received or sent.
+type Direction int
+
+// These are the possible values of Direction.
+const (
+	Inbound  Direction = iota // the message was received from the peer
+	Outbound                  // the message was sent to the peer
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// SetTraceHooks replaces the trace hooks for the connection.  The hooks
+// apply to all frames which are read or written after the call.  If hooks
+// is nil, tracing is disabled.
+func (conn *Conn) SetTraceHooks(hooks *TraceHooks) {
+	conn.trace.set(hooks)
+}
+
+// traceState holds the trace hooks of a connection.  The hooks are shared
+// between the Conn, the sender and the receiver.
+type traceState struct {
+	hooks atomic.Value // *TraceHooks
+}
+
+func newTraceState(hooks *TraceHooks) *traceState {
+	t := &traceState{}
+	t.set(hooks)
+	return t
+}
+
+func (t *traceState) set(hooks *TraceHooks) {
+	t.hooks.Store(hooks)
+}
+
+func (t *traceState) get() *TraceHooks {
+	if t == nil {
+		return nil
+	}
+	return t.hooks.Load().(*TraceHooks)
+}
+
+// messageTrace records the handling of a received message, for the
+// OnMessageStart and OnMessageEnd hooks.  The methods can be called on a
+// nil *messageTrace.
+type messageTrace struct {
+	bytes int64 // updated atomically, first field to ensure 64-bit alignment
+
+	hooks *TraceHooks
+	tp    MessageType
+	start time.Time
+	err   error // set by the holder of the receiver
+}
+
+// count adds n bytes to the amount of data read by the application.
+func (mt *messageTrace) count(n int) {
+	if mt != nil {
+		atomic.AddInt64(&mt.bytes, int64(n))
+	}
+}
+
+// fail records the first error encountered while reading the message.
+func (mt *messageTrace) fail(err error) {
+	if mt != nil && mt.err == nil && err != io.EOF {
+		mt.err = err
+	}
+}
+
+// startMessage reports that the message in rb is passed to the
+// application.  This must be called by the holder of the receiver.
+func (conn *Conn) startMessage(rb *receiver) {
+	hooks := conn.trace.get()
+	if hooks == nil || (hooks.OnMessageStart == nil && hooks.OnMessageEnd == nil) {
+		return
+	}
+	mt := &messageTrace{
+		hooks: hooks,
+		tp:    rb.header.Opcode,
+		start: time.Now(),
+	}
+	rb.msgTrace = mt
+	conn.handlingMu.Lock()
+	conn.handling = mt
+	conn.handlingMu.Unlock()
+	if hooks.OnMessageStart != nil {
+		hooks.OnMessageStart(mt.tp)
+	}
+}
+
+// endMessage reports that the application has finished handling the
+// message most recently passed to startMessage, if any.
+func (conn *Conn) endMessage() {
+	conn.handlingMu.Lock()
+	mt := conn.handling
+	conn.handling = nil
+	conn.handlingMu.Unlock()
+	if mt == nil || mt.hooks.OnMessageEnd == nil {
+		return
+	}
+	mt.hooks.OnMessageEnd(MessageInfo{
+		Type:     mt.tp,
+		Bytes:    atomic.LoadInt64(&mt.bytes),
+		Start:    mt.start,
+		Duration: time.Since(mt.start),
+		Err:      mt.err,
+	})
+}
@@ -0,0 +1,230 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(format string, args ...interface{}) {
+		mu.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	closed := make(chan struct{})
+	hooks := &TraceHooks{
+		OnFrameRead: func(info FrameInfo) {
+			record("read %s %d %t", info.Opcode, info.Length, info.Final)
+		},
+		OnFrameWrite: func(info FrameInfo) {
+			if info.Time.IsZero() || info.Duration < 0 {
+				t.Error("invalid timing information")
+			}
+			record("write %s %d %t", info.Opcode, info.Length, info.Final)
+		},
+		OnControlFrame: func(info FrameInfo, body []byte) {
+			record("control %s %q", info.Opcode, body)
+		},
+		OnClose: func(info ConnInfo, status Status, message string) {
+			record("close %d %d %q", info, status, message)
+			close(closed)
+		},
+	}
+
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			msg, err := conn.ReceiveText(100)
+			if err != nil {
+				return
+			}
+			conn.SendText(msg)
+		},
+		TraceHooks: hooks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.SendFrame(Text, []byte("hello"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SendFrame(pingFrame, []byte("x"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SendFrame(closeFrame, []byte{0x03, 0xe8, 'o', 'k'}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+
+	expected := []string{
+		"read text 5 true",
+		"write text 5 true",
+		"control ping \"x\"",
+		"write pong 1 true",
+		"control close \"\\x03\\xe8ok\"",
+		"write close 2 true",
+		fmt.Sprintf("close %d %d \"ok\"", ClientClosed, StatusOK),
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("wrong events:\n%q\nexpected:\n%q", events, expected)
+	}
+}
+
+func TestMessageSizeHook(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []string
+	server, client := Pipe()
+	server.SetTraceHooks(&TraceHooks{
+		OnMessageSize: func(dir Direction, tp MessageType, size int64) {
+			mu.Lock()
+			sizes = append(sizes, fmt.Sprintf("%s %s %d", dir, tp, size))
+			mu.Unlock()
+		},
+	})
+
+	go func() {
+		w, err := client.SendMessage(Text)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Write([]byte("abc"))
+		w.Write([]byte("defg"))
+		w.Close()
+
+		buf := make([]byte, 10)
+		client.ReceiveText(10)
+		client.ReceiveBinary(buf)
+		client.Close(StatusOK, "")
+	}()
+
+	msg, err := server.ReceiveText(100)
+	if err != nil || msg != "abcdefg" {
+		t.Fatalf("wrong message %q %v", msg, err)
+	}
+	err = server.SendText("xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := server.SendMessageN(Binary, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("12345"))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Wait()
+
+	expected := []string{
+		"inbound text 7",
+		"outbound text 3",
+		"outbound binary 5",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("wrong sizes %q", sizes)
+	}
+}
+
+func TestMessageHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	var infos []MessageInfo
+	server, client := Pipe()
+	server.SetTraceHooks(&TraceHooks{
+		OnMessageStart: func(tp MessageType) {
+			mu.Lock()
+			events = append(events, "start "+tp.String())
+			mu.Unlock()
+		},
+		OnMessageEnd: func(info MessageInfo) {
+			mu.Lock()
+			events = append(events, "end "+info.Type.String())
+			infos = append(infos, info)
+			mu.Unlock()
+		},
+	})
+
+	go func() {
+		client.SendText("hello")
+		client.SendBinary([]byte("0123456789"))
+		client.Close(StatusOK, "")
+	}()
+
+	msg, err := server.ReceiveText(100)
+	if err != nil || msg != "hello" {
+		t.Fatalf("wrong message %q %v", msg, err)
+	}
+	time.Sleep(20 * time.Millisecond) // processing time
+	buf := make([]byte, 4)
+	_, err = server.ReceiveBinary(buf)
+	if err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	_, err = server.ReceiveBinary(buf)
+	if err == nil {
+		t.Fatal("missing error")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"start text", "end text", "start binary", "end binary"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("wrong events %q", events)
+	}
+	if infos[0].Bytes != 5 || infos[0].Err != nil ||
+		infos[0].Duration < 20*time.Millisecond {
+		t.Errorf("wrong info %v", infos[0])
+	}
+	if infos[1].Bytes != 10 || infos[1].Err != ErrTooLarge {
+		t.Errorf("wrong info %v", infos[1])
+	}
+}
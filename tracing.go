@@ -0,0 +1,80 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates spans for websocket operations, for use with a
+// distributed tracing system.  This package does not depend on a tracing
+// library; instead, a small adapter implements Tracer, for example using
+// the OpenTelemetry API:
+//
+//	func (t *otelTracer) StartHandshake(req *http.Request) (context.Context, websocket.Span) {
+//		ctx := otel.GetTextMapPropagator().Extract(req.Context(),
+//			propagation.HeaderCarrier(req.Header))
+//		ctx, span := t.tracer.Start(ctx, "websocket.handshake")
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// StartHandshake is called when a handshake request arrives.  The
+	// trace context of the request can be obtained from the request
+	// headers or from req.Context().  The returned context is used as
+	// the parent for all message spans of the connection.  The span is
+	// ended once the handshake is complete.
+	StartHandshake(req *http.Request) (context.Context, Span)
+
+	// StartMessage is called when a message is received (op is
+	// "receive") or sent (op is "send").  If StartMessage returns nil,
+	// the message is not traced.  This can be used to sample messages.
+	//
+	// Receive spans end once the user has finished reading the message.
+	// Send spans end once the last frame of the message has been written.
+	StartMessage(ctx context.Context, op string, tp MessageType) Span
+}
+
+// Span represents an operation traced by a [Tracer].
+type Span interface {
+	// End is called when the operation is complete.  The argument err
+	// is nil if the operation was successful.
+	End(err error)
+}
+
+// spanTracer holds the tracer of a connection, together with the context
+// returned by StartHandshake.
+type spanTracer struct {
+	tracer Tracer
+	ctx    context.Context
+}
+
+// start starts a message span.  The method can be called on a nil
+// *spanTracer, and may return nil.
+func (st *spanTracer) start(op string, tp MessageType) Span {
+	if st == nil {
+		return nil
+	}
+	return st.tracer.StartMessage(st.ctx, op, tp)
+}
+
+// endSpan ends the span s, if s is non-nil.
+func endSpan(s Span, err error) {
+	if s != nil {
+		s.End(err)
+	}
+}
@@ -0,0 +1,117 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type traceKey struct{}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type testSpan struct {
+	t    *testTracer
+	name string
+}
+
+func (s *testSpan) End(err error) {
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, fmt.Sprintf("%s %v", s.name, err))
+	s.t.mu.Unlock()
+}
+
+func (t *testTracer) StartHandshake(req *http.Request) (context.Context, Span) {
+	ctx := context.WithValue(context.Background(), traceKey{}, req.Header.Get("Traceparent"))
+	return ctx, &testSpan{t: t, name: "handshake"}
+}
+
+func (t *testTracer) StartMessage(ctx context.Context, op string, tp MessageType) Span {
+	name := fmt.Sprintf("%s %s %s", ctx.Value(traceKey{}), op, tp)
+	return &testSpan{t: t, name: name}
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{}
+	done := make(chan struct{})
+	handler := &Handler{
+		Handle: func(conn *Conn) {
+			defer close(done)
+			msg, err := conn.ReceiveText(100)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			w, err := conn.SendMessage(Binary)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			w.Write([]byte(msg))
+			w.Write([]byte(msg))
+			w.Close()
+			conn.Wait()
+		},
+		Tracer: tracer,
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dialer := &Dialer{
+		Header: http.Header{"Traceparent": []string{"p"}},
+	}
+	conn, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 20)
+	_, err = conn.ReceiveBinary(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(StatusOK, "")
+	<-done
+
+	// The receive span ends asynchronously, after ReceiveText has
+	// returned, so the order of the spans is not fixed.
+	expected := []string{
+		"handshake <nil>",
+		"p receive text <nil>",
+		"p send binary <nil>",
+	}
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	sort.Strings(tracer.spans)
+	if !reflect.DeepEqual(tracer.spans, expected) {
+		t.Errorf("wrong spans %q", tracer.spans)
+	}
+}
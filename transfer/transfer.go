@@ -0,0 +1,309 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package transfer sends large payloads, such as files, over websocket
+// connections.
+//
+// The sender first sends a text message containing the JSON-encoded
+// [Meta] data.  The receiver answers with a text message containing the
+// number of bytes it already has, so that interrupted transfers can be
+// resumed.  The payload is then sent as a sequence of binary messages
+// ("chunks"), each starting with a 16 byte header which contains the offset
+// of the chunk and the total size in big-endian order.  If the total size
+// is not known in advance, the header contains -1 instead, until the last
+// chunk.  Finally, the receiver confirms the transfer with another text
+// message.
+package transfer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Meta describes the payload of a transfer.
+type Meta struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+
+	// Size is the length of the payload in bytes, or -1 if the length is
+	// not known in advance.
+	Size int64 `json:"size"`
+}
+
+// Options can be used to configure SendFile and ReceiveFile.  A nil
+// *Options is equivalent to the zero value.
+type Options struct {
+	// ChunkSize is the maximal payload size of a chunk.  If this is zero,
+	// 64 KiB are used.  When receiving, chunks with payloads of up to
+	// MaxChunkSize bytes are accepted, independent of ChunkSize.
+	ChunkSize int
+
+	// Progress, if set, is called after every chunk, with the number of
+	// bytes transferred so far (including any data which was skipped when
+	// resuming a transfer) and the total size, or -1 if this is not known.
+	Progress func(done, total int64)
+}
+
+const (
+	defaultChunkSize = 64 * 1024
+
+	// MaxChunkSize is the maximal chunk payload size accepted by
+	// ReceiveFile.
+	MaxChunkSize = 1 << 20
+
+	headerSize  = 16
+	maxMetaSize = 64 * 1024
+)
+
+// reply is the message sent by the receiver, both to accept the transfer
+// and to confirm its completion.
+type reply struct {
+	Offset int64  `json:"offset"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SendFile sends the data read from r over conn.  The function returns once
+// the receiver has confirmed that all data has been received.
+//
+// If the receiver already has some of the data from an earlier, interrupted
+// transfer, the corresponding part of r is skipped.  If r implements
+// io.Seeker, Seek is used for this, otherwise the data is read and
+// discarded.
+func SendFile(ctx context.Context, conn *websocket.Conn, r io.Reader, meta *Meta, opt *Options) error {
+	if opt == nil {
+		opt = &Options{}
+	}
+	chunkSize := opt.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	total := meta.Size
+	if total < 0 {
+		total = -1
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	err = conn.SendText(string(data))
+	if err != nil {
+		return err
+	}
+
+	var start reply
+	err = receiveJSON(ctx, conn, &start)
+	if err != nil {
+		return err
+	}
+	if start.Error != "" {
+		return &RejectedError{start.Error}
+	}
+	offset := start.Offset
+	if offset < 0 || total >= 0 && offset > total {
+		return errProtocol
+	}
+	if offset > 0 {
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(offset, io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(io.Discard, r, offset)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, headerSize+chunkSize)
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		n, err := io.ReadFull(r, buf[headerSize:])
+		last := false
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+		if total >= 0 && offset+int64(n) > total {
+			return errSizeMismatch
+		}
+		if total >= 0 && offset+int64(n) == total {
+			last = true
+		} else if last {
+			if total >= 0 {
+				return errSizeMismatch
+			}
+			total = offset + int64(n)
+		}
+
+		binary.BigEndian.PutUint64(buf[:8], uint64(offset))
+		binary.BigEndian.PutUint64(buf[8:16], uint64(total))
+		err = conn.SendBinary(buf[:headerSize+n])
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+		if opt.Progress != nil {
+			opt.Progress(offset, total)
+		}
+		if last {
+			break
+		}
+	}
+
+	var done reply
+	err = receiveJSON(ctx, conn, &done)
+	if err != nil {
+		return err
+	}
+	if done.Error != "" {
+		return &RejectedError{done.Error}
+	}
+	if done.Offset != total {
+		return errProtocol
+	}
+	return nil
+}
+
+// ReceiveFile receives data sent by SendFile.  Once the meta data has been
+// received, open is called to obtain a writer for the payload.  The
+// function open also returns the number of bytes of the payload which
+// are already present, for example from an earlier, interrupted transfer.
+// Only the remaining data is then sent by the peer and written to w.
+//
+// If open returns an error, the transfer is rejected and the error is
+// returned.  ReceiveFile returns the meta data sent by the peer.
+func ReceiveFile(ctx context.Context, conn *websocket.Conn, open func(meta *Meta) (w io.Writer, offset int64, err error), opt *Options) (*Meta, error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+
+	meta := &Meta{}
+	err := receiveJSON(ctx, conn, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	w, offset, err := open(meta)
+	if err != nil {
+		sendJSON(conn, &reply{Error: err.Error()})
+		return meta, err
+	}
+	if offset < 0 || meta.Size >= 0 && offset > meta.Size {
+		err = errOffset
+		sendJSON(conn, &reply{Error: err.Error()})
+		return meta, err
+	}
+	err = sendJSON(conn, &reply{Offset: offset})
+	if err != nil {
+		return meta, err
+	}
+
+	buf := make([]byte, headerSize+MaxChunkSize+1)
+	for {
+		_, tp, r, err := websocket.ReceiveOneMessage(ctx, []*websocket.Conn{conn})
+		if err != nil {
+			return meta, err
+		}
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = nil
+		} else if err == nil {
+			// the chunk is too large
+			io.Copy(io.Discard, r)
+			err = errProtocol
+		}
+		if err != nil {
+			return meta, err
+		}
+		if tp != websocket.Binary || n < headerSize {
+			return meta, errProtocol
+		}
+
+		chunkOffset := int64(binary.BigEndian.Uint64(buf[:8]))
+		total := int64(binary.BigEndian.Uint64(buf[8:16]))
+		data := buf[headerSize:n]
+		if chunkOffset != offset || total >= 0 && offset+int64(len(data)) > total {
+			return meta, errProtocol
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			sendJSON(conn, &reply{Offset: offset, Error: err.Error()})
+			return meta, err
+		}
+		offset += int64(len(data))
+		if opt.Progress != nil {
+			opt.Progress(offset, total)
+		}
+		if total >= 0 && offset == total {
+			break
+		}
+	}
+
+	return meta, sendJSON(conn, &reply{Offset: offset})
+}
+
+func sendJSON(conn *websocket.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.SendText(string(data))
+}
+
+func receiveJSON(ctx context.Context, conn *websocket.Conn, v interface{}) error {
+	_, tp, r, err := websocket.ReceiveOneMessage(ctx, []*websocket.Conn{conn})
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxMetaSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMetaSize {
+		io.Copy(io.Discard, r)
+		return errProtocol
+	}
+	if tp != websocket.Text {
+		return errProtocol
+	}
+	return json.Unmarshal(data, v)
+}
+
+// RejectedError is returned by SendFile if the receiver rejected the
+// transfer.
+type RejectedError struct {
+	Reason string
+}
+
+func (err *RejectedError) Error() string {
+	return "transfer rejected: " + err.Reason
+}
+
+var (
+	errProtocol     = errors.New("transfer: protocol error")
+	errSizeMismatch = errors.New("transfer: data does not match the announced size")
+	errOffset       = errors.New("transfer: invalid offset")
+)
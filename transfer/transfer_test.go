@@ -0,0 +1,146 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+)
+
+// connPair returns the two ends of a websocket connection.
+func connPair(t *testing.T) (server, client *websocket.Conn, cleanup func()) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	s := httptest.NewServer(&websocket.Handler{
+		Handle: func(c *websocket.Conn) { conns <- c },
+	})
+	client, err := websocket.DefaultDialer.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(s.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-conns
+	return server, client, func() {
+		client.Close(websocket.StatusOK, "")
+		server.Close(websocket.StatusOK, "")
+		s.Close()
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	payload := make([]byte, 100000)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+
+	type testCase struct {
+		name   string
+		r      io.Reader
+		size   int64
+		have   int // bytes already present at the receiver
+		chunks int // expected number of progress calls at the receiver
+	}
+	cases := []testCase{
+		{"known size", bytes.NewReader(payload), int64(len(payload)), 0, 4},
+		{"unknown size", io.MultiReader(bytes.NewReader(payload)), -1, 0, 4},
+		{"resume with seek", bytes.NewReader(payload), int64(len(payload)), 70000, 1},
+		{"resume without seek", io.MultiReader(bytes.NewReader(payload)), int64(len(payload)), 70000, 1},
+		{"empty", bytes.NewReader(nil), 0, 0, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, client, cleanup := connPair(t)
+			defer cleanup()
+
+			sendErr := make(chan error, 1)
+			go func() {
+				meta := &Meta{Name: "data.bin", Size: c.size}
+				sendErr <- SendFile(context.Background(), client, c.r, meta,
+					&Options{ChunkSize: 32 * 1024})
+			}()
+
+			out := &bytes.Buffer{}
+			calls := 0
+			var lastDone, lastTotal int64
+			meta, err := ReceiveFile(context.Background(), server,
+				func(meta *Meta) (io.Writer, int64, error) {
+					out.Write(payload[:c.have])
+					return out, int64(c.have), nil
+				},
+				&Options{Progress: func(done, total int64) {
+					calls++
+					lastDone, lastTotal = done, total
+				}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = <-sendErr
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := payload
+			if c.size == 0 {
+				expected = nil
+			}
+			if meta.Name != "data.bin" {
+				t.Errorf("wrong name %q", meta.Name)
+			}
+			if !bytes.Equal(out.Bytes(), expected) {
+				t.Errorf("wrong data received (%d bytes)", out.Len())
+			}
+			if calls != c.chunks {
+				t.Errorf("expected %d progress calls, got %d", c.chunks, calls)
+			}
+			if lastDone != int64(len(expected)) || lastTotal != int64(len(expected)) {
+				t.Errorf("wrong final progress %d/%d", lastDone, lastTotal)
+			}
+		})
+	}
+}
+
+func TestRejected(t *testing.T) {
+	server, client, cleanup := connPair(t)
+	defer cleanup()
+
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- SendFile(context.Background(), client,
+			strings.NewReader("hello"), &Meta{Size: 5}, nil)
+	}()
+
+	rejected := errors.New("no space left")
+	_, err := ReceiveFile(context.Background(), server,
+		func(meta *Meta) (io.Writer, int64, error) {
+			return nil, 0, rejected
+		}, nil)
+	if err != rejected {
+		t.Errorf("expected %v, got %v", rejected, err)
+	}
+
+	err = <-sendErr
+	if e, ok := err.(*RejectedError); !ok || e.Reason != rejected.Error() {
+		t.Errorf("expected RejectedError, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tunnel transports byte streams over websocket connections.
+//
+// The data of the stream is sent as a sequence of binary websocket messages.
+// Message boundaries carry no meaning.  Closing the websocket connection
+// corresponds to the end of the stream.
+//
+// To forward all websocket connections made to a server to a fixed TCP
+// address, use
+//
+//	handler := &websocket.Handler{
+//		Handle: tunnel.Forward(func(*websocket.Conn) (net.Conn, error) {
+//			return net.Dial("tcp", "localhost:22")
+//		}),
+//	}
+//
+// Clients use [Dial] to open a websocket connection and to obtain the
+// byte stream as a net.Conn.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+// Conn wraps a websocket connection as a net.Conn.
+//
+// Data written to the Conn is sent as binary websocket messages.  Read
+// returns the contents of the received binary messages.  Once the websocket
+// connection is closed, Read returns io.EOF.  Receiving a text message is
+// an error, and Read returns websocket.ErrMessageType.
+//
+// Read deadlines are supported.  Write deadlines are not supported, since
+// a partially sent message cannot be abandoned without closing the
+// websocket connection.
+type Conn struct {
+	ws *websocket.Conn
+	r  *websocket.StreamReader
+
+	startPump sync.Once
+	data      chan chunk    // received data, filled by pump
+	closed    chan struct{} // closed by Close
+
+	readLock sync.Mutex
+	buf      []byte // received data not yet returned by Read
+	readErr  error
+
+	mu              sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{} // closed when readDeadline changes
+	closeOnce       sync.Once
+}
+
+// chunk is a piece of data received by pump.
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// NewConn returns a new Conn which transports data over ws.  The Conn must
+// be closed after use.
+func NewConn(ws *websocket.Conn) *Conn {
+	return &Conn{
+		ws:              ws,
+		r:               websocket.NewStreamReader(ws, websocket.Binary),
+		data:            make(chan chunk),
+		closed:          make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+	}
+}
+
+// Dial opens a websocket connection to the server at urlStr, and returns
+// the connection as a net.Conn.  If d is nil, websocket.DefaultDialer is
+// used.
+//
+// This can be used to connect a client to a server which uses [Forward]:
+//
+//	conn, err := tunnel.Dial(ctx, nil, "wss://example.com/ssh")
+func Dial(ctx context.Context, d *websocket.Dialer, urlStr string) (*Conn, error) {
+	if d == nil {
+		d = websocket.DefaultDialer
+	}
+	ws, err := d.Dial(ctx, urlStr)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(ws), nil
+}
+
+// Read reads data from the websocket connection.  If the read deadline
+// expires, Read returns an error which wraps os.ErrDeadlineExceeded.
+func (c *Conn) Read(buf []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if len(c.buf) == 0 && c.readErr == nil {
+		c.startPump.Do(func() { go c.pump() })
+		err := c.wait()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(c.buf) == 0 {
+		return 0, c.readErr
+	}
+	n := copy(buf, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// wait waits until pump delivers more data, or until the read deadline
+// expires.
+func (c *Conn) wait() error {
+	for {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		changed := c.deadlineChanged
+		c.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return &timeoutError{}
+			}
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case ch := <-c.data:
+			c.buf = ch.data
+			c.readErr = ch.err
+			return nil
+		case <-timeout:
+			return &timeoutError{}
+		case <-changed:
+		case <-c.closed:
+			return net.ErrClosed
+		}
+	}
+}
+
+// pump reads data from the websocket connection and passes it on to Read.
+func (c *Conn) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.r.Read(buf)
+		ch := chunk{
+			data: append([]byte(nil), buf[:n]...),
+			err:  err,
+		}
+		select {
+		case c.data <- ch:
+		case <-c.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Write sends buf as a binary websocket message.
+func (c *Conn) Write(buf []byte) (int, error) {
+	err := c.ws.SendBinary(buf)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// Close closes the websocket connection.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.ws.Close(websocket.StatusOK, "")
+}
+
+// LocalAddr returns the local network address.  Since this information
+// is not available for websocket connections, the returned address is
+// empty.
+func (c *Conn) LocalAddr() net.Addr {
+	return addr("")
+}
+
+// RemoteAddr returns the address of the websocket client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return addr(c.ws.RemoteAddr)
+}
+
+// SetDeadline sets the read deadline.  Since write deadlines are not
+// supported, an error is returned if t is non-zero.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	if !t.IsZero() {
+		return errNoWriteDeadlines
+	}
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future and pending Read calls.  A
+// zero value for t means Read will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is not supported, and returns an error if t is
+// non-zero.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if !t.IsZero() {
+		return errNoWriteDeadlines
+	}
+	return nil
+}
+
+// timeoutError is returned by Read when the read deadline expires.
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "tunnel: read deadline exceeded" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+func (*timeoutError) Unwrap() error   { return os.ErrDeadlineExceeded }
+
+type addr string
+
+func (a addr) Network() string { return "websocket" }
+func (a addr) String() string  { return string(a) }
+
+// Forward returns a function which can be used for the Handle field of a
+// websocket.Handler.  For every new websocket connection, dial is called to
+// obtain a network connection, and data is relayed in both directions until
+// either side closes the connection.
+func Forward(dial func(ws *websocket.Conn) (net.Conn, error)) func(*websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		target, err := dial(ws)
+		if err != nil {
+			ws.Close(websocket.StatusInternalServerError, "")
+			return
+		}
+		relay(NewConn(ws), target)
+	}
+}
+
+// relay copies data between a and b in both directions.  Once one of the
+// directions stops, both connections are closed.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+var errNoWriteDeadlines = errors.New("tunnel: write deadlines not supported")
@@ -0,0 +1,161 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+)
+
+func TestForward(t *testing.T) {
+	// The tunnel connects to a server which sends a greeting and
+	// then echoes all data back to the client.
+	handler := &websocket.Handler{
+		Handle: Forward(func(*websocket.Conn) (net.Conn, error) {
+			a, b := net.Pipe()
+			go func() {
+				b.Write([]byte("hello\n"))
+				io.Copy(b, b)
+				b.Close()
+			}()
+			return a, nil
+		}),
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n" +
+		"Host: " + strings.TrimPrefix(server.URL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: 0000000000000000000000==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %s", resp.Status)
+	}
+
+	readMessage := func() string {
+		var header [2]byte
+		_, err := io.ReadFull(r, header[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header[0] != 128|byte(websocket.Binary) || header[1] > 125 {
+			t.Fatalf("unexpected frame header % x", header)
+		}
+		body := make([]byte, header[1])
+		_, err = io.ReadFull(r, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	if msg := readMessage(); msg != "hello\n" {
+		t.Errorf("wrong greeting %q", msg)
+	}
+
+	// send a binary message, using the zero mask
+	msg := "ping"
+	frame := []byte{128 | byte(websocket.Binary), 128 | byte(len(msg)), 0, 0, 0, 0}
+	frame = append(frame, msg...)
+	_, err = conn.Write(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := readMessage(); msg != "ping" {
+		t.Errorf("wrong echo %q", msg)
+	}
+}
+
+func TestDialDeadline(t *testing.T) {
+	serverErr := make(chan error, 1)
+	handler := &websocket.Handler{
+		Handle: func(ws *websocket.Conn) {
+			conn := NewConn(ws)
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			buf := make([]byte, 10)
+			_, err := conn.Read(buf)
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+				serverErr <- fmt.Errorf("expected timeout, got %v", err)
+				return
+			}
+
+			conn.SetReadDeadline(time.Time{})
+			conn.Write([]byte("ready"))
+			n, err := conn.Read(buf)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			_, err = conn.Write(buf[:n])
+			serverErr <- err
+		},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := Dial(context.Background(), nil, "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "ready" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "hello" {
+		t.Errorf("got %q, %v", buf, err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,74 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import "unicode/utf8"
+
+// skipMessage applies Handler.Validate to the message which starts at the
+// current frame.  The message is read into memory and is afterwards
+// served from rb.msg, so that the Receive functions see the validated
+// data.  The return value reports whether the message was consumed
+// without being delivered.  If the message is rejected, the connection is
+// failed with InvalidMessage.
+func (conn *Conn) skipMessage(rb *receiver) bool {
+	if conn.validate == nil {
+		return false
+	}
+
+	tp, rsv := rb.header.Opcode, rb.header.RSV
+	msg, err := (&frameReader{rb: rb}).readAlloc(conn.receiveLimit())
+	if rb.connInfo != 0 {
+		return false
+	}
+	if err == nil {
+		err = conn.validate(conn, tp, msg)
+	}
+	if err == ErrSkipMessage {
+		return true
+	} else if err != nil {
+		rb.readErr = err
+		rb.failConnection(InvalidMessage)
+		return false
+	}
+
+	rb.msg = msg
+	rb.header = frameHeader{
+		Opcode: tp,
+		RSV:    rsv,
+		Final:  true,
+		Length: int64(len(msg)),
+	}
+	rb.pos = 0
+	return false
+}
+
+// closeReason converts the error which caused a message to be rejected into
+// the message of a close frame.
+func closeReason(err error) []byte {
+	if err == nil {
+		return nil
+	}
+	body := []byte(err.Error())
+	if len(body) <= 123 {
+		return body
+	}
+	body = body[:123]
+	for len(body) > 0 && !utf8.Valid(body) {
+		body = body[:len(body)-1]
+	}
+	return body
+}
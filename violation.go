@@ -0,0 +1,116 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+// Violation describes which rule of the websocket protocol was broken by
+// the peer, if a connection is closed with [ProtocolViolation].
+type Violation int
+
+// These are the possible values of [Violation].
+const (
+	// NoViolation indicates that the peer did not break the protocol.
+	NoViolation Violation = iota
+
+	// ViolationReservedBits indicates that a frame had reserved bits set,
+	// which were not enabled by an extension.
+	ViolationReservedBits
+
+	// ViolationMasking indicates that a frame sent by the client was not
+	// masked, or that a frame sent by the server was masked.
+	ViolationMasking
+
+	// ViolationFrameLength indicates an invalid payload length in a frame
+	// header.
+	ViolationFrameLength
+
+	// ViolationControlFrame indicates that a control frame was fragmented,
+	// or had a payload longer than 125 bytes.
+	ViolationControlFrame
+
+	// ViolationContinuation indicates a continuation frame without a
+	// preceding fragment, or a new message which started before the
+	// previous message was complete.
+	ViolationContinuation
+
+	// ViolationOpcode indicates a frame with an unknown opcode.
+	ViolationOpcode
+
+	// ViolationClosePayload indicates that the payload of a close frame
+	// was malformed, or contained an invalid status code.
+	ViolationClosePayload
+
+	// ViolationUTF8 indicates that a text message was not valid utf-8.
+	ViolationUTF8
+)
+
+func (v Violation) String() string {
+	switch v {
+	case NoViolation:
+		return "no violation"
+	case ViolationReservedBits:
+		return "reserved bits set"
+	case ViolationMasking:
+		return "wrong masking"
+	case ViolationFrameLength:
+		return "invalid frame length"
+	case ViolationControlFrame:
+		return "invalid control frame"
+	case ViolationContinuation:
+		return "bad continuation frame"
+	case ViolationOpcode:
+		return "unknown opcode"
+	case ViolationClosePayload:
+		return "invalid close payload"
+	case ViolationUTF8:
+		return "invalid utf-8"
+	default:
+		return "unknown violation"
+	}
+}
+
+// ProtocolError describes a protocol violation by the peer.  Once a
+// connection has been failed because of a protocol violation, the errors
+// returned by the Receive* methods wrap a *ProtocolError, which can be
+// extracted using errors.As.
+type ProtocolError struct {
+	Violation Violation
+}
+
+func (err *ProtocolError) Error() string {
+	return "websocket protocol violation: " + err.Violation.String()
+}
+
+// Violation waits for the connection to shut down, and then reports which
+// protocol rule the peer broke.  The result is NoViolation unless Wait
+// reports [ProtocolViolation].
+func (conn *Conn) Violation() Violation {
+	<-conn.shutdownComplete
+	return conn.violation
+}
+
+// violate fails the connection because of a protocol violation by the
+// peer.  The returned error is suitable for returning to the user.
+func (rb *receiver) violate(v Violation) error {
+	err := &ProtocolError{Violation: v}
+	if rb.connInfo == 0 {
+		rb.violation = v
+		rb.readErr = err
+	}
+	rb.failConnection(ProtocolViolation)
+	rb.msgTrace.fail(err)
+	return &closedError{cause: err}
+}
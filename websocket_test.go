@@ -18,6 +18,7 @@ package websocket
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
@@ -25,7 +26,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -43,6 +46,14 @@ type TestServer struct {
 // to handle connections.  Clients can be connected using the .Connect()
 // method.
 func StartTestServer(handler func(*Conn)) (*TestServer, error) {
+	return StartTestServerWithHandler(&Handler{
+		Handle: handler,
+	})
+}
+
+// StartTestServerWithHandler is like StartTestServer, but allows to
+// configure the websocket Handler used by the server.
+func StartTestServerWithHandler(websocket *Handler) (*TestServer, error) {
 	nonce := make([]byte, 8)
 	_, err := rand.Read(nonce)
 	if err != nil {
@@ -62,9 +73,6 @@ func StartTestServer(handler func(*Conn)) (*TestServer, error) {
 
 	// start the websocket server
 	go func() {
-		websocket := &Handler{
-			Handle: handler,
-		}
 		// errors are expected here, when we shut down the server
 		_ = http.Serve(listener, websocket)
 	}()
@@ -410,7 +418,7 @@ func TestClientStatusCode(t *testing.T) {
 	// server code
 	handler := func(conn *Conn) {
 		_, err := conn.ReceiveText(128)
-		if err == ErrConnClosed {
+		if errors.Is(err, ErrConnClosed) {
 			connInfo, status, message := conn.Wait()
 			c <- &res{connInfo, status, message}
 		} else {
@@ -591,6 +599,180 @@ func TestKeepConn(t *testing.T) {
 	}
 }
 
+// TestConcurrentClose checks that Close can safely be called several times
+// from different goroutines.
+func TestConcurrentClose(t *testing.T) {
+	server, client := Pipe()
+
+	const n = 10
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- server.Close(StatusOK, "")
+		}()
+	}
+	numOK := 0
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			numOK++
+		} else if !errors.Is(err, ErrConnClosed) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if numOK != 1 {
+		t.Errorf("%d calls to Close succeeded", numOK)
+	}
+
+	info, _, _ := client.Wait()
+	if info != ClientClosed {
+		t.Errorf("wrong client info %d", info)
+	}
+	server.Wait()
+
+	// Closing a closed connection, from either side, must fail.
+	if err := server.Close(StatusOK, ""); err != ErrConnClosed {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := client.Close(StatusOK, ""); err != ErrConnClosed {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// TestCloseWrite checks that messages sent by the client after the server
+// closed the connection are delivered after CloseWrite, and discarded after
+// Close.
+func TestCloseWrite(t *testing.T) {
+	for _, deliver := range []bool{false, true} {
+		res := make(chan string, 2)
+		handler := func(conn *Conn) {
+			var err error
+			if deliver {
+				err = conn.CloseWrite(StatusOK, "")
+			} else {
+				err = conn.Close(StatusOK, "")
+			}
+			if err != nil {
+				res <- err.Error()
+			}
+			for {
+				msg, err := conn.ReceiveText(128)
+				if err != nil {
+					break
+				}
+				res <- msg
+			}
+			conn.Wait()
+			close(res)
+		}
+
+		server, err := StartTestServer(handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, err := server.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		opcode, _, err := client.ReadFrame()
+		if opcode != closeFrame || err != nil {
+			t.Fatal("close frame expected", err)
+		}
+		err = client.SendFrame(Text, []byte("late"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.SendFrame(closeFrame, nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var msgs []string
+		for msg := range res {
+			msgs = append(msgs, msg)
+		}
+		if deliver && (len(msgs) != 1 || msgs[0] != "late") {
+			t.Errorf("CloseWrite: wrong messages %q", msgs)
+		} else if !deliver && len(msgs) != 0 {
+			t.Errorf("Close: wrong messages %q", msgs)
+		}
+		server.Close()
+	}
+}
+
+// TestDroppedConnError checks that the network error is available, when
+// the connection is reset without a close frame.
+func TestDroppedConnError(t *testing.T) {
+	errc := make(chan error, 1)
+	server := httptest.NewServer(&Handler{
+		Handle: func(conn *Conn) {
+			_, err := conn.ReceiveText(128)
+			errc <- err
+		},
+	})
+	defer server.Close()
+
+	client, err := DefaultDialer.Dial(context.Background(), "ws"+server.URL[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reset the TCP connection
+	client.raw.(*net.TCPConn).SetLinger(0)
+	client.forceStop()
+
+	err = <-errc
+	if !errors.Is(err, ErrConnClosed) {
+		t.Errorf("expected ErrConnClosed, got %v", err)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected the error to wrap ECONNRESET, got %v", err)
+	}
+	client.Wait()
+}
+
+// TestLowMemory tests whether connections work in low-memory mode.
+func TestLowMemory(t *testing.T) {
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle:    echo,
+		LowMemory: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	buf := make([]byte, 1024)
+	for _, size := range []uint64{0, 10, 300, 100000} {
+		err = client.BounceBinary(size, buf, binaryLengthCheck(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = client.SendFrame(pingFrame, []byte("hello"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != pongFrame || string(body) != "hello" {
+			t.Errorf("wrong pong frame: %s %q", tp, body)
+		}
+	}
+}
+
 func TestEchoMany(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode.")
@@ -659,7 +841,7 @@ func echo(conn *Conn) {
 	buf := make([]byte, 16*1024)
 	for {
 		tp, r, err := conn.ReceiveMessage()
-		if err == ErrConnClosed {
+		if errors.Is(err, ErrConnClosed) {
 			break
 		} else if err != nil {
 			fmt.Println("read error:", err)
@@ -688,7 +870,7 @@ func echo(conn *Conn) {
 		}
 
 		err = w.Close()
-		if err != nil && err != ErrConnClosed {
+		if err != nil && !errors.Is(err, ErrConnClosed) {
 			fmt.Println("close error:", err)
 		}
 	}
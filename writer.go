@@ -19,15 +19,58 @@ package websocket
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
 	"io"
+	"net"
 	"reflect"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
-const maxHeaderSize = 10
+const maxHeaderSize = 14 // including the masking key
 
 type sender struct {
 	w      *bufio.Writer
+	raw    io.Writer // the connection underlying w
 	header [maxHeaderSize]byte
+	pool   BufferPool
+
+	// If mask is set, frame payloads are masked.  This is required for
+	// frames sent by the client.
+	mask    bool
+	maskKey [4]byte
+	maskPos int
+	maskBuf []byte
+
+	// Store is the channel used to pass around the sender.
+	store chan *sender
+
+	// Pong, if non-nil, holds pong frames queued by the receiver.
+	pong    *pendingPong
+	pongBuf [125]byte
+
+	// write coalescing, see Handler.CoalesceDelay
+	coalesceDelay time.Duration
+	coalesceBytes int
+	flushPending  bool
+
+	trace   *traceState
+	errors  *errorHook
+	stats   *Stats
+	control *controlCounters
+
+	// tracer, if non-nil, is used to create spans for sent messages.
+	// The span of the message currently being sent is stored in span.
+	tracer *spanTracer
+	span   Span
+
+	// type and size of the message currently being sent
+	msgType MessageType
+	msgSize int64
+
+	// rsv holds the reserved bits for the next frame, see Conn.SendRSV
+	rsv byte
 
 	// ShutdownStarted is closed when we have started to shut down the connection.
 	shutdownStarted <-chan struct{}
@@ -42,14 +85,15 @@ func (wb *sender) isShuttingDown() bool {
 	}
 }
 
-func (wb *sender) sendFrame(opcode MessageType, body []byte, final bool) error {
-	header := wb.header[:]
+// encodeHeader stores the header for a frame with a payload of length l
+// in header, and returns the length of the header in bytes.  The buffer
+// must have space for at least maxHeaderSize bytes.
+func encodeHeader(header []byte, opcode MessageType, l uint64, final bool) int {
 	header[0] = byte(opcode)
 	if final {
 		header[0] |= 128
 	}
 
-	l := len(body)
 	var n int
 	switch {
 	case l < 126:
@@ -72,36 +116,335 @@ func (wb *sender) sendFrame(opcode MessageType, body []byte, final bool) error {
 		header[9] = byte(l)
 		n = 10
 	}
+	return n
+}
+
+// addMask chooses a new masking key and appends it to the frame header in
+// wb.header.  The argument n is the header length before the masking key is
+// added, the function returns the new header length.
+func (wb *sender) addMask(n int) (int, error) {
+	_, err := io.ReadFull(rand.Reader, wb.maskKey[:])
+	if err != nil {
+		return 0, err
+	}
+	wb.maskPos = 0
+	wb.header[1] |= 128
+	copy(wb.header[n:], wb.maskKey[:])
+	return n + 4, nil
+}
+
+// writeBody writes frame payload data to the buffer, applying the masking
+// key if needed.
+func (wb *sender) writeBody(body []byte) (int, error) {
+	if !wb.mask {
+		return wb.w.Write(body)
+	}
+
+	if wb.maskBuf == nil {
+		wb.maskBuf = make([]byte, 512)
+	}
+	total := 0
+	for len(body) > 0 {
+		k := copy(wb.maskBuf, body)
+		for i := 0; i < k; i++ {
+			wb.maskBuf[i] ^= wb.maskKey[wb.maskPos&3]
+			wb.maskPos++
+		}
+		n, err := wb.w.Write(wb.maskBuf[:k])
+		total += n
+		if err != nil {
+			return total, err
+		}
+		body = body[k:]
+	}
+	return total, nil
+}
+
+func (wb *sender) sendFrame(opcode MessageType, body []byte, final bool) error {
+	hooks := wb.trace.get()
+
+	if opcode == Text || opcode == Binary {
+		wb.span = wb.tracer.start("send", opcode)
+		wb.msgType = opcode
+		wb.msgSize = 0
+	}
+
+	if opcode == pingFrame {
+		wb.control.pingSent()
+	}
+
+	var start time.Time
+	if hooks != nil && hooks.OnFrameWrite != nil {
+		start = time.Now()
+	}
+	err := wb.writeFrame(opcode, body, final)
+	if opcode < 8 && (final || err != nil) {
+		endSpan(wb.span, err)
+		wb.span = nil
+	}
+	if err != nil {
+		return err
+	}
+	if opcode < 8 {
+		wb.msgSize += int64(len(body))
+		if final {
+			wb.messageSent(hooks, wb.msgType, wb.msgSize)
+		}
+	}
+	if hooks != nil && hooks.OnFrameWrite != nil {
+		hooks.OnFrameWrite(FrameInfo{
+			Opcode:   opcode,
+			Length:   int64(len(body)),
+			Final:    final,
+			Time:     start,
+			Duration: time.Since(start),
+		})
+	}
+	return nil
+}
+
+// messageSent is called after the last frame of a data message has been
+// written.
+func (wb *sender) messageSent(hooks *TraceHooks, tp MessageType, size int64) {
+	wb.stats.messageSent()
+	if hooks != nil && hooks.OnMessageSize != nil {
+		hooks.OnMessageSize(Outbound, tp, size)
+	}
+}
+
+func (wb *sender) writeFrame(opcode MessageType, body []byte, final bool) error {
+	l := len(body)
+	n := encodeHeader(wb.header[:], opcode, uint64(l), final)
+	wb.header[0] |= wb.rsv
+	if wb.mask {
+		var err error
+		n, err = wb.addMask(n)
+		if err != nil {
+			return err
+		}
+	}
+	header := wb.header[:n]
+
+	if l > wb.w.Available() && wb.raw != nil && !wb.mask {
+		return wb.writev(header, body)
+	}
 
-	_, err := wb.w.Write(header[:n])
+	_, err := wb.w.Write(header)
 	if err != nil {
 		return err
 	}
-	_, err = wb.w.Write(body)
+	_, err = wb.writeBody(body)
 	if err != nil {
 		return err
 	}
 	if final {
+		return wb.endMessage(opcode)
+	}
+	return nil
+}
+
+// writev writes an unmasked frame which does not fit into the buffer.
+// Instead of copying the body into the buffer piece by piece, header and
+// body are passed to the operating system in one writev() call.  Neither
+// slice is modified, so the body can be shared between connections.
+func (wb *sender) writev(header, body []byte) error {
+	err := wb.w.Flush()
+	if err != nil {
+		return err
+	}
+	wb.flushPending = false
+	bufs := net.Buffers{header, body}
+	_, err = bufs.WriteTo(wb.raw)
+	return err
+}
+
+// endMessage is called after the final frame of a message has been written
+// to the buffer.  Normally, the buffer is flushed immediately.  If write
+// coalescing is enabled, data messages are kept in the buffer until either
+// enough data has accumulated, or until the coalescing delay has expired.
+func (wb *sender) endMessage(opcode MessageType) error {
+	if wb.coalesceDelay <= 0 || opcode >= 8 {
+		return wb.w.Flush()
+	}
+
+	limit := wb.coalesceBytes
+	if limit <= 0 || limit > wb.w.Size() {
+		limit = wb.w.Size()
+	}
+	if wb.w.Buffered() >= limit {
 		return wb.w.Flush()
 	}
+
+	if !wb.flushPending {
+		wb.flushPending = true
+		time.AfterFunc(wb.coalesceDelay, wb.delayedFlush)
+	}
+	return nil
+}
+
+func (wb *sender) delayedFlush() {
+	if <-wb.store == nil {
+		// The connection has been closed, and the close frame
+		// has flushed the buffer.
+		return
+	}
+	if wb.flushPending {
+		// In case of error, the error is also stored in the bufio.Writer,
+		// and will be reported by the next send operation.
+		wb.errors.report("flush", wb.w.Flush())
+		wb.flushPending = false
+	}
+	wb.release()
+}
+
+// release returns the sender to the store, after sending a pending pong
+// frame if needed.
+func (wb *sender) release() {
+	for {
+		wb.sendPendingPong()
+		wb.store <- wb
+
+		// If the receiver has queued a pong frame after we checked,
+		// but before we released the sender, we have to try again.
+		if !wb.pong.isSet() {
+			return
+		}
+		select {
+		case wb2 := <-wb.store:
+			if wb2 == nil {
+				return
+			}
+		default:
+			// Somebody else has taken the sender, and will send
+			// the pong frame when they release it.
+			return
+		}
+	}
+}
+
+// sendPendingPong sends the pong frame queued by the receiver, if any.
+func (wb *sender) sendPendingPong() {
+	if wb.pong == nil {
+		return
+	}
+
+	wb.pong.Lock()
+	n := wb.pong.n
+	if n >= 0 {
+		copy(wb.pongBuf[:], wb.pong.body[:n])
+		wb.pong.n = -1
+	}
+	wb.pong.Unlock()
+
+	if n >= 0 && !wb.isShuttingDown() {
+		err := wb.sendFrame(pongFrame, wb.pongBuf[:n], true)
+		wb.errors.report("sending pong", err)
+	}
+}
+
+// pendingPong holds the payload of a pong frame which could not be sent
+// immediately, because the sender was in use.  Only the most recent ping
+// needs to be answered (RFC 6455, section 5.5.3), so there is at most one
+// pending pong frame.
+type pendingPong struct {
+	sync.Mutex
+	n    int // length of the payload, or -1 if no pong is pending
+	body [125]byte
+}
+
+func (pp *pendingPong) set(body []byte) {
+	pp.Lock()
+	pp.n = copy(pp.body[:], body)
+	pp.Unlock()
+}
+
+func (pp *pendingPong) isSet() bool {
+	if pp == nil {
+		return false
+	}
+	pp.Lock()
+	defer pp.Unlock()
+	return pp.n >= 0
+}
+
+// sendPrepared sends an unmasked frame, where header is the encoded frame
+// header.  The slices are not modified, so that they can be shared between
+// connections.
+func (wb *sender) sendPrepared(opcode MessageType, header, body []byte) error {
+	var span Span
+	if opcode < 8 {
+		span = wb.tracer.start("send", opcode)
+	}
+	start := time.Now()
+	var err error
+	if len(body) > wb.w.Available() && wb.raw != nil {
+		err = wb.writev(header, body)
+	} else {
+		_, err = wb.w.Write(header)
+		if err == nil {
+			_, err = wb.w.Write(body)
+		}
+		if err == nil {
+			err = wb.endMessage(opcode)
+		}
+	}
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	length := int64(len(body))
+	hooks := wb.trace.get()
+	if opcode < 8 {
+		wb.messageSent(hooks, opcode, length)
+	}
+	if hooks != nil && hooks.OnFrameWrite != nil {
+		hooks.OnFrameWrite(FrameInfo{
+			Opcode:   opcode,
+			Length:   length,
+			Final:    header[0]&128 != 0,
+			Time:     start,
+			Duration: time.Since(start),
+		})
+	}
 	return nil
 }
 
 func (wb *sender) sendCloseFrame(status Status, body []byte) error {
-	var buf []byte
-	if status != StatusNotSent {
-		buf = make([]byte, 2+len(body))
-		buf[0] = byte(status >> 8)
-		buf[1] = byte(status)
-		copy(buf[2:], body)
+	if status == StatusNotSent {
+		return wb.sendFrame(closeFrame, nil, true)
+	}
+	if frame, ok := closeFrames[status]; ok && len(body) == 0 && !wb.mask {
+		return wb.sendPrepared(closeFrame, frame[:2], frame[2:])
 	}
-	return wb.sendFrame(closeFrame, buf, true)
+
+	buf := getBuffer(wb.pool, 2+len(body))
+	buf[0] = byte(status >> 8)
+	buf[1] = byte(status)
+	copy(buf[2:], body)
+	err := wb.sendFrame(closeFrame, buf, true)
+	putBuffer(wb.pool, buf)
+	return err
+}
+
+// closeFrames contains pre-encoded close frames without a message, for the
+// most common status codes.
+var closeFrames = map[Status][]byte{
+	StatusOK:                  makeCloseFrame(StatusOK),
+	StatusGoingAway:           makeCloseFrame(StatusGoingAway),
+	StatusProtocolError:       makeCloseFrame(StatusProtocolError),
+	StatusTooLarge:            makeCloseFrame(StatusTooLarge),
+	StatusInternalServerError: makeCloseFrame(StatusInternalServerError),
+}
+
+func makeCloseFrame(status Status) []byte {
+	return []byte{128 | byte(closeFrame), 2, byte(status >> 8), byte(status)}
 }
 
 type frameWriter struct {
 	*sender
-	store chan<- *sender
-	tp    MessageType
+	tp   MessageType
+	utf8 *utf8Checker // non-nil, if text must be validated
 }
 
 func (w *frameWriter) Write(p []byte) (int, error) {
@@ -109,6 +452,13 @@ func (w *frameWriter) Write(p []byte) (int, error) {
 		return 0, ErrConnClosed
 	}
 
+	// control frames can be interleaved with the fragments of a message
+	w.sendPendingPong()
+
+	if w.utf8 != nil {
+		return w.writeText(p)
+	}
+
 	err := w.sendFrame(w.tp, p, false)
 	if err != nil {
 		return 0, err
@@ -117,75 +467,372 @@ func (w *frameWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// writeText sends p after checking that it continues a valid utf-8 string.
+// Incomplete runes at the end of p are held back, until the next call to
+// Write completes them.
+func (w *frameWriter) writeText(p []byte) (int, error) {
+	head, body, ok := w.utf8.split(p)
+	if !ok {
+		return 0, ErrInvalidUTF8
+	}
+	for _, data := range [][]byte{head, body} {
+		if len(data) == 0 {
+			continue
+		}
+		err := w.sendFrame(w.tp, data, false)
+		if err != nil {
+			return 0, err
+		}
+		w.tp = contFrame
+	}
+	return len(p), nil
+}
+
+// Flush sends all buffered data of the message to the client.
+func (w *frameWriter) Flush() error {
+	if w.isShuttingDown() {
+		return ErrConnClosed
+	}
+	w.sendPendingPong()
+	return w.w.Flush()
+}
+
 func (w *frameWriter) Close() error {
 	var err error
 
 	if !w.isShuttingDown() {
 		// send the final frame
 		err = w.sendFrame(w.tp, nil, true)
+		if err == nil && w.utf8 != nil && w.utf8.n > 0 {
+			// The incomplete rune at the end of the message was not sent.
+			err = ErrInvalidUTF8
+		}
+	} else {
+		endSpan(w.span, ErrConnClosed)
+		w.span = nil
 	}
 
 	wb := w.sender
 	w.sender = nil
-	w.store <- wb
+	wb.release()
 	return err
 }
 
-// SendMessage starts a new message and returns an io.WriteCloser
+// utf8Checker validates a utf-8 string which is written in pieces.
+type utf8Checker struct {
+	tail [utf8.UTFMax]byte // start of an incomplete rune
+	n    int
+}
+
+// split checks whether p is a valid continuation of the data seen so far.
+// If so, head gives the rune completed by the start of p (if any), and body
+// gives the following complete runes in p.  Bytes at the end of p which
+// start an incomplete rune are kept for the next call.  If p is not valid,
+// the state of c is not changed.
+func (c *utf8Checker) split(p []byte) (head, body []byte, ok bool) {
+	var tail [utf8.UTFMax]byte
+	n := copy(tail[:], c.tail[:c.n])
+	if n > 0 {
+		for n < len(tail) && len(p) > 0 && !utf8.FullRune(tail[:n]) {
+			tail[n] = p[0]
+			n++
+			p = p[1:]
+		}
+		if !utf8.FullRune(tail[:n]) {
+			// p is too short to complete the rune
+			c.tail = tail
+			c.n = n
+			return nil, nil, true
+		}
+		r, size := utf8.DecodeRune(tail[:n])
+		if r == utf8.RuneError && size <= 1 || size != n {
+			return nil, nil, false
+		}
+		head = append([]byte(nil), tail[:n]...)
+	}
+
+	// Find the start of the last rune, if it is incomplete.
+	cut := len(p)
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(p[:cut]) {
+		return nil, nil, false
+	}
+	c.n = copy(c.tail[:], p[cut:])
+	return head, p[:cut], true
+}
+
+// MessageWriter is used to write the body of a message.  The message ends
+// when Close is called.
+type MessageWriter interface {
+	io.WriteCloser
+
+	// Flush sends all data written so far to the peer, without ending the
+	// message.
+	Flush() error
+}
+
+// SendMessage starts a new message and returns a MessageWriter
 // which can be used to send the message body.  The argument tp gives
 // the message type (Text or Binary).  Text messages must be sent in
 // utf-8 encoded form.
-func (conn *Conn) SendMessage(tp MessageType) (io.WriteCloser, error) {
+//
+// Data written to the message may be buffered, use the Flush method of the
+// returned writer to send all buffered data to the peer.  Until the writer
+// is closed, no other messages can be sent on the connection.
+//
+// RFC 6455 does not allow the fragments of different messages to be
+// interleaved (section 5.4), so a long message delays all other messages.
+// Only control frames, for example the pong frames answering pings of the
+// peer, are sent between the fragments.  To keep latency low for other
+// messages, split large data into several smaller messages, and use a
+// SendQueue to give the other messages a higher priority.
+func (conn *Conn) SendMessage(tp MessageType) (MessageWriter, error) {
+	if conn.Draining() {
+		return nil, ErrDraining
+	}
 	wb := <-conn.senderStore
 	if wb == nil {
-		return nil, ErrConnClosed
+		return nil, conn.sendClosedErr()
 	}
 	// The sender is returned to the conn.senderStore in the
 	// frameWriter.Close() method.
 
 	w := &frameWriter{
 		sender: wb,
-		store:  conn.senderStore,
 		tp:     tp,
 	}
+	if tp == Text && conn.validateText {
+		w.utf8 = &utf8Checker{}
+	}
 	return w, nil
 }
 
-// SendBinary sends a binary message to the client.
+// SendMessageN starts a new message of known length, and returns an
+// io.WriteCloser which can be used to send the message body.  The argument
+// tp gives the message type (Text or Binary), and size gives the length of
+// the message body in bytes.
 //
-// For streaming large messages, use SendMessage() instead.
-func (conn *Conn) SendBinary(msg []byte) error {
+// In contrast to SendMessage, the message is sent as a single frame,
+// without fragmentation.  Exactly size bytes must be written before the
+// writer is closed, otherwise [ErrMessageLength] is returned.  If the writer
+// is closed before all data has been written, the connection cannot be
+// used any more and is closed.
+func (conn *Conn) SendMessageN(tp MessageType, size int64) (io.WriteCloser, error) {
+	if size < 0 {
+		return nil, ErrMessageLength
+	}
+	if conn.Draining() {
+		return nil, ErrDraining
+	}
+
 	wb := <-conn.senderStore
+	if wb == nil {
+		return nil, conn.sendClosedErr()
+	}
+	if wb.isShuttingDown() {
+		wb.release()
+		return nil, conn.sendClosedErr()
+	}
+
+	start := time.Now()
+	wb.span = wb.tracer.start("send", tp)
+	n := encodeHeader(wb.header[:], tp, uint64(size), true)
+	var err error
+	if wb.mask {
+		n, err = wb.addMask(n)
+	}
+	if err == nil {
+		_, err = wb.w.Write(wb.header[:n])
+	}
+	if err != nil {
+		endSpan(wb.span, err)
+		wb.span = nil
+		wb.release()
+		return nil, err
+	}
+
+	// The sender is returned to the conn.senderStore in the
+	// sizedWriter.Close() method.
+	w := &sizedWriter{
+		sender: wb,
+		conn:   conn,
+		tp:     tp,
+		size:   size,
+		todo:   size,
+		start:  start,
+	}
+	return w, nil
+}
+
+type sizedWriter struct {
+	*sender
+	conn  *Conn
+	tp    MessageType
+	size  int64
+	todo  int64
+	start time.Time
+}
+
+func (w *sizedWriter) Write(p []byte) (int, error) {
+	if w.sender == nil || w.isShuttingDown() {
+		return 0, ErrConnClosed
+	}
+	if int64(len(p)) > w.todo {
+		return 0, ErrMessageLength
+	}
+
+	n, err := w.writeBody(p)
+	w.todo -= int64(n)
+	return n, err
+}
+
+func (w *sizedWriter) Close() error {
+	wb := w.sender
 	if wb == nil {
 		return ErrConnClosed
 	}
+	w.sender = nil
 
 	var err error
-	if !wb.isShuttingDown() {
-		err = wb.sendFrame(Binary, msg, true)
-	} else {
-		err = ErrConnClosed
+	if w.todo > 0 {
+		// The client expects more data, so no other frames can be sent on
+		// this connection.
+		w.conn.forceStop()
+		err = ErrMessageLength
+	} else if !wb.isShuttingDown() {
+		err = wb.endMessage(w.tp)
+		hooks := wb.trace.get()
+		if err == nil {
+			wb.messageSent(hooks, w.tp, w.size)
+		}
+		if err == nil && hooks != nil && hooks.OnFrameWrite != nil {
+			hooks.OnFrameWrite(FrameInfo{
+				Opcode:   w.tp,
+				Length:   w.size,
+				Final:    true,
+				Time:     w.start,
+				Duration: time.Since(w.start),
+			})
+		}
 	}
+	endSpan(wb.span, err)
+	wb.span = nil
+
+	wb.release()
+	return err
+}
+
+// SendBinaryReader sends a binary message, consisting of the next n bytes
+// read from r.  The message is sent as a single frame, so that large
+// messages, like file downloads, can be sent without holding the whole
+// message in memory.
+//
+// If r returns fewer than n bytes, the connection cannot be used any more
+// and is closed.  In this case, the error from r is returned, or
+// io.ErrUnexpectedEOF if r ended early.
+func (conn *Conn) SendBinaryReader(r io.Reader, n int64) error {
+	return conn.sendReader(Binary, r, n)
+}
 
-	conn.senderStore <- wb
+// SendTextReader sends a text message, consisting of the next n bytes read
+// from r.  The data must be utf-8 encoded.  See SendBinaryReader for
+// details.
+func (conn *Conn) SendTextReader(r io.Reader, n int64) error {
+	return conn.sendReader(Text, r, n)
+}
+
+func (conn *Conn) sendReader(tp MessageType, r io.Reader, n int64) error {
+	w, err := conn.SendMessageN(tp, n)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	err2 := w.Close()
+	if err == nil {
+		err = err2
+	}
 	return err
 }
 
+// SendBinary sends a binary message to the client.
+//
+// For streaming large messages, use SendMessage() instead.
+func (conn *Conn) SendBinary(msg []byte) error {
+	if conn.Draining() {
+		return ErrDraining
+	}
+	return conn.sendBytes(Binary, msg)
+}
+
 // SendText sends a text message to the client.
+//
+// If ValidateOutgoingText is set in the Handler or Dialer, [ErrInvalidUTF8]
+// is returned if msg is not valid utf-8, and no message is sent.
 func (conn *Conn) SendText(msg string) error {
+	if conn.validateText && !utf8.ValidString(msg) {
+		return ErrInvalidUTF8
+	}
+	if conn.Draining() {
+		return ErrDraining
+	}
+	return conn.sendBytes(Text, []byte(msg))
+}
+
+// SendPing sends a ping frame with the given payload.  The peer answers
+// with a pong frame carrying the same payload.  Pings are normally not
+// needed, since pong frames are sent automatically and Handler.IdleTimeout
+// can be used to detect dead connections; SendPing allows applications to
+// implement their own heartbeat schemes.
+//
+// The payload can be at most 125 bytes long, otherwise [ErrTooLarge] is
+// returned.  If a message is being sent using SendMessage, the ping is
+// sent once the message is complete.
+func (conn *Conn) SendPing(payload []byte) error {
+	if len(payload) > 125 {
+		return ErrTooLarge
+	}
+	return conn.sendBytes(pingFrame, payload)
+}
+
+// SendPong sends an unsolicited pong frame with the given payload.  This
+// can be used as a unidirectional heartbeat, the peer does not reply.
+// Pings received from the peer are answered automatically, there is no
+// need to call SendPong for these.
+//
+// The payload can be at most 125 bytes long, otherwise [ErrTooLarge] is
+// returned.
+func (conn *Conn) SendPong(payload []byte) error {
+	if len(payload) > 125 {
+		return ErrTooLarge
+	}
+	return conn.sendBytes(pongFrame, payload)
+}
+
+// sendBytes sends msg as a single frame.
+func (conn *Conn) sendBytes(tp MessageType, msg []byte) error {
 	wb := <-conn.senderStore
 	if wb == nil {
-		return ErrConnClosed
+		return conn.sendClosedErr()
 	}
 
 	var err error
 	if !wb.isShuttingDown() {
-		err = wb.sendFrame(Text, []byte(msg), true)
+		err = wb.sendFrame(tp, msg, true)
 	} else {
-		err = ErrConnClosed
+		err = conn.sendClosedErr()
 	}
 
-	conn.senderStore <- wb
+	wb.release()
 	return err
 }
 
@@ -212,6 +859,11 @@ func doBroadcast(ctx context.Context, clients []*Conn, tp MessageType, msg []byt
 		panic("too many clients")
 	}
 
+	disabled := reflect.Zero(reflect.ChanOf(reflect.BothDir,
+		reflect.TypeOf(&sender{})))
+	todo := numClients
+	errors := make(map[int]error)
+
 	// set up channels for the select statement
 	cases := make([]reflect.SelectCase, numClients+1)
 	for i, conn := range clients {
@@ -219,16 +871,16 @@ func doBroadcast(ctx context.Context, clients []*Conn, tp MessageType, msg []byt
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(conn.senderStore),
 		}
+		if conn.Draining() {
+			errors[i] = ErrDraining
+			cases[i].Chan = disabled
+			todo--
+		}
 	}
 	cases[numClients] = reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(ctx.Done()),
 	}
-
-	disabled := reflect.Zero(reflect.ChanOf(reflect.BothDir,
-		reflect.TypeOf(&sender{})))
-	todo := numClients
-	errors := make(map[int]error)
 mainLoop:
 	for todo > 0 {
 		idx, recv, recvOK := reflect.Select(cases)
@@ -253,7 +905,7 @@ mainLoop:
 
 		wb := recv.Interface().(*sender)
 		err := wb.sendFrame(tp, msg, true)
-		clients[idx].senderStore <- wb
+		wb.release()
 		if err != nil {
 			errors[idx] = err
 			continue mainLoop
@@ -0,0 +1,417 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSendFrameSizes checks that frames are encoded correctly, both
+// for bodies which fit into the write buffer and for bodies which are
+// written directly to the connection.
+func TestSendFrameSizes(t *testing.T) {
+	for _, l := range []int{0, 1, 125, 126, 127, 4000, 65535, 65536, 100000} {
+		out := &bytes.Buffer{}
+		wb := &sender{
+			w:   bufio.NewWriterSize(out, 4096),
+			raw: out,
+		}
+
+		body := make([]byte, l)
+		for i := range body {
+			body[i] = byte(i)
+		}
+
+		// send a small, non-final frame first, to make sure that buffered
+		// data is written before the large frame.
+		err := wb.sendFrame(Binary, []byte{1, 2, 3}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = wb.sendFrame(contFrame, body, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []byte{byte(Binary), 3, 1, 2, 3}
+		expected = append(expected, 128|byte(contFrame))
+		switch {
+		case l < 126:
+			expected = append(expected, byte(l))
+		case l < 1<<16:
+			expected = append(expected, 126, byte(l>>8), byte(l))
+		default:
+			expected = append(expected, 127, 0, 0, 0, 0,
+				byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+		}
+		expected = append(expected, body...)
+
+		if !bytes.Equal(out.Bytes(), expected) {
+			t.Errorf("%d: wrong frame encoding", l)
+		}
+	}
+}
+
+// TestCoalesce checks that small messages are kept in the buffer, when
+// write coalescing is enabled.
+func TestCoalesce(t *testing.T) {
+	out := &lockedBuffer{}
+	store := make(chan *sender, 1)
+	wb := &sender{
+		w:   bufio.NewWriterSize(out, 4096),
+		raw: out,
+
+		coalesceDelay: 10 * time.Millisecond,
+		coalesceBytes: 100,
+		store:         store,
+	}
+
+	for i := 0; i < 3; i++ {
+		err := wb.sendFrame(Text, []byte("hello"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Error("message sent before the coalescing delay")
+	}
+	store <- wb
+
+	time.Sleep(50 * time.Millisecond)
+	if l := out.Len(); l != 3*7 {
+		t.Errorf("expected %d bytes after the delay, got %d", 3*7, l)
+	}
+
+	// If more than coalesceBytes are buffered, data is sent immediately.
+	<-store
+	err := wb.sendFrame(Binary, make([]byte, 120), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := out.Len(); l != 3*7+2+120 {
+		t.Errorf("expected %d bytes, got %d", 3*7+2+120, l)
+	}
+
+	// Control frames are sent immediately.
+	err = wb.sendFrame(pingFrame, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := out.Len(); l != 3*7+2+120+2 {
+		t.Errorf("expected %d bytes, got %d", 3*7+2+120+2, l)
+	}
+	close(store)
+}
+
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Len()
+}
+
+// BenchmarkSenderLock compares the channel-based semaphore which protects
+// the sender with a mutex-based alternative.  The channel is needed for
+// BroadcastBinary and BroadcastText, which select over the senders of all
+// clients; this benchmark quantifies the cost of that design.
+func BenchmarkSenderLock(b *testing.B) {
+	msg := []byte("hello")
+	newSender := func() *sender {
+		return &sender{
+			w:     bufio.NewWriter(io.Discard),
+			raw:   io.Discard,
+			store: make(chan *sender, 1),
+		}
+	}
+
+	b.Run("chan", func(b *testing.B) {
+		wb := newSender()
+		wb.store <- wb
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				wb := <-wb.store
+				wb.sendFrame(Text, msg, true)
+				wb.release()
+			}
+		})
+	})
+
+	b.Run("mutex", func(b *testing.B) {
+		wb := newSender()
+		var mu sync.Mutex
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				wb.sendFrame(Text, msg, true)
+				mu.Unlock()
+			}
+		})
+	})
+}
+
+// TestSendMessageN checks that messages of known length are sent as a
+// single frame.
+func TestSendMessageN(t *testing.T) {
+	const size = 100000
+
+	serverErr := make(chan error, 1)
+	server, err := StartTestServer(func(c *Conn) {
+		defer close(serverErr)
+		w, err := c.SendMessageN(Binary, size)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		buf := make([]byte, size/4)
+		for i := 0; i < 4; i++ {
+			_, err = w.Write(buf)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+		}
+		_, err = w.Write([]byte{0})
+		if err != ErrMessageLength {
+			serverErr <- fmt.Errorf("expected ErrMessageLength, got %v", err)
+		}
+		err = w.Close()
+		if err != nil {
+			serverErr <- err
+		}
+		c.Close(StatusOK, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tp, body, err := client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != Binary || len(body) != size {
+		t.Errorf("wrong frame: %s, %d bytes", tp, len(body))
+	}
+	tp, _, err = client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != closeFrame {
+		t.Errorf("expected close frame, got %s", tp)
+	}
+
+	for err := range serverErr {
+		t.Error(err)
+	}
+}
+
+func TestSendBinaryReader(t *testing.T) {
+	const size = 100000
+
+	serverErr := make(chan error, 2)
+	server, err := StartTestServer(func(c *Conn) {
+		defer close(serverErr)
+		r := bytes.NewReader(make([]byte, size+10))
+		err := c.SendBinaryReader(r, size)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		err = c.SendTextReader(strings.NewReader("short"), 10)
+		if err != io.ErrUnexpectedEOF {
+			serverErr <- fmt.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tp, body, err := client.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != Binary || len(body) != size {
+		t.Errorf("wrong frame: %s, %d bytes", tp, len(body))
+	}
+
+	for err := range serverErr {
+		t.Error(err)
+	}
+}
+
+// TestCloseFrames checks that the pre-encoded close frames are the same as
+// the frames generated by sendFrame.
+func TestCloseFrames(t *testing.T) {
+	for status, frame := range closeFrames {
+		out := &bytes.Buffer{}
+		wb := &sender{w: bufio.NewWriter(out), raw: out}
+		err := wb.sendFrame(closeFrame, []byte{byte(status >> 8), byte(status)}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), frame) {
+			t.Errorf("%d: wrong close frame % x", status, frame)
+		}
+	}
+}
+
+func TestUTF8Checker(t *testing.T) {
+	cases := []struct {
+		pieces []string
+		ok     bool
+	}{
+		{[]string{"hello", " world"}, true},
+		{[]string{"ä", "ö", "ü"}, true},
+		{[]string{"a\xc3", "\xa4b"}, true},
+		{[]string{"\xe2", "\x82", "\xac"}, true},
+		{[]string{"\xf0\x9f", "\x98\x80"}, true},
+		{[]string{"a\xff"}, false},
+		{[]string{"\x80"}, false},
+		{[]string{"a\xc3", "b"}, false},
+		{[]string{"\xe2\x82", "\xe2"}, false},
+		{[]string{"\xed\xa0\x80"}, false}, // surrogate
+	}
+	for i, test := range cases {
+		c := &utf8Checker{}
+		var out []byte
+		ok := true
+		for _, piece := range test.pieces {
+			head, body, pieceOK := c.split([]byte(piece))
+			if !pieceOK {
+				ok = false
+				break
+			}
+			out = append(out, head...)
+			out = append(out, body...)
+		}
+		if ok != test.ok {
+			t.Errorf("%d: expected %t, got %t", i, test.ok, ok)
+		} else if ok && (c.n != 0 || string(out) != strings.Join(test.pieces, "")) {
+			t.Errorf("%d: wrong output %q, %d bytes left", i, out, c.n)
+		}
+	}
+}
+
+func TestValidateOutgoingText(t *testing.T) {
+	server, client := Pipe()
+	server.validateText = true
+
+	err := server.SendText("\xff")
+	if err != ErrInvalidUTF8 {
+		t.Errorf("expected ErrInvalidUTF8, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		w, err := server.SendMessage(Text)
+		if err != nil {
+			done <- err
+			return
+		}
+		w.Write([]byte("a\xc3"))
+		if _, err := w.Write([]byte("\xff")); err != ErrInvalidUTF8 {
+			done <- fmt.Errorf("expected ErrInvalidUTF8, got %v", err)
+			w.Close()
+			return
+		}
+		w.Write([]byte("\xa4"))
+		done <- w.Close()
+	}()
+
+	msg, err := client.ReceiveText(100)
+	if err != nil || msg != "aä" {
+		t.Errorf("wrong message %q, err=%v", msg, err)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	client.Close(StatusOK, "")
+	server.Wait()
+}
+
+func TestSendPingPong(t *testing.T) {
+	errs := make(chan error, 3)
+	server, err := StartTestServerWithHandler(&Handler{
+		Handle: func(conn *Conn) {
+			defer conn.Close(StatusOK, "")
+			errs <- conn.SendPing(make([]byte, 126))
+			errs <- conn.SendPing([]byte("ping"))
+			errs <- conn.SendPong([]byte("pong"))
+			conn.ReceiveText(100)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := <-errs; err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	for _, want := range []MessageType{pingFrame, pongFrame} {
+		// the payload equals the frame type name
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		tp, body, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tp != want || string(body) != want.String() {
+			t.Errorf("wrong frame %s %q", tp, body)
+		}
+	}
+}
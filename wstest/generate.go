@@ -0,0 +1,351 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest
+
+import (
+	"fmt"
+	"math/rand"
+	"unicode/utf8"
+)
+
+// Message is a data message, together with the way it is split into
+// frames when it is sent.
+type Message struct {
+	Opcode  Opcode // Text or Binary
+	Payload []byte
+
+	// Fragments gives the lengths of the frames used to send the message.
+	// The lengths add up to len(Payload).
+	Fragments []int
+
+	// Control lists control frames which are sent after the fragments of
+	// the message: the frames in Control[i] follow fragment i.  Control
+	// is either nil or has the same length as Fragments.
+	Control [][]*Frame
+}
+
+// Script is a sequence of messages, for property-based testing of
+// websocket servers.  Scripts are created by Generate, and can be
+// simplified using Shrink.
+type Script struct {
+	Messages []*Message
+}
+
+// boundaryLengths are payload lengths where the encoding of the frame
+// header changes.
+var boundaryLengths = []int{0, 1, 125, 126, 127, 65535, 65536, 65537}
+
+// Generate returns a random, valid script.  Message lengths are chosen to
+// include the boundaries of the frame header encoding (125, 126, 65535 and
+// 65536 bytes), messages are split into random fragments, and ping frames
+// with random payloads are interleaved with the fragments.  Text messages
+// contain valid utf-8, but fragment boundaries may split characters.
+func Generate(rng *rand.Rand) *Script {
+	s := &Script{}
+	n := 1 + rng.Intn(8)
+	for i := 0; i < n; i++ {
+		s.Messages = append(s.Messages, generateMessage(rng))
+	}
+	return s
+}
+
+func generateMessage(rng *rand.Rand) *Message {
+	var length int
+	if rng.Intn(2) == 0 {
+		length = boundaryLengths[rng.Intn(len(boundaryLengths))]
+	} else {
+		length = rng.Intn(300)
+	}
+
+	m := &Message{}
+	if rng.Intn(2) == 0 {
+		m.Opcode = Text
+		m.Payload = randomText(rng, length)
+	} else {
+		m.Opcode = Binary
+		m.Payload = make([]byte, length)
+		rng.Read(m.Payload)
+	}
+
+	// Split the message into fragments.  Some of the fragment lengths
+	// are also chosen at the header encoding boundaries.
+	todo := len(m.Payload)
+	numFragments := 1
+	if rng.Intn(2) == 0 {
+		numFragments += rng.Intn(5)
+	}
+	for i := 0; i < numFragments-1 && todo > 0; i++ {
+		var k int
+		if rng.Intn(3) == 0 {
+			k = boundaryLengths[rng.Intn(len(boundaryLengths))]
+		} else {
+			k = rng.Intn(todo + 1)
+		}
+		if k > todo {
+			k = todo
+		}
+		m.Fragments = append(m.Fragments, k)
+		todo -= k
+	}
+	m.Fragments = append(m.Fragments, todo)
+
+	if rng.Intn(2) == 0 {
+		m.Control = make([][]*Frame, len(m.Fragments))
+		for i := range m.Control {
+			for rng.Intn(3) == 0 {
+				payload := make([]byte, rng.Intn(126))
+				rng.Read(payload)
+				m.Control[i] = append(m.Control[i], &Frame{
+					Final:   true,
+					Opcode:  Ping,
+					Payload: payload,
+				})
+			}
+		}
+	}
+	return m
+}
+
+// randomText returns a valid utf-8 string of the given length in bytes.
+func randomText(rng *rand.Rand, length int) []byte {
+	buf := make([]byte, 0, length)
+	for len(buf) < length {
+		var r rune
+		switch rng.Intn(4) {
+		case 0:
+			r = rune(0x80 + rng.Intn(0x780)) // two bytes
+		case 1:
+			r = rune(0x800 + rng.Intn(0xD000-0x800)) // three bytes
+		case 2:
+			r = rune(0x10000 + rng.Intn(0x100000)) // four bytes
+		default:
+			r = rune(0x20 + rng.Intn(0x5f))
+		}
+		if len(buf)+utf8.RuneLen(r) > length {
+			r = 'x'
+		}
+		var tmp [utf8.UTFMax]byte
+		k := utf8.EncodeRune(tmp[:], r)
+		buf = append(buf, tmp[:k]...)
+	}
+	return buf
+}
+
+// Frames returns the frames used to send the message.
+func (m *Message) Frames() []*Frame {
+	var frames []*Frame
+	payload := m.Payload
+	op := m.Opcode
+	for i, k := range m.Fragments {
+		frames = append(frames, &Frame{
+			Final:   i == len(m.Fragments)-1,
+			Opcode:  op,
+			Payload: payload[:k],
+		})
+		payload = payload[k:]
+		op = Continuation
+		if m.Control != nil {
+			frames = append(frames, m.Control[i]...)
+		}
+	}
+	return frames
+}
+
+// Pings returns the payloads of all ping frames in the script, in the
+// order in which they are sent.
+func (s *Script) Pings() [][]byte {
+	var pings [][]byte
+	for _, m := range s.Messages {
+		for _, frames := range m.Control {
+			for _, f := range frames {
+				if f.Opcode == Ping {
+					pings = append(pings, f.Payload)
+				}
+			}
+		}
+	}
+	return pings
+}
+
+// Send writes all frames of the script to c.  Each frame is masked with a
+// new random key, unless the ZeroMask or NoMask fields of c are set.
+func (s *Script) Send(c *Client) error {
+	for _, m := range s.Messages {
+		for _, f := range m.Frames() {
+			err := c.WriteFrame(f)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Script) String() string {
+	res := ""
+	for i, m := range s.Messages {
+		if i > 0 {
+			res += " "
+		}
+		res += fmt.Sprintf("%s%v", m.Opcode, m.Fragments)
+		numControl := 0
+		for _, frames := range m.Control {
+			numControl += len(frames)
+		}
+		if numControl > 0 {
+			res += fmt.Sprintf("+%dping", numControl)
+		}
+	}
+	return "[" + res + "]"
+}
+
+// Shrink returns simpler variants of the script: scripts with a message
+// or a control frame removed, with two fragments merged, and with
+// shortened messages.
+func (s *Script) Shrink() []*Script {
+	var res []*Script
+
+	for i := range s.Messages {
+		msgs := make([]*Message, 0, len(s.Messages)-1)
+		msgs = append(msgs, s.Messages[:i]...)
+		msgs = append(msgs, s.Messages[i+1:]...)
+		res = append(res, &Script{Messages: msgs})
+	}
+
+	for i, m := range s.Messages {
+		for _, simpler := range m.shrink() {
+			msgs := append([]*Message(nil), s.Messages...)
+			msgs[i] = simpler
+			res = append(res, &Script{Messages: msgs})
+		}
+	}
+
+	return res
+}
+
+func (m *Message) shrink() []*Message {
+	var res []*Message
+
+	// remove control frames
+	for i, frames := range m.Control {
+		for j := range frames {
+			c := m.clone()
+			c.Control[i] = append(append([]*Frame(nil), frames[:j]...), frames[j+1:]...)
+			res = append(res, c)
+		}
+	}
+
+	// merge adjacent fragments
+	for i := 0; i+1 < len(m.Fragments); i++ {
+		c := m.clone()
+		c.Fragments = append(c.Fragments[:i:i], m.Fragments[i]+m.Fragments[i+1])
+		c.Fragments = append(c.Fragments, m.Fragments[i+2:]...)
+		if c.Control != nil {
+			merged := append(append([]*Frame(nil), m.Control[i]...), m.Control[i+1]...)
+			c.Control = append(c.Control[:i:i], merged)
+			c.Control = append(c.Control, m.Control[i+2:]...)
+		}
+		res = append(res, c)
+	}
+
+	// shorten the payload
+	if len(m.Payload) > 0 {
+		res = append(res, m.truncate(len(m.Payload)/2))
+		if len(m.Payload) > 1 {
+			res = append(res, m.truncate(len(m.Payload)-1))
+		}
+	}
+
+	return res
+}
+
+// truncate returns a copy of the message with the payload shortened to at
+// most n bytes.  Fragments at the end of the message are shortened as
+// needed.  For text messages, the payload is cut at a character boundary.
+func (m *Message) truncate(n int) *Message {
+	if m.Opcode == Text {
+		for n > 0 && !utf8.RuneStart(m.Payload[n]) {
+			n--
+		}
+	}
+	c := m.clone()
+	c.Payload = m.Payload[:n]
+	todo := n
+	for i, k := range c.Fragments {
+		if k > todo {
+			k = todo
+		}
+		c.Fragments[i] = k
+		todo -= k
+	}
+	return c
+}
+
+func (m *Message) clone() *Message {
+	c := &Message{
+		Opcode:    m.Opcode,
+		Payload:   m.Payload,
+		Fragments: append([]int(nil), m.Fragments...),
+	}
+	if m.Control != nil {
+		c.Control = make([][]*Frame, len(m.Control))
+		copy(c.Control, m.Control)
+	}
+	return c
+}
+
+// Check tests a property on n random scripts, generated from the given
+// seed.  If prop returns an error for one of the scripts, the script is
+// shrunk as far as possible while prop keeps failing, and the simplest
+// failing script is returned together with its error.  If prop succeeds
+// for all scripts, Check returns nil, nil.
+func Check(seed int64, n int, prop func(*Script) error) (*Script, error) {
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		s := Generate(rng)
+		err := prop(s)
+		if err != nil {
+			return shrink(s, err, prop)
+		}
+	}
+	return nil, nil
+}
+
+// maxShrinkSteps limits the number of times prop is evaluated while
+// shrinking a failing script.
+const maxShrinkSteps = 1000
+
+func shrink(s *Script, err error, prop func(*Script) error) (*Script, error) {
+	steps := 0
+	for {
+		improved := false
+		for _, c := range s.Shrink() {
+			if steps >= maxShrinkSteps {
+				return s, err
+			}
+			steps++
+			if cErr := prop(c); cErr != nil {
+				s, err = c, cErr
+				improved = true
+				break
+			}
+		}
+		if !improved {
+			return s, err
+		}
+	}
+}
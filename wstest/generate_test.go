@@ -0,0 +1,96 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/wstest"
+)
+
+func TestGeneratedEcho(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	echoed := func(s *wstest.Script) error {
+		client, err := wstest.Dial(server.URL)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		sendErr := make(chan error, 1)
+		go func() {
+			sendErr <- s.Send(client)
+		}()
+		for i, m := range s.Messages {
+			op, data, err := client.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if op != m.Opcode || !bytes.Equal(data, m.Payload) {
+				return fmt.Errorf("message %d: wrong echo", i)
+			}
+		}
+		if err := <-sendErr; err != nil {
+			return err
+		}
+		status, err := client.CloseHandshake(1000, "")
+		if err != nil {
+			return err
+		}
+		if status != 1000 {
+			return fmt.Errorf("wrong close status %d", status)
+		}
+		return nil
+	}
+
+	s, err := wstest.Check(1, 50, echoed)
+	if err != nil {
+		t.Errorf("%s: %v", s, err)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	errLong := errors.New("message too long")
+	short := func(s *wstest.Script) error {
+		for _, m := range s.Messages {
+			if len(m.Payload) > 100 {
+				return errLong
+			}
+		}
+		return nil
+	}
+
+	s, err := wstest.Check(2, 100, short)
+	if err != errLong {
+		t.Fatalf("expected errLong, got %v", err)
+	}
+	if len(s.Messages) != 1 {
+		t.Fatalf("script not shrunk: %s", s)
+	}
+	m := s.Messages[0]
+	l := len(m.Payload)
+	if l <= 100 || l > 103 || len(m.Fragments) != 1 || len(s.Pings()) != 0 {
+		t.Errorf("script not shrunk: %s, length %d", s, l)
+	}
+}
@@ -0,0 +1,314 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandshakeTimeout is the time CheckHandshake waits for a handler to
+// finish processing a handshake request.
+var HandshakeTimeout = 5 * time.Second
+
+// HandshakeSeeds returns a list of handshake requests, for use as the seed
+// corpus when fuzzing the HTTP upgrade path using CheckHandshake.  Apart
+// from a valid request, the list contains requests with missing and
+// repeated header fields, malformed keys, unsupported versions, and unusual
+// subprotocol and extension lists.
+func HandshakeSeeds() [][]byte {
+	const valid = "GET /chat?a=b HTTP/1.1\r\n" +
+		"Host: server.example.com\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	extra := []string{
+		"",
+		"Origin: http://server.example.com\r\n",
+		"Origin: null\r\n",
+		"Origin: http://[::1\r\n",
+		"Sec-WebSocket-Protocol: chat, superchat\r\n",
+		"Sec-WebSocket-Protocol: , ,chat,,\r\n",
+		"Sec-WebSocket-Protocol: chat\r\nSec-WebSocket-Protocol: other\r\n",
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n",
+		"Sec-WebSocket-Extensions: x-ext; a=\"b,c\", y\r\n",
+		"Sec-WebSocket-Key: second\r\n",
+		"X-Forwarded-For: 192.0.2.1, 198.51.100.7\r\n",
+		"Forwarded: for=\"[2001:db8::1]:4711\";proto=https\r\n",
+	}
+	var seeds [][]byte
+	for _, e := range extra {
+		seeds = append(seeds, []byte(valid+e+"\r\n"))
+	}
+
+	modified := []string{
+		strings.Replace(valid, "GET", "POST", 1),
+		strings.Replace(valid, "HTTP/1.1", "HTTP/1.0", 1),
+		strings.Replace(valid, "/chat?a=b", "*", 1),
+		strings.Replace(valid, "/chat?a=b", "http://other.example/x", 1),
+		strings.Replace(valid, "Upgrade: websocket", "Upgrade: h2c, WebSocket", 1),
+		strings.Replace(valid, "Connection: Upgrade", "Connection: keep-alive", 1),
+		strings.Replace(valid, "Connection: Upgrade", "Connection: keep-alive,upgrade", 1),
+		strings.Replace(valid, "dGhlIHNhbXBsZSBub25jZQ==", "", 1),
+		strings.Replace(valid, "dGhlIHNhbXBsZSBub25jZQ==", "not base64!", 1),
+		strings.Replace(valid, "dGhlIHNhbXBsZSBub25jZQ==", "AAAA", 1),
+		strings.Replace(valid, "Version: 13", "Version: 8", 1),
+		strings.Replace(valid, "Version: 13", "Version: 13, 8", 1),
+		strings.Replace(valid, "\r\n", "\n", -1),
+	}
+	for _, m := range modified {
+		seeds = append(seeds, []byte(m+"\r\n"))
+	}
+
+	// a truncated request
+	seeds = append(seeds, []byte(valid))
+
+	return seeds
+}
+
+// CheckHandshake passes the raw HTTP request to handler, using an
+// in-memory connection which reports end of file once the request has been
+// read, and checks the response.  This can be used to fuzz the handshake
+// code of a server:
+//
+//	func FuzzHandshake(f *testing.F) {
+//		for _, seed := range wstest.HandshakeSeeds() {
+//			f.Add(seed)
+//		}
+//		handler := &websocket.Handler{Subprotocols: []string{"chat"}}
+//		f.Fuzz(func(t *testing.T, request []byte) {
+//			err := wstest.CheckHandshake(handler, request)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+//
+// An error is returned if the handler panics, if the handler does not
+// finish within HandshakeTimeout, if the response is not valid HTTP, or if
+// a successful upgrade response (status 101) does not match the request.
+// Requests which are rejected with any other status are not considered to
+// be errors.
+func CheckHandshake(handler http.Handler, request []byte) error {
+	conn := &bufferConn{
+		r:      bytes.NewReader(request),
+		closed: make(chan struct{}),
+	}
+	l := &singleListener{
+		conns: make(chan net.Conn, 1),
+		done:  make(chan struct{}),
+	}
+	l.conns <- conn
+
+	logBuf := &lockedBuffer{}
+	server := &http.Server{
+		Handler:  handler,
+		ErrorLog: log.New(logBuf, "", 0),
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	select {
+	case <-conn.closed:
+	case <-time.After(HandshakeTimeout):
+		return errors.New("wstest: handshake timed out")
+	}
+	if msg := logBuf.String(); strings.Contains(msg, "panic") {
+		return fmt.Errorf("wstest: handler panicked: %s", msg)
+	}
+
+	response := conn.written()
+	if len(response) == 0 {
+		// The request was rejected by the HTTP server, before it reached
+		// the handler.
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), nil)
+	if err != nil {
+		return fmt.Errorf("wstest: invalid response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil
+	}
+	return checkUpgrade(request, resp)
+}
+
+// checkUpgrade checks that an upgrade response matches the request.  The
+// Upgrade and Connection fields of the request are not checked, since
+// servers differ in how leniently they parse these.
+func checkUpgrade(request []byte, resp *http.Response) error {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(request)))
+	if err != nil {
+		return fmt.Errorf("wstest: invalid request was accepted: %w", err)
+	}
+	if req.Method != "GET" || !req.ProtoAtLeast(1, 1) {
+		return fmt.Errorf("wstest: %s %s request was accepted", req.Method, req.Proto)
+	}
+	if v := req.Header.Get("Sec-Websocket-Version"); v != "13" {
+		return fmt.Errorf("wstest: request with version %q was accepted", v)
+	}
+	key := req.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		return errors.New("wstest: request without key was accepted")
+	}
+
+	if !hasToken(resp.Header.Values("Upgrade"), "websocket") ||
+		!hasToken(resp.Header.Values("Connection"), "upgrade") {
+		return errors.New("wstest: upgrade headers missing from response")
+	}
+	if got := resp.Header.Get("Sec-Websocket-Accept"); got != acceptKey(key) {
+		return fmt.Errorf("wstest: wrong Sec-WebSocket-Accept value %q", got)
+	}
+	if proto := resp.Header.Get("Sec-Websocket-Protocol"); proto != "" {
+		var offered []string
+		for _, value := range req.Header.Values("Sec-Websocket-Protocol") {
+			offered = append(offered, strings.Split(value, ",")...)
+		}
+		found := false
+		for _, p := range offered {
+			if strings.TrimSpace(p) == proto {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("wstest: subprotocol %q was not offered", proto)
+		}
+	}
+	return nil
+}
+
+// hasToken reports whether one of the comma-separated lists in values
+// contains the given token.  Case is ignored.
+func hasToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bufferConn is a net.Conn which reads from a fixed buffer and reports
+// end of file once the buffer is exhausted.  Written data is collected.
+type bufferConn struct {
+	r *bytes.Reader
+
+	mu     sync.Mutex
+	w      bytes.Buffer
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *bufferConn) Read(p []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.r.Read(p)
+}
+
+func (c *bufferConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.w.Write(p)
+}
+
+func (c *bufferConn) written() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.w.Bytes()...)
+}
+
+func (c *bufferConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *bufferConn) LocalAddr() net.Addr                { return bufferAddr{} }
+func (c *bufferConn) RemoteAddr() net.Addr               { return bufferAddr{} }
+func (c *bufferConn) SetDeadline(t time.Time) error      { return nil }
+func (c *bufferConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *bufferConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type bufferAddr struct{}
+
+func (bufferAddr) Network() string { return "buffer" }
+func (bufferAddr) String() string  { return "192.0.2.1:1234" }
+
+// singleListener is a net.Listener which returns the connections from a
+// channel.
+type singleListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *singleListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *singleListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *singleListener) Addr() net.Addr {
+	return bufferAddr{}
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,62 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/wstest"
+)
+
+func FuzzHandshake(f *testing.F) {
+	for _, seed := range wstest.HandshakeSeeds() {
+		f.Add(seed)
+	}
+	handler := &websocket.Handler{
+		Subprotocols: []string{"superchat", "chat"},
+		Handle:       echo,
+	}
+	f.Fuzz(func(t *testing.T, request []byte) {
+		err := wstest.CheckHandshake(handler, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCheckHandshakeErrors(t *testing.T) {
+	request := wstest.HandshakeSeeds()[0]
+
+	panicky := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("oops")
+	})
+	if err := wstest.CheckHandshake(panicky, request); err == nil {
+		t.Error("panic was not detected")
+	}
+
+	wrongKey := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Sec-WebSocket-Accept", "wrong")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	})
+	if err := wstest.CheckHandshake(wrongKey, request); err == nil {
+		t.Error("wrong accept key was not detected")
+	}
+}
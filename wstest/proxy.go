@@ -0,0 +1,262 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest
+
+import (
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Faults describes the network problems simulated by a [Proxy].
+type Faults struct {
+	// Latency is the delay added to all data forwarded by the proxy, in
+	// both directions.
+	Latency time.Duration
+
+	// Jitter, if positive, adds a random extra delay between zero and
+	// Jitter to each chunk of forwarded data.  The order of the data is
+	// preserved.
+	Jitter time.Duration
+
+	// Seed is used to initialise the random number generator used for
+	// the jitter.  Using the same seed gives the same sequence of delays.
+	Seed int64
+
+	// SegmentSize, if positive, causes forwarded data to be written in
+	// pieces of at most SegmentSize bytes.  Since the proxy disables
+	// Nagle's algorithm, each piece is sent in a separate TCP segment.
+	// This can be used to split websocket frames across segments.
+	SegmentSize int
+
+	// ResetAfter, if positive, causes each connection to be reset after
+	// ResetAfter bytes have been forwarded from the server to the client.
+	ResetAfter int64
+}
+
+// Proxy is a TCP proxy which sits between a websocket client and a server
+// and simulates network problems.  This allows to test timeout handling
+// and reconnection logic.
+type Proxy struct {
+	// URL is the "ws" URL of the proxy, without a path.
+	URL string
+
+	target string
+	faults Faults
+	ln     net.Listener
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	conns map[*proxyConn]bool
+}
+
+type proxyConn struct {
+	client, server *net.TCPConn
+}
+
+// NewProxy starts a proxy which forwards connections to target.  The
+// target can be given as a "host:port" address, or as a URL with scheme
+// "ws" or "http", for example the URL of an httptest.Server.  If faults is
+// nil, data is forwarded unchanged.
+func NewProxy(target string, faults *Faults) (*Proxy, error) {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		URL:    "ws://" + ln.Addr().String(),
+		target: target,
+		ln:     ln,
+		conns:  make(map[*proxyConn]bool),
+	}
+	if faults != nil {
+		p.faults = *faults
+	}
+	p.rng = rand.New(rand.NewSource(p.faults.Seed))
+
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// Close stops the proxy and resets all connections.
+func (p *Proxy) Close() error {
+	err := p.ln.Close()
+	p.Reset()
+	p.wg.Wait()
+	return err
+}
+
+// Reset abruptly terminates all current connections, by sending a TCP
+// reset to both the client and the server.
+func (p *Proxy) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pc := range p.conns {
+		pc.reset()
+	}
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go p.serve(conn.(*net.TCPConn))
+	}
+}
+
+func (p *Proxy) serve(client *net.TCPConn) {
+	defer p.wg.Done()
+
+	conn, err := net.Dial("tcp", p.target)
+	if err != nil {
+		client.Close()
+		return
+	}
+	pc := &proxyConn{client: client, server: conn.(*net.TCPConn)}
+	pc.client.SetNoDelay(true)
+	pc.server.SetNoDelay(true)
+
+	p.mu.Lock()
+	p.conns[pc] = true
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.forward(pc, pc.server, pc.client, 0)
+	}()
+	go func() {
+		defer wg.Done()
+		p.forward(pc, pc.client, pc.server, p.faults.ResetAfter)
+	}()
+	wg.Wait()
+
+	p.mu.Lock()
+	delete(p.conns, pc)
+	p.mu.Unlock()
+	pc.client.Close()
+	pc.server.Close()
+}
+
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// forward copies data from src to dst, until src is closed.  If limit is
+// positive, the connection is reset after limit bytes have been copied.
+func (p *Proxy) forward(pc *proxyConn, dst, src *net.TCPConn, limit int64) {
+	chunks := make(chan chunk, 64)
+	go func() {
+		defer close(chunks)
+		var last time.Time
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := src.Read(buf)
+			if n > 0 {
+				// Delivery times are kept in order, so that
+				// the data is not reordered.
+				due := time.Now().Add(p.delay())
+				if due.Before(last) {
+					due = last
+				}
+				last = due
+				chunks <- chunk{data: buf[:n], due: due}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var total int64
+	failed := false
+	for c := range chunks {
+		if failed {
+			continue
+		}
+		time.Sleep(time.Until(c.due))
+
+		data := c.data
+		reset := false
+		if limit > 0 && total+int64(len(data)) >= limit {
+			data = data[:limit-total]
+			reset = true
+		}
+		total += int64(len(data))
+		err := p.write(dst, data)
+		if err != nil || reset {
+			pc.reset()
+			failed = true
+		}
+	}
+	if !failed {
+		dst.CloseWrite()
+	}
+}
+
+// write writes data to dst, split into pieces of at most
+// p.faults.SegmentSize bytes.
+func (p *Proxy) write(dst *net.TCPConn, data []byte) error {
+	size := p.faults.SegmentSize
+	if size <= 0 {
+		size = len(data)
+	}
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := dst.Write(data[:n])
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (p *Proxy) delay() time.Duration {
+	d := p.faults.Latency
+	if p.faults.Jitter > 0 {
+		p.mu.Lock()
+		d += time.Duration(p.rng.Int63n(int64(p.faults.Jitter)))
+		p.mu.Unlock()
+	}
+	return d
+}
+
+// reset closes both connections, causing a TCP reset to be sent.
+func (pc *proxyConn) reset() {
+	pc.client.SetLinger(0)
+	pc.server.SetLinger(0)
+	pc.client.Close()
+	pc.server.Close()
+}
@@ -0,0 +1,143 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/wstest"
+)
+
+func TestProxyLatency(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	proxy, err := wstest.NewProxy(server.URL, &wstest.Faults{
+		Latency:     20 * time.Millisecond,
+		Jitter:      5 * time.Millisecond,
+		SegmentSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(), proxy.URL+"/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := strings.Repeat("abc", 100)
+	start := time.Now()
+	err = conn.SendText(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := conn.ReceiveText(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != msg {
+		t.Errorf("wrong message %q", res)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("round trip took only %s", d)
+	}
+
+	err = conn.Close(websocket.StatusOK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, _ := conn.Wait()
+	if info != websocket.ServerClosed {
+		t.Errorf("wrong close information %d", info)
+	}
+}
+
+func TestProxyReset(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	proxy, err := wstest.NewProxy(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	conn, err := websocket.DefaultDialer.Dial(context.Background(), proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conn.SendText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ReceiveText(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.Reset()
+	_, err = conn.ReceiveText(100)
+	if !errors.Is(err, websocket.ErrConnClosed) {
+		t.Errorf("wrong error %v", err)
+	}
+	info, _, _ := conn.Wait()
+	if info != websocket.ConnDropped {
+		t.Errorf("wrong close information %d", info)
+	}
+}
+
+func TestProxyResetAfter(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	proxy, err := wstest.NewProxy(server.URL, &wstest.Faults{ResetAfter: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	client, err := wstest.Dial(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The handshake response uses part of the budget, so the second
+	// message cannot be echoed completely.
+	msg := []byte(strings.Repeat("x", 500))
+	for i := 0; i < 2; i++ {
+		err = client.SendMessage(wstest.Binary, msg, 0)
+		if err != nil {
+			break
+		}
+		_, _, err = client.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Error("connection was not reset")
+	}
+}
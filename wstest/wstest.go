@@ -0,0 +1,472 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package wstest provides a low-level websocket client for testing
+// websocket servers at the protocol level.
+//
+// In contrast to a normal client, the Client in this package gives full
+// control over the frames sent to the server: frames can be fragmented
+// arbitrarily, reserved bits and invalid opcodes can be set, and frames
+// with a given length but meaningless contents can be sent efficiently.
+// This allows to test how a server reacts to unusual or invalid input.
+//
+// A typical test starts the server using net/http/httptest:
+//
+//	server := httptest.NewServer(&websocket.Handler{Handle: handle})
+//	defer server.Close()
+//	client, err := wstest.Dial(server.URL)
+//	...
+//	err = client.SendMessage(wstest.Text, []byte("hello"), 2)
+//
+// The [Proxy] type can be placed between a client and a server, to
+// simulate latency, fragmented TCP segments and connection resets.
+//
+// For property-based tests, [Generate] creates random sequences of
+// messages with random fragmentation and interleaved control frames, and
+// [Check] reduces failing sequences to a minimal example.
+// [CheckHandshake] can be used to fuzz the opening handshake.
+package wstest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Opcode is the opcode of a websocket frame.
+type Opcode byte
+
+// Websocket opcodes, see RFC 6455, section 5.2.
+const (
+	Continuation Opcode = 0
+	Text         Opcode = 1
+	Binary       Opcode = 2
+	Close        Opcode = 8
+	Ping         Opcode = 9
+	Pong         Opcode = 10
+)
+
+func (op Opcode) String() string {
+	switch op {
+	case Continuation:
+		return "continuation"
+	case Text:
+		return "text"
+	case Binary:
+		return "binary"
+	case Close:
+		return "close"
+	case Ping:
+		return "ping"
+	case Pong:
+		return "pong"
+	default:
+		return fmt.Sprintf("Opcode(%d)", op)
+	}
+}
+
+// Frame is a websocket frame.
+type Frame struct {
+	Final   bool
+	RSV     byte // the three reserved bits, in the lowest three bits
+	Opcode  Opcode
+	Payload []byte
+}
+
+// MaxFrameSize is the maximal payload length of frames accepted by
+// ReadFrame.
+const MaxFrameSize = 16 * 1024 * 1024
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Client is the client side of a websocket connection.  All methods
+// operate directly on the network connection; a Client must not be used
+// concurrently by more than one reader and one writer.
+type Client struct {
+	// ZeroMask, if set, causes the client to use the all-zero masking key
+	// for all frames, instead of a random one.  This makes the data on the
+	// wire equal to the unmasked payload.
+	ZeroMask bool
+
+	// NoMask, if set, causes the client to send unmasked frames.  This
+	// violates the protocol and a server must close the connection.
+	NoMask bool
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to the websocket server at the given URL.  The URL can use
+// any of the schemes "ws", "http", and "unix"; "http" is treated the same
+// as "ws" to simplify use with httptest.Server.URL.  For the "unix"
+// scheme, the path of the URL is the name of the socket, and the handshake
+// uses the resource name "/".
+func Dial(urlStr string) (*Client, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws", "http":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = net.Dial("tcp", addr)
+	case "unix":
+		conn, err = net.Dial("unix", u.Path)
+		u = &url.URL{Host: "localhost", Path: "/"}
+	default:
+		return nil, errors.New("wstest: unsupported URL scheme " + u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, u.Host, u.RequestURI(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient performs the opening handshake on an existing connection.
+// The given header fields are added to the handshake request.
+func NewClient(conn net.Conn, host, resource string, header http.Header) (*Client, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &strings.Builder{}
+	fmt.Fprintf(req, "GET %s HTTP/1.1\r\n", resource)
+	fmt.Fprintf(req, "Host: %s\r\n", host)
+	req.WriteString("Upgrade: websocket\r\n")
+	req.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(req, "Sec-WebSocket-Key: %s\r\n", key)
+	req.WriteString("Sec-WebSocket-Version: 13\r\n")
+	for name, values := range header {
+		for _, value := range values {
+			fmt.Fprintf(req, "%s: %s\r\n", name, value)
+		}
+	}
+	req.WriteString("\r\n")
+	_, err = io.WriteString(conn, req.String())
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("wstest: handshake failed: %s", resp.Status)
+	}
+
+	return &Client{
+		conn: conn,
+		r:    r,
+	}, nil
+}
+
+// acceptKey computes the value of the Sec-WebSocket-Accept header field
+// for the given key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Close closes the network connection, without a closing handshake.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Conn returns the underlying network connection.
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// appendHeader appends a frame header, including the masking key, to buf.
+func (c *Client) appendHeader(buf []byte, f *Frame, l uint64) ([]byte, [4]byte, error) {
+	b0 := byte(f.Opcode&15) | (f.RSV&7)<<4
+	if f.Final {
+		b0 |= 128
+	}
+	var b1 byte
+	if !c.NoMask {
+		b1 = 128
+	}
+	switch {
+	case l < 126:
+		buf = append(buf, b0, b1|byte(l))
+	case l < 1<<16:
+		buf = append(buf, b0, b1|126, byte(l>>8), byte(l))
+	default:
+		buf = append(buf, b0, b1|127,
+			byte(l>>56), byte(l>>48), byte(l>>40), byte(l>>32),
+			byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+	}
+
+	var mask [4]byte
+	if c.NoMask {
+		return buf, mask, nil
+	}
+	if !c.ZeroMask {
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return nil, mask, err
+		}
+	}
+	return append(buf, mask[:]...), mask, nil
+}
+
+// WriteFrame sends a single frame.  The frame is masked as configured by
+// the ZeroMask and NoMask fields.
+func (c *Client) WriteFrame(f *Frame) error {
+	buf := make([]byte, 0, 14+len(f.Payload))
+	buf, mask, err := c.appendHeader(buf, f, uint64(len(f.Payload)))
+	if err != nil {
+		return err
+	}
+	start := len(buf)
+	buf = append(buf, f.Payload...)
+	for i := range buf[start:] {
+		buf[start+i] ^= mask[i%4]
+	}
+	_, err = c.conn.Write(buf)
+	return err
+}
+
+// WriteNonsenseFrame sends a frame with the given header and a payload of
+// length l.  The payload consists of arbitrary data, and is sent using
+// repeated writes from buf, which must be at least 14 bytes long.  This
+// can be used to efficiently send very large frames.
+func (c *Client) WriteNonsenseFrame(buf []byte, op Opcode, l uint64, final bool) error {
+	header, _, err := c.appendHeader(buf[:0], &Frame{Final: final, Opcode: op}, l)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(header)
+	if err != nil {
+		return err
+	}
+	for l > 0 {
+		chunk := uint64(len(buf))
+		if chunk > l {
+			chunk = l
+		}
+		n, err := c.conn.Write(buf[:chunk])
+		if err != nil {
+			return err
+		}
+		l -= uint64(n)
+	}
+	return nil
+}
+
+// WriteRaw writes data directly to the network connection.  This can be
+// used to send malformed frames.
+func (c *Client) WriteRaw(data []byte) error {
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// SendMessage sends a message, split into fragments of at most
+// fragmentSize bytes.  If fragmentSize is zero or negative, the message is
+// sent as a single frame.
+func (c *Client) SendMessage(op Opcode, payload []byte, fragmentSize int) error {
+	if fragmentSize <= 0 || fragmentSize > len(payload) {
+		fragmentSize = len(payload)
+	}
+	for {
+		n := fragmentSize
+		final := n >= len(payload)
+		if final {
+			n = len(payload)
+		}
+		err := c.WriteFrame(&Frame{Final: final, Opcode: op, Payload: payload[:n]})
+		if err != nil || final {
+			return err
+		}
+		payload = payload[n:]
+		op = Continuation
+	}
+}
+
+// ReadFrame reads the next frame sent by the server.  Masked frames, which
+// a server must not send, result in an error.
+func (c *Client) ReadFrame() (*Frame, error) {
+	var h [2]byte
+	_, err := io.ReadFull(c.r, h[:])
+	if err != nil {
+		return nil, err
+	}
+	if h[1]&128 != 0 {
+		return nil, errMasked
+	}
+
+	var l uint64
+	switch h[1] & 127 {
+	case 127:
+		var buf [8]byte
+		_, err = io.ReadFull(c.r, buf[:])
+		l = binary.BigEndian.Uint64(buf[:])
+	case 126:
+		var buf [2]byte
+		_, err = io.ReadFull(c.r, buf[:])
+		l = uint64(binary.BigEndian.Uint16(buf[:]))
+	default:
+		l = uint64(h[1] & 127)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l > MaxFrameSize {
+		return nil, errTooLarge
+	}
+
+	f := &Frame{
+		Final:   h[0]&128 != 0,
+		RSV:     (h[0] >> 4) & 7,
+		Opcode:  Opcode(h[0] & 15),
+		Payload: make([]byte, l),
+	}
+	_, err = io.ReadFull(c.r, f.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadMessage reads the next message sent by the server, reassembling
+// fragmented messages.  Ping frames are answered automatically and pong
+// frames are ignored.  If the server sends a close frame, a *CloseError is
+// returned.
+func (c *Client) ReadMessage() (Opcode, []byte, error) {
+	var op Opcode
+	var data []byte
+	started := false
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f.Opcode {
+		case Ping:
+			err = c.WriteFrame(&Frame{Final: true, Opcode: Pong, Payload: f.Payload})
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case Pong:
+			continue
+		case Close:
+			return 0, nil, parseClose(f.Payload)
+		case Continuation:
+			if !started {
+				return 0, nil, errUnexpectedContinuation
+			}
+		default:
+			if started {
+				return 0, nil, errExpectedContinuation
+			}
+			op = f.Opcode
+			started = true
+		}
+		data = append(data, f.Payload...)
+		if f.Final {
+			return op, data, nil
+		}
+	}
+}
+
+// CloseHandshake sends a close frame with the given status code and
+// reason, waits for the close frame of the server, and then closes the
+// network connection.  Messages received before the server's close frame
+// are discarded.  The status code sent by the server is returned, or 1005
+// if the close frame of the server contained no status code.
+func (c *Client) CloseHandshake(status uint16, reason string) (uint16, error) {
+	payload := []byte(reason)
+	if status != 0 {
+		payload = append([]byte{byte(status >> 8), byte(status)}, payload...)
+	}
+	err := c.WriteFrame(&Frame{Final: true, Opcode: Close, Payload: payload})
+	if err != nil {
+		c.conn.Close()
+		return 0, err
+	}
+
+	for {
+		f, err := c.ReadFrame()
+		if err != nil {
+			c.conn.Close()
+			return 0, err
+		}
+		if f.Opcode == Close {
+			c.conn.Close()
+			closeErr := parseClose(f.Payload)
+			return closeErr.Status, nil
+		}
+	}
+}
+
+// CloseError is returned by ReadMessage when the server closes the
+// connection.
+type CloseError struct {
+	Status uint16 // 1005 if no status code was sent
+	Reason string
+}
+
+func (err *CloseError) Error() string {
+	return fmt.Sprintf("wstest: connection closed with status %d %q",
+		err.Status, err.Reason)
+}
+
+func parseClose(payload []byte) *CloseError {
+	if len(payload) < 2 {
+		return &CloseError{Status: 1005}
+	}
+	return &CloseError{
+		Status: binary.BigEndian.Uint16(payload),
+		Reason: string(payload[2:]),
+	}
+}
+
+var (
+	errMasked                 = errors.New("wstest: server sent a masked frame")
+	errTooLarge               = errors.New("wstest: frame too large")
+	errUnexpectedContinuation = errors.New("wstest: unexpected continuation frame")
+	errExpectedContinuation   = errors.New("wstest: expected continuation frame")
+)
@@ -0,0 +1,140 @@
+// seehuhn.de/go/websocket - an http server to establish websocket connections
+// Copyright (C) 2026  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wstest_test
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"seehuhn.de/go/websocket"
+	"seehuhn.de/go/websocket/wstest"
+)
+
+func echo(conn *websocket.Conn) {
+	defer conn.Close(websocket.StatusOK, "")
+	for {
+		tp, r, err := conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		w, err := conn.SendMessage(tp)
+		if err != nil {
+			io.Copy(io.Discard, r)
+			return
+		}
+		io.Copy(w, r)
+		w.Close()
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	client, err := wstest.Dial(server.URL + "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("a fragmented message")
+	err = client.SendMessage(wstest.Binary, msg, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a ping between the fragments of a message
+	err = client.WriteFrame(&wstest.Frame{Opcode: wstest.Text, Payload: []byte("hel")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.WriteFrame(&wstest.Frame{Final: true, Opcode: wstest.Ping, Payload: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.WriteFrame(&wstest.Frame{Final: true, Opcode: wstest.Continuation, Payload: []byte("lo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wstest.Binary || !bytes.Equal(data, msg) {
+		t.Errorf("wrong message %s %q", op, data)
+	}
+	// The echo handler forwards each fragment as it arrives, so the pong
+	// can arrive before, between or after the echoed fragments.
+	// ReadMessage skips the pong frame.
+	op, data, err = client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wstest.Text || string(data) != "hello" {
+		t.Errorf("wrong message %s %q", op, data)
+	}
+
+	err = client.WriteFrame(&wstest.Frame{Final: true, Opcode: wstest.Ping, Payload: []byte("y")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		f, err := client.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Opcode == wstest.Pong && string(f.Payload) == "x" {
+			// the pong for the first ping, if it came late
+			continue
+		}
+		if f.Opcode != wstest.Pong || string(f.Payload) != "y" {
+			t.Errorf("expected pong, got %s %q", f.Opcode, f.Payload)
+		}
+		break
+	}
+
+	status, err := client.CloseHandshake(1000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 1000 {
+		t.Errorf("wrong status %d", status)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	server := httptest.NewServer(&websocket.Handler{Handle: echo})
+	defer server.Close()
+
+	client, err := wstest.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// reserved bits must not be set
+	err = client.WriteFrame(&wstest.Frame{Final: true, RSV: 4, Opcode: wstest.Text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.ReadMessage()
+	closeErr, ok := err.(*wstest.CloseError)
+	if !ok || closeErr.Status != uint16(websocket.StatusProtocolError) {
+		t.Errorf("expected protocol error, got %v", err)
+	}
+}